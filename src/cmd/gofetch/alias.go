@@ -0,0 +1,126 @@
+package main
+
+// 設定ファイルのエイリアス (gofetch <alias> name=value ...)
+// URLのテンプレート、メソッド、ヘッダー、フラグをまとめて名前を付けておき、
+// "gofetch deploy-status env=prod" のように呼び出す
+// テンプレートの {name} は name=value の引数で置き換え、${VAR} は環境変数で置き換える
+// URLとヘッダーの {{env "NAME"}} や {{secret "vault:..."}} はシークレットとして解決する
+// name=value の形でない引数はそのままフラグとして渡す
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// aliasDef は設定ファイルに書くエイリアス1つ
+type aliasDef struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Flags   []string          `yaml:"flags"`
+}
+
+// aliasPlaceholder はURLテンプレートの {name}
+var aliasPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\}`)
+
+// requestOptions はURL以外のリクエストの内容
+// 今のところエイリアスからだけ指定できる
+type requestOptions struct {
+	Method string
+	Header http.Header
+}
+
+// expand はエイリアスの引数からコマンドライン引数とリクエストの内容を作る
+func (a aliasDef) expand(name string, args []string) ([]string, requestOptions, error) {
+	opts := requestOptions{Method: http.MethodGet, Header: http.Header{}}
+	if a.URL == "" {
+		return nil, opts, fmt.Errorf("alias %s: url is required", name)
+	}
+
+	vars := map[string]string{}
+	var passthrough []string
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if ok && !strings.HasPrefix(arg, "-") {
+			vars[k] = v
+		} else {
+			passthrough = append(passthrough, arg)
+		}
+	}
+
+	var missing []string
+	used := map[string]bool{}
+	rawURL := aliasPlaceholder.ReplaceAllStringFunc(a.URL, func(m string) string {
+		key := m[1 : len(m)-1]
+		v, ok := vars[key]
+		if !ok {
+			missing = append(missing, key)
+			return m
+		}
+		used[key] = true
+		return url.PathEscape(v)
+	})
+	if len(missing) > 0 {
+		return nil, opts, fmt.Errorf("alias %s: missing %s (use name=value)", name, strings.Join(missing, ", "))
+	}
+	for k := range vars {
+		if !used[k] {
+			return nil, opts, fmt.Errorf("alias %s: unknown variable %q", name, k)
+		}
+	}
+
+	if a.Method != "" {
+		opts.Method = strings.ToUpper(a.Method)
+	}
+	for k, v := range a.Headers {
+		v, err := expandSecretHeader(k, os.ExpandEnv(v))
+		if err != nil {
+			return nil, opts, fmt.Errorf("alias %s: %w", name, err)
+		}
+		opts.Header.Set(k, v)
+	}
+	rawURL, err := expandSecretURL(os.ExpandEnv(rawURL))
+	if err != nil {
+		return nil, opts, fmt.Errorf("alias %s: %w", name, err)
+	}
+	expanded := append([]string{"-u", rawURL}, a.Flags...)
+	return append(expanded, passthrough...), opts, nil
+}
+
+// aliasNames はエイリアスの名前を名前順に返す
+func (c *config) aliasNames() []string {
+	names := make([]string, 0, len(c.Aliases))
+	for name := range c.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runAliasList は aliases サブコマンドとして設定ファイルのエイリアスを表示する
+func runAliasList() int {
+	conf, err := loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if len(conf.Aliases) == 0 {
+		path, _ := configPath()
+		fmt.Println("No aliases defined in", path)
+		return 0
+	}
+	for _, name := range conf.aliasNames() {
+		a := conf.Aliases[name]
+		method := a.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		fmt.Printf("%-20s %-6s %s\n", name, strings.ToUpper(method), a.URL)
+	}
+	return 0
+}
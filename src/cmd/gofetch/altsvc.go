@@ -0,0 +1,232 @@
+package main
+
+// Alt-Svc レスポンスヘッダー (RFC 7838) のキャッシュ
+// ブラウザと同じように、一度受け取った Alt-Svc を保存先(既定はディスク)に保存し、
+// 次回以降のリクエストで広告された h3 の接続先を使う
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gofetch/pkg/gofetch/store"
+)
+
+const (
+	// ma パラメーターが省略されたときの有効期間
+	altSvcDefaultMaxAge = 24 * time.Hour
+)
+
+// altSvcEntry は Alt-Svc で広告された代替サービス1件
+type altSvcEntry struct {
+	Protocol string    `json:"protocol"`
+	Host     string    `json:"host"`
+	Port     string    `json:"port"`
+	Expires  time.Time `json:"expires"`
+}
+
+// altSvcCacheKey はキャッシュを保存するキー
+const altSvcCacheKey = "origins"
+
+// altSvcCache はオリジンごとの代替サービスを保存する
+type altSvcCache struct {
+	kv      store.KV
+	Origins map[string][]altSvcEntry `json:"origins"`
+}
+
+// loadAltSvcCache は保存先からキャッシュを読み込む
+// まだ保存されていなければ空のキャッシュを返す
+func loadAltSvcCache(kv store.KV) (*altSvcCache, error) {
+	c := &altSvcCache{kv: kv, Origins: map[string][]altSvcEntry{}}
+	data, err := kv.Get(altSvcCacheKey)
+	if errors.Is(err, store.ErrNotFound) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Origins == nil {
+		c.Origins = map[string][]altSvcEntry{}
+	}
+	return c, nil
+}
+
+// save は期限切れのエントリを取り除いてからキャッシュを書き出す
+func (c *altSvcCache) save() error {
+	now := time.Now()
+	for origin, entries := range c.Origins {
+		var live []altSvcEntry
+		for _, e := range entries {
+			if e.Expires.After(now) {
+				live = append(live, e)
+			}
+		}
+		if len(live) == 0 {
+			delete(c.Origins, origin)
+		} else {
+			c.Origins[origin] = live
+		}
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.kv.Set(altSvcCacheKey, data)
+}
+
+// lookup はオリジンに対して有効な指定プロトコルの代替サービスを返す
+func (c *altSvcCache) lookup(origin, protocol string) *altSvcEntry {
+	now := time.Now()
+	for _, e := range c.Origins[origin] {
+		if e.Protocol == protocol && e.Expires.After(now) {
+			return &e
+		}
+	}
+	return nil
+}
+
+// remove はオリジンの指定プロトコルの代替サービスを削除する
+func (c *altSvcCache) remove(origin, protocol string) {
+	var kept []altSvcEntry
+	for _, e := range c.Origins[origin] {
+		if e.Protocol != protocol {
+			kept = append(kept, e)
+		}
+	}
+	c.Origins[origin] = kept
+}
+
+// update はレスポンスの Alt-Svc ヘッダーでオリジンのエントリを置き換える
+// ヘッダーがなければ何もしない
+func (c *altSvcCache) update(origin string, header string, now time.Time) {
+	if header == "" {
+		return
+	}
+	entries, clear := parseAltSvc(header, now)
+	if clear {
+		delete(c.Origins, origin)
+		verbosef("Alt-Svc: cleared for %s", origin)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+	c.Origins[origin] = entries
+	for _, e := range entries {
+		verbosef("Alt-Svc: cached %s=%s for %s (until %s)", e.Protocol, net.JoinHostPort(e.Host, e.Port), origin, e.Expires.Format(time.RFC3339))
+	}
+}
+
+// altSvcOrigin はリクエストのオリジンを scheme://host:port の形で返す
+func altSvcOrigin(req *http.Request) string {
+	port := req.URL.Port()
+	if port == "" {
+		port = "443"
+		if req.URL.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return req.URL.Scheme + "://" + net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// parseAltSvc は Alt-Svc ヘッダーを解析する
+// "clear" が指定されていれば clear に true を返す
+func parseAltSvc(header string, now time.Time) (entries []altSvcEntry, clear bool) {
+	if strings.TrimSpace(header) == "clear" {
+		return nil, true
+	}
+	for _, value := range splitQuoted(header, ',') {
+		params := splitQuoted(value, ';')
+		if len(params) == 0 {
+			continue
+		}
+		protocol, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok {
+			continue
+		}
+		host, port, err := net.SplitHostPort(strings.Trim(authority, `"`))
+		if err != nil {
+			continue
+		}
+		e := altSvcEntry{
+			Protocol: protocol,
+			Host:     host,
+			Port:     port,
+			Expires:  now.Add(altSvcDefaultMaxAge),
+		}
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "ma") {
+				if sec, err := strconv.Atoi(strings.Trim(v, `"`)); err == nil {
+					e.Expires = now.Add(time.Duration(sec) * time.Second)
+				}
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, false
+}
+
+// splitQuoted は引用符の外にある区切り文字で文字列を分割する
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuote = !inQuote
+		case '\\':
+			i++
+		case sep:
+			if !inQuote {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// altSvcTransport は Alt-Svc で広告された h3 の接続先を優先して使い、
+// 失敗した場合は通常のTCP接続にフォールバックする
+type altSvcTransport struct {
+	cache    *altSvcCache
+	base     http.RoundTripper
+	newHTTP3 func(addr string) (http.RoundTripper, error)
+}
+
+// RoundTrip はキャッシュに h3 の代替サービスがあればそれを使ってリクエストを送る
+func (t *altSvcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := altSvcOrigin(req)
+	if req.URL.Scheme == "https" && req.Body == nil {
+		if e := t.cache.lookup(origin, "h3"); e != nil {
+			host := e.Host
+			if host == "" {
+				host = req.URL.Hostname()
+			}
+			addr := net.JoinHostPort(host, e.Port)
+			verbosef("Alt-Svc: trying h3 at %s", addr)
+			rt, err := t.newHTTP3(addr)
+			if err == nil {
+				var resp *http.Response
+				resp, err = rt.RoundTrip(req)
+				if err == nil {
+					return resp, nil
+				}
+			}
+			// 使えなかった代替サービスは忘れて、TCPでやり直す
+			verbosef("Alt-Svc: h3 failed (%v), falling back to TCP", err)
+			t.cache.remove(origin, "h3")
+		}
+	}
+	return t.base.RoundTrip(req)
+}
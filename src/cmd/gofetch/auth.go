@@ -0,0 +1,116 @@
+package main
+
+// 認証情報の指定 (--auth, --bearer, --api-key, --api-key-in)
+// --auth user:pass は Basic 認証、--bearer は Authorization: Bearer を付ける
+// --api-key NAME=VALUE は --api-key-in に従ってヘッダーかクエリパラメーターに付ける
+// 値にはシークレットの埋め込みを使え、指定した値は詳細モードの表示やリトライの記録では伏せる
+// 短い値も伏せられるよう、Authorization と API キーのヘッダー、API キーのクエリパラメーターは位置で伏せる
+//
+//	gofetch -u https://api.example.com/me --bearer '{{env "API_TOKEN"}}'
+//	gofetch -u https://api.example.com/items --api-key api_key='{{file "/run/secrets/key"}}' --api-key-in query
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// requestAuth はリクエストに付ける認証情報
+type requestAuth struct {
+	// authorization は Authorization ヘッダーの値
+	authorization string
+	// keyName と keyValue は API キー。keyInQuery ならクエリパラメーターに付ける
+	keyName, keyValue string
+	keyInQuery        bool
+}
+
+// parseRequestAuth は認証のオプションを解釈する。何も指定がなければ nil を返す
+func parseRequestAuth(basic, bearer, apiKey, apiKeyIn string) (*requestAuth, error) {
+	if basic != "" && bearer != "" {
+		return nil, fmt.Errorf("--auth and --bearer cannot be used together")
+	}
+	if apiKeyIn != "header" && apiKeyIn != "query" {
+		return nil, fmt.Errorf("invalid --api-key-in %q (want header or query)", apiKeyIn)
+	}
+	a := &requestAuth{keyInQuery: apiKeyIn == "query"}
+	switch {
+	case basic != "":
+		user, pass, ok := strings.Cut(basic, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid --auth %q (want user:password)", redactSecrets(basic))
+		}
+		user, err := expandSecrets(user)
+		if err != nil {
+			return nil, fmt.Errorf("--auth: %w", err)
+		}
+		if pass, err = expandSecrets(pass); err != nil {
+			return nil, fmt.Errorf("--auth: %w", err)
+		}
+		registerSecret(pass)
+		credentials := base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+		registerSecret(credentials)
+		a.authorization = "Basic " + credentials
+	case bearer != "":
+		token, err := expandSecrets(bearer)
+		if err != nil {
+			return nil, fmt.Errorf("--bearer: %w", err)
+		}
+		registerSecret(token)
+		a.authorization = "Bearer " + token
+	}
+	if apiKey != "" {
+		name, value, ok := strings.Cut(apiKey, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("invalid --api-key (want NAME=VALUE)")
+		}
+		value, err := expandSecrets(value)
+		if err != nil {
+			return nil, fmt.Errorf("--api-key: %w", err)
+		}
+		registerSecret(value)
+		registerSecret(url.QueryEscape(value))
+		a.keyName, a.keyValue = name, value
+		if !a.keyInQuery && strings.EqualFold(name, "Authorization") && a.authorization != "" {
+			return nil, fmt.Errorf("--api-key Authorization cannot be used with --auth or --bearer")
+		}
+		// ヘッダーの API キーも Authorization と同じく、別のオリジンに転送せず、表示では伏せる
+		// クエリパラメーターの API キーは、表示するURLの中のそのパラメーターの値を伏せる
+		if a.keyInQuery {
+			registerSecretParam(name)
+		} else {
+			addSensitiveHeader(name)
+		}
+	}
+	if a.authorization == "" && a.keyName == "" {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// applyHeader は認証のヘッダーを h に設定する。-H やエイリアスの同じ名前のヘッダーは置き換える
+func (a *requestAuth) applyHeader(h http.Header) {
+	if a.authorization != "" {
+		h.Set("Authorization", a.authorization)
+	}
+	if a.keyName != "" && !a.keyInQuery {
+		h.Set(a.keyName, a.keyValue)
+	}
+}
+
+// applyURL は API キーをクエリパラメーターに付けたURLを返す。同じ名前のパラメーターは置き換える
+func (a *requestAuth) applyURL(rawURL string) (string, error) {
+	if a.keyName == "" || !a.keyInQuery {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set(a.keyName, a.keyValue)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
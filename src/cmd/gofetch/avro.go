@@ -0,0 +1,383 @@
+package main
+
+// Avro の Object Container File の読み取り (--summarize)
+// ヘッダーのスキーマとコーデックを読み、ブロックごとの件数を足して行数を数える
+// 見本の行は先頭のブロックだけを展開し、スキーマに従ってJSONにできる値に戻す
+// コーデックは null、deflate、snappy、zstandard に対応する。それ以外でもスキーマと行数は表示する
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// avroMagic は Object Container File の先頭の4バイト
+var avroMagic = []byte("Obj\x01")
+
+// avroFile は読み取った Object Container File
+type avroFile struct {
+	schema any
+	codec  string
+	// rows はブロックの件数の合計、blocks はブロックの数
+	rows, blocks int64
+	sample       []map[string]any
+	// sampleErr は見本の行を読めなかった理由
+	sampleErr error
+}
+
+// readAvroFile は data を読み、行を最大 sampleRows 件取り出す
+func readAvroFile(data []byte, sampleRows int) (*avroFile, error) {
+	r := &avroReader{data: data, pos: len(avroMagic)}
+	meta := map[string][]byte{}
+	if err := r.blocks(func() error {
+		key, err := r.bytes()
+		if err != nil {
+			return err
+		}
+		meta[string(key)], err = r.bytes()
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("avro header: %w", err)
+	}
+	sync, err := r.next(16)
+	if err != nil {
+		return nil, fmt.Errorf("avro header: %w", err)
+	}
+
+	f := &avroFile{codec: string(meta["avro.codec"])}
+	if f.codec == "" {
+		f.codec = "null"
+	}
+	if err := json.Unmarshal(meta["avro.schema"], &f.schema); err != nil {
+		return nil, fmt.Errorf("avro schema: %w", err)
+	}
+	names := map[string]any{}
+	collectAvroNames(f.schema, "", names)
+
+	for r.pos < len(r.data) {
+		count, err := r.long()
+		if err != nil {
+			return f, fmt.Errorf("avro block %d: %w", f.blocks+1, err)
+		}
+		size, err := r.long()
+		if err != nil {
+			return f, fmt.Errorf("avro block %d: %w", f.blocks+1, err)
+		}
+		block, err := r.next(size)
+		if err != nil {
+			return f, fmt.Errorf("avro block %d: %w", f.blocks+1, err)
+		}
+		if marker, err := r.next(16); err != nil || !bytes.Equal(marker, sync) {
+			return f, fmt.Errorf("avro block %d: sync marker does not match", f.blocks+1)
+		}
+		f.rows += count
+		f.blocks++
+
+		// 見本の行がそろうまでブロックを展開して読む
+		if len(f.sample) >= sampleRows || f.sampleErr != nil {
+			continue
+		}
+		plain, err := avroDecompress(f.codec, block)
+		if err != nil {
+			f.sampleErr = err
+			continue
+		}
+		br := &avroReader{data: plain, names: names}
+		for i := int64(0); i < count && len(f.sample) < sampleRows; i++ {
+			v, err := br.value(f.schema, 0)
+			if err != nil {
+				f.sampleErr = fmt.Errorf("avro block %d row %d: %w", f.blocks, i+1, err)
+				break
+			}
+			row, ok := v.(map[string]any)
+			if !ok {
+				// レコードでないスキーマは value という列1つにする
+				row = map[string]any{"value": v}
+			}
+			f.sample = append(f.sample, row)
+		}
+	}
+	return f, nil
+}
+
+// avroDecompress はブロックを codec に合わせて展開する
+func avroDecompress(codec string, block []byte) ([]byte, error) {
+	switch codec {
+	case "null":
+		return block, nil
+	case "deflate":
+		return io.ReadAll(flate.NewReader(bytes.NewReader(block)))
+	case "snappy":
+		// 末尾の4バイトは展開した内容の CRC32
+		if len(block) < 4 {
+			return nil, errors.New("snappy block too short")
+		}
+		plain, err := snappy.Decode(nil, block[:len(block)-4])
+		if err != nil {
+			return nil, err
+		}
+		if crc32.ChecksumIEEE(plain) != binary.BigEndian.Uint32(block[len(block)-4:]) {
+			return nil, errors.New("snappy block checksum mismatch")
+		}
+		return plain, nil
+	case "zstandard":
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(block, nil)
+	}
+	return nil, fmt.Errorf("avro codec %s is not supported", codec)
+}
+
+// collectAvroNames は名前の付いた型 (record、enum、fixed) を名前で引けるように集める
+func collectAvroNames(schema any, namespace string, names map[string]any) {
+	switch s := schema.(type) {
+	case []any:
+		for _, branch := range s {
+			collectAvroNames(branch, namespace, names)
+		}
+	case map[string]any:
+		if name, ok := s["name"].(string); ok {
+			if ns, ok := s["namespace"].(string); ok {
+				namespace = ns
+			}
+			full := name
+			if !strings.Contains(name, ".") && namespace != "" {
+				full = namespace + "." + name
+			}
+			names[name], names[full] = s, s
+		}
+		if fields, ok := s["fields"].([]any); ok {
+			for _, field := range fields {
+				if m, ok := field.(map[string]any); ok {
+					collectAvroNames(m["type"], namespace, names)
+				}
+			}
+		}
+		for _, key := range []string{"items", "values"} {
+			if inner, ok := s[key]; ok {
+				collectAvroNames(inner, namespace, names)
+			}
+		}
+	}
+}
+
+// avroTypeName はスキーマの型を短く表す。union は null|double のようにする
+func avroTypeName(schema any) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case []any:
+		names := make([]string, len(s))
+		for i, branch := range s {
+			names[i] = avroTypeName(branch)
+		}
+		return strings.Join(names, "|")
+	case map[string]any:
+		typ, _ := s["type"].(string)
+		if logical, ok := s["logicalType"].(string); ok {
+			return fmt.Sprintf("%s (%s)", typ, logical)
+		}
+		switch typ {
+		case "array":
+			return "array<" + avroTypeName(s["items"]) + ">"
+		case "map":
+			return "map<" + avroTypeName(s["values"]) + ">"
+		case "record", "enum", "fixed":
+			name, _ := s["name"].(string)
+			return fmt.Sprintf("%s %s", typ, name)
+		}
+		if typ == "" {
+			return avroTypeName(s["type"])
+		}
+		return typ
+	}
+	return "?"
+}
+
+// avroReader は Avro のバイナリの値を読む
+type avroReader struct {
+	data  []byte
+	pos   int
+	names map[string]any
+}
+
+// next は n バイトを読む
+func (r *avroReader) next(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(r.data)-r.pos) {
+		return nil, errBinaryTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// long はジグザグ符号化の可変長整数を読む。int も同じ形式
+func (r *avroReader) long() (int64, error) {
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		return 0, errBinaryTruncated
+	}
+	r.pos += n
+	return int64(v>>1) ^ -int64(v&1), nil
+}
+
+// bytes は長さの付いたバイト列を読む
+func (r *avroReader) bytes() ([]byte, error) {
+	n, err := r.long()
+	if err != nil {
+		return nil, err
+	}
+	return r.next(n)
+}
+
+// blocks は配列とマップのブロックを読み、要素ごとに item を呼ぶ
+func (r *avroReader) blocks(item func() error) error {
+	for {
+		count, err := r.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		// 件数が負ならブロックのバイト数が続く
+		if count < 0 {
+			count = -count
+			if _, err := r.long(); err != nil {
+				return err
+			}
+		}
+		if count > int64(len(r.data)-r.pos) {
+			return errBinaryTruncated
+		}
+		for i := int64(0); i < count; i++ {
+			if err := item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// value はスキーマに従って値を1つ読む
+func (r *avroReader) value(schema any, depth int) (any, error) {
+	if depth > binaryMaxDepth {
+		return nil, fmt.Errorf("nested deeper than %d levels", binaryMaxDepth)
+	}
+	switch s := schema.(type) {
+	case string:
+		return r.named(s, depth)
+	case []any:
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s)) {
+			return nil, fmt.Errorf("union branch %d out of range", i)
+		}
+		return r.value(s[i], depth+1)
+	case map[string]any:
+		switch typ, _ := s["type"].(string); typ {
+		case "record", "error":
+			fields, _ := s["fields"].([]any)
+			row := make(map[string]any, len(fields))
+			for _, field := range fields {
+				m, _ := field.(map[string]any)
+				name, _ := m["name"].(string)
+				v, err := r.value(m["type"], depth+1)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", name, err)
+				}
+				row[name] = v
+			}
+			return row, nil
+		case "enum":
+			i, err := r.long()
+			if err != nil {
+				return nil, err
+			}
+			symbols, _ := s["symbols"].([]any)
+			if i < 0 || i >= int64(len(symbols)) {
+				return nil, fmt.Errorf("enum index %d out of range", i)
+			}
+			return symbols[i], nil
+		case "array":
+			items := []any{}
+			err := r.blocks(func() error {
+				v, err := r.value(s["items"], depth+1)
+				items = append(items, v)
+				return err
+			})
+			return items, err
+		case "map":
+			values := map[string]any{}
+			err := r.blocks(func() error {
+				key, err := r.bytes()
+				if err != nil {
+					return err
+				}
+				values[string(key)], err = r.value(s["values"], depth+1)
+				return err
+			})
+			return values, err
+		case "fixed":
+			size, _ := s["size"].(float64)
+			return r.next(int64(size))
+		case "":
+			return r.value(s["type"], depth+1)
+		default:
+			// logicalType の付いたプリミティブ型は元の型として読む
+			return r.named(typ, depth)
+		}
+	}
+	return nil, fmt.Errorf("invalid schema %v", schema)
+}
+
+// named はプリミティブ型か、名前で参照した型の値を読む
+func (r *avroReader) named(name string, depth int) (any, error) {
+	switch name {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := r.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		return r.long()
+	case "float":
+		b, err := r.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case "double":
+		b, err := r.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes":
+		return r.bytes()
+	case "string":
+		b, err := r.bytes()
+		return string(b), err
+	}
+	if schema, ok := r.names[name]; ok {
+		return r.value(schema, depth+1)
+	}
+	return nil, fmt.Errorf("unknown type %s", name)
+}
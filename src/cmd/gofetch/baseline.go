@@ -0,0 +1,343 @@
+package main
+
+// ベースラインの記録と検証 (gofetch baseline)
+// URLの一覧についてステータス、一部のヘッダー、本文のハッシュを記録しておき、
+// 後から同じURLを取得して変化(ドリフト)がないかを確認する
+// 改ざんの検知や、CDNへのデプロイが行き渡ったかの確認に使う
+// record で --mask を指定すると毎回変わる値を伏せてからハッシュを計算し、規則はベースラインに記録して verify でも使う
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ベースラインのヘルプメッセージ
+	BaselineHelpMessage = `
+Usage: gofetch baseline <record|verify> [options]
+Commands:
+  record        Fetch URLs and save status, headers and body hash
+  verify        Fetch URLs again and report drift from the baseline
+Options:
+  -i, --input   File with one URL per line, "-" for stdin (record only)
+  -b, --baseline Baseline file (default: baseline.json)
+  --headers     Comma separated headers to record (default: content-type,etag,last-modified)
+  -t, --timeout Timeout in seconds (default: 30)
+  --no-dedup    Fetch duplicate URLs again instead of reusing the first response
+  -p, --parallel Number of URLs fetched at the same time (default: 1)
+  --auto-concurrency Start with 1 and adjust parallelism up to --parallel (default: 16)
+                from error rates and latency, backing off on 429 and 5xx
+  --mask        Volatile value to mask before hashing (repeatable, record only):
+                a JSON path (.meta.request_id), re:<regexp>, or uuid, timestamp, unix-time
+  --mask-file   File with one --mask rule per line (record only)
+`
+)
+
+// baselineEntry はURL1件分の記録
+type baselineEntry struct {
+	URL     string            `json:"url"`
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	SHA256  string            `json:"sha256"`
+}
+
+// baselineFile はベースラインファイル全体
+type baselineFile struct {
+	Created time.Time       `json:"created"`
+	Headers []string        `json:"headers"`
+	Masks   []string        `json:"masks,omitempty"`
+	Entries []baselineEntry `json:"entries"`
+}
+
+// runBaseline は baseline サブコマンドを実行して終了コードを返す
+func runBaseline(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(BaselineHelpMessage)
+		return 0
+	}
+	command := args[0]
+
+	fs := flag.NewFlagSet("baseline "+command, flag.ContinueOnError)
+	input := fs.String("i", "", "File with one URL per line")
+	fs.StringVar(input, "input", "", "File with one URL per line")
+	path := fs.String("b", "baseline.json", "Baseline file")
+	fs.StringVar(path, "baseline", "baseline.json", "Baseline file")
+	headers := fs.String("headers", "content-type,etag,last-modified", "Headers to record")
+	timeout := fs.Int("t", 30, "Timeout in seconds")
+	fs.IntVar(timeout, "timeout", 30, "Timeout in seconds")
+	noDedup := fs.Bool("no-dedup", false, "Do not reuse responses for duplicate requests")
+	parallel := fs.Int("p", 0, "Number of URLs fetched at the same time")
+	fs.IntVar(parallel, "parallel", 0, "Number of URLs fetched at the same time")
+	autoConcurrency := fs.Bool("auto-concurrency", false, "Adjust parallelism from error rates and latency")
+	var maskSpecs stringList
+	fs.Var(&maskSpecs, "mask", "Volatile value to mask before hashing (repeatable)")
+	maskFile := fs.String("mask-file", "", "File with one --mask rule per line")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+
+	maxParallel := *parallel
+	if maxParallel <= 0 {
+		maxParallel = 1
+		if *autoConcurrency {
+			maxParallel = defaultAutoConcurrency
+		}
+	}
+	limiter := newAIMDLimiter(maxParallel, *autoConcurrency)
+	defer limiter.report()
+
+	client := &http.Client{
+		Timeout: time.Duration(*timeout) * time.Second,
+	}
+	// 同じURLが一覧に何度あっても取得は1回にする
+	if !*noDedup {
+		dedup := newDedupTransport(nil)
+		client.Transport = dedup
+		defer dedup.report()
+	}
+
+	switch command {
+	case "record":
+		if *input == "" {
+			fmt.Println("Error: --input is required")
+			fmt.Print(BaselineHelpMessage)
+			return 1
+		}
+		urls, err := readURLList(*input)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		masks, err := loadMaskRules(maskSpecs, *maskFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		return recordBaseline(client, limiter, urls, splitList(*headers), masks, *path)
+	case "verify":
+		// 記録したときと同じ規則で比べる
+		if len(maskSpecs) > 0 || *maskFile != "" {
+			fmt.Println("Error: --mask and --mask-file apply to record; verify uses the masks stored in the baseline")
+			return 1
+		}
+		return verifyBaseline(client, limiter, *path)
+	default:
+		fmt.Println("Error: unknown baseline command:", command)
+		fmt.Print(BaselineHelpMessage)
+		return 1
+	}
+}
+
+// fetchBaselineEntries はURLを並列に取得し、URLの順に結果を返す
+// レスポンスの種類ごとのレイテンシも集計する
+func fetchBaselineEntries(client *http.Client, limiter *aimdLimiter, urls []string, headers []string, masks maskRules) ([]baselineEntry, []error, *latencyBreakdown) {
+	entries := make([]baselineEntry, len(urls))
+	errs := make([]error, len(urls))
+	latencies := newLatencyBreakdown()
+	runLimited(len(urls), limiter, func(i int) (int, error) {
+		start := time.Now()
+		entries[i], errs[i] = fetchBaselineEntry(client, urls[i], headers, masks)
+		latencies.add(entries[i].Status, errs[i], time.Since(start))
+		return entries[i].Status, errs[i]
+	})
+	return entries, errs, latencies
+}
+
+// recordBaseline はURLを取得してベースラインファイルに保存する
+// masks があれば伏せてからハッシュを計算し、規則も保存する
+func recordBaseline(client *http.Client, limiter *aimdLimiter, urls []string, headers []string, masks maskRules, path string) int {
+	f := baselineFile{Created: time.Now().UTC(), Headers: headers, Masks: masks.specs()}
+	failed := false
+	entries, errs, latencies := fetchBaselineEntries(client, limiter, urls, headers, masks)
+	for i, u := range urls {
+		entry, err := entries[i], errs[i]
+		if err != nil {
+			fmt.Printf("ERROR  %s: %v\n", u, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("OK     %s (%d, sha256 %s)\n", u, entry.Status, entry.SHA256[:12])
+		f.Entries = append(f.Entries, entry)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	fmt.Printf("Recorded %d URLs to %s\n", len(f.Entries), path)
+	latencies.print(os.Stdout)
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// verifyBaseline はベースラインファイルのURLを取得し直して差分を表示する
+// ドリフトやエラーがあれば1を返す
+func verifyBaseline(client *http.Client, limiter *aimdLimiter, path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	var f baselineFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		fmt.Println("Error:", path+":", err)
+		return 1
+	}
+
+	masks, err := loadMaskRules(f.Masks, "")
+	if err != nil {
+		fmt.Println("Error:", path+":", err)
+		return 1
+	}
+	urls := make([]string, len(f.Entries))
+	for i, e := range f.Entries {
+		urls[i] = e.URL
+	}
+	entries, errs, latencies := fetchBaselineEntries(client, limiter, urls, f.Headers, masks)
+
+	drifted := 0
+	for i, want := range f.Entries {
+		got, err := entries[i], errs[i]
+		if err != nil {
+			fmt.Printf("ERROR  %s: %v\n", want.URL, err)
+			drifted++
+			continue
+		}
+		diffs := diffBaselineEntry(want, got)
+		if len(diffs) == 0 {
+			fmt.Printf("OK     %s\n", want.URL)
+			continue
+		}
+		drifted++
+		fmt.Printf("DRIFT  %s\n", want.URL)
+		for _, d := range diffs {
+			fmt.Printf("       %s\n", d)
+		}
+	}
+
+	fmt.Printf("%d of %d URLs drifted from %s (recorded %s)\n", drifted, len(f.Entries), path, f.Created.Format(time.RFC3339))
+	latencies.print(os.Stdout)
+	if drifted > 0 {
+		return 1
+	}
+	return 0
+}
+
+// fetchBaselineEntry はURLを取得して記録用のエントリを作る
+// masks があれば本文を読んでから伏せてハッシュを計算する
+func fetchBaselineEntry(client *http.Client, u string, headers []string, masks maskRules) (baselineEntry, error) {
+	resp, err := client.Get(u)
+	if err != nil {
+		return baselineEntry{}, err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if len(masks) > 0 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return baselineEntry{}, err
+		}
+		h.Write(masks.apply(body))
+	} else if _, err := io.Copy(h, resp.Body); err != nil {
+		return baselineEntry{}, err
+	}
+	entry := baselineEntry{
+		URL:    u,
+		Status: resp.StatusCode,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}
+	for _, name := range headers {
+		if v := resp.Header.Get(name); v != "" {
+			if entry.Headers == nil {
+				entry.Headers = map[string]string{}
+			}
+			entry.Headers[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	return entry, nil
+}
+
+// diffBaselineEntry は記録と今回の結果の違いを列挙する
+func diffBaselineEntry(want, got baselineEntry) []string {
+	var diffs []string
+	if want.Status != got.Status {
+		diffs = append(diffs, fmt.Sprintf("status: %d -> %d", want.Status, got.Status))
+	}
+
+	names := map[string]bool{}
+	for k := range want.Headers {
+		names[k] = true
+	}
+	for k := range got.Headers {
+		names[k] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for k := range names {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	for _, k := range sorted {
+		if want.Headers[k] != got.Headers[k] {
+			diffs = append(diffs, fmt.Sprintf("header %s: %q -> %q", k, want.Headers[k], got.Headers[k]))
+		}
+	}
+
+	if want.SHA256 != got.SHA256 {
+		diffs = append(diffs, fmt.Sprintf("body sha256: %s -> %s", want.SHA256, got.SHA256))
+	}
+	return diffs
+}
+
+// readURLList はURLを1行に1つ書いたファイルを読み込む
+// 空行と # で始まる行は無視する。path が "-" の場合は標準入力から読む
+func readURLList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// splitList はカンマ区切りの文字列を分割し、空の要素を取り除く
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
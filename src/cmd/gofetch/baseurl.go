@@ -0,0 +1,76 @@
+package main
+
+// ベースURLとパスだけの指定 (--base-url, GOFETCH_BASE_URL)
+// ベースURLを設定しておくと "gofetch /users/42" のようにパスだけで呼び出せる
+// ベースURLのパスは残し、その後ろにパスをつなげる (RFC 3986 の相対参照の解決とは異なる)
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// baseURLEnv はベースURLを指定する環境変数
+const baseURLEnv = "GOFETCH_BASE_URL"
+
+// isPathOnly はスキームもホストもないパスだけの指定かどうかを返す
+func isPathOnly(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//")
+}
+
+// joinBaseURL はベースURLにパスとクエリをつなげる
+// パスの各要素とクエリはエスケープし直すので、空白などをそのまま書いてもよい
+func joinBaseURL(base, ref string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid base URL %q (want scheme://host[/path])", base)
+	}
+
+	ref, fragment, _ := strings.Cut(ref, "#")
+	path, query, _ := strings.Cut(ref, "?")
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, seg := range segments {
+		if unescaped, err := url.PathUnescape(seg); err == nil {
+			seg = unescaped
+		}
+		segments[i] = url.PathEscape(seg)
+	}
+	escaped := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + strings.Join(segments, "/")
+	joined, err := url.Parse(u.Scheme + "://" + u.Host + escaped)
+	if err != nil {
+		return "", err
+	}
+
+	// ベースURLのクエリに指定されたクエリを順番を保ったまま追加する
+	var params []string
+	if u.RawQuery != "" {
+		params = append(params, u.RawQuery)
+	}
+	if query != "" {
+		for _, kv := range strings.Split(query, "&") {
+			k, v, hasValue := strings.Cut(kv, "=")
+			k = escapeQueryPart(k)
+			if hasValue {
+				k += "=" + escapeQueryPart(v)
+			}
+			params = append(params, k)
+		}
+	}
+	joined.RawQuery = strings.Join(params, "&")
+	joined.User = u.User
+	if fragment != "" {
+		joined.Fragment = fragment
+	}
+	return joined.String(), nil
+}
+
+// escapeQueryPart はクエリのキーまたは値をエスケープし直す
+func escapeQueryPart(s string) string {
+	if unescaped, err := url.QueryUnescape(s); err == nil {
+		s = unescaped
+	}
+	return url.QueryEscape(s)
+}
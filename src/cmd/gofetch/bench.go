@@ -0,0 +1,879 @@
+package main
+
+// 負荷の生成 (gofetch bench)
+// シナリオファイルに書いた複数のターゲットへ重みに応じてリクエストを振り分け、
+// 「80% は GET /list、20% は POST /create」のような混ざった負荷を生成する
+// ターゲットごとにリクエスト数、エラー、レイテンシのパーセンタイルを表示する
+//
+//	base_url: https://api.example.com
+//	targets:
+//	  - name: list
+//	    weight: 80
+//	    url: /list
+//	  - name: create
+//	    weight: 20
+//	    method: POST
+//	    url: /create
+//	    headers:
+//	      Content-Type: application/json
+//	    body: '{"name":"bench"}'
+//
+// 既定はクローズドモデルで、仮想ユーザーはレスポンスを受け取ってから think_time だけ待って次を送る
+// rate を指定するとオープンモデルになり、レスポンスを待たずに毎秒 rate 件の予定どおりに送る
+// 仮想ユーザーがすべて使用中ならその回は送らず、落とした数として数える
+//
+//	think_time: 500ms          # 固定
+//	think_time: 200ms-1s       # 一様分布
+//	think_time: exp:500ms      # 平均 500ms の指数分布
+//	rate: 50
+//
+// サーバーが遅くなると送る予定だったリクエストが送られず、遅い時間帯が統計から抜け落ちる
+// (coordinated omission)。そのため実測のレイテンシに加えて、予定の時刻から数えた補正済みの
+// レイテンシも表示する。オープンモデルでは予定の送信時刻から完了までを測り、
+// クローズドモデルでは HdrHistogram と同じく、想定の間隔を超えた分だけ抜けたはずの値を補う
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// benchのヘルプメッセージ
+	BenchHelpMessage = `
+Usage: gofetch bench [options] (--scenario <file> | -u <url>)
+Options:
+  --scenario    YAML file with weighted targets (base_url, targets: name, weight,
+                method, url, headers, body)
+  -u, --url     Single target URL (instead of --scenario)
+  -c, --concurrency Number of virtual users (default: 10)
+  -d, --duration Test duration (default: 10s)
+  -n, --requests Stop after this many requests in total (default: no limit)
+  --think-time  Pause between a virtual user's requests: 500ms, 200ms-1s (uniform)
+                or exp:500ms (exponential with that mean) (closed model only)
+  --rate        Send this many requests per second on a fixed schedule (open model);
+                -c caps the requests in flight
+  -t, --timeout Timeout per request in seconds (default: 30)
+  --live        Show a dashboard on stderr while running (RPS, error rate,
+                rolling p50/p99 and an RPS sparkline), updated every second
+  --results-json Write the results as one JSON line per run to this file
+  --results-csv Write the results as CSV rows (one per target) to this file
+  --results-append Append to the results files instead of overwriting them
+  --run-id      Run ID recorded in the results (default: timestamp and random suffix)
+  --compare-to  Compare with the last run in a --results-json file and print the deltas
+  --max-regression Fail when a metric gets worse than this versus --compare-to
+                (metric=N% relative or metric=N absolute; metrics: rps, p50_ms,
+                p90_ms, p99_ms, corrected_p99_ms, error_rate; default: p99_ms=10%,rps=10%)
+  --coordinator Listen on this address (e.g. :7070), send the scenario to --workers
+                workers and merge their results into one report
+  --workers     Number of workers the coordinator waits for (default: 1)
+  --worker      Connect to the coordinator at this address and run the load it sends
+                (-c, -d, -n and --rate are per run; the rate and request count are
+                split between workers). The connection is not encrypted and carries
+                the scenario headers; use a trusted network or an SSH tunnel
+  --chaos-drop-rate Fail this share of requests without sending them (e.g. 5% or 0.05)
+  --chaos-latency Add latency before each request: 200ms, 50ms-500ms (uniform) or
+                exp:100ms (exponential with that mean)
+  --chaos-abort-after-bytes Abort each response body after this many bytes (e.g. 4KB)
+                Injected faults are counted as the "chaos" response class
+  --sample-bodies Save a random sample of this many requests and their responses
+                (up to 1MB of body each) for spot-checking (default: none)
+  --sample-dir  Directory for --sample-bodies (default: bench-samples)
+  --new-connections Open a new connection for every request instead of reusing them
+  --local-port-range Bind outgoing connections to source ports in LOW-HIGH (busy
+                ports are skipped; fails when none is free), e.g. 40000-40100
+  --local-port-order Pick source ports sequential (default) or random
+  --verbose     Print each failed request to stderr
+`
+)
+
+// benchTarget はシナリオのターゲット1つ
+type benchTarget struct {
+	Name    string            `yaml:"name"`
+	Weight  int               `yaml:"weight"`
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+// benchScenario はシナリオファイル全体
+type benchScenario struct {
+	BaseURL string `yaml:"base_url"`
+	// ThinkTime は仮想ユーザーがリクエストの間に待つ時間
+	ThinkTime string `yaml:"think_time"`
+	// Rate は毎秒のリクエスト数。0より大きければオープンモデルになる
+	Rate    float64       `yaml:"rate"`
+	Targets []benchTarget `yaml:"targets"`
+}
+
+// loadBenchScenario はシナリオファイルを読み込み、ターゲットのURLとメソッドを補う
+func loadBenchScenario(path string) (*benchScenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s benchScenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.normalize(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// normalize はターゲットの名前、重み、メソッド、URLの既定値を埋める
+func (s *benchScenario) normalize() error {
+	if len(s.Targets) == 0 {
+		return fmt.Errorf("no targets")
+	}
+	for i := range s.Targets {
+		t := &s.Targets[i]
+		if t.URL == "" {
+			return fmt.Errorf("target %d: url is required", i+1)
+		}
+		if s.BaseURL != "" && isPathOnly(t.URL) {
+			u, err := joinBaseURL(s.BaseURL, t.URL)
+			if err != nil {
+				return fmt.Errorf("target %d: %w", i+1, err)
+			}
+			t.URL = u
+		}
+		if t.Name == "" {
+			t.Name = t.URL
+		}
+		if t.Weight < 0 {
+			return fmt.Errorf("target %s: weight must not be negative", t.Name)
+		}
+		if t.Weight == 0 {
+			t.Weight = 1
+		}
+		t.Method = strings.ToUpper(t.Method)
+		if t.Method == "" {
+			t.Method = http.MethodGet
+		}
+		for k, v := range t.Headers {
+			expanded, err := expandSecretHeader(k, v)
+			if err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+			t.Headers[k] = expanded
+		}
+		body, err := expandSecrets(t.Body)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		t.Body = body
+	}
+	return nil
+}
+
+// thinkTime はリクエストの間に待つ時間の分布
+type thinkTime struct {
+	// kind は fixed, uniform, exp のいずれか
+	kind     string
+	min, max time.Duration
+}
+
+// parseThinkTime は 500ms, 200ms-1s, exp:500ms の形式の待ち時間を解釈する
+func parseThinkTime(s string) (thinkTime, error) {
+	if s == "" {
+		return thinkTime{}, nil
+	}
+	if mean, ok := strings.CutPrefix(s, "exp:"); ok {
+		d, err := time.ParseDuration(mean)
+		if err != nil || d < 0 {
+			return thinkTime{}, fmt.Errorf("invalid think time %q", s)
+		}
+		return thinkTime{kind: "exp", min: d, max: d}, nil
+	}
+	if lo, hi, ok := strings.Cut(s, "-"); ok {
+		minD, err1 := time.ParseDuration(lo)
+		maxD, err2 := time.ParseDuration(hi)
+		if err1 != nil || err2 != nil || minD < 0 || maxD < minD {
+			return thinkTime{}, fmt.Errorf("invalid think time %q (want MIN-MAX, e.g. 200ms-1s)", s)
+		}
+		return thinkTime{kind: "uniform", min: minD, max: maxD}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return thinkTime{}, fmt.Errorf("invalid think time %q", s)
+	}
+	return thinkTime{kind: "fixed", min: d, max: d}, nil
+}
+
+// sample は分布から待ち時間を1つ選ぶ
+func (t thinkTime) sample(r *rand.Rand) time.Duration {
+	switch t.kind {
+	case "uniform":
+		return t.min + time.Duration(r.Int64N(int64(t.max-t.min)+1))
+	case "exp":
+		return time.Duration(r.ExpFloat64() * float64(t.min))
+	}
+	return t.min
+}
+
+// mean は待ち時間の平均を返す
+func (t thinkTime) mean() time.Duration {
+	if t.kind == "uniform" {
+		return (t.min + t.max) / 2
+	}
+	return t.min
+}
+
+// String は待ち時間の分布を表示用に返す
+func (t thinkTime) String() string {
+	switch t.kind {
+	case "":
+		return "none"
+	case "uniform":
+		return fmt.Sprintf("%s-%s uniform", t.min, t.max)
+	case "exp":
+		return fmt.Sprintf("exponential, mean %s", t.min)
+	}
+	return t.min.String()
+}
+
+// pick は重みに応じてターゲットを1つ選ぶ
+func (s *benchScenario) pick(r *rand.Rand) int {
+	total := 0
+	for _, t := range s.Targets {
+		total += t.Weight
+	}
+	n := r.IntN(total)
+	for i, t := range s.Targets {
+		if n < t.Weight {
+			return i
+		}
+		n -= t.Weight
+	}
+	return len(s.Targets) - 1
+}
+
+// benchStats はターゲット1つ分の集計
+type benchStats struct {
+	Requests  int
+	Errors    int
+	Statuses  map[int]int
+	Latencies []time.Duration
+	// Corrected は coordinated omission を補正したレイテンシ
+	Corrected []time.Duration
+	// Classes はエラーも含めたレスポンスの種類ごとのレイテンシ
+	Classes *latencyBreakdown
+}
+
+// benchRecorder はすべてのターゲットの集計を並行して記録する
+type benchRecorder struct {
+	mu    sync.Mutex
+	stats []benchStats
+	total int
+	limit int
+	// dropped はオープンモデルで仮想ユーザーが空いておらず送らなかった数
+	dropped int
+}
+
+// newBenchRecorder は n 個のターゲットの集計を作る。limit が0より大きければその数で打ち切る
+func newBenchRecorder(n, limit int) *benchRecorder {
+	r := &benchRecorder{stats: make([]benchStats, n), limit: limit}
+	for i := range r.stats {
+		r.stats[i].Statuses = map[int]int{}
+		r.stats[i].Classes = newLatencyBreakdown()
+	}
+	return r
+}
+
+// reserve は次のリクエストを送ってよいかを返す。--requests の上限に達していれば false
+func (r *benchRecorder) reserve() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.limit > 0 && r.total >= r.limit {
+		return false
+	}
+	r.total++
+	return true
+}
+
+// drop は送らなかったリクエストを数える
+func (r *benchRecorder) drop() {
+	r.mu.Lock()
+	r.dropped++
+	r.mu.Unlock()
+}
+
+// take はここまでの集計を返し、集計を空にする。--requests の上限のための数は残す
+func (r *benchRecorder) take() []benchStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	taken := r.stats
+	r.stats = make([]benchStats, len(taken))
+	for i := range r.stats {
+		r.stats[i].Statuses = map[int]int{}
+		r.stats[i].Classes = newLatencyBreakdown()
+	}
+	return taken
+}
+
+// merge は別の集計をターゲットごとに加える
+func (r *benchRecorder) merge(stats []benchStats, dropped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, other := range stats {
+		if i >= len(r.stats) {
+			break
+		}
+		s := &r.stats[i]
+		s.Requests += other.Requests
+		s.Errors += other.Errors
+		for code, n := range other.Statuses {
+			s.Statuses[code] += n
+		}
+		s.Latencies = append(s.Latencies, other.Latencies...)
+		s.Corrected = append(s.Corrected, other.Corrected...)
+		if other.Classes != nil {
+			s.Classes.merge(other.Classes)
+		}
+		r.total += other.Requests
+	}
+	r.dropped += dropped
+}
+
+// record はリクエスト1件の結果を記録する
+// corrected は予定の送信時刻から数えたレイテンシ。5xx とエラーを失敗として数える
+func (r *benchRecorder) record(target, status int, latency, corrected time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := &r.stats[target]
+	s.Requests++
+	s.Classes.add(status, err, latency)
+	if err != nil {
+		s.Errors++
+		return
+	}
+	s.Statuses[status]++
+	if status >= 500 {
+		s.Errors++
+	}
+	s.Latencies = append(s.Latencies, latency)
+	s.Corrected = append(s.Corrected, corrected)
+}
+
+// correct はクローズドモデルの補正済みレイテンシに、想定の間隔 interval ごとに
+// 送られるはずだったリクエストの値を補う (HdrHistogram の recordValueWithExpectedInterval と同じ)
+func (r *benchRecorder) correct(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.stats {
+		s := &r.stats[i]
+		for _, latency := range s.Latencies {
+			for missing := latency - interval; missing >= interval; missing -= interval {
+				s.Corrected = append(s.Corrected, missing)
+			}
+		}
+	}
+}
+
+// expectedInterval はクローズドモデルで仮想ユーザーが1件ごとに使うと想定する間隔を返す
+// 実測のレイテンシの中央値に平均の待ち時間を足したもの
+func (r *benchRecorder) expectedInterval(think thinkTime) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []time.Duration
+	for _, s := range r.stats {
+		all = append(all, s.Latencies...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return percentile(all, 50) + think.mean()
+}
+
+// sendBenchRequest はターゲットにリクエストを1件送り、本文を読み捨てる
+// sample があればレスポンスを記録する
+func sendBenchRequest(client *http.Client, t benchTarget, sample *benchSample) (int, error) {
+	var body io.Reader
+	if t.Body != "" {
+		body = strings.NewReader(t.Body)
+	}
+	req, err := http.NewRequest(t.Method, t.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if sample != nil {
+		return resp.StatusCode, sample.capture(resp)
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+// runBench は bench サブコマンドを実行して終了コードを返す
+func runBench(args []string) int {
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		fmt.Print(BenchHelpMessage)
+		return 0
+	}
+
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	scenarioPath := fs.String("scenario", "", "YAML file with weighted targets")
+	target := fs.String("u", "", "Single target URL")
+	fs.StringVar(target, "url", "", "Single target URL")
+	concurrency := fs.Int("c", 10, "Number of virtual users")
+	fs.IntVar(concurrency, "concurrency", 10, "Number of virtual users")
+	duration := fs.Duration("d", 10*time.Second, "Test duration")
+	fs.DurationVar(duration, "duration", 10*time.Second, "Test duration")
+	requests := fs.Int("n", 0, "Stop after this many requests")
+	fs.IntVar(requests, "requests", 0, "Stop after this many requests")
+	thinkFlag := fs.String("think-time", "", "Pause between a virtual user's requests")
+	rate := fs.Float64("rate", 0, "Requests per second (open model)")
+	timeout := fs.Int("t", 30, "Timeout in seconds")
+	fs.IntVar(timeout, "timeout", 30, "Timeout in seconds")
+	live := fs.Bool("live", false, "Show a dashboard on stderr while running")
+	resultsJSON := fs.String("results-json", "", "Write the results as JSON lines to this file")
+	resultsCSV := fs.String("results-csv", "", "Write the results as CSV to this file")
+	resultsAppend := fs.Bool("results-append", false, "Append to the results files")
+	runID := fs.String("run-id", "", "Run ID recorded in the results")
+	compareTo := fs.String("compare-to", "", "Compare with the last run in this results file")
+	maxRegression := fs.String("max-regression", defaultMaxRegression, "Regression thresholds for --compare-to")
+	coordinator := fs.String("coordinator", "", "Listen on this address and run the scenario on workers")
+	workers := fs.Int("workers", 1, "Number of workers the coordinator waits for")
+	workerAddr := fs.String("worker", "", "Run the load sent by the coordinator at this address")
+	chaosDrop := fs.String("chaos-drop-rate", "", "Fail this share of requests without sending them")
+	chaosLatency := fs.String("chaos-latency", "", "Add latency before each request")
+	chaosAbort := fs.String("chaos-abort-after-bytes", "", "Abort each response body after this many bytes")
+	sampleBodies := fs.Int("sample-bodies", 0, "Save a random sample of this many responses")
+	sampleDir := fs.String("sample-dir", "bench-samples", "Directory for --sample-bodies")
+	newConnections := fs.Bool("new-connections", false, "Open a new connection for every request")
+	localPorts := fs.String("local-port-range", "", "Source ports for outgoing connections as LOW-HIGH")
+	localPortOrder := fs.String("local-port-order", "sequential", "Pick source ports sequential or random")
+	fs.BoolVar(&verbose, "verbose", false, "Print each failed request to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	// ワーカーはシナリオも設定もコーディネーターから受け取る
+	if *workerAddr != "" {
+		return runBenchWorker(*workerAddr)
+	}
+
+	var scenario *benchScenario
+	switch {
+	case *scenarioPath != "":
+		var err error
+		scenario, err = loadBenchScenario(*scenarioPath)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	case *target != "":
+		scenario = &benchScenario{Targets: []benchTarget{{URL: *target}}}
+		if err := scenario.normalize(); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	default:
+		fmt.Println("Error: --scenario or -u is required")
+		fmt.Print(BenchHelpMessage)
+		return 1
+	}
+	if *concurrency < 1 {
+		fmt.Println("Error: --concurrency must be at least 1")
+		return 1
+	}
+	if *coordinator != "" {
+		if *workers < 1 {
+			fmt.Println("Error: --workers must be at least 1")
+			return 1
+		}
+		if *requests > 0 && *requests < *workers {
+			fmt.Println("Error: --requests must be at least the number of --workers")
+			return 1
+		}
+		// 本文はワーカーが受け取るので、コーディネーターでは抜き取れない
+		if *sampleBodies > 0 {
+			fmt.Println("Error: --sample-bodies cannot be used with --coordinator")
+			return 1
+		}
+	}
+	if *sampleBodies < 0 {
+		fmt.Println("Error: --sample-bodies must not be negative")
+		return 1
+	}
+
+	// 比較する前回の結果は試験を始める前に読み込んで誤りを見つける
+	var previous benchRunResult
+	var limits map[string]regressionLimit
+	if *compareTo != "" {
+		var err error
+		if previous, err = loadPreviousBenchRun(*compareTo); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if limits, err = parseMaxRegression(*maxRegression); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	if *thinkFlag != "" {
+		scenario.ThinkTime = *thinkFlag
+	}
+	if *rate != 0 {
+		scenario.Rate = *rate
+	}
+	think, err := parseThinkTime(scenario.ThinkTime)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if scenario.Rate < 0 {
+		fmt.Println("Error: --rate must not be negative")
+		return 1
+	}
+	if scenario.Rate > 0 && think.kind != "" {
+		fmt.Println("Error: --think-time applies to the closed model only and cannot be combined with --rate")
+		return 1
+	}
+
+	load := benchLoad{Concurrency: *concurrency, Duration: *duration, Requests: *requests, Timeout: time.Duration(*timeout) * time.Second,
+		NewConnections: *newConnections, LocalPorts: *localPorts, LocalPortOrder: *localPortOrder}
+	if load.LocalPorts != "" {
+		if _, err := parseLocalPortRange(load.LocalPorts, load.LocalPortOrder); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	load.Chaos.Latency = *chaosLatency
+	if load.Chaos.DropRate, err = parseRate(*chaosDrop); err != nil {
+		fmt.Println("Error: invalid --chaos-drop-rate:", *chaosDrop)
+		return 1
+	}
+	if *chaosAbort != "" {
+		if load.Chaos.AbortAfter, err = parseSize(*chaosAbort); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if err := load.Chaos.validate(); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	var dashboard *liveDashboard
+	var observe func(time.Duration, bool)
+	if *live {
+		dashboard = newLiveDashboard(liveWindow)
+		observe = dashboard.observe
+		go dashboard.run()
+	}
+	var rec *benchRecorder
+	var start time.Time
+	var elapsed time.Duration
+	var sampler *benchSampler
+	failed := false
+	if *coordinator != "" {
+		// 負荷はワーカーがかけ、ここでは集計をまとめるだけにする
+		rec = newBenchRecorder(len(scenario.Targets), 0)
+		start, elapsed, err = coordinateBench(*coordinator, *workers, scenario, load, rec, observe)
+		if err != nil {
+			fmt.Println("Error:", err)
+			failed = true
+		}
+		load.Concurrency *= *workers
+	} else {
+		if *sampleBodies > 0 {
+			sampler = newBenchSampler(*sampleBodies)
+		}
+		rec = newBenchRecorder(len(scenario.Targets), *requests)
+		start = time.Now()
+		elapsed = generateBenchLoad(scenario, think, load, rec, observe, sampler)
+	}
+	if dashboard != nil {
+		dashboard.close()
+	}
+
+	correction := "latency from the scheduled send time"
+	if scenario.Rate <= 0 {
+		interval := rec.expectedInterval(think)
+		rec.correct(interval)
+		correction = fmt.Sprintf("expected interval %s per virtual user", interval.Round(100*time.Microsecond))
+	}
+	printBenchSummary(scenario, rec, elapsed, correction)
+	if rec.dropped > 0 {
+		fmt.Fprintf(os.Stderr, "Dropped: %d scheduled request(s) not sent because all %d virtual user(s) were busy\n", rec.dropped, load.Concurrency)
+	}
+
+	// 抜き取ったレスポンスの保存
+	if sampler != nil {
+		n, err := sampler.save(*sampleDir)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Samples: %d request(s) and response(s) saved to %s\n", n, *sampleDir)
+	}
+
+	// 結果の書き出し
+	if *runID == "" {
+		*runID = newBenchRunID(start)
+	}
+	result := newBenchRunResult(*runID, start, scenario, rec, elapsed, load.Concurrency)
+	if *resultsJSON != "" {
+		if err := writeResultsJSON(*resultsJSON, result, *resultsAppend); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if *resultsCSV != "" {
+		if err := writeResultsCSV(*resultsCSV, result, *resultsAppend); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if *resultsJSON != "" || *resultsCSV != "" {
+		fmt.Fprintf(os.Stderr, "Results: run %s written\n", *runID)
+	}
+
+	// 前回との比較
+	if *compareTo != "" && !compareBenchRuns(previous, result, limits) {
+		fmt.Println("Error: performance regressed beyond --max-regression")
+		return 1
+	}
+	for _, s := range rec.stats {
+		if s.Errors > 0 {
+			return 1
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// benchLoad は負荷のかけ方。Concurrency は仮想ユーザーの数、Requests が0より大きければその数で打ち切る
+type benchLoad struct {
+	Concurrency int
+	Duration    time.Duration
+	Requests    int
+	Timeout     time.Duration
+	// Chaos はクライアント側で注入する障害
+	Chaos benchChaos
+	// NewConnections ならリクエストごとに新しく接続する
+	NewConnections bool
+	// LocalPorts は接続元のポートの範囲、LocalPortOrder はその選び方
+	LocalPorts     string
+	LocalPortOrder string
+}
+
+// generateBenchLoad はシナリオどおりに負荷をかけて rec に記録し、かかった時間を返す
+// observe があればリクエストごとにレイテンシと失敗したかを渡す。sampler があればレスポンスを抜き取る
+func generateBenchLoad(scenario *benchScenario, think thinkTime, load benchLoad, rec *benchRecorder, observe func(time.Duration, bool), sampler *benchSampler) time.Duration {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = load.Concurrency
+	transport.DisableKeepAlives = load.NewConnections
+	var ports *localPortRange
+	if load.LocalPorts != "" {
+		// 範囲は実行の前に確かめてある
+		ports, _ = parseLocalPortRange(load.LocalPorts, load.LocalPortOrder)
+		transport.DialContext = ports.dialContext(net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})
+	}
+	client := &http.Client{Timeout: load.Timeout, Transport: transport}
+	if load.Chaos.enabled() {
+		client.Transport = newChaosTransport(transport, load.Chaos)
+		fmt.Fprintf(os.Stderr, "Chaos: %s\n", load.Chaos)
+	}
+
+	// send は重みに応じてターゲットを選び、リクエストを1件送って記録する
+	// at は予定の送信時刻。ゼロなら実際に送った時刻を使う
+	send := func(r *rand.Rand, at time.Time) {
+		ti := scenario.pick(r)
+		t := scenario.Targets[ti]
+		reqStart := time.Now()
+		if at.IsZero() {
+			at = reqStart
+		}
+		var sample *benchSample
+		if sampler != nil {
+			sample = sampler.pick(t)
+		}
+		status, err := sendBenchRequest(client, t, sample)
+		if err != nil {
+			verbosef("%s: %v", t.Name, err)
+		}
+		latency := time.Since(reqStart)
+		if sample != nil {
+			sample.latency, sample.err = latency, err
+		}
+		rec.record(ti, status, latency, time.Since(at), err)
+		if observe != nil {
+			observe(latency, err != nil || status >= 500)
+		}
+	}
+
+	start := time.Now()
+	deadline := start.Add(load.Duration)
+	var wg sync.WaitGroup
+	if scenario.Rate > 0 {
+		fmt.Fprintf(os.Stderr, "Running an open model at %g req/s for %s (up to %d in flight) against %d target(s)\n", scenario.Rate, load.Duration, load.Concurrency, len(scenario.Targets))
+		// 予定の時刻はレスポンスの遅れに関係なく start から一定の間隔で進む
+		interval := time.Duration(float64(time.Second) / scenario.Rate)
+		slots := make(chan struct{}, load.Concurrency)
+		r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		for i := 0; ; i++ {
+			at := start.Add(time.Duration(i) * interval)
+			if !at.Before(deadline) {
+				break
+			}
+			time.Sleep(time.Until(at))
+			select {
+			case slots <- struct{}{}:
+			default:
+				rec.drop()
+				continue
+			}
+			if !rec.reserve() {
+				break
+			}
+			seed := r.Uint64()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-slots }()
+				send(rand.New(rand.NewPCG(seed, seed)), at)
+			}()
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Running a closed model with %d virtual user(s) for %s (think time %s) against %d target(s)\n", load.Concurrency, load.Duration, think, len(scenario.Targets))
+		for i := 0; i < load.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+				for time.Now().Before(deadline) && rec.reserve() {
+					send(r, time.Time{})
+					if pause := think.sample(r); pause > 0 {
+						time.Sleep(min(pause, time.Until(deadline)))
+					}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	if ports != nil {
+		fmt.Fprintf(os.Stderr, "Local ports: %s\n", ports)
+	}
+	return elapsed
+}
+
+// percentile は昇順に並んだレイテンシの p パーセンタイルを返す
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(i, 0), len(sorted)-1)]
+}
+
+// printBenchSummary はターゲットごとと全体の集計を表にして表示する
+// correction は補正済みレイテンシの表に添える補正の方法
+func printBenchSummary(scenario *benchScenario, rec *benchRecorder, elapsed time.Duration, correction string) {
+	ms := roundLatency
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSHARE\tREQUESTS\tERRORS\tRPS\tP50\tP90\tP99\tMAX\tSTATUS")
+	var all benchStats
+	all.Statuses = map[int]int{}
+	all.Classes = newLatencyBreakdown()
+	for i, t := range scenario.Targets {
+		s := rec.stats[i]
+		all.Requests += s.Requests
+		all.Errors += s.Errors
+		all.Latencies = append(all.Latencies, s.Latencies...)
+		all.Corrected = append(all.Corrected, s.Corrected...)
+		all.Classes.merge(s.Classes)
+		for code, n := range s.Statuses {
+			all.Statuses[code] += n
+		}
+		printBenchRow(tw, t.Name, s, rec.total, elapsed, ms)
+	}
+	if len(scenario.Targets) > 1 {
+		printBenchRow(tw, "(all)", all, rec.total, elapsed, ms)
+	}
+	tw.Flush()
+
+	fmt.Printf("\nCorrected for coordinated omission (%s):\n", correction)
+	fmt.Fprintln(tw, "TARGET\tSAMPLES\tP50\tP90\tP99\tMAX")
+	for i, t := range scenario.Targets {
+		printCorrectedRow(tw, t.Name, rec.stats[i].Corrected, ms)
+	}
+	if len(scenario.Targets) > 1 {
+		printCorrectedRow(tw, "(all)", all.Corrected, ms)
+	}
+	tw.Flush()
+
+	fmt.Println("\nBy response class:")
+	fmt.Fprintln(tw, "TARGET\tCLASS\tCOUNT\tP50\tP90\tP99\tMAX")
+	for i, t := range scenario.Targets {
+		rec.stats[i].Classes.writeRows(tw, t.Name+"\t")
+	}
+	if len(scenario.Targets) > 1 {
+		all.Classes.writeRows(tw, "(all)\t")
+	}
+	tw.Flush()
+}
+
+// printCorrectedRow は補正済みレイテンシの集計1行を書く
+func printCorrectedRow(tw io.Writer, name string, latencies []time.Duration, ms func(time.Duration) string) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var maxLatency time.Duration
+	if n := len(latencies); n > 0 {
+		maxLatency = latencies[n-1]
+	}
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", name, len(latencies),
+		ms(percentile(latencies, 50)), ms(percentile(latencies, 90)), ms(percentile(latencies, 99)), ms(maxLatency))
+}
+
+// printBenchRow は集計1行を書く
+func printBenchRow(tw io.Writer, name string, s benchStats, total int, elapsed time.Duration, ms func(time.Duration) string) {
+	sort.Slice(s.Latencies, func(i, j int) bool { return s.Latencies[i] < s.Latencies[j] })
+	share := 0.0
+	if total > 0 {
+		share = 100 * float64(s.Requests) / float64(total)
+	}
+	codes := make([]int, 0, len(s.Statuses))
+	for code := range s.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	var statuses []string
+	for _, code := range codes {
+		statuses = append(statuses, fmt.Sprintf("%d:%d", code, s.Statuses[code]))
+	}
+	var maxLatency time.Duration
+	if n := len(s.Latencies); n > 0 {
+		maxLatency = s.Latencies[n-1]
+	}
+	fmt.Fprintf(tw, "%s\t%.1f%%\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s\n", name, share, s.Requests, s.Errors,
+		float64(s.Requests)/elapsed.Seconds(),
+		ms(percentile(s.Latencies, 50)), ms(percentile(s.Latencies, 90)), ms(percentile(s.Latencies, 99)), ms(maxLatency),
+		orDash(strings.Join(statuses, " ")))
+}
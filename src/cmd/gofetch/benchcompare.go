@@ -0,0 +1,172 @@
+package main
+
+// 前回の負荷試験の結果との比較 (bench --compare-to)
+// --results-json で書き出した結果の最後の実行と今回を比べ、指標ごとの差を表示する
+// しきい値を超えて悪化した指標があれば失敗にし、性能の劣化を止める関門として使えるようにする
+//
+//	gofetch bench --scenario s.yaml --compare-to nightly.jsonl --max-regression 'p99_ms=10%,rps=5%,error_rate=1'
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// defaultMaxRegression は --max-regression を省略したときのしきい値
+const defaultMaxRegression = "p99_ms=10%,rps=10%"
+
+// benchMetric は比較する指標
+type benchMetric struct {
+	Name string
+	// HigherIsBetter は値が大きいほど良い指標か
+	HigherIsBetter bool
+	Value          func(benchTargetResult) float64
+}
+
+// benchMetrics は比較する指標の一覧
+var benchMetrics = []benchMetric{
+	{"rps", true, func(t benchTargetResult) float64 { return t.RPS }},
+	{"p50_ms", false, func(t benchTargetResult) float64 { return t.P50MS }},
+	{"p90_ms", false, func(t benchTargetResult) float64 { return t.P90MS }},
+	{"p99_ms", false, func(t benchTargetResult) float64 { return t.P99MS }},
+	{"corrected_p99_ms", false, func(t benchTargetResult) float64 { return t.CorrP99MS }},
+	{"error_rate", false, func(t benchTargetResult) float64 {
+		if t.Requests == 0 {
+			return 0
+		}
+		return round3(100 * float64(t.Errors) / float64(t.Requests))
+	}},
+}
+
+// regressionLimit は指標1つのしきい値
+// Relative なら前回に対する割合(%)、そうでなければ指標の単位での差
+type regressionLimit struct {
+	Limit    float64
+	Relative bool
+}
+
+// parseMaxRegression は "p99_ms=10%,error_rate=1" の形のしきい値を解釈する
+func parseMaxRegression(spec string) (map[string]regressionLimit, error) {
+	limits := map[string]regressionLimit{}
+	for _, part := range splitList(spec) {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid regression threshold %q (want metric=N%% or metric=N)", part)
+		}
+		name = strings.TrimSpace(name)
+		known := false
+		for _, m := range benchMetrics {
+			if m.Name == name {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown metric %q in regression threshold", name)
+		}
+		value = strings.TrimSpace(value)
+		relative := strings.HasSuffix(value, "%")
+		n, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid regression threshold %q", part)
+		}
+		limits[name] = regressionLimit{Limit: n, Relative: relative}
+	}
+	return limits, nil
+}
+
+// loadPreviousBenchRun は結果のファイルから最後の実行を読み込む
+func loadPreviousBenchRun(path string) (benchRunResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return benchRunResult{}, err
+	}
+	defer f.Close()
+	var last string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			last = line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return benchRunResult{}, err
+	}
+	if last == "" {
+		return benchRunResult{}, fmt.Errorf("%s: no bench results", path)
+	}
+	var r benchRunResult
+	if err := json.Unmarshal([]byte(last), &r); err != nil {
+		return benchRunResult{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if r.Schema != benchResultsSchema {
+		return benchRunResult{}, fmt.Errorf("%s: unsupported results schema %d (want %d)", path, r.Schema, benchResultsSchema)
+	}
+	return r, nil
+}
+
+// compareBenchRuns は前回と今回の指標をターゲットごとに並べて表示する
+// しきい値を超えて悪化した指標があれば false を返す
+func compareBenchRuns(prev, cur benchRunResult, limits map[string]regressionLimit) bool {
+	fmt.Printf("\nCompared to run %s (%s):\n", prev.RunID, prev.Timestamp.Format("2006-01-02 15:04:05"))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tMETRIC\tPREVIOUS\tCURRENT\tCHANGE\tRESULT")
+	previous := map[string]benchTargetResult{}
+	for _, t := range prev.Targets {
+		previous[t.Target] = t
+	}
+	ok := true
+	for _, t := range cur.Targets {
+		p, found := previous[t.Target]
+		if !found {
+			fmt.Fprintf(tw, "%s\t-\t-\t-\t-\tnew target\n", t.Target)
+			continue
+		}
+		for _, m := range benchMetrics {
+			before, after := m.Value(p), m.Value(t)
+			result := ""
+			if limit, checked := limits[m.Name]; checked {
+				result = "ok"
+				if regressed(m, before, after, limit) {
+					result = "REGRESSED"
+					ok = false
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Target, m.Name,
+				strconv.FormatFloat(before, 'f', -1, 64), strconv.FormatFloat(after, 'f', -1, 64), formatChange(before, after), result)
+		}
+	}
+	tw.Flush()
+	return ok
+}
+
+// regressed は指標がしきい値を超えて悪化したかを返す
+func regressed(m benchMetric, before, after float64, limit regressionLimit) bool {
+	worse := after - before
+	if m.HigherIsBetter {
+		worse = before - after
+	}
+	if !limit.Relative {
+		return worse > limit.Limit
+	}
+	if before == 0 {
+		return worse > 0
+	}
+	return 100*worse/math.Abs(before) > limit.Limit
+}
+
+// formatChange は前回からの変化を割合で表す
+func formatChange(before, after float64) string {
+	if before == 0 {
+		if after == 0 {
+			return "0%"
+		}
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(after-before)/math.Abs(before))
+}
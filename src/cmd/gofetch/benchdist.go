@@ -0,0 +1,308 @@
+package main
+
+// 複数のマシンでの負荷の生成 (bench --coordinator, --worker)
+// 1台では出せない負荷をかけるため、コーディネーターが待ち受けてワーカーの接続を待ち、
+// 揃ったらシナリオを送ってすべてのワーカーで同時に実行する
+// ワーカーは1秒ごとにその間の集計を送り、コーディネーターはそれをまとめて1つの結果として表示する
+// --rate と --requests はワーカーの間で分け、-c と -d はワーカーごとに使う
+//
+//	gofetch bench --scenario s.yaml --coordinator :7070 --workers 3 -c 50 -d 1m
+//	gofetch bench --worker coordinator.example.com:7070
+//
+// やりとりは TCP 上の JSON Lines。暗号化しないので信頼できるネットワークかSSHトンネルの中で使う
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// benchWireVersion はコーディネーターとワーカーの間の形式の版。形式を変えたら上げる
+	benchWireVersion = 1
+	// benchWireInterval はワーカーが集計を送る間隔
+	benchWireInterval = time.Second
+	// benchWireSilence はこの間ワーカーから何も届かなければ切れたとみなす時間
+	benchWireSilence = 30 * time.Second
+)
+
+// benchMessage はコーディネーターとワーカーの間でやりとりする1行
+// Type は hello (ワーカーから), start (コーディネーターから), stats, done, error
+type benchMessage struct {
+	Type    string           `json:"type"`
+	Version int              `json:"version,omitempty"`
+	Worker  string           `json:"worker,omitempty"`
+	Job     *benchJob        `json:"job,omitempty"`
+	Stats   []benchWireStats `json:"stats,omitempty"`
+	Dropped int              `json:"dropped,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// benchJob はワーカー1台に送る仕事
+type benchJob struct {
+	Scenario benchScenario `json:"scenario"`
+	Load     benchLoad     `json:"load"`
+}
+
+// benchWireStats は送るときのターゲット1つ分の集計。レイテンシはナノ秒
+type benchWireStats struct {
+	Requests  int                        `json:"requests"`
+	Errors    int                        `json:"errors"`
+	Statuses  map[int]int                `json:"statuses,omitempty"`
+	Latencies []time.Duration            `json:"latencies,omitempty"`
+	Corrected []time.Duration            `json:"corrected,omitempty"`
+	Classes   map[string][]time.Duration `json:"classes,omitempty"`
+}
+
+// toWireStats は集計を送る形式にする
+func toWireStats(stats []benchStats) []benchWireStats {
+	wire := make([]benchWireStats, len(stats))
+	for i, s := range stats {
+		s.Classes.mu.Lock()
+		wire[i] = benchWireStats{
+			Requests:  s.Requests,
+			Errors:    s.Errors,
+			Statuses:  s.Statuses,
+			Latencies: s.Latencies,
+			Corrected: s.Corrected,
+			Classes:   s.Classes.samples,
+		}
+		s.Classes.mu.Unlock()
+	}
+	return wire
+}
+
+// fromWireStats は受け取った集計を元の形式に戻す
+func fromWireStats(wire []benchWireStats) []benchStats {
+	stats := make([]benchStats, len(wire))
+	for i, w := range wire {
+		classes := newLatencyBreakdown()
+		for class, samples := range w.Classes {
+			classes.samples[class] = samples
+		}
+		stats[i] = benchStats{
+			Requests:  w.Requests,
+			Errors:    w.Errors,
+			Statuses:  w.Statuses,
+			Latencies: w.Latencies,
+			Corrected: w.Corrected,
+			Classes:   classes,
+		}
+	}
+	return stats
+}
+
+// benchWorkerConn はコーディネーターにつながったワーカー1台
+type benchWorkerConn struct {
+	name string
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// receive は次の1行を読む。benchWireSilence の間何も届かなければエラーにする
+func (w *benchWorkerConn) receive() (benchMessage, error) {
+	var m benchMessage
+	w.conn.SetReadDeadline(time.Now().Add(benchWireSilence))
+	err := w.dec.Decode(&m)
+	return m, err
+}
+
+// splitShare は total を n 台で分けたときの i 台目 (0から) の分を返す。余りは先頭から配る
+func splitShare(total, n, i int) int {
+	share := total / n
+	if i < total%n {
+		share++
+	}
+	return share
+}
+
+// coordinateBench は addr で workers 台のワーカーを待ち、シナリオを送って実行させ、
+// 届いた集計を rec にまとめる。始めた時刻とかかった時間を返す
+// observe があれば届いたリクエストごとにレイテンシと失敗したかを渡す
+func coordinateBench(addr string, workers int, scenario *benchScenario, load benchLoad, rec *benchRecorder, observe func(time.Duration, bool)) (time.Time, time.Duration, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	defer ln.Close()
+	fmt.Fprintf(os.Stderr, "Coordinator listening on %s, waiting for %d worker(s)\n", ln.Addr(), workers)
+
+	var conns []*benchWorkerConn
+	defer func() {
+		for _, w := range conns {
+			w.conn.Close()
+		}
+	}()
+	for len(conns) < workers {
+		c, err := ln.Accept()
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		w := &benchWorkerConn{conn: c, enc: json.NewEncoder(c), dec: json.NewDecoder(c)}
+		hello, err := w.receive()
+		if err != nil || hello.Type != "hello" {
+			fmt.Fprintf(os.Stderr, "Ignoring connection from %s: not a gofetch worker\n", c.RemoteAddr())
+			c.Close()
+			continue
+		}
+		if hello.Version != benchWireVersion {
+			w.enc.Encode(benchMessage{Type: "error", Error: fmt.Sprintf("coordinator speaks version %d, worker speaks %d", benchWireVersion, hello.Version)})
+			fmt.Fprintf(os.Stderr, "Rejecting worker %s (%s): version %d, want %d\n", hello.Worker, c.RemoteAddr(), hello.Version, benchWireVersion)
+			c.Close()
+			continue
+		}
+		w.name = fmt.Sprintf("%s (%s)", hello.Worker, c.RemoteAddr())
+		conns = append(conns, w)
+		fmt.Fprintf(os.Stderr, "Worker %d/%d connected: %s\n", len(conns), workers, w.name)
+	}
+
+	// 毎秒のリクエスト数と合計のリクエスト数はワーカーの間で分ける
+	start := time.Now()
+	for i, w := range conns {
+		job := benchJob{Scenario: *scenario, Load: load}
+		job.Scenario.Rate = scenario.Rate / float64(workers)
+		if load.Requests > 0 {
+			job.Load.Requests = splitShare(load.Requests, workers, i)
+		}
+		if err := w.enc.Encode(benchMessage{Type: "start", Job: &job}); err != nil {
+			return start, time.Since(start), fmt.Errorf("worker %s: %w", w.name, err)
+		}
+	}
+
+	errs := make([]error, len(conns))
+	requests := make([]int, len(conns))
+	var wg sync.WaitGroup
+	for i, w := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				m, err := w.receive()
+				if err != nil {
+					errs[i] = fmt.Errorf("worker %s: %w", w.name, err)
+					return
+				}
+				switch m.Type {
+				case "stats", "done":
+					stats := fromWireStats(m.Stats)
+					rec.merge(stats, m.Dropped)
+					for _, s := range stats {
+						requests[i] += s.Requests
+						if observe == nil {
+							continue
+						}
+						// 種類ごとの記録にはエラーも含めたすべてのリクエストがある
+						for class, samples := range s.Classes.samples {
+							failed := class != "2xx" && class != "3xx" && class != "4xx"
+							for _, latency := range samples {
+								observe(latency, failed)
+							}
+						}
+					}
+					if m.Type == "done" {
+						return
+					}
+				case "error":
+					errs[i] = fmt.Errorf("worker %s: %s", w.name, m.Error)
+					return
+				default:
+					errs[i] = fmt.Errorf("worker %s: unexpected message %q", w.name, m.Type)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	for i, w := range conns {
+		status := "done"
+		if errs[i] != nil {
+			status = "failed"
+		}
+		fmt.Fprintf(os.Stderr, "Worker %s: %d request(s), %s\n", w.name, requests[i], status)
+	}
+	return start, elapsed, errors.Join(errs...)
+}
+
+// runBenchWorker はコーディネーターにつないで、送られたシナリオで負荷をかけ、集計を送り返す
+func runBenchWorker(addr string) int {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	host, _ := os.Hostname()
+	if err := enc.Encode(benchMessage{Type: "hello", Version: benchWireVersion, Worker: host}); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Connected to coordinator %s, waiting for the scenario\n", addr)
+
+	var m benchMessage
+	if err := dec.Decode(&m); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	switch {
+	case m.Type == "error":
+		fmt.Println("Error: coordinator:", m.Error)
+		return 1
+	case m.Type != "start" || m.Job == nil:
+		fmt.Printf("Error: unexpected message %q from coordinator\n", m.Type)
+		return 1
+	}
+	job := m.Job
+	think, err := parseThinkTime(job.Scenario.ThinkTime)
+	if err == nil && len(job.Scenario.Targets) == 0 {
+		err = fmt.Errorf("no targets")
+	}
+	if err == nil {
+		err = job.Load.Chaos.validate()
+	}
+	if err != nil {
+		enc.Encode(benchMessage{Type: "error", Error: err.Error()})
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	// 実行中は1秒ごとにその間の集計を送る
+	rec := newBenchRecorder(len(job.Scenario.Targets), job.Load.Requests)
+	stop := make(chan struct{})
+	sent := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(benchWireInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := enc.Encode(benchMessage{Type: "stats", Stats: toWireStats(rec.take())}); err != nil {
+					sent <- err
+					return
+				}
+			case <-stop:
+				sent <- nil
+				return
+			}
+		}
+	}()
+	elapsed := generateBenchLoad(&job.Scenario, think, job.Load, rec, nil, nil)
+	close(stop)
+	if err := <-sent; err != nil {
+		fmt.Println("Error: sending results to the coordinator:", err)
+		return 1
+	}
+	if err := enc.Encode(benchMessage{Type: "done", Stats: toWireStats(rec.take()), Dropped: rec.dropped}); err != nil {
+		fmt.Println("Error: sending results to the coordinator:", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Sent %d request(s) over %s to the coordinator\n", rec.total, elapsed.Round(time.Millisecond))
+	return 0
+}
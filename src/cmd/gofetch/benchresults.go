@@ -0,0 +1,192 @@
+package main
+
+// 負荷試験の結果の書き出し (bench --results-json, --results-csv)
+// 夜間のCIなどで結果を時系列のグラフにできるよう、決まった形式で書き出す
+// --results-append を付けると既存のファイルに実行IDと時刻付きで追記する
+// JSONは1回の実行を1行にしたJSON Lines、CSVはターゲット1つを1行にする
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// benchResultsSchema は書き出す形式の版。列や項目を変えたら上げる
+const benchResultsSchema = 1
+
+// benchTargetResult はターゲット1つ分の結果。"(all)" は全ターゲットの合計
+type benchTargetResult struct {
+	Target    string  `json:"target"`
+	Method    string  `json:"method,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	RPS       float64 `json:"rps"`
+	P50MS     float64 `json:"p50_ms"`
+	P90MS     float64 `json:"p90_ms"`
+	P99MS     float64 `json:"p99_ms"`
+	MaxMS     float64 `json:"max_ms"`
+	CorrP50MS float64 `json:"corrected_p50_ms"`
+	CorrP99MS float64 `json:"corrected_p99_ms"`
+}
+
+// benchRunResult は1回の実行の結果
+type benchRunResult struct {
+	Schema      int                 `json:"schema"`
+	RunID       string              `json:"run_id"`
+	Timestamp   time.Time           `json:"timestamp"`
+	Model       string              `json:"model"`
+	Concurrency int                 `json:"concurrency"`
+	Rate        float64             `json:"rate,omitempty"`
+	DurationS   float64             `json:"duration_s"`
+	Dropped     int                 `json:"dropped"`
+	Targets     []benchTargetResult `json:"targets"`
+}
+
+// newBenchRunID は時刻と乱数から実行IDを作る
+func newBenchRunID(t time.Time) string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return t.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// newBenchRunResult は集計から書き出す結果を作る
+func newBenchRunResult(runID string, start time.Time, scenario *benchScenario, rec *benchRecorder, elapsed time.Duration, concurrency int) benchRunResult {
+	r := benchRunResult{
+		Schema:      benchResultsSchema,
+		RunID:       runID,
+		Timestamp:   start.UTC().Truncate(time.Millisecond),
+		Model:       "closed",
+		Concurrency: concurrency,
+		DurationS:   round3(elapsed.Seconds()),
+		Dropped:     rec.dropped,
+	}
+	if scenario.Rate > 0 {
+		r.Model, r.Rate = "open", scenario.Rate
+	}
+	var all benchStats
+	for i, t := range scenario.Targets {
+		s := rec.stats[i]
+		all.Requests += s.Requests
+		all.Errors += s.Errors
+		all.Latencies = append(all.Latencies, s.Latencies...)
+		all.Corrected = append(all.Corrected, s.Corrected...)
+		r.Targets = append(r.Targets, newBenchTargetResult(t.Name, t.Method, t.URL, s, elapsed))
+	}
+	r.Targets = append(r.Targets, newBenchTargetResult("(all)", "", "", all, elapsed))
+	return r
+}
+
+// newBenchTargetResult はターゲット1つ分の集計から結果を作る
+func newBenchTargetResult(name, method, url string, s benchStats, elapsed time.Duration) benchTargetResult {
+	latencies := sortedDurations(s.Latencies)
+	corrected := sortedDurations(s.Corrected)
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	r := benchTargetResult{
+		Target:    name,
+		Method:    method,
+		URL:       url,
+		Requests:  s.Requests,
+		Errors:    s.Errors,
+		RPS:       round3(float64(s.Requests) / elapsed.Seconds()),
+		P50MS:     ms(percentile(latencies, 50)),
+		P90MS:     ms(percentile(latencies, 90)),
+		P99MS:     ms(percentile(latencies, 99)),
+		CorrP50MS: ms(percentile(corrected, 50)),
+		CorrP99MS: ms(percentile(corrected, 99)),
+	}
+	if n := len(latencies); n > 0 {
+		r.MaxMS = ms(latencies[n-1])
+	}
+	return r
+}
+
+// round3 は小数点以下3桁に丸める
+func round3(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// sortedDurations は昇順に並べた複製を返す
+func sortedDurations(d []time.Duration) []time.Duration {
+	s := append([]time.Duration(nil), d...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s
+}
+
+// openResultsFile は書き出し先を開く。appendTo なら末尾に追記し、ファイルが空だったかも返す
+func openResultsFile(path string, appendTo bool) (*os.File, bool, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, false, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, false, err
+	}
+	return f, fi.Size() == 0, nil
+}
+
+// writeResultsJSON は実行の結果を1行のJSONとして書く
+func writeResultsJSON(path string, r benchRunResult, appendTo bool) error {
+	f, _, err := openResultsFile(path, appendTo)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s\n", data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// benchResultsColumns はCSVの列。順序も形式の一部
+var benchResultsColumns = []string{
+	"schema", "run_id", "timestamp", "model", "concurrency", "rate", "duration_s", "dropped",
+	"target", "method", "url", "requests", "errors", "rps",
+	"p50_ms", "p90_ms", "p99_ms", "max_ms", "corrected_p50_ms", "corrected_p99_ms",
+}
+
+// writeResultsCSV は実行の結果をターゲットごとに1行のCSVとして書く
+// 新しいファイルか空のファイルにだけ見出しの行を書く
+func writeResultsCSV(path string, r benchRunResult, appendTo bool) error {
+	f, empty, err := openResultsFile(path, appendTo)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if empty {
+		w.Write(benchResultsColumns)
+	}
+	num := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, t := range r.Targets {
+		w.Write([]string{
+			strconv.Itoa(r.Schema), r.RunID, r.Timestamp.Format(time.RFC3339), r.Model,
+			strconv.Itoa(r.Concurrency), num(r.Rate), num(r.DurationS), strconv.Itoa(r.Dropped),
+			t.Target, t.Method, t.URL, strconv.Itoa(t.Requests), strconv.Itoa(t.Errors), num(t.RPS),
+			num(t.P50MS), num(t.P90MS), num(t.P99MS), num(t.MaxMS), num(t.CorrP50MS), num(t.CorrP99MS),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
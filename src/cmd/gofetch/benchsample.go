@@ -0,0 +1,137 @@
+package main
+
+// 負荷試験中のレスポンスの抜き取り (bench --sample-bodies, --sample-dir)
+// すべてのリクエストから N 件を偏りなく選び (reservoir sampling)、送ったリクエストと
+// 受け取ったレスポンスの本文を --sample-dir に保存して、負荷をかけた状態でも正しい内容が
+// 返っているかを後から確かめられるようにする。選ばれなかったレスポンスの本文は読み捨てる
+// ファイルは --save-failures と同じく HTTP の形のテキストで、先頭の # の行にターゲットと結果を書く
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// benchSampleMaxBody は抜き取ったレスポンスの本文を保存する上限。超えた分は読み捨てる
+const benchSampleMaxBody = 1 << 20
+
+// benchSample は抜き取ったリクエストとレスポンス1件
+type benchSample struct {
+	seq     int
+	target  benchTarget
+	latency time.Duration
+	err     error
+	// proto、status、header、body はレスポンスを受け取った場合だけ入る
+	proto     string
+	status    string
+	header    http.Header
+	body      []byte
+	truncated bool
+}
+
+// benchSampler はリクエストから決まった数を偏りなく抜き取る
+type benchSampler struct {
+	mu      sync.Mutex
+	r       *rand.Rand
+	seen    int
+	samples []*benchSample
+}
+
+// newBenchSampler は n 件を抜き取る benchSampler を作る
+func newBenchSampler(n int) *benchSampler {
+	return &benchSampler{r: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())), samples: make([]*benchSample, 0, n)}
+}
+
+// pick はリクエストを送る前に呼び、このリクエストを抜き取るなら記録先を返す。抜き取らなければ nil
+// i 件目は n/i の確率で選ばれ、選ばれたら先に選んだものの1つと入れ替わる
+func (s *benchSampler) pick(t benchTarget) *benchSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	sample := &benchSample{seq: s.seen, target: t}
+	if len(s.samples) < cap(s.samples) {
+		s.samples = append(s.samples, sample)
+		return sample
+	}
+	if j := s.r.IntN(s.seen); j < len(s.samples) {
+		s.samples[j] = sample
+		return sample
+	}
+	return nil
+}
+
+// capture はレスポンスの本文を上限まで sample に記録し、残りを読み捨てる
+func (sample *benchSample) capture(resp *http.Response) error {
+	sample.proto, sample.status, sample.header = resp.Proto, resp.Status, resp.Header
+	var err error
+	sample.body, err = io.ReadAll(io.LimitReader(resp.Body, benchSampleMaxBody))
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	sample.truncated = n > 0
+	return err
+}
+
+// save は抜き取ったものを送った順に dir に保存し、保存した数を返す
+func (s *benchSampler) save(dir string) (int, error) {
+	s.mu.Lock()
+	samples := append([]*benchSample(nil), s.samples...)
+	s.mu.Unlock()
+	if len(samples) == 0 {
+		return 0, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].seq < samples[j].seq })
+	for i, sample := range samples {
+		name := fmt.Sprintf("%04d-%s%s", i+1, unsafeFileChars.ReplaceAllString(sample.target.Name, "_"), failureFileSuffix)
+		if err := os.WriteFile(filepath.Join(dir, name), sample.bytes(), 0644); err != nil {
+			return i, err
+		}
+	}
+	return len(samples), nil
+}
+
+// bytes は保存するファイルの内容を返す
+func (sample *benchSample) bytes() []byte {
+	t := sample.target
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# target %s, request %d, %s\n", t.Name, sample.seq, roundLatency(sample.latency))
+	if sample.err != nil {
+		fmt.Fprintf(&b, "# error: %s\n", redactSecrets(sample.err.Error()))
+	}
+	if sample.truncated {
+		fmt.Fprintf(&b, "# body truncated to %s\n", formatSize(benchSampleMaxBody))
+	}
+	fmt.Fprintf(&b, "%s %s\r\n", t.Method, redactSecrets(t.URL))
+	names := make([]string, 0, len(t.Headers))
+	for name := range t.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, redactSecrets(maskSensitive(name, t.Headers[name])))
+	}
+	b.WriteString("\r\n")
+	if t.Body != "" {
+		b.WriteString(redactSecrets(t.Body))
+		b.WriteString("\r\n\r\n")
+	}
+	if sample.status == "" {
+		return b.Bytes()
+	}
+	fmt.Fprintf(&b, "%s %s\r\n", sample.proto, sample.status)
+	sample.header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(sample.body)
+	return b.Bytes()
+}
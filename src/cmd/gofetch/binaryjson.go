@@ -0,0 +1,563 @@
+package main
+
+// MessagePack と CBOR のレスポンスの表示 (--decode)
+// バイナリの MessagePack と CBOR の本文をJSONにして、整形して表示する。JSONにした本文には --jq、--table、--csv も使える
+// auto (既定) は Content-Type が application/msgpack や application/cbor、+cbor のときにだけ変換し、
+// -o のファイルには --jq などを指定しなければ受け取ったまま書く。msgpack か cbor を指定すると Content-Type に
+// かかわらずその形式として読み、-o にもJSONを書く。none なら変換しない
+// マップのキーの順序は受け取ったとおりに残す。文字列でないキーはJSONにした表記を文字列にして使う
+// バイナリは base64 の文字列に、MessagePack の timestamp と CBOR の日時のタグは RFC 3339 の文字列にする
+// そのほかの拡張型は {"ext": 型, "data": base64}、タグは {"tag": 番号, "value": 値} の形にする
+//
+//	gofetch -u https://internal.example.com/v1/state --jq nodes.0.name
+//	gofetch -u https://internal.example.com/v1/state --decode msgpack -o state.json
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// binaryMaxDepth は入れ子の深さの上限。壊れた本文で再帰が深くなりすぎないようにする
+const binaryMaxDepth = 512
+
+// errBinaryTruncated は値の途中で本文が終わった場合のエラー
+var errBinaryTruncated = errors.New("unexpected end of data")
+
+// parseDecodeFormat は --decode の値を確かめる
+func parseDecodeFormat(s string) (string, error) {
+	switch s {
+	case "auto", "msgpack", "cbor", "none":
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid --decode %q (want auto, msgpack, cbor or none)", s)
+}
+
+// binaryFormatOf は Content-Type から MessagePack か CBOR かを判定する。どちらでもなければ空文字列を返す
+func binaryFormatOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == "application/msgpack" || mediaType == "application/x-msgpack" || mediaType == "application/vnd.msgpack":
+		return "msgpack"
+	case mediaType == "application/cbor" || strings.HasSuffix(mediaType, "+cbor"):
+		return "cbor"
+	}
+	return ""
+}
+
+// decodeBinaryJSON は format ("msgpack" か "cbor") の本文をJSONにする
+func decodeBinaryJSON(format string, body []byte) ([]byte, error) {
+	d := &binaryDecoder{data: body}
+	var err error
+	if format == "msgpack" {
+		err = d.msgpackValue()
+	} else {
+		err = d.cborValue()
+	}
+	if err == nil && d.pos < len(d.data) {
+		err = fmt.Errorf("%d byte(s) of unexpected data after the value", len(d.data)-d.pos)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the response as %s at byte %d: %w", format, d.pos, err)
+	}
+	return d.out.Bytes(), nil
+}
+
+// binaryDecoder は MessagePack か CBOR を読み、JSONを out に書く
+type binaryDecoder struct {
+	data  []byte
+	pos   int
+	depth int
+	out   bytes.Buffer
+}
+
+// next は n バイトを読む
+func (d *binaryDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errBinaryTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// uint は n バイトのビッグエンディアンの符号なし整数を読む
+func (d *binaryDecoder) uint(n int) (uint64, error) {
+	b, err := d.next(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// enter は入れ子を1段深くする
+func (d *binaryDecoder) enter() error {
+	if d.depth++; d.depth > binaryMaxDepth {
+		return fmt.Errorf("nested deeper than %d levels", binaryMaxDepth)
+	}
+	return nil
+}
+
+// writeString はJSONの文字列を書く
+func (d *binaryDecoder) writeString(s string) {
+	// json.Marshal と違い、<、>、& をエスケープしない
+	enc := json.NewEncoder(&d.out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	d.out.Truncate(d.out.Len() - 1)
+}
+
+// writeFloat は bits の精度で浮動小数点数を書く
+func (d *binaryDecoder) writeFloat(f float64, bits int) {
+	// JSON は NaN と無限大を表せないので文字列にする
+	switch {
+	case math.IsNaN(f):
+		d.writeString("NaN")
+	case math.IsInf(f, 1):
+		d.writeString("Infinity")
+	case math.IsInf(f, -1):
+		d.writeString("-Infinity")
+	default:
+		d.out.WriteString(strconv.FormatFloat(f, 'g', -1, bits))
+	}
+}
+
+// writeTime は日時を RFC 3339 の文字列で書く
+func (d *binaryDecoder) writeTime(t time.Time) {
+	d.writeString(t.UTC().Format(time.RFC3339Nano))
+}
+
+// sequence は n 個の要素を配列として書く。n が負なら item が終わりを返すまで続ける
+func (d *binaryDecoder) sequence(n int64, item func() error) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	d.out.WriteByte('[')
+	for i := int64(0); n < 0 || i < n; i++ {
+		if n < 0 && d.atBreak() {
+			break
+		}
+		if i > 0 {
+			d.out.WriteByte(',')
+		}
+		if err := item(); err != nil {
+			return err
+		}
+	}
+	d.out.WriteByte(']')
+	d.depth--
+	return nil
+}
+
+// mapping は n 組のキーと値をオブジェクトとして書く。n が負なら終わりの印まで続ける
+func (d *binaryDecoder) mapping(n int64, value func() error) error {
+	if err := d.enter(); err != nil {
+		return err
+	}
+	d.out.WriteByte('{')
+	for i := int64(0); n < 0 || i < n; i++ {
+		if n < 0 && d.atBreak() {
+			break
+		}
+		if i > 0 {
+			d.out.WriteByte(',')
+		}
+		// キーを書いてみて、文字列でなければその表記を文字列にする
+		start := d.out.Len()
+		if err := value(); err != nil {
+			return err
+		}
+		if key := d.out.Bytes()[start:]; len(key) == 0 || key[0] != '"' {
+			text := string(key)
+			d.out.Truncate(start)
+			d.writeString(text)
+		}
+		d.out.WriteByte(':')
+		if err := value(); err != nil {
+			return err
+		}
+	}
+	d.out.WriteByte('}')
+	d.depth--
+	return nil
+}
+
+// atBreak は CBOR の長さを決めない配列やマップの終わり (0xff) なら読み進めて true を返す
+func (d *binaryDecoder) atBreak() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// msgpackValue は MessagePack の値を1つ読む
+func (d *binaryDecoder) msgpackValue() error {
+	head, err := d.next(1)
+	if err != nil {
+		return err
+	}
+	b := head[0]
+	switch {
+	case b <= 0x7f:
+		d.out.WriteString(strconv.Itoa(int(b)))
+		return nil
+	case b >= 0xe0:
+		d.out.WriteString(strconv.Itoa(int(int8(b))))
+		return nil
+	case b&0xf0 == 0x80:
+		return d.mapping(int64(b&0x0f), d.msgpackValue)
+	case b&0xf0 == 0x90:
+		return d.sequence(int64(b&0x0f), d.msgpackValue)
+	case b&0xe0 == 0xa0:
+		return d.readString(uint64(b & 0x1f))
+	}
+
+	// 長さや値の大きさを持つ形式は、同じ種類の先頭の値からの差で続くバイト数 (1, 2, 4, 8) が決まる
+	switch b {
+	case 0xc0:
+		d.out.WriteString("null")
+	case 0xc2:
+		d.out.WriteString("false")
+	case 0xc3:
+		d.out.WriteString("true")
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return err
+		}
+		data, err := d.next(n)
+		if err != nil {
+			return err
+		}
+		d.writeString(base64.StdEncoding.EncodeToString(data))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (b - 0xc7))
+		if err != nil {
+			return err
+		}
+		return d.msgpackExt(n)
+	case 0xca:
+		v, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(float64(math.Float32frombits(uint32(v))), 32)
+	case 0xcb:
+		v, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(math.Float64frombits(v), 64)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (b - 0xcc))
+		if err != nil {
+			return err
+		}
+		d.out.WriteString(strconv.FormatUint(v, 10))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		v, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		// 符号を広げる
+		shift := 64 - 8*size
+		d.out.WriteString(strconv.FormatInt(int64(v<<shift)>>shift, 10))
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.msgpackExt(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return err
+		}
+		return d.readString(n)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return err
+		}
+		return d.sequence(int64(n), d.msgpackValue)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return err
+		}
+		return d.mapping(int64(n), d.msgpackValue)
+	default:
+		return fmt.Errorf("invalid msgpack type byte 0x%02x", b)
+	}
+	return nil
+}
+
+// readString は n バイトの文字列を読む
+func (d *binaryDecoder) readString(n uint64) error {
+	s, err := d.next(n)
+	if err != nil {
+		return err
+	}
+	d.writeString(string(s))
+	return nil
+}
+
+// msgpackExt は長さ n の拡張型を読む。型 -1 は timestamp
+func (d *binaryDecoder) msgpackExt(n uint64) error {
+	typ, err := d.next(1)
+	if err != nil {
+		return err
+	}
+	data, err := d.next(n)
+	if err != nil {
+		return err
+	}
+	if int8(typ[0]) == -1 {
+		switch len(data) {
+		case 4:
+			d.writeTime(time.Unix(int64(binary.BigEndian.Uint32(data)), 0))
+			return nil
+		case 8:
+			v := binary.BigEndian.Uint64(data)
+			d.writeTime(time.Unix(int64(v&(1<<34-1)), int64(v>>34)))
+			return nil
+		case 12:
+			d.writeTime(time.Unix(int64(binary.BigEndian.Uint64(data[4:])), int64(binary.BigEndian.Uint32(data))))
+			return nil
+		}
+	}
+	fmt.Fprintf(&d.out, `{"ext":%d,"data":`, int8(typ[0]))
+	d.writeString(base64.StdEncoding.EncodeToString(data))
+	d.out.WriteByte('}')
+	return nil
+}
+
+// cborValue は CBOR の値を1つ読む
+func (d *binaryDecoder) cborValue() error {
+	head, err := d.next(1)
+	if err != nil {
+		return err
+	}
+	major, info := head[0]>>5, head[0]&0x1f
+	if major == 7 {
+		return d.cborSimple(info)
+	}
+	if info == 31 {
+		return d.cborIndefinite(major)
+	}
+	n, err := d.cborArgument(info)
+	if err != nil {
+		return err
+	}
+	switch major {
+	case 0:
+		d.out.WriteString(strconv.FormatUint(n, 10))
+	case 1:
+		// -1-n は int64 に収まらないことがある
+		v := new(big.Int).SetUint64(n)
+		d.out.WriteString(v.Neg(v).Sub(v, big.NewInt(1)).String())
+	case 2:
+		data, err := d.next(n)
+		if err != nil {
+			return err
+		}
+		d.writeString(base64.StdEncoding.EncodeToString(data))
+	case 3:
+		return d.readString(n)
+	case 4, 5:
+		// 要素は1バイト以上あるので、残りより多ければ壊れている
+		if n > uint64(len(d.data)-d.pos) {
+			return errBinaryTruncated
+		}
+		if major == 4 {
+			return d.sequence(int64(n), d.cborValue)
+		}
+		return d.mapping(int64(n), d.cborValue)
+	case 6:
+		return d.cborTag(n)
+	}
+	return nil
+}
+
+// cborArgument は頭のバイトの下位5ビットが示す長さや値を読む
+func (d *binaryDecoder) cborArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return d.uint(1 << (info - 24))
+	}
+	return 0, fmt.Errorf("invalid CBOR additional information %d", info)
+}
+
+// cborIndefinite は長さを決めない文字列、配列、マップを読む
+func (d *binaryDecoder) cborIndefinite(major byte) error {
+	switch major {
+	case 2, 3:
+		// 同じ種類の長さの決まったかたまりをつなげる
+		var joined []byte
+		for !d.atBreak() {
+			head, err := d.next(1)
+			if err != nil {
+				return err
+			}
+			if head[0]>>5 != major || head[0]&0x1f == 31 {
+				return fmt.Errorf("invalid chunk in an indefinite-length string")
+			}
+			n, err := d.cborArgument(head[0] & 0x1f)
+			if err != nil {
+				return err
+			}
+			chunk, err := d.next(n)
+			if err != nil {
+				return err
+			}
+			joined = append(joined, chunk...)
+		}
+		if major == 2 {
+			d.writeString(base64.StdEncoding.EncodeToString(joined))
+		} else {
+			d.writeString(string(joined))
+		}
+		return nil
+	case 4:
+		return d.sequence(-1, d.cborValue)
+	case 5:
+		return d.mapping(-1, d.cborValue)
+	}
+	return fmt.Errorf("invalid indefinite length for CBOR major type %d", major)
+}
+
+// cborSimple は真偽値、null、浮動小数点数などを読む
+func (d *binaryDecoder) cborSimple(info byte) error {
+	switch info {
+	case 20:
+		d.out.WriteString("false")
+	case 21:
+		d.out.WriteString("true")
+	case 22, 23:
+		// undefined も null にする
+		d.out.WriteString("null")
+	case 24:
+		v, err := d.uint(1)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&d.out, `{"simple":%d}`, v)
+	case 25:
+		v, err := d.uint(2)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(halfFloat(uint16(v)), 32)
+	case 26:
+		v, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(float64(math.Float32frombits(uint32(v))), 32)
+	case 27:
+		v, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		d.writeFloat(math.Float64frombits(v), 64)
+	case 31:
+		return fmt.Errorf("unexpected CBOR break")
+	default:
+		if info >= 28 {
+			return fmt.Errorf("invalid CBOR additional information %d", info)
+		}
+		fmt.Fprintf(&d.out, `{"simple":%d}`, info)
+	}
+	return nil
+}
+
+// cborTag はタグの付いた値を読む。日時と大きな整数はその値にする
+func (d *binaryDecoder) cborTag(tag uint64) error {
+	if d.pos < len(d.data) {
+		head := d.data[d.pos]
+		switch {
+		case tag == 1 && (head>>5 <= 1 || head == 0xfa || head == 0xfb || head == 0xf9):
+			// 1970年からの秒数を読んでから日時にする
+			start := d.out.Len()
+			if err := d.cborValue(); err != nil {
+				return err
+			}
+			secs, err := strconv.ParseFloat(d.out.String()[start:], 64)
+			d.out.Truncate(start)
+			if err != nil || math.IsInf(secs, 0) || math.IsNaN(secs) {
+				return fmt.Errorf("invalid epoch time in CBOR tag 1")
+			}
+			whole, frac := math.Modf(secs)
+			d.writeTime(time.Unix(int64(whole), int64(frac*1e9)))
+			return nil
+		case (tag == 2 || tag == 3) && head>>5 == 2 && head&0x1f != 31:
+			d.pos++
+			n, err := d.cborArgument(head & 0x1f)
+			if err != nil {
+				return err
+			}
+			data, err := d.next(n)
+			if err != nil {
+				return err
+			}
+			v := new(big.Int).SetBytes(data)
+			if tag == 3 {
+				v.Neg(v).Sub(v, big.NewInt(1))
+			}
+			d.out.WriteString(v.String())
+			return nil
+		case tag == 0 && head>>5 == 3:
+			// RFC 3339 の文字列はそのまま使う
+			return d.cborValue()
+		}
+	}
+	if err := d.enter(); err != nil {
+		return err
+	}
+	fmt.Fprintf(&d.out, `{"tag":%d,"value":`, tag)
+	if err := d.cborValue(); err != nil {
+		return err
+	}
+	d.out.WriteByte('}')
+	d.depth--
+	return nil
+}
+
+// halfFloat は半精度の浮動小数点数を float64 にする
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
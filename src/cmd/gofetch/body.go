@@ -0,0 +1,64 @@
+package main
+
+// リクエストのメソッド、ヘッダー、本文 (-X, -H, --user-agent, -d, --data-file)
+// 本文を指定してメソッドを省略した場合は curl と同じく POST にする
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// requestMethods は -X で指定できるメソッド
+var requestMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodHead, http.MethodOptions,
+}
+
+// parseMethod はメソッド名を大文字にして、指定できるものか確かめる
+func parseMethod(s string) (string, error) {
+	m := strings.ToUpper(s)
+	for _, allowed := range requestMethods {
+		if m == allowed {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported method %q (want one of %s)", s, strings.Join(requestMethods, ", "))
+}
+
+// parseRequestHeaders は "Key: Value" の形のヘッダーの指定を解釈する
+// 値にはシークレットの埋め込みを使える
+func parseRequestHeaders(specs []string) (http.Header, error) {
+	h := http.Header{}
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q (want \"Key: Value\")", spec)
+		}
+		value, err := expandSecretHeader(name, strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", name, err)
+		}
+		h.Add(name, value)
+	}
+	return h, nil
+}
+
+// readRequestBody は -d の文字列か --data-file のファイルから本文を読む
+// --data-file が - なら標準入力から読む。どちらも指定がなければ nil を返す
+func readRequestBody(data, dataFile string) ([]byte, error) {
+	switch {
+	case data != "" && dataFile != "":
+		return nil, fmt.Errorf("-d and --data-file cannot be used together")
+	case data != "":
+		return []byte(data), nil
+	case dataFile == "-":
+		return io.ReadAll(os.Stdin)
+	case dataFile != "":
+		return os.ReadFile(dataFile)
+	}
+	return nil, nil
+}
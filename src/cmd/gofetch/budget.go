@@ -0,0 +1,200 @@
+package main
+
+// パフォーマンスバジェットの検査 (--budget, --budget-file)
+// レスポンスサイズ、TTFB、合計時間がしきい値を超えたらCIを失敗させる
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// budget はレスポンス1件に対するしきい値
+// 0 の項目は検査しない
+type budget struct {
+	Size int64
+	TTFB time.Duration
+	Time time.Duration
+}
+
+// budgetRule はバジェットファイルの1エントリ
+type budgetRule struct {
+	Match string `yaml:"match"`
+	Size  string `yaml:"size"`
+	TTFB  string `yaml:"ttfb"`
+	Time  string `yaml:"time"`
+}
+
+// budgetFile はバジェットファイル全体
+//
+//	budgets:
+//	  - match: "https://example.com/*"
+//	    size: 500KB
+//	    ttfb: 200ms
+//	    time: 1s
+type budgetFile struct {
+	Budgets []budgetRule `yaml:"budgets"`
+}
+
+// isZero はしきい値が1つも設定されていないかどうかを返す
+func (b budget) isZero() bool {
+	return b.Size == 0 && b.TTFB == 0 && b.Time == 0
+}
+
+// parseBudget は "size=500KB,ttfb=200ms,time=1s" 形式の指定を解析する
+func parseBudget(spec string) (budget, error) {
+	var b budget
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return b, fmt.Errorf("invalid budget %q (want key=value)", item)
+		}
+		if err := b.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// set はしきい値を1項目設定する
+func (b *budget) set(key, value string) error {
+	var err error
+	switch strings.ToLower(key) {
+	case "size":
+		b.Size, err = parseSize(value)
+	case "ttfb":
+		b.TTFB, err = time.ParseDuration(value)
+	case "time":
+		b.Time, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown budget %q (want size, ttfb or time)", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid budget %s: %w", key, err)
+	}
+	return nil
+}
+
+// loadBudgetFile はバジェットファイルからURLに最初に一致したしきい値を返す
+// 一致するエントリがなければ ok に false を返す
+func loadBudgetFile(path string, rawURL string) (b budget, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b, false, err
+	}
+	var f budgetFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return b, false, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, rule := range f.Budgets {
+		if !globMatch(rule.Match, rawURL) {
+			continue
+		}
+		for key, value := range map[string]string{"size": rule.Size, "ttfb": rule.TTFB, "time": rule.Time} {
+			if value == "" {
+				continue
+			}
+			if err := b.set(key, value); err != nil {
+				return b, false, fmt.Errorf("%s (%s): %w", path, rule.Match, err)
+			}
+		}
+		return b, true, nil
+	}
+	return b, false, nil
+}
+
+// merge は other で設定されている項目を上書きしたしきい値を返す
+func (b budget) merge(other budget) budget {
+	if other.Size != 0 {
+		b.Size = other.Size
+	}
+	if other.TTFB != 0 {
+		b.TTFB = other.TTFB
+	}
+	if other.Time != 0 {
+		b.Time = other.Time
+	}
+	return b
+}
+
+// check は計測値をしきい値と比べて結果を標準エラー出力に表示する
+// 1つでも超えていれば false を返す
+func (b budget) check(size int64, ttfb, total time.Duration) bool {
+	ok := true
+	report := func(name, actual, limit string, exceeded bool) {
+		status := "ok"
+		if exceeded {
+			status = "EXCEEDED"
+			ok = false
+		}
+		fmt.Fprintf(os.Stderr, "Budget: %-4s %10s / %-10s %s\n", name, actual, limit, status)
+	}
+	if b.Size != 0 {
+		report("size", formatSize(size), formatSize(b.Size), size > b.Size)
+	}
+	if b.TTFB != 0 {
+		report("ttfb", ttfb.Round(time.Millisecond).String(), b.TTFB.String(), ttfb > b.TTFB)
+	}
+	if b.Time != 0 {
+		report("time", total.Round(time.Millisecond).String(), b.Time.String(), total > b.Time)
+	}
+	return ok
+}
+
+// sizeUnits はサイズ指定で使える単位
+var sizeUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// parseSize は "500KB" や "1.5MB" のようなサイズ指定をバイト数に変換する
+func parseSize(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	scale := 1.0
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			scale = u.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * scale), nil
+}
+
+// formatSize はバイト数を読みやすい単位で表す
+func formatSize(n int64) string {
+	for _, u := range sizeUnits {
+		if u.scale > 1 && float64(n) >= u.scale {
+			return strconv.FormatFloat(float64(n)/u.scale, 'f', 1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
+
+// globMatch は * を任意の文字列として pattern が s 全体に一致するかを返す
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	return err == nil && re.MatchString(s)
+}
@@ -0,0 +1,228 @@
+package main
+
+// キャッシュの挙動の確認 (--cache-check)
+// URLを2回取得し、2回目は ETag や Last-Modified を使った条件付きリクエストにする
+// Cache-Control、ETag、Age、X-Cache、Vary を読んで、共有キャッシュに保存できるか、
+// どれだけの間新しいとみなされるか、CDNが実際にキャッシュから返しているかを表示する
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheableStatus は明示的な鮮度の指定がなくてもキャッシュできるステータス (RFC 9110 15.1)
+var cacheableStatus = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheHitHeaders はCDNがキャッシュの結果を返すヘッダー
+var cacheHitHeaders = []string{"X-Cache", "CF-Cache-Status", "X-Cache-Status", "X-Served-By", "Cache-Status"}
+
+// cacheResponse はキャッシュの判定に使うレスポンスの情報
+type cacheResponse struct {
+	Status  int
+	Header  http.Header
+	Elapsed time.Duration
+}
+
+// fetchForCache はヘッダーを加えてURLを取得し、本文を読み捨てる
+func fetchForCache(client *http.Client, rawURL string, header http.Header) (*cacheResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return nil, err
+	}
+	return &cacheResponse{Status: resp.StatusCode, Header: resp.Header, Elapsed: time.Since(start)}, nil
+}
+
+// parseCacheControl は Cache-Control をディレクティブ名から値への対応にする
+func parseCacheControl(values []string) map[string]string {
+	directives := map[string]string{}
+	for _, d := range splitList(strings.Join(values, ",")) {
+		name, value, _ := strings.Cut(d, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return directives
+}
+
+// cacheFreshness は共有キャッシュでの鮮度の期間と、その根拠を返す
+// 期間が分からない場合は ok が false になる
+func cacheFreshness(h http.Header, cc map[string]string) (d time.Duration, source string, ok bool) {
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, found := cc[name]; found {
+			if n, err := strconv.Atoi(v); err == nil {
+				return time.Duration(n) * time.Second, name, true
+			}
+		}
+	}
+	if exp := h.Get("Expires"); exp != "" {
+		expires, err := http.ParseTime(exp)
+		if err != nil {
+			// 不正な Expires は期限切れとして扱う
+			return 0, "Expires (invalid)", true
+		}
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		return expires.Sub(date), "Expires", true
+	}
+	if lm, err := http.ParseTime(h.Get("Last-Modified")); err == nil {
+		date, err := http.ParseTime(h.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		// 多くのキャッシュが使う経験的な鮮度 (Last-Modified からの経過時間の10%)
+		return date.Sub(lm) / 10, "heuristic (10% of Last-Modified age)", true
+	}
+	return 0, "", false
+}
+
+// cacheHitStatus はCDNのヘッダーからキャッシュの結果を探す
+func cacheHitStatus(h http.Header) (name, value string) {
+	for _, name := range cacheHitHeaders {
+		if v := h.Get(name); v != "" {
+			return name, v
+		}
+	}
+	return "", ""
+}
+
+// isCacheHit はCDNのヘッダーの値がキャッシュのヒットを表すかを返す
+func isCacheHit(value string) bool {
+	v := strings.ToUpper(value)
+	return strings.Contains(v, "HIT") && !strings.Contains(v, "MISS")
+}
+
+// runCacheCheck はURLを2回取得してキャッシュの挙動を表示する
+// 共有キャッシュに保存できないと判断した場合は false を返す
+func runCacheCheck(client *http.Client, rawURL string, base http.Header) (bool, error) {
+	first, err := fetchForCache(client, rawURL, base.Clone())
+	if err != nil {
+		return false, err
+	}
+	h := first.Header
+	cc := parseCacheControl(h.Values("Cache-Control"))
+
+	fmt.Printf("Status:         %d\n", first.Status)
+	for _, name := range []string{"Cache-Control", "Expires", "ETag", "Last-Modified", "Age", "Vary", "Pragma"} {
+		fmt.Printf("%-15s %s\n", name+":", orDash(strings.Join(h.Values(name), ", ")))
+	}
+	if name, value := cacheHitStatus(h); name != "" {
+		fmt.Printf("%-15s %s\n", name+":", value)
+	}
+
+	// 共有キャッシュに保存できるか
+	cacheable := true
+	var reasons []string
+	if _, ok := cc["no-store"]; ok {
+		cacheable = false
+		reasons = append(reasons, "Cache-Control: no-store")
+	}
+	if _, ok := cc["private"]; ok {
+		cacheable = false
+		reasons = append(reasons, "Cache-Control: private (browser cache only)")
+	}
+	if strings.TrimSpace(h.Get("Vary")) == "*" {
+		cacheable = false
+		reasons = append(reasons, "Vary: *")
+	}
+	if h.Get("Set-Cookie") != "" {
+		reasons = append(reasons, "Set-Cookie is present (many CDNs will not cache this)")
+	}
+	freshness, source, hasFreshness := cacheFreshness(h, cc)
+	_, public := cc["public"]
+	if !hasFreshness && !cacheableStatus[first.Status] && !public {
+		cacheable = false
+		reasons = append(reasons, fmt.Sprintf("status %d is not cacheable without explicit freshness", first.Status))
+	}
+	if _, ok := cc["no-cache"]; ok {
+		reasons = append(reasons, "Cache-Control: no-cache (stored but revalidated on every use)")
+	}
+
+	fmt.Println()
+	if cacheable {
+		fmt.Println("Cacheable:      yes (shared caches)")
+	} else {
+		fmt.Println("Cacheable:      no")
+	}
+	for _, r := range reasons {
+		fmt.Printf("                - %s\n", r)
+	}
+	switch {
+	case !cacheable:
+	case hasFreshness && freshness <= 0:
+		fmt.Printf("Fresh for:      0s (already stale, %s)\n", source)
+	case hasFreshness:
+		remaining := freshness
+		if age, err := strconv.Atoi(h.Get("Age")); err == nil {
+			remaining -= time.Duration(age) * time.Second
+		}
+		fmt.Printf("Fresh for:      %s (%s), %s remaining\n", freshness, source, max(remaining, 0))
+	default:
+		fmt.Println("Fresh for:      unknown (no max-age, Expires or Last-Modified)")
+	}
+
+	// 条件付きリクエストで再検証できるか
+	cond := base.Clone()
+	etag := h.Get("ETag")
+	lastModified := h.Get("Last-Modified")
+	if etag != "" {
+		cond.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		cond.Set("If-Modified-Since", lastModified)
+	}
+	second, err := fetchForCache(client, rawURL, cond)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case etag == "" && lastModified == "":
+		fmt.Printf("Revalidation:   not possible (no ETag or Last-Modified), second request %d\n", second.Status)
+	case second.Status == http.StatusNotModified:
+		fmt.Printf("Revalidation:   304 Not Modified in %s\n", second.Elapsed.Round(time.Millisecond))
+	default:
+		fmt.Printf("Revalidation:   conditional request returned %d, not 304\n", second.Status)
+	}
+	if etag != "" && second.Header.Get("ETag") != "" && second.Header.Get("ETag") != etag {
+		fmt.Printf("                - ETag changed between requests (%s -> %s)\n", etag, second.Header.Get("ETag"))
+	}
+
+	// CDNが実際にキャッシュから返しているか
+	name1, hit1 := cacheHitStatus(h)
+	name2, hit2 := cacheHitStatus(second.Header)
+	age1, err1 := strconv.Atoi(h.Get("Age"))
+	age2, err2 := strconv.Atoi(second.Header.Get("Age"))
+	switch {
+	case name2 != "" && isCacheHit(hit2):
+		fmt.Printf("CDN:            serving hits (%s: %s on the second request)\n", name2, hit2)
+	case name1 != "" && isCacheHit(hit1):
+		fmt.Printf("CDN:            hit on the first request (%s: %s), %s: %s on the second\n", name1, hit1, name2, orDash(hit2))
+	case err2 == nil && age2 > 0:
+		fmt.Printf("CDN:            likely served from cache (Age: %d)\n", age2)
+	case name1 != "" || name2 != "":
+		fmt.Printf("CDN:            not serving hits (%s: %s, then %s)\n", orDash(name1), orDash(hit1), orDash(hit2))
+	default:
+		fmt.Println("CDN:            no cache status headers or Age; no evidence of a shared cache")
+	}
+	if err1 == nil && err2 == nil && age2 < age1 {
+		fmt.Printf("                - Age went down (%d -> %d); requests hit different cache nodes\n", age1, age2)
+	}
+	verbosef("Cache check: first request %s, second request %s", first.Elapsed.Round(time.Millisecond), second.Elapsed.Round(time.Millisecond))
+	return cacheable, nil
+}
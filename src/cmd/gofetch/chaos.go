@@ -0,0 +1,141 @@
+package main
+
+// クライアント側での障害の注入 (bench --chaos-drop-rate, --chaos-latency, --chaos-abort-after-bytes)
+// gofetch 自身のリクエストをわざと落としたり遅らせたり、本文の途中で切ったりして、
+// リトライや監視の仕組みがクライアント側の不調にどう反応するかを確かめる
+// 注入した障害はレスポンスの種類で chaos として実際のエラーと分けて数える
+//
+//	gofetch bench --scenario s.yaml --chaos-drop-rate 5% --chaos-latency 50ms-500ms --chaos-abort-after-bytes 4KB
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errChaos は注入した障害のエラー
+var errChaos = errors.New("chaos")
+
+// benchChaos は注入する障害の設定
+type benchChaos struct {
+	// DropRate は送らずに失敗させるリクエストの割合 (0から1)
+	DropRate float64
+	// Latency は送る前に加える待ち時間。--think-time と同じ形式
+	Latency string
+	// AbortAfter は本文をこのバイト数まで読んだら切る。0なら切らない
+	AbortAfter int64
+}
+
+// enabled は障害を注入するかを返す
+func (c benchChaos) enabled() bool {
+	return c.DropRate > 0 || c.Latency != "" || c.AbortAfter > 0
+}
+
+// validate は設定の誤りを返す
+func (c benchChaos) validate() error {
+	if c.DropRate < 0 || c.DropRate > 1 {
+		return fmt.Errorf("--chaos-drop-rate must be between 0 and 100%%")
+	}
+	if c.AbortAfter < 0 {
+		return fmt.Errorf("--chaos-abort-after-bytes must not be negative")
+	}
+	if _, err := parseThinkTime(c.Latency); err != nil {
+		return fmt.Errorf("--chaos-latency: %w", err)
+	}
+	return nil
+}
+
+// String は注入する障害を表示用に返す
+func (c benchChaos) String() string {
+	var parts []string
+	if c.DropRate > 0 {
+		parts = append(parts, fmt.Sprintf("dropping %g%% of requests", 100*c.DropRate))
+	}
+	if c.Latency != "" {
+		latency, _ := parseThinkTime(c.Latency)
+		parts = append(parts, fmt.Sprintf("adding %s latency", latency))
+	}
+	if c.AbortAfter > 0 {
+		parts = append(parts, fmt.Sprintf("aborting bodies after %s", formatSize(c.AbortAfter)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseRate は "5%" か "0.05" の形の割合を解釈する
+func parseRate(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		return v / 100, err
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// chaosTransport はリクエストに障害を注入する
+type chaosTransport struct {
+	next    http.RoundTripper
+	chaos   benchChaos
+	latency thinkTime
+}
+
+// newChaosTransport は next に障害を注入する RoundTripper を作る。設定は validate で確かめてあるものとする
+func newChaosTransport(next http.RoundTripper, c benchChaos) *chaosTransport {
+	latency, _ := parseThinkTime(c.Latency)
+	return &chaosTransport{next: next, chaos: c, latency: latency}
+}
+
+// RoundTrip は待ち時間を加え、割合に応じて落とし、本文を途中で切るようにして送る
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.latency.kind != "" {
+		r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		select {
+		case <-time.After(t.latency.sample(r)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if t.chaos.DropRate > 0 && rand.Float64() < t.chaos.DropRate {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: request dropped", errChaos)
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || t.chaos.AbortAfter <= 0 {
+		return resp, err
+	}
+	resp.Body = &chaosBody{ReadCloser: resp.Body, left: t.chaos.AbortAfter}
+	return resp, nil
+}
+
+// chaosBody は left バイトを読んだら接続を切る
+type chaosBody struct {
+	io.ReadCloser
+	left int64
+}
+
+// Read は left バイトまで読み、それを超えて読もうとしたら本文を閉じてエラーを返す
+func (b *chaosBody) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// ちょうど読み終えた本文は切らない
+		var one [1]byte
+		if n, err := b.ReadCloser.Read(one[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		b.ReadCloser.Close()
+		return 0, fmt.Errorf("%w: connection aborted", errChaos)
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
@@ -0,0 +1,261 @@
+package main
+
+// ディスクに置かない秘密鍵によるクライアント証明書の認証 (--key ssh-agent, --key command:...)
+// 秘密鍵を取り出さずに、相互TLSのハンドシェイクの署名だけを外に頼む
+//
+// --key ssh-agent は SSH_AUTH_SOCK の ssh-agent に入っている鍵のうち、--cert の公開鍵と同じものを使う
+// ssh-agent は渡したデータを自分でハッシュしてから署名するが、TLSはハッシュ済みの値に署名させるので、
+// 使えるのはハッシュを前提としない Ed25519 の鍵だけになる
+//
+// --key command:<コマンド> はハンドシェイクのたびにコマンドを実行して署名させる
+// PKCS#11 のトークン (スマートカードやHSM) は pkcs11-tool などを呼ぶスクリプトで使う
+// コマンドは標準入力で署名するデータを受け取り、標準出力に署名を書く
+// 環境変数 GOFETCH_SIGN_ALGORITHM に方式、GOFETCH_SIGN_HASH にハッシュ関数の名前を渡す
+//
+//	rsa-pkcs1  DigestInfo を付けたハッシュ値 (PKCS#11 の CKM_RSA_PKCS でそのまま署名できる)
+//	rsa-pss    ハッシュ値。ソルトの長さはハッシュ値と同じ
+//	ecdsa      ハッシュ値。署名はDERか、r と s を並べた形 (CKM_ECDSA の出力)
+//	ed25519    データそのもの
+//
+// コマンドは空白で区切って直接実行し、シェルは通さない
+// 返した署名は --cert の公開鍵で確かめてから使う
+//
+//	gofetch -u https://internal.example.com --cert client.pem --key ssh-agent
+//	gofetch -u https://internal.example.com --cert client.pem --key 'command:./pkcs11-sign.sh 01'
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	// sshAgentKey は ssh-agent の鍵を使う --key の値
+	sshAgentKey = "ssh-agent"
+	// commandKeyPrefix はコマンドに署名させる --key の値の接頭辞
+	commandKeyPrefix = "command:"
+)
+
+// digestInfoPrefixes は PKCS#1 v1.5 の署名でハッシュ値の前に付ける DigestInfo
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// isExternalKey は --key がファイルではなく外部の鍵を指すかを返す
+func isExternalKey(keyFile string) bool {
+	return keyFile == sshAgentKey || strings.HasPrefix(keyFile, commandKeyPrefix)
+}
+
+// loadExternalKeyPair は証明書のファイルと外部の鍵からクライアント証明書を作る
+func loadExternalKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var cert tls.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, fmt.Errorf("no PEM certificates found in %s", certFile)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return tls.Certificate{}, err
+	}
+	if keyFile == sshAgentKey {
+		cert.PrivateKey, err = newAgentSigner(cert.Leaf.PublicKey)
+	} else {
+		cert.PrivateKey, err = newCommandSigner(strings.TrimPrefix(keyFile, commandKeyPrefix), cert.Leaf.PublicKey)
+	}
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+// agentSigner は ssh-agent の鍵で署名する
+type agentSigner struct {
+	agent agent.ExtendedAgent
+	key   ssh.PublicKey
+	pub   crypto.PublicKey
+}
+
+// newAgentSigner は ssh-agent から pub と同じ公開鍵を探す
+func newAgentSigner(pub crypto.PublicKey) (*agentSigner, error) {
+	if _, ok := pub.(ed25519.PublicKey); !ok {
+		return nil, errors.New("ssh-agent can only sign TLS handshakes with Ed25519 keys (the agent hashes the data itself)")
+	}
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("ssh-agent: SSH_AUTH_SOCK is not set")
+	}
+	// 接続はハンドシェイクのたびに使うので、実行の終わりまで開いておく
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	want, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	client := agent.NewClient(conn)
+	keys, err := client.List()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	for _, k := range keys {
+		if bytes.Equal(k.Marshal(), want.Marshal()) {
+			verbosef("TLS: signing with ssh-agent key %s (%s)", ssh.FingerprintSHA256(k), orDash(k.Comment))
+			return &agentSigner{agent: client, key: k, pub: pub}, nil
+		}
+	}
+	conn.Close()
+	return nil, fmt.Errorf("ssh-agent: no key matches the certificate (%s)", ssh.FingerprintSHA256(want))
+}
+
+func (s *agentSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign はデータを ssh-agent で署名する。Ed25519 ではハッシュせずにデータそのものを受け取る
+func (s *agentSigner) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != 0 {
+		return nil, errors.New("ssh-agent: cannot sign a precomputed digest")
+	}
+	sig, err := s.agent.Sign(s.key, message)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent: %w", err)
+	}
+	return sig.Blob, nil
+}
+
+// commandSigner は外部のコマンドで署名する
+type commandSigner struct {
+	args []string
+	pub  crypto.PublicKey
+}
+
+// newCommandSigner は --key command: のコマンドを確かめる
+func newCommandSigner(command string, pub crypto.PublicKey) (*commandSigner, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("--key command: no command given")
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("--key command: %w", err)
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("--key command: unsupported public key type %T", pub)
+	}
+	verbosef("TLS: signing with %s", args[0])
+	return &commandSigner{args: args, pub: pub}, nil
+}
+
+func (s *commandSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign はコマンドに署名させ、公開鍵で確かめてから返す
+func (s *commandSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	input := digest
+	var algorithm string
+	switch s.pub.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			algorithm = "rsa-pss"
+		} else {
+			prefix, ok := digestInfoPrefixes[hash]
+			if !ok {
+				return nil, fmt.Errorf("--key command: unsupported hash %s", hash)
+			}
+			algorithm, input = "rsa-pkcs1", append(append([]byte{}, prefix...), digest...)
+		}
+	case *ecdsa.PublicKey:
+		algorithm = "ecdsa"
+	case ed25519.PublicKey:
+		algorithm = "ed25519"
+	}
+	hashName := ""
+	if hash != 0 {
+		hashName = hash.String()
+	}
+
+	cmd := exec.Command(s.args[0], s.args[1:]...)
+	cmd.Env = append(os.Environ(), "GOFETCH_SIGN_ALGORITHM="+algorithm, "GOFETCH_SIGN_HASH="+hashName)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = os.Stderr
+	sig, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("--key command %s: %w", s.args[0], err)
+	}
+	if pub, ok := s.pub.(*ecdsa.PublicKey); ok {
+		sig = ecdsaDER(pub, sig)
+	}
+	if err := verifySignature(s.pub, digest, sig, opts); err != nil {
+		return nil, fmt.Errorf("--key command %s: signature does not match the certificate (%s, %s): %w", s.args[0], algorithm, orDash(hashName), err)
+	}
+	return sig, nil
+}
+
+// ecdsaDER は r と s を並べた形の署名をTLSで使うDERにする。DERならそのまま返す
+func ecdsaDER(pub *ecdsa.PublicKey, sig []byte) []byte {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		return sig
+	}
+	der, err := asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])})
+	if err != nil {
+		return sig
+	}
+	return der
+}
+
+// verifySignature は署名が公開鍵で確かめられるかを返す
+func verifySignature(pub crypto.PublicKey, digest, sig []byte, opts crypto.SignerOpts) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			return rsa.VerifyPSS(pub, pss.Hash, digest, sig, pss)
+		}
+		return rsa.VerifyPKCS1v15(pub, opts.HashFunc(), digest, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+// リクエストの本文の圧縮 (--compress-body)
+// -d、--data-file、--form の本文を gzip か zstd で圧縮しながら送り、Content-Encoding を付ける
+// 圧縮後の大きさは送り終えるまでわからないので、Content-Length は付けずにチャンク形式で送る
+// 圧縮した本文を受け付けるAPIへの送信や、サーバーの展開の扱いを確かめるのに使う
+//
+//	gofetch -u https://api.example.com/logs --data-file events.ndjson --compress-body zstd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"gofetch/pkg/gofetch"
+)
+
+// bodyEncodings は --compress-body で指定できるエンコーディング
+var bodyEncodings = []string{"gzip", "zstd"}
+
+// parseBodyEncoding は --compress-body の指定を小文字にして、使えるものか確かめる
+func parseBodyEncoding(s string) (string, error) {
+	enc := strings.ToLower(s)
+	for _, allowed := range bodyEncodings {
+		if enc == allowed {
+			return enc, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported --compress-body %q (want one of %s)", s, strings.Join(bodyEncodings, ", "))
+}
+
+// compressRequestBody はリクエストの本文を encoding で圧縮して送るようにする
+// 試行やリダイレクトのたびに元の本文を開き直して圧縮するので、送り直しても同じ本文になる
+func compressRequestBody(r *gofetch.Request, encoding string) {
+	open := r.OpenBody
+	if r.Body != nil {
+		data := r.Body
+		open = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	}
+	r.Body = nil
+	r.ContentLength = -1
+	r.OpenBody = func() (io.ReadCloser, error) {
+		src, err := open()
+		if err != nil {
+			return nil, err
+		}
+		pr, pw := io.Pipe()
+		go func() {
+			defer src.Close()
+			pw.CloseWithError(compressTo(pw, src, encoding))
+		}()
+		return pr, nil
+	}
+	r.Header = r.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	r.Header.Set("Content-Encoding", encoding)
+}
+
+// compressTo は src を encoding で圧縮して w に書く
+func compressTo(w io.Writer, src io.Reader, encoding string) error {
+	var zw io.WriteCloser
+	switch encoding {
+	case "gzip":
+		zw = gzip.NewWriter(w)
+	case "zstd":
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		zw = enc
+	default:
+		return fmt.Errorf("unsupported --compress-body %q", encoding)
+	}
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
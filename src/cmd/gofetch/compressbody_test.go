@@ -0,0 +1,87 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"gofetch/pkg/gofetch"
+)
+
+func TestCompressRequestBody(t *testing.T) {
+	const body = "name=gofetch&lang=ja"
+	tests := []struct {
+		name     string
+		encoding string
+		request  gofetch.Request
+	}{
+		{name: "gzip bytes", encoding: "gzip", request: gofetch.Request{Method: "POST", URL: "http://example.com/", Body: []byte(body)}},
+		{name: "zstd stream", encoding: "zstd", request: gofetch.Request{Method: "POST", URL: "http://example.com/", ContentLength: int64(len(body)),
+			OpenBody: func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(body)), nil }}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressRequestBody(&tt.request, tt.encoding)
+			req, err := tt.request.NewHTTPRequest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if req.ContentLength != -1 {
+				t.Errorf("ContentLength = %d, want -1", req.ContentLength)
+			}
+			// リダイレクトで開き直しても同じ本文になる
+			for range 2 {
+				if got := inflateBody(t, req.Body, tt.encoding); got != body {
+					t.Errorf("body = %q, want %q", got, body)
+				}
+				if req.Body, err = req.GetBody(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func inflateBody(t *testing.T, r io.ReadCloser, encoding string) string {
+	t.Helper()
+	defer r.Close()
+	var zr io.Reader
+	var err error
+	if encoding == "gzip" {
+		zr, err = gzip.NewReader(r)
+	} else {
+		zr, err = zstd.NewReader(r)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParseBodyEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "gzip", want: "gzip"},
+		{in: "ZSTD", want: "zstd"},
+		{in: "br", wantErr: true},
+		{in: "", wantErr: true},
+	} {
+		got, err := parseBodyEncoding(tt.in)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseBodyEncoding(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
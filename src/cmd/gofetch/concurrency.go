@@ -0,0 +1,129 @@
+package main
+
+// バッチ実行の並列数 (--parallel, --auto-concurrency)
+// --auto-concurrency では並列数1から始め、エラーもなくレイテンシも悪化していなければ
+// 1つずつ増やし、429や5xx、エラー、レイテンシの急な悪化があれば半分に減らす (AIMD)
+// 未知のサーバーに対して並列数を手で調整しなくて済むようにする
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultAutoConcurrency は --auto-concurrency で --parallel を省略したときの上限
+const defaultAutoConcurrency = 16
+
+// aimdLimiter は同時に実行するリクエストの数を結果に応じて増減させる
+type aimdLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	max      int
+	adaptive bool
+	inflight int
+	// successes は前回並列数を変えてから成功した数
+	successes int
+	// minLatency はこれまでに見た最も短いレイテンシ。悪化の判定の基準にする
+	minLatency time.Duration
+	// decreased は最後に並列数を減らした時刻
+	decreased time.Time
+	peak      int
+	backoffs  int
+}
+
+// newAIMDLimiter は並列数の上限が n の limiter を作る
+// adaptive でなければ常に n 個を同時に実行する
+func newAIMDLimiter(n int, adaptive bool) *aimdLimiter {
+	n = max(n, 1)
+	l := &aimdLimiter{limit: n, max: n, adaptive: adaptive}
+	if adaptive {
+		l.limit = 1
+	}
+	l.peak = l.limit
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire は実行できる枠が空くまで待つ
+func (l *aimdLimiter) acquire() {
+	l.mu.Lock()
+	for l.inflight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inflight++
+	l.mu.Unlock()
+}
+
+// release は枠を返し、start に始めたリクエストの結果から並列数を調整する
+func (l *aimdLimiter) release(start time.Time, status int, err error) {
+	latency := time.Since(start)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight--
+	defer l.cond.Broadcast()
+	if !l.adaptive {
+		return
+	}
+
+	unhealthy := err != nil || status == http.StatusTooManyRequests || status >= 500
+	if err == nil && (l.minLatency == 0 || latency < l.minLatency) {
+		l.minLatency = latency
+	}
+	// 最短の4倍を超えたら、サーバーが詰まり始めたとみなす
+	if err == nil && l.minLatency > 0 && latency > 4*l.minLatency && latency > 50*time.Millisecond {
+		unhealthy = true
+	}
+
+	if unhealthy {
+		// 前回減らす前に始めたリクエストの結果では、同じ混雑で何度も減らさない
+		if start.Before(l.decreased) {
+			return
+		}
+		next := max(1, l.limit/2)
+		if next != l.limit {
+			verbosef("Concurrency: %d -> %d (status %d, err %v, latency %s)", l.limit, next, status, err, latency.Round(time.Millisecond))
+		}
+		l.limit = next
+		l.successes = 0
+		l.decreased = time.Now()
+		l.backoffs++
+		return
+	}
+	l.successes++
+	if l.successes >= l.limit && l.limit < l.max {
+		l.limit++
+		l.successes = 0
+		l.peak = max(l.peak, l.limit)
+		verbosef("Concurrency: increased to %d", l.limit)
+	}
+}
+
+// report は並列数の推移を標準エラー出力に表示する
+func (l *aimdLimiter) report() {
+	if !l.adaptive {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "Concurrency: peaked at %d of %d, ended at %d, backed off %d time(s)\n", l.peak, l.max, l.limit, l.backoffs)
+}
+
+// runLimited は n 件の処理を limiter の並列数で実行する
+// fn はステータスとエラーを返し、limiter の調整に使われる
+func runLimited(n int, l *aimdLimiter, fn func(i int) (int, error)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		l.acquire()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			status, err := fn(i)
+			l.release(start, status, err)
+		}(i)
+	}
+	wg.Wait()
+}
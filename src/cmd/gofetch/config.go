@@ -0,0 +1,96 @@
+package main
+
+// 設定ファイル
+// ユーザーの設定ディレクトリ(Linuxでは ~/.config/gofetch/config.yaml)から読み込む
+// そこになければホームディレクトリの ~/.gofetch.yaml を使う
+// 環境変数 GOFETCH_CONFIG で別のファイルを指定できる
+//
+//	aliases:
+//	  deploy-status:
+//	    url: https://deploy.internal.example.com/status/{env}
+//	    method: GET
+//	    headers:
+//	      Authorization: Bearer ${DEPLOY_TOKEN}
+//	    flags: ["-t", "10"]
+//	confirm:
+//	  hosts: ["*.prod.example.com"]
+//	profiles:
+//	  prod:
+//	    hosts: ["*.prod.example.com"]
+//	    methods: [GET, HEAD]
+//	    auth:
+//	      token_url: https://auth.example.com/oauth/token
+//	      client_id: gofetch
+//	      client_secret: ${CLIENT_SECRET}
+//
+// トークンなどの値は {{decrypt "..."}} で暗号化して書ける (configcrypt.go)
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configEnv は設定ファイルのパスを指定する環境変数
+const configEnv = "GOFETCH_CONFIG"
+
+// config は設定ファイル全体
+type config struct {
+	Aliases  map[string]aliasDef `yaml:"aliases"`
+	Confirm  confirmConfig       `yaml:"confirm"`
+	Profiles map[string]profile  `yaml:"profiles"`
+}
+
+// configPath は設定ファイルのパスを返す
+func configPath() (string, error) {
+	if path := os.Getenv(configEnv); path != "" {
+		return path, nil
+	}
+	var path string
+	if dir, err := os.UserConfigDir(); err == nil {
+		path = filepath.Join(dir, "gofetch", "config.yaml")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dotfile := filepath.Join(home, ".gofetch.yaml")
+		if _, err := os.Stat(dotfile); err == nil {
+			return dotfile, nil
+		}
+	}
+	if path == "" {
+		return "", errors.New("no config directory")
+	}
+	return path, nil
+}
+
+// loadConfig は設定ファイルを読み込む
+// ファイルがなければ空の設定を返す
+func loadConfig() (*config, error) {
+	path, err := configPath()
+	if err != nil {
+		return &config{}, nil
+	}
+	c, err := readConfigFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &config{}, nil
+	}
+	return c, err
+}
+
+// readConfigFile は path の設定ファイルを読み込む
+func readConfigFile(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
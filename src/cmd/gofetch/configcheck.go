@@ -0,0 +1,387 @@
+package main
+
+// 設定ファイルとシナリオのファイルの確認 (gofetch config check)
+// 設定ファイル、flow の手順のファイル、bench のシナリオを読み込む型と照らし合わせ、
+// 知らないキーと型の合わない値を行番号とともに報告する
+// YAMLの読み込みは知らないキーを黙って無視するので、綴りを誤った設定が効いていないことに気づける
+//
+//	gofetch config check                      設定ファイル (GOFETCH_CONFIG か既定の場所)
+//	gofetch config check flows/checkout.yaml  種類は steps か targets のキーで見分ける
+//	gofetch config check --migrate app.yaml   キャメルケースのキーと名前を変えたキーを直して書き換える (元のファイルは .bak に残す)
+//
+// キーはすべてスネークケースで、baseUrl や maxAttempts のようなキャメルケースのキーは読み込まれない
+// 対応する base_url や max_attempts があればそれを示し、--migrate で置き換えられるようにする
+// 名前を変えたキー (configRenamedKeys) も新しい名前を示し、--migrate で置き換える
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configProblem は確認で見つけた問題1つ
+type configProblem struct {
+	line    int
+	path    string
+	message string
+}
+
+// configRenamedKeys は名前を変えたキーの表。読み込む型ごとに古い名前から新しい名前を引く
+// 古い名前は読み込まれないので、check が報告し、--migrate が新しい名前に書き換える
+var configRenamedKeys = map[reflect.Type]map[string]string{
+	reflect.TypeOf(profile{}): {
+		"basic_auth":   "basic",
+		"bearer_token": "bearer",
+		"confirm":      "require_confirm",
+	},
+	reflect.TypeOf(authConfig{}): {
+		"grant_type": "grant",
+	},
+	reflect.TypeOf(flowStep{}): {
+		"depends_on": "needs",
+	},
+}
+
+// configChecker はファイル1つの確認の状態
+type configChecker struct {
+	problems []configProblem
+	// renames は --migrate で置き換えるキーのノードと新しい名前
+	renames map[*yaml.Node]string
+}
+
+// runConfigCheck は設定ファイルかシナリオのファイルを確かめて結果を表示する
+func runConfigCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	kind := fs.String("kind", "auto", "File kind: auto, config, flow or bench")
+	migrate := fs.Bool("migrate", false, "Rename camelCase and renamed keys to their current names in place (keeps a .bak copy)")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	var path string
+	switch fs.NArg() {
+	case 0:
+		var err error
+		if path, err = configPath(); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	case 1:
+		path = fs.Arg(0)
+	default:
+		fmt.Println("Error: give at most one file")
+		return 1
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		fmt.Printf("%s: %v\n", path, err)
+		return 1
+	}
+	if len(doc.Content) == 0 {
+		fmt.Printf("%s: empty file\n", path)
+		return 1
+	}
+	root := doc.Content[0]
+	if *kind == "auto" {
+		*kind = detectConfigKind(root)
+	}
+	var target any
+	switch *kind {
+	case "config":
+		target = config{}
+	case "flow":
+		target = flowFile{}
+	case "bench":
+		target = benchScenario{}
+	default:
+		fmt.Printf("Error: invalid --kind %q (want auto, config, flow or bench)\n", *kind)
+		return 1
+	}
+
+	c := &configChecker{renames: map[*yaml.Node]string{}}
+	c.check(root, reflect.TypeOf(target), "")
+	for _, p := range c.problems {
+		where := p.path
+		if where == "" {
+			where = "(top level)"
+		}
+		fmt.Printf("%s:%d: error: %s: %s\n", path, p.line, where, p.message)
+	}
+	errors := len(c.problems)
+
+	// キャメルケースのキーと名前を変えたキーを書き換えたら、その分の誤りは直っている
+	if *migrate && len(c.renames) > 0 {
+		for key, name := range c.renames {
+			key.Value = name
+		}
+		var out bytes.Buffer
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(&doc); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if err := os.WriteFile(path+".bak", data, 0600); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if err := os.WriteFile(path, out.Bytes(), 0600); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		fmt.Printf("Migrated %d key(s) in %s (original saved as %s.bak)\n", len(c.renames), path, path)
+		errors -= len(c.renames)
+	}
+
+	// 構造に問題がなければ、実際に読み込んで値の誤りを確かめる
+	if errors == 0 {
+		if err := loadCheckedFile(*kind, path); err != nil {
+			fmt.Printf("%s: error: %v\n", path, strings.TrimPrefix(err.Error(), path+": "))
+			errors++
+		}
+	}
+
+	fmt.Printf("%s (%s): %d error(s)\n", path, *kind, errors)
+	if errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// detectConfigKind は最上位のキーからファイルの種類を見分ける
+func detectConfigKind(root *yaml.Node) string {
+	if root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			switch root.Content[i].Value {
+			case "steps":
+				return "flow"
+			case "targets":
+				return "bench"
+			}
+		}
+	}
+	return "config"
+}
+
+// loadCheckedFile は種類に応じた読み込みでファイルを読み、値の誤りを返す
+func loadCheckedFile(kind, path string) error {
+	switch kind {
+	case "flow":
+		_, err := loadFlowFile(path)
+		return err
+	case "bench":
+		_, err := loadBenchScenario(path)
+		return err
+	}
+	c, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name, p := range c.Profiles {
+		for _, m := range p.Methods {
+			if _, err := parseMethod(m); err != nil {
+				return fmt.Errorf("profiles.%s.methods: %w", name, err)
+			}
+		}
+		if p.Timeout < 0 {
+			return fmt.Errorf("profiles.%s.timeout: must not be negative", name)
+		}
+		if p.Proxy != "" {
+			if _, err := proxyConfig(p.Proxy, "", "", false, false); err != nil {
+				return fmt.Errorf("profiles.%s.proxy: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// check はノードが型 t として読み込めるかを確かめる
+func (c *configChecker) check(n *yaml.Node, t reflect.Type, path string) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	// null はどの型でも空の値として読める
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			c.report(n, path, "expected a mapping, got %s", describeNode(n))
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			name := key.Value
+			if f, ok := fields[name]; ok {
+				c.check(value, f.Type, joinConfigPath(path, name))
+				continue
+			}
+			// 名前を変えたキーとキャメルケースのキーは読み込まれないので、対応するキーを示して --migrate で直せるようにする
+			// 新しい名前のキーもある場合は、書き換えるとキーが重なるので示すだけにする
+			if renamed, ok := configRenamedKeys[t][name]; ok {
+				c.report(key, path, "deprecated key %q (renamed to %q; %s)", name, renamed, c.migratable(key, n, renamed))
+				c.check(value, fields[renamed].Type, joinConfigPath(path, renamed))
+				continue
+			}
+			if snake := toSnakeCase(name); snake != name {
+				if f, ok := fields[snake]; ok {
+					c.report(key, path, "unknown key %q (did you mean %q? %s)", name, snake, c.migratable(key, n, snake))
+					c.check(value, f.Type, joinConfigPath(path, snake))
+					continue
+				}
+			}
+			msg := fmt.Sprintf("unknown key %q", name)
+			if near := nearestKey(name, fields); near != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", near)
+			}
+			c.report(key, path, "%s", msg)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			c.report(n, path, "expected a mapping, got %s", describeNode(n))
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			c.check(n.Content[i+1], t.Elem(), joinConfigPath(path, n.Content[i].Value))
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			c.report(n, path, "expected a list, got %s", describeNode(n))
+			return
+		}
+		for i, item := range n.Content {
+			c.check(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+		}
+	case reflect.Interface:
+		// スキーマのような自由な形の値は確かめない
+	default:
+		if n.Kind != yaml.ScalarNode {
+			c.report(n, path, "expected %s, got %s", t.Kind(), describeNode(n))
+			return
+		}
+		if err := n.Decode(reflect.New(t).Interface()); err != nil {
+			c.report(n, path, "expected %s, got %q", t.Kind(), n.Value)
+		}
+	}
+}
+
+// migratable は mapping の中のキー key を name に書き換える予定に加え、報告に添える文を返す
+// mapping に name のキーがすでにあれば書き換えず、手で直すよう促す
+func (c *configChecker) migratable(key, mapping *yaml.Node, name string) string {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == name {
+			return fmt.Sprintf("%q is also set, remove one", name)
+		}
+	}
+	c.renames[key] = name
+	return "fix with --migrate"
+}
+
+// report は誤りを記録する
+func (c *configChecker) report(n *yaml.Node, path, format string, args ...any) {
+	c.problems = append(c.problems, configProblem{line: n.Line, path: path, message: fmt.Sprintf(format, args...)})
+}
+
+// yamlFields は構造体の yaml タグの名前からフィールドを引く表を作る
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// describeNode はノードの種類を表す
+func describeNode(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", n.Value)
+}
+
+// joinConfigPath はキーの経路をつなげる
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// toSnakeCase は baseUrl や BaseURL を base_url にする
+func toSnakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || nextLower && unicode.IsUpper(runes[i-1]) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		} else if r == '-' {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// nearestKey は綴りの近い既知のキーを返す。近いものがなければ空文字列を返す
+func nearestKey(name string, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+	for key := range fields {
+		if d := editDistance(strings.ToLower(name), key); d < bestDist || d == bestDist && key < best {
+			best, bestDist = key, d
+		}
+	}
+	return best
+}
+
+// editDistance は2つの文字列のレーベンシュタイン距離を返す
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
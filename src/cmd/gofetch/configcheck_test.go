@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigCheckKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		// want は問題のメッセージに含まれる文字列。空なら問題がない
+		want []string
+		// renames は --migrate で置き換えるキー
+		renames map[string]string
+	}{
+		{
+			name: "snake_case keys",
+			yaml: "profiles:\n  prod:\n    base_url: https://api.example.com\n    require_confirm: true\n    timeout: 10\n",
+		},
+		{
+			name:    "camelCase key",
+			yaml:    "profiles:\n  prod:\n    baseUrl: https://api.example.com\n",
+			want:    []string{`unknown key "baseUrl" (did you mean "base_url"? fix with --migrate)`},
+			renames: map[string]string{"baseUrl": "base_url"},
+		},
+		{
+			name:    "PascalCase key with an initialism",
+			yaml:    "profiles:\n  prod:\n    BaseURL: https://api.example.com\n",
+			want:    []string{`unknown key "BaseURL" (did you mean "base_url"?`},
+			renames: map[string]string{"BaseURL": "base_url"},
+		},
+		{
+			name:    "kebab-case key",
+			yaml:    "profiles:\n  prod:\n    require-confirm: true\n",
+			want:    []string{`unknown key "require-confirm" (did you mean "require_confirm"?`},
+			renames: map[string]string{"require-confirm": "require_confirm"},
+		},
+		{
+			name: "misspelled key",
+			yaml: "profiles:\n  prod:\n    timout: 10\n",
+			want: []string{`unknown key "timout" (did you mean "timeout"?)`},
+		},
+		{
+			name:    "camelCase key with a wrong value",
+			yaml:    "profiles:\n  prod:\n    requireConfirm: maybe\n",
+			want:    []string{`unknown key "requireConfirm"`, `expected bool, got "maybe"`},
+			renames: map[string]string{"requireConfirm": "require_confirm"},
+		},
+		{
+			name:    "renamed key",
+			yaml:    "profiles:\n  prod:\n    bearer_token: abc\n    auth:\n      grant_type: client_credentials\n",
+			want:    []string{`deprecated key "bearer_token" (renamed to "bearer"; fix with --migrate)`, `deprecated key "grant_type" (renamed to "grant"`},
+			renames: map[string]string{"bearer_token": "bearer", "grant_type": "grant"},
+		},
+		{
+			name: "renamed key next to its new name",
+			yaml: "profiles:\n  prod:\n    confirm: true\n    require_confirm: false\n",
+			want: []string{`deprecated key "confirm" (renamed to "require_confirm"; "require_confirm" is also set, remove one)`},
+		},
+		{
+			name: "unknown top-level key",
+			yaml: "profile:\n  prod: {}\n",
+			want: []string{`unknown key "profile" (did you mean "profiles"?)`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.yaml), &doc); err != nil {
+				t.Fatal(err)
+			}
+			c := &configChecker{renames: map[*yaml.Node]string{}}
+			c.check(doc.Content[0], reflect.TypeOf(config{}), "")
+			if len(c.problems) != len(tt.want) {
+				t.Fatalf("problems = %+v, want %d", c.problems, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(c.problems[i].message, want) {
+					t.Errorf("problem %d = %q, want %q", i, c.problems[i].message, want)
+				}
+			}
+			renames := map[string]string{}
+			for key, name := range c.renames {
+				renames[key.Value] = name
+			}
+			if len(renames) != len(tt.renames) || len(tt.renames) > 0 && !reflect.DeepEqual(renames, tt.renames) {
+				t.Errorf("renames = %v, want %v", renames, tt.renames)
+			}
+		})
+	}
+}
+
+func TestConfigCheckMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	original := "profiles:\n  prod:\n    baseUrl: https://api.example.com\n    bearer_token: abc\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code := runConfigCheck([]string{path}); code != 1 {
+		t.Errorf("check exit code = %d, want 1 for a camelCase key", code)
+	}
+	if code := runConfigCheck([]string{"--migrate", path}); code != 0 {
+		t.Errorf("--migrate exit code = %d, want 0", code)
+	}
+	got, _ := os.ReadFile(path)
+	if !strings.Contains(string(got), "base_url: https://api.example.com") || !strings.Contains(string(got), "bearer: abc") {
+		t.Errorf("migrated file = %q", got)
+	}
+	if bak, _ := os.ReadFile(path + ".bak"); string(bak) != original {
+		t.Errorf("backup = %q, want %q", bak, original)
+	}
+	if code := runConfigCheck([]string{path}); code != 0 {
+		t.Errorf("check after --migrate exit code = %d, want 0", code)
+	}
+}
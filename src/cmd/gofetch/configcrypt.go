@@ -0,0 +1,280 @@
+package main
+
+// 設定ファイルの暗号化した値 ({{decrypt "..."}}, gofetch config encrypt)
+// config サブコマンドのもう1つの check は configcheck.go にある
+// トークンやクライアントシークレットを age で暗号化して設定ファイルに書いておき、使うときに復号する
+// 設定ファイルを dotfiles のリポジトリにそのまま置いても値が漏れないようにする
+//
+//	profiles:
+//	  prod:
+//	    auth:
+//	      client_secret: '{{decrypt "YWdlLWVuY3J5cHRpb24ub3JnL3YxCi0+IFgy..."}}'
+//
+// 値は gofetch config encrypt で作る。標準入力の値を age の公開鍵 (--to) か
+// パスフレーズ (--passphrase) で暗号化し、base64 にした埋め込みを出力する
+//
+//	printf %s "$CLIENT_SECRET" | gofetch config encrypt --to age1...
+//
+// 復号には GOFETCH_AGE_IDENTITY に指定したファイル (age の秘密鍵か SSH の秘密鍵) を使う
+// 省略した場合は設定ディレクトリの gofetch/identity.txt があればそれを使う
+// パスフレーズで暗号化した値は GOFETCH_CONFIG_PASSPHRASE か、端末で入力したパスフレーズで復号する
+// 復号した値はほかのシークレットと同じく表示では伏せる
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"golang.org/x/term"
+)
+
+const (
+	// ConfigHelpMessage は config のヘルプメッセージ
+	ConfigHelpMessage = `
+Usage: gofetch config check [--kind auto|config|flow|bench] [--migrate] [file]
+       gofetch config encrypt (--to <recipient> ... | --passphrase)
+
+check validates the config file (default: GOFETCH_CONFIG or the default
+location), a flow file or a bench scenario, and reports unknown keys, values
+of the wrong type, camelCase keys and renamed keys (e.g. bearer_token, now bearer)
+with line numbers.
+  --kind        File kind (default: auto, from the steps or targets keys)
+  --migrate     Rename camelCase and renamed keys in place, keeping the original as <file>.bak
+
+encrypt encrypts a value read from stdin for the config file and prints it as
+{{decrypt "..."}}, to be used wherever secrets are allowed (alias URLs and
+headers, profile auth). Decryption uses the identity file in
+GOFETCH_AGE_IDENTITY (default: gofetch/identity.txt in the config directory)
+or the passphrase in GOFETCH_CONFIG_PASSPHRASE (prompted for on a terminal).
+  --to          age recipient (age1...), SSH public key or recipients file (repeatable)
+  --passphrase  Encrypt with a passphrase instead (GOFETCH_CONFIG_PASSPHRASE or prompted)
+`
+	// ageIdentityEnv は復号に使う秘密鍵のファイルを指定する環境変数
+	ageIdentityEnv = "GOFETCH_AGE_IDENTITY"
+	// configPassphraseEnv はパスフレーズを指定する環境変数
+	configPassphraseEnv = "GOFETCH_CONFIG_PASSPHRASE"
+)
+
+// configIdentities は復号に使う鍵。値ごとに読み直さないよう一度だけ読む
+var configIdentities struct {
+	sync.Mutex
+	keys       []age.Identity
+	loaded     bool
+	passphrase *age.ScryptIdentity
+}
+
+// runConfig は config サブコマンドを実行して終了コードを返す
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(ConfigHelpMessage)
+		if len(args) == 0 {
+			return 1
+		}
+		return 0
+	}
+	switch args[0] {
+	case "check":
+		return runConfigCheck(args[1:])
+	case "encrypt":
+		return runConfigEncrypt(args[1:])
+	}
+	fmt.Printf("Error: unknown config command %q\n", args[0])
+	fmt.Print(ConfigHelpMessage)
+	return 1
+}
+
+// runConfigEncrypt は標準入力の値を暗号化して埋め込みを出力する
+func runConfigEncrypt(args []string) int {
+	fs := flag.NewFlagSet("config encrypt", flag.ContinueOnError)
+	var to stringList
+	fs.Var(&to, "to", "age recipient, SSH public key or recipients file (repeatable)")
+	usePassphrase := fs.Bool("passphrase", false, "Encrypt with a passphrase")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if len(to) == 0 && !*usePassphrase || len(to) > 0 && *usePassphrase {
+		fmt.Println("Error: give either --to or --passphrase")
+		return 1
+	}
+	var recipients []age.Recipient
+	if *usePassphrase {
+		passphrase, err := readPassphrase(true)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		r, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		recipients = []age.Recipient{r}
+	} else {
+		var err error
+		if recipients, err = parseRecipients(to); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintln(os.Stderr, "Enter the value to encrypt, then Ctrl-D:")
+	}
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	// echo で渡した場合の末尾の改行は値に含めない
+	value = bytes.TrimRight(value, "\r\n")
+	if len(value) == 0 {
+		fmt.Println("Error: no value on stdin")
+		return 1
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, recipients...)
+	if err == nil {
+		if _, err = w.Write(value); err == nil {
+			err = w.Close()
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	fmt.Printf("{{decrypt %q}}\n", base64.StdEncoding.EncodeToString(out.Bytes()))
+	return 0
+}
+
+// decryptConfigValue は gofetch config encrypt で作った base64 の値を復号する
+func decryptConfigValue(encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("decrypt: value is not base64: %w", err)
+	}
+	configIdentities.Lock()
+	defer configIdentities.Unlock()
+	if !configIdentities.loaded {
+		if configIdentities.keys, err = loadConfigIdentities(); err != nil {
+			return "", err
+		}
+		configIdentities.loaded = true
+	}
+
+	// パスフレーズで暗号化した値はほかの鍵と混ぜられないので、鍵で復号できなかったときだけ使う
+	var noMatch *age.NoIdentityMatchError
+	if len(configIdentities.keys) > 0 {
+		plain, err := decryptAge(data, configIdentities.keys...)
+		if err == nil || !errors.As(err, &noMatch) {
+			return plain, err
+		}
+	}
+	if !bytes.Contains(data, []byte("\n-> scrypt ")) {
+		return "", fmt.Errorf("decrypt: no identity matches (set %s to the identity file)", ageIdentityEnv)
+	}
+	if configIdentities.passphrase == nil {
+		passphrase, err := readPassphrase(false)
+		if err != nil {
+			return "", err
+		}
+		if configIdentities.passphrase, err = age.NewScryptIdentity(passphrase); err != nil {
+			return "", err
+		}
+	}
+	plain, err := decryptAge(data, configIdentities.passphrase)
+	if errors.As(err, &noMatch) {
+		return "", fmt.Errorf("decrypt: wrong passphrase")
+	}
+	return plain, err
+}
+
+// decryptAge は age で暗号化したデータを復号する
+func decryptAge(data []byte, identities ...age.Identity) (string, error) {
+	r, err := age.Decrypt(bytes.NewReader(data), identities...)
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+	return string(plain), nil
+}
+
+// loadConfigIdentities は復号に使う秘密鍵を読み込む。ファイルがなければ空を返す
+func loadConfigIdentities() ([]age.Identity, error) {
+	path := os.Getenv(ageIdentityEnv)
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(dir, "gofetch", "identity.txt")
+		if _, err := os.Stat(path); err != nil {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		id, err := agessh.ParseIdentity(data)
+		if err != nil {
+			return nil, fmt.Errorf("decrypt: %s: %w", path, err)
+		}
+		return []age.Identity{id}, nil
+	}
+	ids, err := age.ParseIdentities(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// readPassphrase は GOFETCH_CONFIG_PASSPHRASE か端末からパスフレーズを読む
+// confirm なら端末で2回入力させて確かめる
+func readPassphrase(confirm bool) (string, error) {
+	if p := os.Getenv(configPassphraseEnv); p != "" {
+		return p, nil
+	}
+	tty, err := os.Open("/dev/tty")
+	fd := int(os.Stdin.Fd())
+	if err == nil {
+		defer tty.Close()
+		fd = int(tty.Fd())
+	}
+	if !term.IsTerminal(fd) {
+		return "", fmt.Errorf("passphrase required: set %s or run on a terminal", configPassphraseEnv)
+	}
+	fmt.Fprint(os.Stderr, "Config passphrase: ")
+	p, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if len(p) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+	if confirm {
+		fmt.Fprint(os.Stderr, "Confirm passphrase: ")
+		again, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Equal(p, again) {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return string(p), nil
+}
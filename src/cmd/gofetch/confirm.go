@@ -0,0 +1,108 @@
+package main
+
+// 危険な操作の前の確認 (--confirm, --yes)
+// 既存のファイルの上書き、HEADで調べた大きさがしきい値を超えるダウンロード、
+// 設定ファイルで本番として指定したホストへのGET以外のリクエストの前に確認を求める
+// --yes を指定するとすべて了承したものとして進む
+//
+//	confirm:
+//	  hosts: ["api.example.com", "*.prod.example.com"]
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultConfirmSize は --confirm で確認を求めるダウンロードの大きさの既定値
+const defaultConfirmSize = 100 << 20
+
+// confirmConfig は設定ファイルの confirm の項目
+type confirmConfig struct {
+	// Hosts はGET以外のリクエストの前に必ず確認を求めるホスト。* を使える
+	Hosts []string `yaml:"hosts"`
+}
+
+// errNotConfirmed は確認で了承されなかったことを表す
+var errNotConfirmed = errors.New("aborted")
+
+// confirmer は標準入力から確認の答えを読む
+type confirmer struct {
+	yes bool
+	in  *bufio.Reader
+}
+
+// newConfirmer は確認を求める confirmer を作る。yes なら常に了承する
+func newConfirmer(yes bool) *confirmer {
+	return &confirmer{yes: yes, in: bufio.NewReader(os.Stdin)}
+}
+
+// ask は質問を標準エラー出力に表示し、y で答えれば nil を返す
+// 標準入力が端末でなければ答えを読めないので、--yes を求めるエラーにする
+func (c *confirmer) ask(format string, args ...any) error {
+	question := fmt.Sprintf(format, args...)
+	if c.yes {
+		verbosef("Confirm: %s yes (--yes)", question)
+		return nil
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%s: confirmation required but stdin is not a terminal (use --yes)", question)
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := c.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errNotConfirmed
+}
+
+// confirmOverwrite は出力先のファイルが既にあれば上書きしてよいかを確認する
+func (c *confirmer) confirmOverwrite(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	return c.ask("Overwrite existing file %s?", path)
+}
+
+// confirmMethod はGET以外のリクエストを設定ファイルのホストに送ってよいかを確認する
+func (c *confirmer) confirmMethod(method, rawURL string, hosts []string) error {
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		return nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range hosts {
+		if globMatch(strings.ToLower(pattern), host) {
+			return c.ask("Send %s to protected host %s?", method, host)
+		}
+	}
+	return nil
+}
+
+// confirmSize はHEADで本文の大きさを調べ、limit を超えていればダウンロードしてよいかを確認する
+// HEADに失敗したり大きさが分からなかったりした場合は確認しない
+func (c *confirmer) confirmSize(client *http.Client, rawURL string, header http.Header, limit int64) error {
+	req, err := http.NewRequest(http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header = header.Clone()
+	resp, err := client.Do(req)
+	if err != nil {
+		verbosef("Confirm: HEAD failed: %v", err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.ContentLength <= limit {
+		return nil
+	}
+	return c.ask("Download %s (over %s)?", formatSize(resp.ContentLength), formatSize(limit))
+}
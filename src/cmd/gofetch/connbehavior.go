@@ -0,0 +1,163 @@
+package main
+
+// 接続の閉じ方とアイドル時の挙動の再現 (--connection-close, --linger, --half-close)
+// 本番で見られたサーバーのkeep-aliveの不具合を再現するために、
+// Connection: close を送る、レスポンスの後に接続をN秒アイドルのまま保つ、
+// リクエストを送り終えたら書き込み側だけを閉じる、といった挙動を選べるようにする
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// connWatcher は最後に確立した接続を見張り、サーバーが閉じた時刻を記録する
+type connWatcher struct {
+	halfClose bool
+
+	mu       sync.Mutex
+	lastRead time.Time
+	closedAt time.Time
+	closeErr error
+	closed   chan struct{}
+}
+
+// wrap は接続を見張る対象にする。前の接続の記録は捨てる
+func (w *connWatcher) wrap(conn net.Conn) net.Conn {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastRead = time.Time{}
+	w.closedAt = time.Time{}
+	w.closeErr = nil
+	w.closed = make(chan struct{})
+	return &watchedConn{Conn: conn, watcher: w, closed: w.closed}
+}
+
+// watchedConn は読み込みの結果を connWatcher に伝え、必要なら書き込み側を閉じる net.Conn
+type watchedConn struct {
+	net.Conn
+	watcher *connWatcher
+	closed  chan struct{}
+	once    sync.Once
+	// tail はヘッダーの終わりを書き込みの境目をまたいで見つけるための直前のバイト列
+	tail       []byte
+	halfClosed bool
+}
+
+// Read はサーバーから受信した時刻と、接続が閉じられたことを記録する
+func (c *watchedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	w := c.watcher
+	w.mu.Lock()
+	if n > 0 && c.closed == w.closed {
+		w.lastRead = time.Now()
+	}
+	w.mu.Unlock()
+	if err != nil {
+		c.once.Do(func() {
+			w.mu.Lock()
+			if c.closed == w.closed {
+				w.closedAt = time.Now()
+				w.closeErr = err
+			}
+			w.mu.Unlock()
+			close(c.closed)
+		})
+	}
+	return n, err
+}
+
+// Write はリクエストのヘッダーを書き終えたら、--half-close のときに書き込み側を閉じる
+func (c *watchedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err != nil || !c.watcher.halfClose || c.halfClosed {
+		return n, err
+	}
+	buf := append(c.tail, p[:n]...)
+	if bytes.Contains(buf, []byte("\r\n\r\n")) {
+		c.halfClosed = true
+		if err := closeWrite(c.Conn); err != nil {
+			verbosef("Half-close: %v", err)
+		} else {
+			verbosef("Half-close: closed the write side after sending the request")
+		}
+	}
+	if len(buf) > 3 {
+		buf = buf[len(buf)-3:]
+	}
+	c.tail = append(c.tail[:0], buf...)
+	return n, err
+}
+
+// Close はサーバーより先に gofetch が接続を閉じたことを記録する
+// keep-aliveが許されなかった場合は、レスポンスを読み終えた時点で閉じる
+func (c *watchedConn) Close() error {
+	c.once.Do(func() {
+		w := c.watcher
+		w.mu.Lock()
+		if c.closed == w.closed {
+			w.closedAt = time.Now()
+			w.closeErr = errClosedLocally
+		}
+		w.mu.Unlock()
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+// NetConn は包んでいる接続を返す
+func (c *watchedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// errClosedLocally はサーバーではなく gofetch が接続を閉じたことを表す
+var errClosedLocally = errors.New("closed by gofetch after the response (keep-alive not in use)")
+
+// closeWrite は包まれた接続をたどり、書き込み側を閉じられる接続を見つけて閉じる
+func closeWrite(conn net.Conn) error {
+	for {
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			return cw.CloseWrite()
+		}
+		u, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return errors.New("connection does not support closing the write side")
+		}
+		conn = u.NetConn()
+	}
+}
+
+// linger は最後の接続を d の間アイドルのまま保ち、その間にサーバーが閉じたかを表示する
+func (w *connWatcher) linger(d time.Duration) {
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if closed == nil {
+		fmt.Fprintln(os.Stderr, "Linger: no connection to watch")
+		return
+	}
+	select {
+	case <-closed:
+	case <-time.After(d):
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closedAt.IsZero() {
+		fmt.Fprintf(os.Stderr, "Linger: connection still open after %s idle\n", d)
+		return
+	}
+	idle := w.closedAt.Sub(w.lastRead)
+	if w.lastRead.IsZero() {
+		idle = 0
+	}
+	if w.closeErr == errClosedLocally {
+		fmt.Fprintf(os.Stderr, "Linger: connection %v\n", w.closeErr)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Linger: server closed the connection after %s idle (%v)\n", idle.Round(time.Millisecond), w.closeErr)
+}
@@ -0,0 +1,230 @@
+package main
+
+// クッキー (--cookie, --cookies, --cookie-jar)
+// リダイレクトの途中で受け取ったクッキーは、続くリクエストで送るよう常にメモリ上のクッキージャーに入れる
+// --cookie は送るクッキーを "name=value" (; で区切って複数も可) で指定する
+// --cookies はファイルのクッキーを読み込んで送り、--cookie-jar は終わったときにクッキーをファイルに書く
+// 同じファイルを両方に指定すれば、ログインしてから取得するような手順を複数回の実行に分けられる
+// ファイルは curl と同じ Netscape の cookies.txt の形式で、期限のないセッションのクッキーも書く
+// 読み書きは保存先 (pkg/gofetch/store) を通し、書き込みの途中で失敗しても以前のファイルは壊れない
+//
+//	gofetch -u https://example.com/login -d 'user=alice&password=...' --cookie-jar cookies.txt
+//	gofetch -u https://example.com/account --cookies cookies.txt --cookie-jar cookies.txt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	"gofetch/pkg/gofetch/store"
+)
+
+// cookieJarHeader はクッキーのファイルの先頭に書くコメント
+const cookieJarHeader = "# Netscape HTTP Cookie File\n# Written by gofetch. Edit at your own risk.\n\n"
+
+// storedCookie はファイルに書くクッキー1つ
+type storedCookie struct {
+	domain     string
+	subdomains bool
+	path       string
+	secure     bool
+	httpOnly   bool
+	// expires がゼロならセッションのクッキー
+	expires time.Time
+	name    string
+	value   string
+}
+
+// key はクッキーを区別する組み合わせ
+func (c storedCookie) key() string {
+	return c.domain + "\t" + c.path + "\t" + c.name
+}
+
+// cookieJar は http.Client に使うクッキージャー。受け取ったクッキーを保存できるよう覚えておく
+type cookieJar struct {
+	*cookiejar.Jar
+	mu      sync.Mutex
+	cookies map[string]storedCookie
+}
+
+// newCookieJar は空のクッキージャーを作る
+func newCookieJar() *cookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &cookieJar{Jar: jar, cookies: map[string]storedCookie{}}
+}
+
+// SetCookies はレスポンスのクッキーを入れ、保存するために覚えておく
+func (j *cookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Jar.SetCookies(u, cookies)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		s := storedCookie{domain: u.Hostname(), path: c.Path, secure: c.Secure, httpOnly: c.HttpOnly, name: c.Name, value: c.Value}
+		if c.Domain != "" {
+			s.domain, s.subdomains = strings.TrimPrefix(strings.ToLower(c.Domain), "."), true
+		}
+		if s.path == "" || !strings.HasPrefix(s.path, "/") {
+			// パスの既定はリクエストのパスのディレクトリ (RFC 6265 5.1.4)
+			s.path = "/"
+			if i := strings.LastIndex(u.Path, "/"); i > 0 {
+				s.path = u.Path[:i]
+			}
+		}
+		switch {
+		case c.MaxAge < 0:
+			s.expires = time.Unix(1, 0)
+		case c.MaxAge > 0:
+			s.expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			s.expires = c.Expires
+		}
+		// 期限切れのクッキーは消す
+		if !s.expires.IsZero() && !s.expires.After(time.Now()) {
+			delete(j.cookies, s.key())
+			continue
+		}
+		j.cookies[s.key()] = s
+	}
+}
+
+// addCookies は --cookie の "name=value; name2=value2" を targets のURLに送るクッキーとして入れる
+func (j *cookieJar) addCookies(spec string, targets []string) error {
+	cookies, err := http.ParseCookie(spec)
+	if err != nil {
+		return fmt.Errorf("invalid --cookie %q: %w", spec, err)
+	}
+	for _, target := range targets {
+		u, err := url.Parse(target)
+		if err != nil {
+			continue
+		}
+		// URLのパスに関係なく送るよう、パスは / にする
+		for _, c := range cookies {
+			c.Path = "/"
+		}
+		j.SetCookies(u, cookies)
+	}
+	return nil
+}
+
+// cookieFile は --cookies と --cookie-jar のファイルを、そのディレクトリの保存先とキーに分ける
+func cookieFile(path string) (store.Blob, string) {
+	return store.NewFileStore(filepath.Dir(path)), filepath.Base(path)
+}
+
+// load は保存先の key にある cookies.txt の形式のデータからクッキーを読み込む。path はエラーの表示に使う
+func (j *cookieJar) load(b store.Blob, key, path string) error {
+	f, err := b.Open(key)
+	if errors.Is(err, store.ErrNotFound) {
+		return fmt.Errorf("open %s: %w", path, os.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: want 7 tab-separated fields", path, n)
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid expiry %q", path, n, fields[4])
+		}
+		s := storedCookie{
+			domain:     strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			subdomains: strings.EqualFold(fields[1], "TRUE"),
+			path:       fields[2],
+			secure:     strings.EqualFold(fields[3], "TRUE"),
+			httpOnly:   httpOnly,
+			name:       fields[5],
+			value:      fields[6],
+		}
+		if expiry > 0 {
+			s.expires = time.Unix(expiry, 0)
+		}
+		if !s.expires.IsZero() && !s.expires.After(time.Now()) {
+			continue
+		}
+		scheme := "http"
+		if s.secure {
+			scheme = "https"
+		}
+		c := &http.Cookie{Name: s.name, Value: s.value, Path: s.path, Secure: s.secure, HttpOnly: s.httpOnly, Expires: s.expires}
+		if s.subdomains {
+			c.Domain = s.domain
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: s.domain, Path: s.path}, []*http.Cookie{c})
+	}
+	return sc.Err()
+}
+
+// save はクッキーを cookies.txt の形式で保存先の key に書く
+func (j *cookieJar) save(b store.Blob, key string) error {
+	j.mu.Lock()
+	list := make([]storedCookie, 0, len(j.cookies))
+	for _, c := range j.cookies {
+		if c.expires.IsZero() || c.expires.After(time.Now()) {
+			list = append(list, c)
+		}
+	}
+	j.mu.Unlock()
+	sort.Slice(list, func(a, b int) bool { return list[a].key() < list[b].key() })
+
+	var out strings.Builder
+	out.WriteString(cookieJarHeader)
+	for _, c := range list {
+		domain := c.domain
+		if c.subdomains {
+			domain = "." + domain
+		}
+		if c.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expiry int64
+		if !c.expires.IsZero() {
+			expiry = c.expires.Unix()
+		}
+		fmt.Fprintf(&out, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, netscapeBool(c.subdomains), c.path, netscapeBool(c.secure), expiry, c.name, c.value)
+	}
+	// クッキーにはセッションの情報が入るので、保存先のファイルは本人だけが読める
+	w, err := b.Create(key)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, out.String()); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// netscapeBool は cookies.txt の TRUE か FALSE を返す
+func netscapeBool(v bool) string {
+	if v {
+		return "TRUE"
+	}
+	return "FALSE"
+}
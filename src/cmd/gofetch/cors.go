@@ -0,0 +1,192 @@
+package main
+
+// CORSのプリフライトの再現 (--cors-check)
+// ブラウザと同じように Origin を付けた OPTIONS のプリフライトを送り、続けて実際のリクエストを送る
+// ブラウザがリクエストを許可するために必要なレスポンスヘッダーのうち、
+// 足りないものや値が合わないものを1つずつ表示する
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsSafelistedMethods はプリフライトで許可されていなくても使えるメソッド
+var corsSafelistedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// corsCheck はCORSの確認の設定
+type corsCheck struct {
+	Origin      string
+	Method      string
+	Headers     []string
+	Credentials bool
+}
+
+// corsChecker は確認の結果を表示しながら数える
+type corsChecker struct {
+	failures int
+}
+
+// pass は満たしている項目を表示する
+func (c *corsChecker) pass(format string, args ...any) {
+	fmt.Printf("  ok       %s\n", fmt.Sprintf(format, args...))
+}
+
+// fail は満たしていない項目を表示する
+func (c *corsChecker) fail(kind, format string, args ...any) {
+	c.failures++
+	fmt.Printf("  %-8s %s\n", kind, fmt.Sprintf(format, args...))
+}
+
+// info は判定に関係しない情報を表示する
+func (c *corsChecker) info(format string, args ...any) {
+	fmt.Printf("  info     %s\n", fmt.Sprintf(format, args...))
+}
+
+// sendCORS はリクエストを送り、本文を読み捨ててレスポンスを返す
+func sendCORS(client *http.Client, method, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp, nil
+}
+
+// checkAllowOrigin は Access-Control-Allow-Origin と Access-Control-Allow-Credentials を確認する
+func (c *corsChecker) checkAllowOrigin(h http.Header, cfg corsCheck) {
+	allow := h.Values("Access-Control-Allow-Origin")
+	switch {
+	case len(allow) == 0:
+		c.fail("MISSING", "Access-Control-Allow-Origin")
+	case len(allow) > 1:
+		c.fail("MISMATCH", "Access-Control-Allow-Origin sent %d times (%s)", len(allow), strings.Join(allow, ", "))
+	case allow[0] == "*" && cfg.Credentials:
+		c.fail("MISMATCH", "Access-Control-Allow-Origin: * is not allowed with credentials (want %s)", cfg.Origin)
+	case allow[0] == "*":
+		c.pass("Access-Control-Allow-Origin: *")
+	case allow[0] != cfg.Origin:
+		c.fail("MISMATCH", "Access-Control-Allow-Origin: %s (want %s)", allow[0], cfg.Origin)
+	default:
+		c.pass("Access-Control-Allow-Origin: %s", allow[0])
+		vary := false
+		for _, v := range splitList(strings.Join(h.Values("Vary"), ",")) {
+			if strings.EqualFold(v, "Origin") || v == "*" {
+				vary = true
+			}
+		}
+		if !vary {
+			c.info("Vary does not include Origin; shared caches may return this response to other origins")
+		}
+	}
+	if cfg.Credentials {
+		if v := h.Get("Access-Control-Allow-Credentials"); v == "true" {
+			c.pass("Access-Control-Allow-Credentials: true")
+		} else if v == "" {
+			c.fail("MISSING", "Access-Control-Allow-Credentials (credentials were requested)")
+		} else {
+			c.fail("MISMATCH", "Access-Control-Allow-Credentials: %s (want true)", v)
+		}
+	}
+}
+
+// runCORSCheck はプリフライトと実際のリクエストを送り、足りないCORSのヘッダーを表示する
+// ブラウザがリクエストを拒否する場合は false を返す
+func runCORSCheck(client *http.Client, rawURL string, base http.Header, cfg corsCheck) (bool, error) {
+	c := &corsChecker{}
+
+	// プリフライト
+	header := base.Clone()
+	header.Set("Origin", cfg.Origin)
+	header.Set("Access-Control-Request-Method", cfg.Method)
+	if len(cfg.Headers) > 0 {
+		header.Set("Access-Control-Request-Headers", strings.ToLower(strings.Join(cfg.Headers, ",")))
+	}
+	resp, err := sendCORS(client, http.MethodOptions, rawURL, header)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Preflight: OPTIONS %s (Origin: %s, method %s)\n", resp.Status, cfg.Origin, cfg.Method)
+	h := resp.Header
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		c.fail("STATUS", "preflight returned %d; browsers require a 2xx status", resp.StatusCode)
+	}
+	c.checkAllowOrigin(h, cfg)
+
+	methods := splitList(strings.Join(h.Values("Access-Control-Allow-Methods"), ","))
+	wildcard := slices.Contains(methods, "*") && !cfg.Credentials
+	switch {
+	case slices.Contains(methods, cfg.Method) || wildcard:
+		c.pass("Access-Control-Allow-Methods allows %s", cfg.Method)
+	case slices.Contains(corsSafelistedMethods, cfg.Method):
+		c.pass("%s is a safelisted method and needs no Access-Control-Allow-Methods", cfg.Method)
+	case len(methods) == 0:
+		c.fail("MISSING", "Access-Control-Allow-Methods (needed for %s)", cfg.Method)
+	default:
+		c.fail("MISMATCH", "Access-Control-Allow-Methods: %s does not include %s", strings.Join(methods, ", "), cfg.Method)
+	}
+
+	if len(cfg.Headers) > 0 {
+		allowed := map[string]bool{}
+		for _, name := range splitList(strings.Join(h.Values("Access-Control-Allow-Headers"), ",")) {
+			allowed[strings.ToLower(name)] = true
+		}
+		var missing []string
+		for _, name := range cfg.Headers {
+			lower := strings.ToLower(name)
+			// Authorization は * では許可されない
+			if allowed[lower] || (allowed["*"] && !cfg.Credentials && lower != "authorization") {
+				continue
+			}
+			missing = append(missing, name)
+		}
+		switch {
+		case len(missing) == 0:
+			c.pass("Access-Control-Allow-Headers allows %s", strings.Join(cfg.Headers, ", "))
+		case len(allowed) == 0:
+			c.fail("MISSING", "Access-Control-Allow-Headers (needed for %s)", strings.Join(missing, ", "))
+		default:
+			c.fail("MISMATCH", "Access-Control-Allow-Headers: %s does not include %s", h.Get("Access-Control-Allow-Headers"), strings.Join(missing, ", "))
+		}
+	}
+	if v := h.Get("Access-Control-Max-Age"); v != "" {
+		c.info("Access-Control-Max-Age: %s", v)
+	} else {
+		c.info("no Access-Control-Max-Age; browsers cache the preflight for 5 seconds")
+	}
+
+	// 実際のリクエスト
+	header = base.Clone()
+	header.Set("Origin", cfg.Origin)
+	for _, name := range cfg.Headers {
+		if header.Get(name) == "" {
+			header.Set(name, "gofetch")
+		}
+	}
+	resp, err = sendCORS(client, cfg.Method, rawURL, header)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Request: %s %s\n", cfg.Method, resp.Status)
+	c.checkAllowOrigin(resp.Header, cfg)
+	if v := resp.Header.Get("Access-Control-Expose-Headers"); v != "" {
+		c.info("Access-Control-Expose-Headers: %s", v)
+	} else {
+		c.info("no Access-Control-Expose-Headers; scripts can read only safelisted response headers")
+	}
+
+	if c.failures > 0 {
+		fmt.Printf("CORS: %d problem(s); the browser would block this request\n", c.failures)
+		return false, nil
+	}
+	fmt.Println("CORS: the browser would allow this request")
+	return true, nil
+}
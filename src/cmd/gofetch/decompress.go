@@ -0,0 +1,137 @@
+package main
+
+// 圧縮の指定と展開 (--compressed, --raw)
+// --compressed は Accept-Encoding で gzip、br、zstd を提示し、Content-Encoding に合わせて本文を展開する
+// net/http が自分で展開するのは自分で gzip を提示した場合だけなので、br と zstd とあわせてここで展開する
+// gzip, br のように重ねたエンコーディングは後ろから順に戻す。知らないエンコーディングはそのまま残す
+// --raw は展開せず、受け取ったままのバイトを書く。Accept-Encoding を指定しなければ、いつもどおり gzip を提示する
+// --verbose ではヘッダーを展開する前のまま表示する
+//
+//	gofetch -u https://example.com/app.js --compressed
+//	gofetch -u https://example.com/data.json --compressed --raw -o data.json.br
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedEncodings は --compressed で提示する Accept-Encoding
+const compressedEncodings = "gzip, br, zstd"
+
+// decompressTransport は Content-Encoding の本文を展開する
+type decompressTransport struct {
+	next http.RoundTripper
+}
+
+// wrap は next の上で圧縮された本文を展開する gofetch.Middleware
+func (t *decompressTransport) wrap(next http.RoundTripper) http.RoundTripper {
+	t.next = next
+	return t
+}
+
+// RoundTrip はリクエストを送り、圧縮された本文を展開するレスポンスを返す
+func (t *decompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || req.Method == http.MethodHead || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp, err
+	}
+	encodings := splitList(strings.Join(resp.Header.Values("Content-Encoding"), ","))
+	for _, enc := range encodings {
+		if !canDecompress(enc) {
+			verbosef("Content-Encoding %s is not supported; keeping the body as received", enc)
+			return resp, nil
+		}
+	}
+	if len(encodings) == 0 {
+		return resp, nil
+	}
+
+	body := &decompressBody{closers: []io.Closer{resp.Body}}
+	var r io.Reader = resp.Body
+	for i := len(encodings) - 1; i >= 0; i-- {
+		if r, err = newDecompressor(strings.ToLower(encodings[i]), r, body); err != nil {
+			body.Close()
+			return nil, fmt.Errorf("decompressing %s response: %w", encodings[i], err)
+		}
+	}
+	body.Reader = r
+	verbosef("Decompressing %s", strings.Join(encodings, ", "))
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// canDecompress は展開できるエンコーディングかを返す
+func canDecompress(enc string) bool {
+	switch strings.ToLower(enc) {
+	case "identity", "gzip", "x-gzip", "deflate", "br", "zstd":
+		return true
+	}
+	return false
+}
+
+// newDecompressor は enc の展開をする r のリーダーを返す。閉じる必要があれば body に加える
+func newDecompressor(enc string, r io.Reader, body *decompressBody) (io.Reader, error) {
+	switch enc {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r)
+		// 本文が空なら展開するものもない
+		if errors.Is(err, io.EOF) {
+			return strings.NewReader(""), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body.closers = append(body.closers, zr)
+		return zr, nil
+	case "deflate":
+		// HTTP の deflate は zlib の形式
+		zr, err := zlib.NewReader(r)
+		if errors.Is(err, io.EOF) {
+			return strings.NewReader(""), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body.closers = append(body.closers, zr)
+		return zr, nil
+	case "br":
+		return brotli.NewReader(r), nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		rc := zr.IOReadCloser()
+		body.closers = append(body.closers, rc)
+		return rc, nil
+	}
+	return r, nil
+}
+
+// decompressBody は展開した本文。閉じると展開のリーダーと元の本文を閉じる
+type decompressBody struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (b *decompressBody) Close() error {
+	var err error
+	for i := len(b.closers) - 1; i >= 0; i-- {
+		if cerr := b.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+// バッチ実行での同じリクエストの重複の排除
+// 1回の実行の中でメソッド、URL、ヘッダー、本文のハッシュが同じリクエストを見つけ、
+// 2回目以降はネットワークに送らずにメモリに残したレスポンスを返す
+// 最後に何回の通信を省けたかを表示する
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// dedupEntry は記録したレスポンス
+type dedupEntry struct {
+	once   sync.Once
+	status int
+	proto  string
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// dedupTransport は同じリクエストへのレスポンスを使い回す http.RoundTripper
+type dedupTransport struct {
+	base http.RoundTripper
+
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+	saved   int
+}
+
+// newDedupTransport は base を包んで重複を排除する dedupTransport を作る
+func newDedupTransport(base http.RoundTripper) *dedupTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &dedupTransport{base: base, entries: map[string]*dedupEntry{}}
+}
+
+// requestSignature はメソッド、URL、ヘッダー、本文のハッシュからリクエストの署名を作る
+func requestSignature(req *http.Request) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %q\n", name, req.Header[name])
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// RoundTrip は初めてのリクエストを送ってレスポンスを記録し、2回目以降は記録から返す
+// 本文を読み直せないリクエストはそのまま送る
+func (t *dedupTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return t.base.RoundTrip(req)
+	}
+	sig, err := requestSignature(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	e, seen := t.entries[sig]
+	if !seen {
+		e = &dedupEntry{}
+		t.entries[sig] = e
+	} else {
+		t.saved++
+	}
+	t.mu.Unlock()
+
+	e.once.Do(func() {
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			e.err = err
+			return
+		}
+		defer resp.Body.Close()
+		e.body, e.err = io.ReadAll(resp.Body)
+		e.status, e.proto, e.header = resp.StatusCode, resp.Proto, resp.Header
+	})
+	if e.err != nil {
+		return nil, e.err
+	}
+	if seen {
+		verbosef("Dedup: %s %s served from this run's cache", req.Method, req.URL)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.status, http.StatusText(e.status)),
+		StatusCode:    e.status,
+		Proto:         e.proto,
+		Header:        e.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}, nil
+}
+
+// report は省けた通信の回数を表示する
+func (t *dedupTransport) report() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.saved > 0 {
+		fmt.Printf("Dedup: %d duplicate request(s) served from cache, %d network call(s) made\n", t.saved, len(t.entries))
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+// よく使う発見用ドキュメントの取得 (gofetch discover)
+// /.well-known/openid-configuration、security.txt、robots.txt、sitemap.xml、JWKSを
+// ホスト名だけで取得して読みやすく表示する
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// discover のヘルプメッセージ
+	DiscoverHelpMessage = `
+Usage: gofetch discover <kind> <host|url> [options]
+Kinds:
+  openid        /.well-known/openid-configuration
+  jwks          JSON Web Key Set (from jwks_uri, or the given URL) with a key summary
+  security      /.well-known/security.txt (falls back to /security.txt)
+  robots        /robots.txt
+  sitemap       /sitemap.xml (or the given URL), listing URLs or child sitemaps
+Options:
+  -t, --timeout Timeout in seconds (default: 30)
+  --raw         Print the document as fetched
+  --verbose     Print requests to stderr
+`
+)
+
+// runDiscover は discover サブコマンドを実行して終了コードを返す
+func runDiscover(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(DiscoverHelpMessage)
+		return 0
+	}
+	kind := args[0]
+
+	fs := flag.NewFlagSet("discover "+kind, flag.ContinueOnError)
+	timeout := fs.Int("t", 30, "Timeout in seconds")
+	fs.IntVar(timeout, "timeout", 30, "Timeout in seconds")
+	raw := fs.Bool("raw", false, "Print the document as fetched")
+	fs.BoolVar(&verbose, "verbose", false, "Print diagnostic information to stderr")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Println("Error: a host or URL is required")
+		fmt.Print(DiscoverHelpMessage)
+		return 1
+	}
+	base, err := discoverBase(fs.Arg(0))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	client := &http.Client{Timeout: time.Duration(*timeout) * time.Second}
+	switch kind {
+	case "openid":
+		err = discoverOpenID(client, base, *raw)
+	case "jwks":
+		err = discoverJWKS(client, base, *raw)
+	case "security":
+		err = discoverSecurityTxt(client, base)
+	case "robots":
+		err = discoverText(client, base.ResolveReference(&url.URL{Path: "/robots.txt"}))
+	case "sitemap":
+		err = discoverSitemap(client, base, *raw)
+	default:
+		fmt.Println("Error: unknown discover kind:", kind)
+		fmt.Print(DiscoverHelpMessage)
+		return 1
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// discoverBase はホスト名またはURLを解析する。スキームがなければhttpsとみなす
+func discoverBase(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid host or URL %q", s)
+	}
+	return u, nil
+}
+
+// hasPath はホスト名だけでなくパスまで指定されたかどうかを返す
+func hasPath(u *url.URL) bool {
+	return u.Path != "" && u.Path != "/"
+}
+
+// fetchDocument はURLを取得して本文を返す。200以外はエラーにする
+func fetchDocument(client *http.Client, u *url.URL) ([]byte, error) {
+	verbosef("Discover: GET %s", u)
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// printJSON はJSONを整形して表示する
+func printJSON(data []byte) error {
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// openIDConfiguration は表示する主な項目
+type openIDConfiguration struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	ScopesSupported       []string `json:"scopes_supported"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// fetchOpenIDConfiguration は /.well-known/openid-configuration を取得する
+// パスを含むURLが指定された場合は、その下の /.well-known を使う (発行者にパスがある場合)
+func fetchOpenIDConfiguration(client *http.Client, base *url.URL) ([]byte, *openIDConfiguration, error) {
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(u.Path, "/.well-known/openid-configuration") {
+		u.Path += "/.well-known/openid-configuration"
+	}
+	data, err := fetchDocument(client, &u)
+	if err != nil {
+		return nil, nil, err
+	}
+	var conf openIDConfiguration
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, nil, fmt.Errorf("invalid openid-configuration: %w", err)
+	}
+	return data, &conf, nil
+}
+
+// discoverOpenID は OpenID Connect の設定の主な項目を表示する
+func discoverOpenID(client *http.Client, base *url.URL, raw bool) error {
+	data, conf, err := fetchOpenIDConfiguration(client, base)
+	if err != nil {
+		return err
+	}
+	if raw {
+		return printJSON(data)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	rows := []struct{ name, value string }{
+		{"issuer", conf.Issuer},
+		{"authorization_endpoint", conf.AuthorizationEndpoint},
+		{"token_endpoint", conf.TokenEndpoint},
+		{"userinfo_endpoint", conf.UserinfoEndpoint},
+		{"jwks_uri", conf.JWKSURI},
+		{"scopes", strings.Join(conf.ScopesSupported, " ")},
+		{"response_types", strings.Join(conf.ResponseTypes, " ")},
+		{"grant_types", strings.Join(conf.GrantTypes, " ")},
+		{"id_token_signing_algs", strings.Join(conf.SigningAlgs, " ")},
+	}
+	for _, r := range rows {
+		if r.value != "" {
+			fmt.Fprintf(tw, "%s\t%s\n", r.name, r.value)
+		}
+	}
+	return tw.Flush()
+}
+
+// jwk はJWKSの鍵1つのうち表示に使う項目
+type jwk struct {
+	Kid string   `json:"kid"`
+	Kty string   `json:"kty"`
+	Alg string   `json:"alg"`
+	Use string   `json:"use"`
+	Crv string   `json:"crv"`
+	N   string   `json:"n"`
+	X5c []string `json:"x5c"`
+}
+
+// discoverJWKS はJWKSを取得して鍵の一覧を表示する
+// パスを含むURLが指定された場合はそれをJWKSのURLとして扱う
+func discoverJWKS(client *http.Client, base *url.URL, raw bool) error {
+	jwksURL := base
+	if !hasPath(base) || strings.HasSuffix(base.Path, "/.well-known/openid-configuration") {
+		_, conf, err := fetchOpenIDConfiguration(client, base)
+		if err != nil {
+			return err
+		}
+		if conf.JWKSURI == "" {
+			return errors.New("openid-configuration has no jwks_uri")
+		}
+		jwksURL, err = url.Parse(conf.JWKSURI)
+		if err != nil {
+			return err
+		}
+	}
+	data, err := fetchDocument(client, jwksURL)
+	if err != nil {
+		return err
+	}
+	if raw {
+		return printJSON(data)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KID\tKTY\tALG\tUSE\tSIZE\tX5C")
+	for _, k := range set.Keys {
+		size := k.Crv
+		if k.Kty == "RSA" {
+			if n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.N, "=")); err == nil {
+				size = fmt.Sprintf("%d bits", len(n)*8)
+			}
+		}
+		x5c := "-"
+		if len(k.X5c) > 0 {
+			x5c = fmt.Sprintf("%d cert(s)", len(k.X5c))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", orDash(k.Kid), k.Kty, orDash(k.Alg), orDash(k.Use), orDash(size), x5c)
+	}
+	tw.Flush()
+	fmt.Printf("%d key(s) from %s\n", len(set.Keys), jwksURL)
+	return nil
+}
+
+// orDash は空の値を "-" にする
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// discoverSecurityTxt は security.txt を表示し、Expires が過ぎていれば警告する
+func discoverSecurityTxt(client *http.Client, base *url.URL) error {
+	data, err := fetchDocument(client, base.ResolveReference(&url.URL{Path: "/.well-known/security.txt"}))
+	if err != nil {
+		var fallbackErr error
+		data, fallbackErr = fetchDocument(client, base.ResolveReference(&url.URL{Path: "/security.txt"}))
+		if fallbackErr != nil {
+			return err
+		}
+	}
+	fmt.Print(string(data))
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		field, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(field), "Expires") {
+			continue
+		}
+		expires, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: invalid Expires: %s\n", strings.TrimSpace(value))
+		} else if expires.Before(time.Now()) {
+			fmt.Fprintf(os.Stderr, "Warning: security.txt expired on %s\n", expires.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// discoverText はテキストのドキュメントをそのまま表示する
+func discoverText(client *http.Client, u *url.URL) error {
+	data, err := fetchDocument(client, u)
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(data))
+	return nil
+}
+
+// sitemapDocument は urlset と sitemapindex の両方を表す
+type sitemapDocument struct {
+	XMLName xml.Name
+	URLs    []sitemapLoc `xml:"url"`
+	Maps    []sitemapLoc `xml:"sitemap"`
+}
+
+// sitemapLoc はサイトマップの1件
+type sitemapLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// discoverSitemap はサイトマップのURL(インデックスなら子サイトマップ)を一覧表示する
+func discoverSitemap(client *http.Client, base *url.URL, raw bool) error {
+	u := base
+	if !hasPath(base) {
+		u = base.ResolveReference(&url.URL{Path: "/sitemap.xml"})
+	}
+	data, err := fetchDocument(client, u)
+	if err != nil {
+		return err
+	}
+	if raw {
+		fmt.Print(string(data))
+		return nil
+	}
+	var doc sitemapDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid sitemap: %w", err)
+	}
+
+	entries, noun := doc.URLs, "URL(s)"
+	if doc.XMLName.Local == "sitemapindex" {
+		entries, noun = doc.Maps, "sitemap(s)"
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\n", strings.TrimSpace(e.Loc), e.LastMod)
+	}
+	tw.Flush()
+	fmt.Printf("%d %s in %s\n", len(entries), noun, u)
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+// DNSのHTTPSレコードを直接問い合わせるための簡易リゾルバ
+// 標準のnetパッケージではHTTPS/SVCBレコードを取得できないため、
+// dnsmessageパッケージでクエリを組み立ててDNSサーバーへ送信する
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// EDNS0で通知するUDPペイロードサイズ
+	dnsUDPSize = 1232
+	// DNSクエリ1回あたりのタイムアウト
+	dnsQueryTimeout = 5 * time.Second
+)
+
+// httpsRecord は HTTPS レコード1件の内容
+type httpsRecord struct {
+	Priority      uint16
+	Target        string
+	ALPN          []string
+	NoDefaultALPN bool
+	Port          uint16
+	IPv4Hint      []net.IP
+	IPv6Hint      []net.IP
+	ECH           []byte
+	// Mandatory はクライアントが解釈できなければならないキーの一覧
+	Mandatory []dnsmessage.SVCParamKey
+}
+
+// resolveDNSServers はクエリ送信先のDNSサーバー一覧を返す
+// override が指定されていればそれを優先し、なければシステムの設定を使う
+func resolveDNSServers(override string) ([]string, error) {
+	if override != "" {
+		if _, _, err := net.SplitHostPort(override); err != nil {
+			override = net.JoinHostPort(override, "53")
+		}
+		return []string{override}, nil
+	}
+	servers := systemDNSServers()
+	if len(servers) == 0 {
+		return nil, errors.New("no DNS server configured (use --dns-server)")
+	}
+	return servers, nil
+}
+
+// lookupHTTPS はホストのHTTPSレコードを問い合わせる
+// ポートが443以外の場合は RFC 9460 に従って _port._https.host を問い合わせる
+func lookupHTTPS(ctx context.Context, servers []string, host string, port string) ([]httpsRecord, error) {
+	qname := host
+	if port != "" && port != "443" {
+		qname = "_" + port + "._https." + host
+	}
+
+	msg, err := dnsQuery(ctx, servers, qname, dnsmessage.TypeHTTPS)
+	if err != nil {
+		return nil, err
+	}
+
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	var records []httpsRecord
+	for {
+		h, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Type != dnsmessage.TypeHTTPS {
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		r, err := p.HTTPSResource()
+		if err != nil {
+			return nil, err
+		}
+		rec := httpsRecord{
+			Priority: r.Priority,
+			Target:   strings.TrimSuffix(r.Target.String(), "."),
+		}
+		if err := parseSVCParams(&rec, r.Params); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// parseSVCParams はSvcParamsの値をレコードに展開する
+func parseSVCParams(rec *httpsRecord, params []dnsmessage.SVCParam) error {
+	for _, param := range params {
+		v := param.Value
+		switch param.Key {
+		case dnsmessage.SVCParamMandatory:
+			for ; len(v) >= 2; v = v[2:] {
+				rec.Mandatory = append(rec.Mandatory, dnsmessage.SVCParamKey(binary.BigEndian.Uint16(v)))
+			}
+		case dnsmessage.SVCParamALPN:
+			for len(v) > 0 {
+				n := int(v[0])
+				if n == 0 || len(v) < 1+n {
+					return errors.New("malformed alpn SvcParam")
+				}
+				rec.ALPN = append(rec.ALPN, string(v[1:1+n]))
+				v = v[1+n:]
+			}
+		case dnsmessage.SVCParamNoDefaultALPN:
+			rec.NoDefaultALPN = true
+		case dnsmessage.SVCParamPort:
+			if len(v) != 2 {
+				return errors.New("malformed port SvcParam")
+			}
+			rec.Port = binary.BigEndian.Uint16(v)
+		case dnsmessage.SVCParamIPv4Hint:
+			for ; len(v) >= net.IPv4len; v = v[net.IPv4len:] {
+				rec.IPv4Hint = append(rec.IPv4Hint, net.IP(append([]byte(nil), v[:net.IPv4len]...)))
+			}
+		case dnsmessage.SVCParamIPv6Hint:
+			for ; len(v) >= net.IPv6len; v = v[net.IPv6len:] {
+				rec.IPv6Hint = append(rec.IPv6Hint, net.IP(append([]byte(nil), v[:net.IPv6len]...)))
+			}
+		case dnsmessage.SVCParamECH:
+			rec.ECH = v
+		}
+	}
+	return nil
+}
+
+// dnsQuery はクエリを組み立てて順番にサーバーへ送信し、最初に得られた応答を返す
+func dnsQuery(ctx context.Context, servers []string, name string, qtype dnsmessage.Type) ([]byte, error) {
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var idBuf [2]byte
+	if _, err := rand.Read(idBuf[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(idBuf[:])
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(dnsUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, server := range servers {
+		resp, err := dnsExchange(ctx, server, query, id)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("DNS query for %s failed: %w", name, lastErr)
+}
+
+// dnsExchange は1台のサーバーとUDPでやり取りし、応答が切り詰められていればTCPで再送する
+func dnsExchange(ctx context.Context, server string, query []byte, id uint16) ([]byte, error) {
+	resp, err := dnsRoundTrip(ctx, "udp", server, query)
+	if err != nil {
+		return nil, err
+	}
+	h, err := checkDNSResponse(resp, id)
+	if err != nil {
+		return nil, err
+	}
+	if h.Truncated {
+		resp, err = dnsRoundTrip(ctx, "tcp", server, query)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := checkDNSResponse(resp, id); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// checkDNSResponse は応答のIDとRCODEを確認する
+func checkDNSResponse(resp []byte, id uint16) (dnsmessage.Header, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return h, err
+	}
+	if h.ID != id {
+		return h, errors.New("DNS response ID mismatch")
+	}
+	if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+		return h, fmt.Errorf("DNS server returned %s", h.RCode)
+	}
+	return h, nil
+}
+
+// dnsRoundTrip は指定したネットワークでクエリを1回送受信する
+func dnsRoundTrip(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		// TCPでは先頭2バイトにメッセージ長を付ける
+		buf := make([]byte, 2+len(query))
+		binary.BigEndian.PutUint16(buf, uint16(len(query)))
+		copy(buf[2:], query)
+		if _, err := conn.Write(buf); err != nil {
+			return nil, err
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+			return nil, err
+		}
+		resp := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+		if _, err := io.ReadFull(conn, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, dnsUDPSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
@@ -0,0 +1,215 @@
+package main
+
+// TTLに従うプロセス内のDNSキャッシュ
+// 同じホストへ何度も接続するときに毎回リゾルバへ問い合わせないようにする
+// --dns-cache-off で無効にでき、--dns-reresolve N でN回ごとに強制的に引き直せる
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsCacheEntry はホスト1つ分のキャッシュ
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// dnsCache はホスト名から解決したアドレスをTTLの間だけ保持する
+type dnsCache struct {
+	servers []string
+	// reresolveEvery が正のとき、ホストごとにこの回数に1回はキャッシュを使わない
+	reresolveEvery int
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+	lookups map[string]int
+	hosts   map[string][]string
+}
+
+// newDNSCache はDNSキャッシュを作る
+func newDNSCache(servers []string, reresolveEvery int) *dnsCache {
+	return &dnsCache{
+		servers:        servers,
+		reresolveEvery: reresolveEvery,
+		entries:        map[string]dnsCacheEntry{},
+		lookups:        map[string]int{},
+		hosts:          loadHostsFile(systemHostsFile()),
+	}
+}
+
+// lookup はホスト名をアドレスに解決する
+// 自分で解決すると net の名前解決の httptrace のフックが呼ばれないので、ここで呼ぶ
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	addrs, err := c.lookupAddrs(ctx, host)
+	if trace != nil && trace.DNSDone != nil {
+		info := httptrace.DNSDoneInfo{Err: err}
+		for _, a := range addrs {
+			info.Addrs = append(info.Addrs, net.IPAddr{IP: net.ParseIP(a)})
+		}
+		trace.DNSDone(info)
+	}
+	return addrs, err
+}
+
+// lookupAddrs はhostsファイルの記載を優先し、次にキャッシュ、最後にDNSへ問い合わせる
+func (c *dnsCache) lookupAddrs(ctx context.Context, host string) ([]string, error) {
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+
+	c.mu.Lock()
+	if addrs, ok := c.hosts[key]; ok {
+		c.mu.Unlock()
+		verbosef("DNS: %s -> %s (hosts file)", host, strings.Join(addrs, ","))
+		return addrs, nil
+	}
+	c.lookups[key]++
+	n := c.lookups[key]
+	forced := c.reresolveEvery > 0 && n > 1 && (n-1)%c.reresolveEvery == 0
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+
+	now := time.Now()
+	if ok && !forced && now.Before(entry.expires) {
+		verbosef("DNS: %s -> %s (cached, %s left)", host, strings.Join(entry.addrs, ","), entry.expires.Sub(now).Round(time.Second))
+		return entry.addrs, nil
+	}
+	if forced {
+		verbosef("DNS: re-resolving %s (lookup #%d)", host, n)
+	}
+
+	addrs, ttl, err := c.resolve(ctx, key)
+	if err != nil || len(addrs) == 0 {
+		// 検索ドメインなどDNSへの直接の問い合わせで解決できない名前は
+		// システムのリゾルバに任せ、キャッシュしない
+		ips, sysErr := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if sysErr != nil {
+			return nil, sysErr
+		}
+		addrs = nil
+		for _, ip := range ips {
+			addrs = append(addrs, ip.String())
+		}
+		verbosef("DNS: %s -> %s (system resolver, not cached)", host, strings.Join(addrs, ","))
+		return addrs, nil
+	}
+
+	c.mu.Lock()
+	c.entries[key] = dnsCacheEntry{addrs: addrs, expires: now.Add(ttl)}
+	c.mu.Unlock()
+	verbosef("DNS: %s -> %s (ttl %s)", host, strings.Join(addrs, ","), ttl)
+	return addrs, nil
+}
+
+// resolve はA/AAAAレコードを問い合わせ、アドレスと最小のTTLを返す
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]string, time.Duration, error) {
+	var addrs []string
+	var minTTL uint32
+	first := true
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		msg, err := dnsQuery(ctx, c.servers, host, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		var p dnsmessage.Parser
+		if _, err := p.Start(msg); err != nil {
+			return nil, 0, err
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return nil, 0, err
+		}
+		for {
+			h, err := p.AnswerHeader()
+			if err == dnsmessage.ErrSectionDone {
+				break
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+			switch h.Type {
+			case dnsmessage.TypeA:
+				r, err := p.AResource()
+				if err != nil {
+					return nil, 0, err
+				}
+				addrs = append(addrs, net.IP(r.A[:]).String())
+			case dnsmessage.TypeAAAA:
+				r, err := p.AAAAResource()
+				if err != nil {
+					return nil, 0, err
+				}
+				addrs = append(addrs, net.IP(r.AAAA[:]).String())
+			default:
+				// CNAMEなどはTTLだけ考慮する
+				if err := p.SkipAnswer(); err != nil {
+					return nil, 0, err
+				}
+			}
+			if first || h.TTL < minTTL {
+				minTTL = h.TTL
+				first = false
+			}
+		}
+	}
+	return addrs, time.Duration(minTTL) * time.Second, nil
+}
+
+// dialContext はキャッシュで名前解決してから dial で接続する
+// 複数のアドレスがあれば順番に試す
+func (c *dnsCache) dialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := c.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		var lastErr error = errors.New("no addresses for " + host)
+		for _, ip := range addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
+
+// loadHostsFile はhostsファイルを読み込んでホスト名からアドレスへの対応を返す
+func loadHostsFile(path string) map[string][]string {
+	hosts := map[string][]string{}
+	f, err := os.Open(path)
+	if err != nil {
+		return hosts
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(name)
+			hosts[name] = append(hosts[name], fields[0])
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+)
+
+// systemDNSServers は /etc/resolv.conf に書かれたネームサーバーを返す
+func systemDNSServers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		// fe80::1%eth0 のようなゾーン付きアドレスもそのまま使う
+		if net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]) == nil {
+			continue
+		}
+		servers = append(servers, net.JoinHostPort(fields[1], "53"))
+	}
+	return servers
+}
+
+// systemHostsFile はhostsファイルのパスを返す
+func systemHostsFile() string {
+	return "/etc/hosts"
+}
@@ -0,0 +1,57 @@
+//go:build windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// systemDNSServers は稼働中のネットワークアダプターに設定されたDNSサーバーを返す
+func systemDNSServers() []string {
+	var size uint32 = 15000
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0]))
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_PREFIX, 0, aa, &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return nil
+		}
+	}
+
+	var servers []string
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); aa != nil; aa = aa.Next {
+		if aa.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			ip := dns.Address.IP()
+			if ip == nil || ip.IsUnspecified() {
+				continue
+			}
+			// fec0:0:0:ffff::1 などのサイトローカルな既定値は使わない
+			if ip.To4() == nil && ip[0] == 0xfe && ip[1]&0xc0 == 0xc0 {
+				continue
+			}
+			servers = append(servers, net.JoinHostPort(ip.String(), "53"))
+		}
+	}
+	return servers
+}
+
+// systemHostsFile はhostsファイルのパスを返す
+func systemHostsFile() string {
+	root := os.Getenv("SystemRoot")
+	if root == "" {
+		root = `C:\Windows`
+	}
+	return filepath.Join(root, "System32", "drivers", "etc", "hosts")
+}
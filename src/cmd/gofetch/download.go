@@ -0,0 +1,235 @@
+package main
+
+// ファイルへのダウンロード (-o, --continue)
+// 本文をメモリーに溜めずに <出力ファイル>.partial へ書き、受け取り終えたら出力ファイルの名前に変える
+// 途中で失敗したときは --keep-partial か --continue なら .partial を残し、
+// --continue で次に実行したときに Range でその続きから受け取る
+// 失敗したときも、この実行より前からあったファイルは消さない。--continue で名前を変えた出力ファイルは、何も書いていなければ元の名前に戻す
+// --continue でサーバーが Range に応じなければ一時ファイルに初めから書き、成功してから出力ファイルに置き換える
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+
+	"gofetch/pkg/gofetch"
+)
+
+// downloadFile は -o の書き出し先の .partial
+// サーバーが Range に応じずに初めから送ってきたときは、.partial を残したまま一時ファイルに書く
+type downloadFile struct {
+	file *os.File
+	// enc は --encrypt-output で暗号化しながら file に書くもの
+	enc    *encryptedFile
+	output string
+	// offset は開いたときに既にあった大きさ。0より大きければこの実行で作ったファイルではない
+	offset int64
+	// renamed は --continue で既にあった出力ファイルを .partial に名前を変えたか
+	renamed bool
+	// fresh は続きから受け取れなかったときに初めから書く一時ファイル。成功すれば .partial の代わりに出力ファイルにする
+	fresh *os.File
+}
+
+// openDownload はダウンロードの書き出し先を開く。続きから受け取る位置は offset にある
+// resume なら .partial の続きから、.partial がなく出力ファイルがあればその続きから受け取る
+// resume でなければ .partial を新しく作る。前の実行の .partial があれば消さずにエラーにする
+// recipients があれば暗号化しながら書く。暗号化したものは続きから書けないので resume とは使えない
+func openDownload(output string, resume bool, recipients []age.Recipient) (*downloadFile, error) {
+	d := &downloadFile{output: output}
+	path := output + partialSuffix
+	if !resume {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0o644)
+		if errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("%s exists from an earlier download: use --continue to resume it or remove it", path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.file = f
+		if len(recipients) > 0 {
+			if d.enc, err = newEncryptedFile(f, recipients); err != nil {
+				f.Close()
+				os.Remove(path)
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		err := os.Rename(output, path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		d.renamed = err == nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	d.file, d.offset = f, fi.Size()
+	return d, nil
+}
+
+// Name は .partial のパスを返す
+func (d *downloadFile) Name() string {
+	return d.file.Name()
+}
+
+// current は今の書き出し先を返す
+func (d *downloadFile) current() gofetch.Destination {
+	switch {
+	case d.enc != nil:
+		return d.enc
+	case d.fresh != nil:
+		return d.fresh
+	}
+	return d.file
+}
+
+func (d *downloadFile) Write(b []byte) (int, error) {
+	return d.current().Write(b)
+}
+
+func (d *downloadFile) Seek(offset int64, whence int) (int64, error) {
+	return d.current().Seek(offset, whence)
+}
+
+// Truncate は書き出し先を切り詰める
+// 前からあった .partial を空にするときは、成功するまで残しておけるよう一時ファイルに切り替える
+func (d *downloadFile) Truncate(size int64) error {
+	if d.fresh == nil && d.enc == nil && d.offset > 0 && size < d.offset {
+		f, err := os.CreateTemp(filepath.Dir(d.output), filepath.Base(d.output)+".*.tmp")
+		if err != nil {
+			return err
+		}
+		d.fresh = f
+		return nil
+	}
+	return d.current().Truncate(size)
+}
+
+// Close は暗号化を終えて、開いているファイルを閉じる
+func (d *downloadFile) Close() error {
+	var err error
+	if d.enc != nil {
+		err = d.enc.Close()
+	}
+	if d.fresh != nil {
+		d.fresh.Close()
+	}
+	if cerr := d.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// finishDownload は書き終えた .partial か一時ファイルを出力ファイルの名前に変える
+func finishDownload(d *downloadFile) error {
+	if err := d.Close(); err != nil {
+		return err
+	}
+	if d.fresh == nil {
+		return os.Rename(d.Name(), d.output)
+	}
+	// 一時ファイルは 0600 で作られるので、ふつうのファイルと同じにする
+	if err := os.Chmod(d.fresh.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(d.fresh.Name(), d.output); err != nil {
+		return err
+	}
+	return os.Remove(d.Name())
+}
+
+// abandonDownload は失敗したダウンロードの .partial を閉じ、keep でなければ消す
+// この実行より前からあったファイルは消さない。出力ファイルから名前を変えて何も書いていなければ元の名前に戻す
+// 初めから書き直していた一時ファイルは消し、前からあった .partial をそのまま残す
+func abandonDownload(d *downloadFile, keep bool) {
+	d.Close()
+	if d.fresh != nil {
+		os.Remove(d.fresh.Name())
+	}
+	if d.offset == 0 {
+		if !keep {
+			os.Remove(d.Name())
+		}
+		return
+	}
+	if fi, err := os.Stat(d.Name()); d.renamed && err == nil && fi.Size() == d.offset {
+		os.Rename(d.Name(), d.output)
+	}
+}
+
+// runDownload は r の本文を d に書きながら取得する。showProgress なら進み具合を表示する
+func runDownload(fetcher *gofetch.Client, r gofetch.Request, d *downloadFile, showProgress bool) (gofetch.Response, error) {
+	if d.offset > 0 {
+		fmt.Fprintf(os.Stderr, "Resuming %s from %s\n", d.output, formatSize(d.offset))
+	}
+	var progress *progressBar
+	if showProgress {
+		progress = newProgressBar()
+	}
+	if progress != nil {
+		wrap := fetcher.WrapBody
+		fetcher.OnResponse = progress.begin
+		fetcher.WrapBody = func(body io.ReadCloser, cancel context.CancelCauseFunc) io.ReadCloser {
+			if wrap != nil {
+				body = wrap(body, cancel)
+			}
+			return progress.wrap(body)
+		}
+	}
+	res, err := fetcher.Download(context.Background(), r, d, d.offset)
+	if progress != nil {
+		progress.finish()
+	}
+	return res, err
+}
+
+// completeDownload は受け取り終えた .partial を出力ファイルにする。続きから受け取った場合はその大きさを表示する
+func completeDownload(d *downloadFile, res gofetch.Response) error {
+	switch {
+	case res.Complete():
+		fmt.Fprintf(os.Stderr, "%s is already complete (%s)\n", d.output, formatSize(res.Size))
+	case res.Size == 0 && len(res.Body) > 0:
+		// 続きを頼んだときのエラーのレスポンスは、受け取ってある分を消さないよう書かない
+		abandonDownload(d, true)
+		return fmt.Errorf("cannot resume %s: server answered %s", d.output, res.Raw.Status)
+	case res.Resumed > 0:
+		fmt.Fprintf(os.Stderr, "Resumed at %s, received %s (%s total)\n", formatSize(res.Resumed), formatSize(res.Size-res.Resumed), formatSize(res.Size))
+	}
+	return finishDownload(d)
+}
+
+// failDownload は失敗したダウンロードを片付けて終了コードを返す
+// keepPartial か resume なら受け取った分を .partial に残す
+func failDownload(d *downloadFile, resp *http.Response, err error, keepPartial, resume bool) int {
+	keep := keepPartial || resume
+	abandonDownload(d, keep)
+	if fi, serr := os.Stat(d.Name()); keep && serr == nil && fi.Size() > 0 {
+		expected := int64(-1)
+		if resp != nil {
+			expected = resp.ContentLength
+		}
+		reportPartial(d.Name(), fi.Size(), expected)
+		if d.enc == nil {
+			fmt.Fprintln(os.Stderr, "Partial: run again with --continue to resume")
+		}
+		if keepPartial {
+			return exitPartial
+		}
+	}
+	return exitCodeForError(err)
+}
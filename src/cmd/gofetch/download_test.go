@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestAbandonDownload(t *testing.T) {
+	tests := []struct {
+		name string
+		// output と partial はダウンロードの前からあったファイルの内容。空ならない
+		output, partial string
+		resume, keep    bool
+		// write は開いた後に書き足す内容
+		write string
+		// wantOutput と wantPartial は後に残るファイルの内容。空ならない
+		wantOutput, wantPartial string
+	}{
+		{name: "new download removed", write: "abc"},
+		{name: "new download kept", keep: true, write: "abc", wantPartial: "abc"},
+		{name: "complete output restored", output: "complete", resume: true, wantOutput: "complete"},
+		{name: "existing partial kept", partial: "half", resume: true, wantPartial: "half"},
+		{name: "resumed output with new data kept as partial", output: "half", resume: true, write: "more", wantPartial: "halfmore"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "file.bin")
+			if tt.output != "" {
+				os.WriteFile(output, []byte(tt.output), 0o644)
+			}
+			if tt.partial != "" {
+				os.WriteFile(output+partialSuffix, []byte(tt.partial), 0o644)
+			}
+			d, err := openDownload(output, tt.resume, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.write != "" {
+				d.Seek(d.offset, 0)
+				d.Write([]byte(tt.write))
+			}
+			abandonDownload(d, tt.keep)
+			for name, want := range map[string]string{output: tt.wantOutput, output + partialSuffix: tt.wantPartial} {
+				got, err := os.ReadFile(name)
+				switch {
+				case want == "" && err == nil:
+					t.Errorf("%s exists with %q", filepath.Base(name), got)
+				case want != "" && string(got) != want:
+					t.Errorf("%s = %q, %v; want %q", filepath.Base(name), got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenDownloadExistingPartial(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "file.bin")
+	os.WriteFile(output+partialSuffix, []byte("half"), 0o644)
+	if _, err := openDownload(output, false, nil); err == nil {
+		t.Fatal("openDownload without resume succeeded over an existing .partial")
+	}
+	if got, _ := os.ReadFile(output + partialSuffix); string(got) != "half" {
+		t.Errorf(".partial = %q; want it untouched", got)
+	}
+}
+
+func TestDownloadRestartKeepsPartial(t *testing.T) {
+	for _, succeed := range []bool{true, false} {
+		output := filepath.Join(t.TempDir(), "file.bin")
+		os.WriteFile(output+partialSuffix, []byte("half"), 0o644)
+		d, err := openDownload(output, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// サーバーが Range に応じなかったときの Client.Download と同じ順に呼ぶ
+		d.Truncate(0)
+		d.Seek(0, 0)
+		d.Write([]byte("new"))
+		if got, _ := os.ReadFile(output + partialSuffix); string(got) != "half" {
+			t.Fatalf(".partial = %q while restarting; want %q", got, "half")
+		}
+		want := map[string]string{output: "", output + partialSuffix: "half"}
+		if succeed {
+			if err := finishDownload(d); err != nil {
+				t.Fatal(err)
+			}
+			want = map[string]string{output: "new", output + partialSuffix: ""}
+		} else {
+			abandonDownload(d, true)
+		}
+		for name, w := range want {
+			got, err := os.ReadFile(name)
+			switch {
+			case w == "" && err == nil:
+				t.Errorf("succeed=%v: %s exists with %q", succeed, filepath.Base(name), got)
+			case w != "" && string(got) != w:
+				t.Errorf("succeed=%v: %s = %q, %v; want %q", succeed, filepath.Base(name), got, err, w)
+			}
+		}
+		// 一時ファイルは残らない
+		if entries, _ := os.ReadDir(filepath.Dir(output)); len(entries) != 1 {
+			t.Errorf("succeed=%v: files left: %v", succeed, entries)
+		}
+	}
+}
+
+func TestEncryptedDownload(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "file.bin")
+	d, err := openDownload(output, false, []age.Recipient{id.Recipient()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 途中で切れて続きを受け取り、その後サーバーが Range に応じずに初めから送り直した場合
+	d.Truncate(0)
+	d.Seek(0, io.SeekStart)
+	d.Write([]byte("abc"))
+	if _, err := d.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	d.Write([]byte("def"))
+	if _, err := d.Seek(2, io.SeekStart); err == nil {
+		t.Error("Seek into the middle of encrypted output succeeded")
+	}
+	d.Truncate(0)
+	d.Seek(0, io.SeekStart)
+	d.Write([]byte("hello"))
+	if err := finishDownload(d); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := age.Decrypt(f, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); string(got) != "hello" || err != nil {
+		t.Errorf("decrypted = %q, %v; want %q", got, err, "hello")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+// Encrypted Client Hello (ECH) の設定取得と結果の報告
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+)
+
+// resolveECHConfig はECHConfigListを返す
+// configFlag が指定されていればBase64としてデコードし、なければDNSのHTTPSレコードから取得する
+func resolveECHConfig(ctx context.Context, rawURL string, configFlag string, dnsServer string) ([]byte, error) {
+	if configFlag != "" {
+		list, err := base64.StdEncoding.DecodeString(configFlag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --ech-config: %w", err)
+		}
+		return list, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := resolveDNSServers(dnsServer)
+	if err != nil {
+		return nil, err
+	}
+	records, err := lookupHTTPS(ctx, servers, u.Hostname(), u.Port())
+	if err != nil {
+		return nil, err
+	}
+
+	// 優先度の高い(値の小さい)レコードから順に、ECH設定を持つものを採用する
+	// 優先度0はエイリアスモードなので対象外
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+	for _, r := range records {
+		if r.Priority != 0 && len(r.ECH) > 0 {
+			return r.ECH, nil
+		}
+	}
+	return nil, fmt.Errorf("no ECH config found in HTTPS records for %s", u.Hostname())
+}
+
+// reportECH はECHが受け入れられたかどうかを標準エラー出力に表示する
+func reportECH(state *tls.ConnectionState, err error) {
+	var rejection *tls.ECHRejectionError
+	switch {
+	case errors.As(err, &rejection):
+		if len(rejection.RetryConfigList) > 0 {
+			fmt.Fprintf(os.Stderr, "ECH: rejected (server offered retry configs: %s)\n",
+				base64.StdEncoding.EncodeToString(rejection.RetryConfigList))
+		} else {
+			fmt.Fprintln(os.Stderr, "ECH: rejected")
+		}
+	case err != nil:
+		// ECHとは無関係のエラーは通常のエラー処理に任せる
+	case state == nil:
+		fmt.Fprintln(os.Stderr, "ECH: not used (plain HTTP)")
+	case state.ECHAccepted:
+		fmt.Fprintln(os.Stderr, "ECH: accepted")
+	default:
+		fmt.Fprintln(os.Stderr, "ECH: not accepted")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+// 異常なリクエストによる堅牢性の確認 (--edge-case)
+// 自分のサーバーやプロキシに対して、巨大なヘッダー、ヘッダーの折り返し、Content-Length の重複、
+// リクエストスマグリングの形をしたリクエストなどを1件ずつ送り、サーバーがどう応答したかを表示する
+// net/http はこうしたリクエストを作れないので、接続に直接書き込む
+// 自分が管理しているサーバーだけに使うこと
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// edgeCase は送る異常なリクエスト1種類
+type edgeCase struct {
+	Name        string
+	Description string
+	build       func(host, path string) string
+}
+
+// edgeCases は --edge-case で選べるリクエスト
+var edgeCases = []edgeCase{
+	{"oversized-header", "a single 64KB header value", func(host, path string) string {
+		return "GET " + path + " HTTP/1.1\r\nHost: " + host + "\r\nX-Pad: " + strings.Repeat("a", 64<<10) + "\r\nConnection: close\r\n\r\n"
+	}},
+	{"many-headers", "1000 distinct headers", func(host, path string) string {
+		var b strings.Builder
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&b, "X-H%d: %d\r\n", i, i)
+		}
+		return "GET " + path + " HTTP/1.1\r\nHost: " + host + "\r\n" + b.String() + "Connection: close\r\n\r\n"
+	}},
+	{"obs-fold", "a header folded onto a continuation line", func(host, path string) string {
+		return "GET " + path + " HTTP/1.1\r\nHost: " + host + "\r\nX-Folded: first\r\n second\r\nConnection: close\r\n\r\n"
+	}},
+	{"bare-lf", "lines terminated by LF only", func(host, path string) string {
+		return "GET " + path + " HTTP/1.1\nHost: " + host + "\nConnection: close\n\n"
+	}},
+	{"space-in-name", "whitespace before the header colon", func(host, path string) string {
+		return "GET " + path + " HTTP/1.1\r\nHost: " + host + "\r\nX-Bad : 1\r\nConnection: close\r\n\r\n"
+	}},
+	{"duplicate-content-length", "two different Content-Length headers", func(host, path string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 5\r\nContent-Length: 6\r\nConnection: close\r\n\r\nhello"
+	}},
+	{"cl-te", "both Content-Length and Transfer-Encoding: chunked", func(host, path string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\n0\r\n\r\nX"
+	}},
+	{"te-obfuscated", "Transfer-Encoding with a space before the colon", func(host, path string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: " + host + "\r\nContent-Length: 5\r\nTransfer-Encoding : chunked\r\nConnection: close\r\n\r\n0\r\n\r\n"
+	}},
+	{"te-te", "two Transfer-Encoding headers (chunked, identity)", func(host, path string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: " + host + "\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: identity\r\nConnection: close\r\n\r\n0\r\n\r\n"
+	}},
+	{"invalid-chunk-size", "a chunk size that is not hexadecimal", func(host, path string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: " + host + "\r\nTransfer-Encoding: chunked\r\nConnection: close\r\n\r\nzz\r\nhello\r\n0\r\n\r\n"
+	}},
+	{"absolute-uri", "absolute-form request target with a different Host", func(host, path string) string {
+		return "GET http://" + host + path + " HTTP/1.1\r\nHost: other.invalid\r\nConnection: close\r\n\r\n"
+	}},
+	{"missing-host", "an HTTP/1.1 request without Host", func(host, path string) string {
+		return "GET " + path + " HTTP/1.1\r\nConnection: close\r\n\r\n"
+	}},
+}
+
+// edgeCaseNames は選べるリクエストの名前を返す
+func edgeCaseNames() []string {
+	names := make([]string, len(edgeCases))
+	for i, c := range edgeCases {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// selectEdgeCases は "all" またはカンマ区切りの名前からリクエストを選ぶ
+func selectEdgeCases(spec string) ([]edgeCase, error) {
+	if spec == "all" {
+		return edgeCases, nil
+	}
+	byName := map[string]edgeCase{}
+	for _, c := range edgeCases {
+		byName[c.Name] = c
+	}
+	var selected []edgeCase
+	for _, name := range splitList(spec) {
+		c, ok := byName[name]
+		if !ok {
+			names := edgeCaseNames()
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown edge case %q (want all or %s)", name, strings.Join(names, ", "))
+		}
+		selected = append(selected, c)
+	}
+	return selected, nil
+}
+
+// edgeCaseResult はリクエスト1件に対するサーバーの応答
+type edgeCaseResult struct {
+	Status    string
+	Responses int
+	Elapsed   time.Duration
+	Err       error
+}
+
+// runEdgeCases はリクエストを1件ずつ新しい接続で送り、結果を表にして表示する
+func runEdgeCases(dial dialFunc, tlsConf *tls.Config, rawURL string, cases []edgeCase, timeout time.Duration) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	host := u.Host
+	path := u.RequestURI()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if u.Scheme == "https" {
+		dial = dialTLSWrapped(dial, tlsConf, func(c net.Conn) net.Conn { return c })
+	}
+
+	fmt.Fprintf(os.Stderr, "Sending %d edge-case request(s) to %s\n", len(cases), addr)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CASE\tRESPONSE\tTIME\tNOTE")
+	for _, c := range cases {
+		r := sendEdgeCase(dial, addr, []byte(c.build(host, path)), timeout)
+		note := c.Description
+		if r.Responses > 1 {
+			note = fmt.Sprintf("%d responses to one request (possible desync); %s", r.Responses, note)
+		}
+		status := r.Status
+		if r.Err != nil {
+			status = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, status, r.Elapsed.Round(time.Millisecond), note)
+	}
+	tw.Flush()
+}
+
+// sendEdgeCase はリクエストを送り、接続が閉じられるかタイムアウトするまで応答を読む
+func sendEdgeCase(dial dialFunc, addr string, req []byte, timeout time.Duration) edgeCaseResult {
+	var r edgeCaseResult
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	verbosef("Edge case: sending %d bytes", len(req))
+	if _, err := conn.Write(req); err != nil {
+		r.Err = fmt.Errorf("write failed: %v", err)
+		r.Elapsed = time.Since(start)
+		return r
+	}
+
+	var buf bytes.Buffer
+	chunk := make([]byte, 32<<10)
+	for buf.Len() < 1<<20 {
+		n, err := conn.Read(chunk)
+		buf.Write(chunk[:n])
+		if err != nil {
+			if buf.Len() == 0 {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					r.Err = fmt.Errorf("no response within %s", timeout)
+				} else {
+					r.Err = fmt.Errorf("closed without response (%v)", err)
+				}
+			}
+			break
+		}
+	}
+	r.Elapsed = time.Since(start)
+
+	data := buf.Bytes()
+	if line, _, ok := bytes.Cut(data, []byte("\n")); ok || len(data) > 0 {
+		r.Status = strings.TrimSpace(string(line))
+	}
+	// 行頭のステータス行を数える。1件より多ければサーバーがリクエストを分けて解釈した可能性がある
+	r.Responses = bytes.Count(append([]byte("\n"), data...), []byte("\nHTTP/1."))
+	return r
+}
@@ -0,0 +1,169 @@
+package main
+
+// 複数のエグレス(プロキシ)経由での取得と比較 (--egress, --egress-file)
+// 地域ごとのSOCKS/HTTPプロキシを経由して同じURLを取得し、
+// ステータス、レイテンシ、本文のハッシュを並べて地域特有の障害を見つける
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// egress はプロキシ1つ分の経路
+type egress struct {
+	Name  string
+	Proxy *url.URL
+}
+
+// egressFile はエグレスの一覧を書いたファイル
+//
+//	egress:
+//	  - name: tokyo
+//	    proxy: socks5://10.0.1.10:1080
+//	  - name: frankfurt
+//	    proxy: http://10.0.2.10:3128
+type egressFile struct {
+	Egress []struct {
+		Name  string `yaml:"name"`
+		Proxy string `yaml:"proxy"`
+	} `yaml:"egress"`
+}
+
+// egressResult はエグレス1つ分の取得結果
+type egressResult struct {
+	Name    string
+	Status  int
+	Latency time.Duration
+	Size    int64
+	SHA256  string
+	Err     error
+	// WireSent と WireReceived はヘッダーを含めて通信路上で送受信したバイト数
+	WireSent     int64
+	WireReceived int64
+}
+
+// newEgress は名前とプロキシURLからエグレスを作る
+func newEgress(name, proxy string) (egress, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return egress{}, fmt.Errorf("egress %s: %w", name, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return egress{}, fmt.Errorf("egress %s: unsupported proxy scheme %q", name, u.Scheme)
+	}
+	return egress{Name: name, Proxy: u}, nil
+}
+
+// parseEgress は "name=proxyURL" 形式の指定を解析する
+func parseEgress(spec string) (egress, error) {
+	name, proxy, ok := strings.Cut(spec, "=")
+	if !ok || name == "" {
+		return egress{}, fmt.Errorf("invalid egress %q (want name=proxy-url)", spec)
+	}
+	return newEgress(name, proxy)
+}
+
+// loadEgressFile はエグレスの一覧をファイルから読み込む
+func loadEgressFile(path string) ([]egress, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f egressFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var list []egress
+	for _, e := range f.Egress {
+		eg, err := newEgress(e.Name, e.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		list = append(list, eg)
+	}
+	return list, nil
+}
+
+// fetchViaEgresses はすべてのエグレスから同時にURLを取得する
+// 結果はエグレスの指定順に並ぶ
+func fetchViaEgresses(base *http.Transport, timeout time.Duration, rawURL string, egresses []egress) []egressResult {
+	results := make([]egressResult, len(egresses))
+	var wg sync.WaitGroup
+	for i, eg := range egresses {
+		wg.Add(1)
+		go func(i int, eg egress) {
+			defer wg.Done()
+			wire := &wireCounter{}
+			transport := base.Clone()
+			transport.Proxy = http.ProxyURL(eg.Proxy)
+			transport.DialContext = wire.dialContext(transport.DialContext)
+			client := &http.Client{Timeout: timeout, Transport: transport}
+			results[i] = fetchViaEgress(client, rawURL, eg.Name)
+			results[i].WireSent = wire.sent.Load()
+			results[i].WireReceived = wire.received.Load()
+		}(i, eg)
+	}
+	wg.Wait()
+	return results
+}
+
+// fetchViaEgress は1つのエグレスからURLを取得して結果をまとめる
+func fetchViaEgress(client *http.Client, rawURL string, name string) egressResult {
+	r := egressResult{Name: name}
+	start := time.Now()
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	r.Size, r.Err = io.Copy(h, resp.Body)
+	r.Latency = time.Since(start)
+	r.Status = resp.StatusCode
+	r.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return r
+}
+
+// printEgressResults は結果を表にして表示する
+// すべてのエグレスで成功し、ステータスと本文が一致していれば true を返す
+func printEgressResults(results []egressResult) bool {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "EGRESS\tSTATUS\tLATENCY\tSIZE\tWIRE TX/RX\tSHA256")
+	consistent := true
+	for i, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\tERROR\t\t\t\t%v\n", r.Name, r.Err)
+			consistent = false
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s/%s\t%s\n", r.Name, r.Status, r.Latency.Round(time.Millisecond),
+			formatSize(r.Size), formatSize(r.WireSent), formatSize(r.WireReceived), r.SHA256[:12])
+		first := results[0]
+		if i > 0 && (first.Err != nil || r.Status != first.Status || r.SHA256 != first.SHA256) {
+			consistent = false
+		}
+	}
+	tw.Flush()
+
+	if consistent {
+		fmt.Printf("Result: consistent across %d egress points\n", len(results))
+	} else {
+		fmt.Println("Result: responses differ between egress points")
+	}
+	return consistent
+}
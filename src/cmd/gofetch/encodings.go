@@ -0,0 +1,59 @@
+package main
+
+// 圧縮による削減量の比較 (--compare-encodings)
+// 同じリソースを Accept-Encoding を identity、gzip、br、zstd に変えて取得し、
+// 転送されたサイズと時間を並べて、Brotli や zstd を有効にしたときの削減量を示す
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// compareEncodings は比べる Accept-Encoding の値
+var compareEncodings = []string{"identity", "gzip", "br", "zstd"}
+
+// runCompareEncodings はエンコーディングごとに取得し、サイズと時間を表にして表示する
+// Accept-Encoding を明示するので、net/http は本文を展開せず、転送されたままのサイズを数える
+func runCompareEncodings(client *http.Client, rawURL string, base http.Header) {
+	type row struct {
+		negotiateResult
+		Elapsed time.Duration
+	}
+	var rows []row
+	for _, enc := range compareEncodings {
+		start := time.Now()
+		r := fetchNegotiated(client, rawURL, base, "Accept-Encoding", enc)
+		rows = append(rows, row{r, time.Since(start)})
+	}
+
+	// identity で得たサイズを基準にする
+	var identity int64 = -1
+	if r := rows[0]; r.Err == nil && r.Encoding == "" {
+		identity = r.Size
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUESTED\tSERVED\tSTATUS\tSIZE\tTIME\tSAVED")
+	for _, r := range rows {
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\t\tERROR\t\t\t%v\n", r.Value, r.Err)
+			continue
+		}
+		served := orDash(r.Encoding)
+		saved := "-"
+		switch {
+		case r.Value != "identity" && r.Encoding == "":
+			served = "- (not supported)"
+		case identity > 0 && r.Encoding != "":
+			saved = fmt.Sprintf("%.1f%%", 100*float64(identity-r.Size)/float64(identity))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", r.Value, served, r.Status, formatSize(r.Size), r.Elapsed.Round(time.Millisecond), saved)
+	}
+	tw.Flush()
+	if identity < 0 {
+		fmt.Fprintln(os.Stderr, "Warning: the server compressed the identity response; savings are not shown")
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+// 出力ファイルの暗号化 (--encrypt-output)
+// 共有のマシンで機密性の高いデータを取得するときに、平文をディスクに残さないように
+// age (https://age-encryption.org) の形式で暗号化してから書き込む
+// 受信者は age1... の公開鍵、SSHの公開鍵、またはそれらを1行に1つずつ書いたファイルで指定する
+// -o へのダウンロードでは受け取るたびに暗号化して書くので、本文全体をメモリーに溜めない
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// parseRecipient は受信者1つを解析する
+func parseRecipient(s string) (age.Recipient, error) {
+	switch {
+	case strings.HasPrefix(s, "age1"):
+		return age.ParseX25519Recipient(s)
+	case strings.HasPrefix(s, "ssh-"):
+		return agessh.ParseRecipient(s)
+	}
+	return nil, fmt.Errorf("unknown recipient type %q (want age1... or an SSH public key)", s)
+}
+
+// parseRecipients は --encrypt-output の指定から受信者の一覧を作る
+// 公開鍵でなければ受信者を書いたファイルのパスとして読み込む
+func parseRecipients(specs []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, "age1") || strings.HasPrefix(spec, "ssh-") {
+			r, err := parseRecipient(spec)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, r)
+			continue
+		}
+		list, err := loadRecipientsFile(spec)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, list...)
+	}
+	return recipients, nil
+}
+
+// loadRecipientsFile は受信者を1行に1つずつ書いたファイルを読み込む
+// 空行と # で始まる行は無視する
+func loadRecipientsFile(path string) ([]age.Recipient, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var recipients []age.Recipient
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		recipients = append(recipients, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%s: no recipients", path)
+	}
+	return recipients, nil
+}
+
+// writeEncrypted はデータを暗号化しながら一時ファイルに書き込み、完了したら path に置き換える
+// 途中で失敗しても中途半端なファイルは残らない
+func writeEncrypted(path string, data []byte, recipients []age.Recipient) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".gofetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w, err := age.Encrypt(f, recipients...)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// encryptedFile は書くデータを暗号化しながら f に書く、ダウンロードの書き出し先
+// age の形式は先頭から順にしか書けないので、Seek で動けるのは先頭か書き終えた位置だけ
+// 先頭からの書き直しは Truncate(0) で f を空にして暗号化をやり直す
+type encryptedFile struct {
+	f          *os.File
+	recipients []age.Recipient
+	w          io.WriteCloser
+	// written は暗号化して書いた平文の大きさ
+	written int64
+}
+
+// newEncryptedFile は空の f に暗号化して書き始める
+func newEncryptedFile(f *os.File, recipients []age.Recipient) (*encryptedFile, error) {
+	w, err := age.Encrypt(f, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedFile{f: f, recipients: recipients, w: w}, nil
+}
+
+func (e *encryptedFile) Write(b []byte) (int, error) {
+	n, err := e.w.Write(b)
+	e.written += int64(n)
+	return n, err
+}
+
+// Seek は平文の位置で動く。書き終えた位置より前には、先頭にしか戻れない
+func (e *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case whence == io.SeekStart && offset == e.written:
+		return offset, nil
+	case whence == io.SeekStart && offset == 0:
+		return 0, e.Truncate(0)
+	}
+	return 0, fmt.Errorf("encrypted output cannot seek to %d", offset)
+}
+
+// Truncate は f を空にして暗号化をやり直す。途中までの切り詰めはできない
+func (e *encryptedFile) Truncate(size int64) error {
+	if size != 0 {
+		return fmt.Errorf("encrypted output cannot be truncated to %d", size)
+	}
+	if err := e.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := e.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w, err := age.Encrypt(e.f, e.recipients...)
+	if err != nil {
+		return err
+	}
+	e.w, e.written = w, 0
+	return nil
+}
+
+// Close は暗号化を終える。f は閉じない
+func (e *encryptedFile) Close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,48 @@
+package main
+
+// 終了コード
+// シェルスクリプトやCIで失敗の種類を見分けられるよう、失敗の種類ごとに決まった終了コードで終わる
+//
+//	0  成功 (--fail がなければ 4xx と 5xx も成功)
+//	1  使い方や設定の誤り、--budget や --golden などの確認の失敗
+//	2  ネットワークのエラー (名前解決、接続、TLS、切断)
+//	3  本文を途中までしか受信できなかった (--keep-partial)
+//	4  HTTPのエラー (--fail で 4xx か 5xx のレスポンス、リダイレクトのループ)
+//	5  タイムアウト
+//
+// 複数のURLを取得した場合は、失敗したURLのうち最初のものの終了コードで終わる。--fail なら 4xx と 5xx は 4 になる
+
+import (
+	"errors"
+	"io/fs"
+
+	"gofetch/pkg/gofetch"
+)
+
+const (
+	// exitUsage は使い方や設定の誤りと、確認の失敗の終了コード
+	exitUsage = 1
+	// exitNetwork はネットワークのエラーの終了コード
+	exitNetwork = 2
+	// exitHTTP は --fail で 4xx か 5xx のレスポンスを受け取ったときと、リダイレクトがループしたときの終了コード
+	exitHTTP = 4
+	// exitTimeout はタイムアウトの終了コード
+	exitTimeout = 5
+)
+
+// exitCodeForError は取得に失敗したエラーの終了コードを返す
+func exitCodeForError(err error) int {
+	var pathErr *fs.PathError
+	var loopErr *gofetch.RedirectLoopError
+	switch {
+	case errors.As(err, &pathErr):
+		// 出力ファイルに書けないなどの手元の誤り
+		return exitUsage
+	case errors.As(err, &loopErr):
+		// サーバーまでは届いているので、ネットワークではなくHTTPのエラーとする
+		return exitHTTP
+	case responseClass(0, err) == "timeout":
+		return exitTimeout
+	}
+	return exitNetwork
+}
@@ -0,0 +1,117 @@
+package main
+
+// レスポンスの確認 (--expect-status, --expect-body-contains, --expect-header, --expect-max-time)
+// ステータス、本文に含まれる文字列、ヘッダー、合計時間を確かめ、1つでも外れたら終了コード1で終了する
+// コンテナや Kubernetes のヘルスチェック、デプロイ後のスモークテストに gofetch をそのまま使えるようにする
+// ステータスは 200、200,204、2xx のように指定する。ヘッダーは Name だけなら存在を、Name: value なら
+// 値に value を含むかを確かめるので、Content-Type: application/json は charset の付いた値にも合う
+// 本文は --proto や --decode でJSONにした後のものを使う。結果はそれぞれ標準エラー出力に表示する
+//
+//	gofetch -u http://localhost:8080/healthz --expect-status 200 --expect-body-contains ok --expect-max-time 2s
+//	gofetch -u https://api.example.com/v1/ping --expect-status 2xx --expect-header 'Content-Type: application/json'
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expectations はレスポンスに期待すること。空の項目は確かめない
+type expectations struct {
+	// statuses は 200 か 2xx の形のステータスの並び。どれかに合えばよい
+	statuses []string
+	contains []string
+	headers  [][2]string
+	maxTime  time.Duration
+}
+
+// parseExpectations はフラグの値から期待することを作る
+func parseExpectations(status string, contains, headers []string, maxTime string) (expectations, error) {
+	e := expectations{contains: contains}
+	for _, s := range strings.Split(status, ",") {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if s == "" {
+			continue
+		}
+		if len(s) != 3 || !(s[1:] == "xx" && s[0] >= '1' && s[0] <= '5') {
+			if n, err := strconv.Atoi(s); err != nil || n < 100 || n > 599 {
+				return e, fmt.Errorf("invalid --expect-status %q (want e.g. 200, 200,204 or 2xx)", s)
+			}
+		}
+		e.statuses = append(e.statuses, s)
+	}
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return e, fmt.Errorf("invalid --expect-header %q (want Name or Name: value)", h)
+		}
+		e.headers = append(e.headers, [2]string{http.CanonicalHeaderKey(name), strings.TrimSpace(value)})
+	}
+	if maxTime != "" {
+		d, err := time.ParseDuration(maxTime)
+		if err != nil || d <= 0 {
+			return e, fmt.Errorf("invalid --expect-max-time %q (want e.g. 500ms or 2s)", maxTime)
+		}
+		e.maxTime = d
+	}
+	return e, nil
+}
+
+// isZero は期待することが1つもないかを返す
+func (e expectations) isZero() bool {
+	return len(e.statuses) == 0 && len(e.contains) == 0 && len(e.headers) == 0 && e.maxTime == 0
+}
+
+// needsBody は本文を確かめるかを返す。本文をファイルに直接書く場合は読めないので使う
+func (e expectations) needsBody() bool {
+	return len(e.contains) > 0
+}
+
+// check はレスポンスを確かめて結果を標準エラー出力に表示する。1つでも外れていれば false を返す
+func (e expectations) check(resp *http.Response, body []byte, total time.Duration) bool {
+	ok := true
+	report := func(name, detail string, passed bool) {
+		status := "ok"
+		if !passed {
+			status = "FAILED"
+			ok = false
+		}
+		fmt.Fprintf(os.Stderr, "Expect: %-6s %s %s\n", name, detail, status)
+	}
+	if len(e.statuses) > 0 {
+		code := strconv.Itoa(resp.StatusCode)
+		matched := false
+		for _, s := range e.statuses {
+			matched = matched || s == code || strings.HasSuffix(s, "xx") && s[0] == code[0]
+		}
+		report("status", fmt.Sprintf("%d (want %s)", resp.StatusCode, strings.Join(e.statuses, ", ")), matched)
+	}
+	for _, s := range e.contains {
+		report("body", fmt.Sprintf("contains %q", s), bytes.Contains(body, []byte(s)))
+	}
+	for _, h := range e.headers {
+		values := resp.Header.Values(h[0])
+		if h[1] == "" {
+			report("header", fmt.Sprintf("%s present", h[0]), len(values) > 0)
+			continue
+		}
+		matched := false
+		for _, v := range values {
+			matched = matched || strings.Contains(v, h[1])
+		}
+		shown := make([]string, len(values))
+		for i, v := range values {
+			shown[i] = redactSecrets(maskSensitive(h[0], v))
+		}
+		report("header", fmt.Sprintf("%s: %s (got %s)", h[0], h[1], orDash(strings.Join(shown, ", "))), matched)
+	}
+	if e.maxTime != 0 {
+		report("time", fmt.Sprintf("%s (max %s)", total.Round(time.Millisecond), e.maxTime), total <= e.maxTime)
+	}
+	return ok
+}
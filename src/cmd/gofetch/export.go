@@ -0,0 +1,71 @@
+package main
+
+// レスポンスヘッダーの書き出し (--export-header, --export-file)
+// 指定したレスポンスヘッダーを KEY=value の形で出力し、スクリプトが
+// eval やdotenvファイルで ETag、レート制限の残り回数、リクエストIDなどを受け取れるようにする
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// exportKeyPattern はシェルの変数名として使える名前
+var exportKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// headerExport は書き出すヘッダー1つ
+type headerExport struct {
+	Key    string
+	Header string
+}
+
+// parseHeaderExport は "KEY=Header" を解析する
+// "Header" だけの場合は X-Request-Id を X_REQUEST_ID のように変数名にする
+func parseHeaderExport(spec string) (headerExport, error) {
+	key, header, ok := strings.Cut(spec, "=")
+	if !ok {
+		header = spec
+		key = strings.ToUpper(strings.ReplaceAll(spec, "-", "_"))
+	}
+	if header == "" || !exportKeyPattern.MatchString(key) {
+		return headerExport{}, fmt.Errorf("invalid --export-header %q (want KEY=Header-Name)", spec)
+	}
+	return headerExport{Key: key, Header: header}, nil
+}
+
+// shellQuote は値をシェルの単一引用符で囲む
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// dotenvQuote は値をdotenvの二重引用符で囲む
+func dotenvQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// writeHeaderExports はヘッダーを KEY=value の行にして書き出す
+// path が空なら eval できる形で標準出力に、そうでなければdotenvの形でファイルに書く
+// 複数の値を持つヘッダーはカンマでつなぐ。ないヘッダーは空の値にする
+func writeHeaderExports(h http.Header, exports []headerExport, path string) error {
+	var b strings.Builder
+	for _, e := range exports {
+		values := h.Values(e.Header)
+		if len(values) == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: response has no %s header; exporting %s as empty\n", http.CanonicalHeaderKey(e.Header), e.Key)
+		}
+		value := strings.Join(values, ", ")
+		if path == "" {
+			fmt.Fprintf(&b, "%s=%s\n", e.Key, shellQuote(value))
+		} else {
+			fmt.Fprintf(&b, "%s=%s\n", e.Key, dotenvQuote(value))
+		}
+	}
+	if path == "" {
+		_, err := fmt.Print(b.String())
+		return err
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
@@ -0,0 +1,238 @@
+package main
+
+// 取得したアーカイブの展開 (--extract, --strip-components)
+// "curl | tar xz" の代わりに、.tar.gz/.tgz/.tar/.zip を指定したディレクトリに展開する
+// 形式は本文の先頭のバイト列で判定する
+// 展開先の外に書き込むエントリ(絶対パス、..、外を指すシンボリックリンク)はエラーにする
+// 書き込みは os.Root を通し、先に展開したシンボリックリンクをたどって外に書いたり、リンクを通してファイルを上書きしたりしない
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// extractResponse は本文のアーカイブを dir に展開し、展開したファイルの数を表示する
+func extractResponse(body []byte, dir string, strip int) error {
+	n, err := extractArchive(body, dir, strip)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Extracted %d file(s) to %s\n", n, dir)
+	return nil
+}
+
+// extractArchive はアーカイブの形式を判定して dir に展開し、展開したファイルの数を返す
+// 書き込みはすべて os.Root を通すので、先に展開したシンボリックリンクをたどって dir の外に書くことはない
+func extractArchive(data []byte, dir string, strip int) (int, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer root.Close()
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		return extractTar(zr, root, strip)
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return extractZip(data, root, strip)
+	case len(data) > 262 && string(data[257:262]) == "ustar":
+		return extractTar(bytes.NewReader(data), root, strip)
+	}
+	return 0, errors.New("response is not a .tar.gz, .tar or .zip archive")
+}
+
+// archivePath はアーカイブ内のパスから先頭の strip 個の要素を取り除き、展開先の中の相対パスを / 区切りで返す
+// 取り除いた結果が空になるエントリは skip に true を返す
+func archivePath(name string, strip int) (rel string, skip bool, err error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", false, fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false, fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	parts := strings.Split(cleaned, "/")
+	if cleaned == "." || len(parts) <= strip {
+		return "", true, nil
+	}
+	return path.Join(parts[strip:]...), false, nil
+}
+
+// checkLinkTarget はシンボリックリンクが展開先の外を指していないか調べる
+// 親のディレクトリは実際のパスで確かめる。x/.. のように名前の後に .. が続くと、x が後からシンボリックリンクに
+// なった場合に見た目と違う場所を指すので、.. は先頭にだけ許す
+func checkLinkTarget(root *os.Root, rel, target string) (string, error) {
+	unsafe := fmt.Errorf("unsafe symlink in archive: %s -> %s", rel, target)
+	if path.IsAbs(target) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return "", unsafe
+	}
+	seenName := false
+	for _, part := range strings.Split(strings.ReplaceAll(target, `\`, "/"), "/") {
+		switch part {
+		case "..":
+			if seenName {
+				return "", unsafe
+			}
+		case "", ".":
+		default:
+			seenName = true
+		}
+	}
+	base, err := filepath.EvalSymlinks(root.Name())
+	if err != nil {
+		return "", err
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Join(base, filepath.FromSlash(path.Dir(rel))))
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(base, parent) || !withinDir(base, filepath.Join(parent, filepath.FromSlash(target))) {
+		return "", unsafe
+	}
+	return filepath.Join(parent, path.Base(rel)), nil
+}
+
+// withinDir は p が dir かその中にあるかを返す
+func withinDir(dir, p string) bool {
+	r, err := filepath.Rel(dir, p)
+	return err == nil && r != ".." && !strings.HasPrefix(r, ".."+string(filepath.Separator))
+}
+
+// mkdirAllIn は root の中に rel のディレクトリを親から順に作る
+func mkdirAllIn(root *os.Root, rel string) error {
+	if rel == "." || rel == "" {
+		return nil
+	}
+	dir := ""
+	for _, part := range strings.Split(rel, "/") {
+		dir = path.Join(dir, part)
+		if err := root.Mkdir(dir, 0755); err != nil && !errors.Is(err, os.ErrExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractTar はtarアーカイブを展開する
+func extractTar(r io.Reader, root *os.Root, strip int) (int, error) {
+	tr := tar.NewReader(r)
+	count := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		rel, skip, err := archivePath(hdr.Name, strip)
+		if err != nil {
+			return count, err
+		}
+		if skip {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = mkdirAllIn(root, rel)
+		case tar.TypeReg:
+			err = writeArchiveFile(root, rel, tr, hdr.FileInfo().Mode().Perm())
+			count++
+		case tar.TypeSymlink:
+			if err = mkdirAllIn(root, path.Dir(rel)); err == nil {
+				var dest string
+				if dest, err = checkLinkTarget(root, rel, hdr.Linkname); err == nil {
+					err = os.Symlink(hdr.Linkname, dest)
+				}
+			}
+			count++
+		default:
+			verbosef("Extract: skipping %s (unsupported entry type)", hdr.Name)
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		verbosef("Extract: %s", filepath.Join(root.Name(), filepath.FromSlash(rel)))
+	}
+}
+
+// extractZip はzipアーカイブを展開する
+func extractZip(data []byte, root *os.Root, strip int) (int, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, f := range zr.File {
+		rel, skip, err := archivePath(f.Name, strip)
+		if err != nil {
+			return count, err
+		}
+		if skip {
+			continue
+		}
+		if f.FileInfo().IsDir() {
+			if err := mkdirAllIn(root, rel); err != nil {
+				return count, err
+			}
+			continue
+		}
+		if f.Mode()&os.ModeSymlink != 0 {
+			verbosef("Extract: skipping %s (symlink in zip)", f.Name)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return count, err
+		}
+		err = writeArchiveFile(root, rel, rc, f.Mode().Perm())
+		rc.Close()
+		if err != nil {
+			return count, err
+		}
+		count++
+		verbosef("Extract: %s", filepath.Join(root.Name(), filepath.FromSlash(rel)))
+	}
+	return count, nil
+}
+
+// writeArchiveFile はエントリの内容を root の中のファイルに書き込む
+// 既にあるシンボリックリンクを通して書くことはしない
+func writeArchiveFile(root *os.Root, rel string, r io.Reader, perm os.FileMode) error {
+	if err := mkdirAllIn(root, path.Dir(rel)); err != nil {
+		return err
+	}
+	if fi, err := root.Lstat(rel); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("unsafe path in archive: %s would be written through a symlink", rel)
+	}
+	if perm == 0 {
+		perm = 0644
+	}
+	f, err := root.OpenFile(rel, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
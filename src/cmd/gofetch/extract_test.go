@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// archiveEntry はテストで作るアーカイブのエントリ。link があればシンボリックリンクにする
+type archiveEntry struct {
+	name, body, link string
+	dir              bool
+}
+
+func buildTar(t *testing.T, entries []archiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case e.dir:
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+		strip   int
+		// files は展開先の中にできるファイルとその内容
+		files map[string]string
+		// wantErr はエラーに含まれる文字列。空ならエラーにならない
+		wantErr string
+	}{
+		{
+			name:    "regular files",
+			entries: []archiveEntry{{name: "pkg/", dir: true}, {name: "pkg/a.txt", body: "a"}, {name: "pkg/sub/b.txt", body: "b"}},
+			files:   map[string]string{"pkg/a.txt": "a", "pkg/sub/b.txt": "b"},
+		},
+		{
+			name:    "strip components",
+			entries: []archiveEntry{{name: "pkg-1.0/bin/tool", body: "x"}, {name: "pkg-1.0/README", body: "r"}},
+			strip:   1,
+			files:   map[string]string{"bin/tool": "x", "README": "r"},
+		},
+		{
+			name:    "parent after a name in the same entry",
+			entries: []archiveEntry{{name: "lib/real.so", body: "so"}, {name: "lib/link.so", link: "real.so"}, {name: "up", link: "lib/../lib"}},
+			wantErr: "unsafe symlink",
+		},
+		{
+			name:    "relative symlink to sibling",
+			entries: []archiveEntry{{name: "lib/real.so", body: "so"}, {name: "lib/link.so", link: "real.so"}, {name: "bin/tool", link: "../lib/real.so"}},
+			files:   map[string]string{"lib/real.so": "so", "lib/link.so": "so", "bin/tool": "so"},
+		},
+		{
+			name:    "absolute path",
+			entries: []archiveEntry{{name: "/etc/passwd", body: "x"}},
+			wantErr: "unsafe path",
+		},
+		{
+			name:    "parent path",
+			entries: []archiveEntry{{name: "../escape.txt", body: "x"}},
+			wantErr: "unsafe path",
+		},
+		{
+			name:    "symlink to parent",
+			entries: []archiveEntry{{name: "out", link: "../"}},
+			wantErr: "unsafe symlink",
+		},
+		{
+			name:    "absolute symlink",
+			entries: []archiveEntry{{name: "out", link: "/etc"}},
+			wantErr: "unsafe symlink",
+		},
+		{
+			name:    "chained symlinks",
+			entries: []archiveEntry{{name: "a", link: "."}, {name: "a/b", link: ".."}, {name: "a/b/PWNED.txt", body: "pwned"}},
+			wantErr: "unsafe symlink",
+		},
+		{
+			name:    "parent after a symlinked name",
+			entries: []archiveEntry{{name: "a", link: "."}, {name: "c", link: "a/../x"}},
+			wantErr: "unsafe symlink",
+		},
+		{
+			name:    "file through existing symlink",
+			entries: []archiveEntry{{name: "sub/target.txt", body: "keep"}, {name: "link", link: "sub/target.txt"}, {name: "link", body: "overwrite"}},
+			wantErr: "through a symlink",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "out")
+			_, err := extractArchive(buildTar(t, tt.entries), dir, tt.strip)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for name, want := range tt.files {
+				got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+				if err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+				}
+			}
+			// 展開先の外には何も書かない
+			entries, err := os.ReadDir(parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if e.Name() != "out" {
+					t.Errorf("wrote %s outside the extract directory", e.Name())
+				}
+			}
+			if got, err := os.ReadFile(filepath.Join(dir, "sub", "target.txt")); err == nil && string(got) != "keep" {
+				t.Errorf("sub/target.txt was overwritten through a symlink: %q", got)
+			}
+		})
+	}
+}
+
+func TestExtractZipParentPath(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("../escape.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("x"))
+	zw.Close()
+
+	parent := t.TempDir()
+	if _, err := extractArchive(buf.Bytes(), filepath.Join(parent, "out"), 0); err == nil || !strings.Contains(err.Error(), "unsafe path") {
+		t.Fatalf("err = %v, want unsafe path", err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); err == nil {
+		t.Fatal("escape.txt was written outside the extract directory")
+	}
+}
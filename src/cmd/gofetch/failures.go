@@ -0,0 +1,78 @@
+package main
+
+// 失敗したレスポンスの保存 (--save-failures, --save-failures-max)
+// 2xx以外のステータスやバジェットの超過で失敗したとき、レスポンスのヘッダーと本文を
+// ディレクトリに自動で保存し、後から再実行せずに原因を調べられるようにする
+// 保存するファイルの数には上限を設け、古いものから消す
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// failureFileSuffix は保存したレスポンスのファイルの拡張子
+const failureFileSuffix = ".http"
+
+// unsafeFileChars はファイル名に使わない文字
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// saveFailure はレスポンスをディレクトリに保存し、保存したファイルのパスを返す
+// ファイル名は時刻、ホスト、ステータスから作る
+func saveFailure(dir string, resp *http.Response, body []byte, reason string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	host := unsafeFileChars.ReplaceAllString(resp.Request.URL.Host, "_")
+	name := fmt.Sprintf("%s-%s-%d%s", now.Format("20060102-150405.000"), host, resp.StatusCode, failureFileSuffix)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s %s\n# %s\n", resp.Request.Method, redactSecrets(resp.Request.URL.String()), reason)
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&b)
+	b.WriteString("\r\n")
+	b.Write(body)
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	if err := pruneFailures(dir, keep); err != nil {
+		verbosef("Failures: %v", err)
+	}
+	return path, nil
+}
+
+// pruneFailures は保存したレスポンスが keep 件を超えたら古いものから消す
+// ファイル名が時刻で始まるので、名前の順が古い順になる
+func pruneFailures(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), failureFileSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > keep {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			return err
+		}
+		verbosef("Failures: removed %s", names[0])
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,733 @@
+package main
+
+// 複数の手順からなる処理の実行 (gofetch flow)
+// YAMLに書いた手順を順に実行し、手順ごとに結果を表示する
+// 「作成する → 完了するまで状態を確かめる → 結果を取得する → スキーマを確かめる」のような
+// 一連のAPIの呼び出しを1つのファイルで確かめる
+//
+//	base_url: https://api.example.com
+//	vars:
+//	  name: demo
+//	steps:
+//	  - name: create
+//	    method: POST
+//	    url: /jobs
+//	    headers:
+//	      Content-Type: application/json
+//	    body: '{"name":"${name}"}'
+//	    extract:
+//	      job: .id
+//	    assert:
+//	      - status == 201
+//	  - name: poll
+//	    url: /jobs/${job}
+//	    extract:
+//	      state: .state
+//	    until: state != "running"
+//	    max_attempts: 30
+//	    interval: 2s
+//	  - name: failed
+//	    if: state == "failed"
+//	    fail: job ${job} failed
+//	  - name: result
+//	    url: /jobs/${job}/result
+//	    assert:
+//	      - status == 200
+//	      - .items.# > 0
+//	    schema:
+//	      type: object
+//	      required: [items]
+//
+// 手順の項目
+//   - url があればリクエストを送る。method、headers、body も書ける。相対的なURLは base_url につなげる
+//   - extract はレスポンスから変数に取り出す。.json.path、header:Name、status、body
+//   - until は条件が成り立つまで interval (既定1s) ごとに max_attempts (既定30) 回まで送り直す
+//   - wait はリクエストの前に待つ時間
+//   - if は条件が成り立たなければ手順を飛ばす。goto は手順のあとに名前の手順へ移る
+//   - assert はすべて成り立つべき条件の一覧、schema は本文が合うべきJSONスキーマ (api と同じ範囲)
+//   - fail は手順に来たら流れを失敗させる。if と組み合わせて使う
+//
+// 手順に needs (手順の名前の一覧) を書くか parallel: true にすると、手順を書いた順ではなく
+// 依存関係に従って実行する。needs の手順がすべて成功したら始め、互いに依存しない手順は
+// --concurrency 個まで並行して実行する。needs の手順が失敗した手順は実行しない
+// 変数は needs の手順が取り出したものを引き継ぐ。この場合 goto は使えない
+//
+// 条件は「値 演算子 値」か「値」1つ (空、false、0、null 以外なら成り立つ)
+// 値は変数名、"文字列"、数値、status、duration_ms、.json.path (.items.# は要素の数)、header:Name
+// 演算子は == != < <= > >= contains matches。両方が数値なら数値として比べる
+// URL、ヘッダー、本文、fail では ${変数} を展開し、シークレットの埋め込みも使える
+// 受け取ったクッキーは以降の手順のリクエストで送る
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// flow のヘルプメッセージ
+	FlowHelpMessage = `
+Usage: gofetch flow [options] <file>
+Runs the steps of a YAML flow (requests, extract, until, wait, if, goto, assert,
+schema, fail) in order and reports each step.
+Options:
+  --var         Set a variable as name=value (repeatable; overrides vars in the file)
+  --concurrency Maximum number of steps run at once when steps declare needs (default: 4)
+  -t, --timeout Timeout per request in seconds (default: 30)
+  --verbose     Print requests, responses and extracted values to stderr
+`
+	// flowMaxSteps は goto の繰り返しが止まらない場合に打ち切る、実行する手順の数
+	flowMaxSteps = 1000
+	// flowDefaultAttempts と flowDefaultInterval は until の既定の回数と間隔
+	flowDefaultAttempts = 30
+	flowDefaultInterval = time.Second
+	// flowDefaultConcurrency は依存関係に従って実行する場合に同時に実行する手順の既定の数
+	flowDefaultConcurrency = 4
+)
+
+// flowFile は手順のファイル全体
+type flowFile struct {
+	BaseURL string            `yaml:"base_url"`
+	Vars    map[string]string `yaml:"vars"`
+	// Parallel なら needs がなくても依存関係に従って実行する
+	Parallel bool       `yaml:"parallel"`
+	Steps    []flowStep `yaml:"steps"`
+}
+
+// graph は依存関係に従って実行するかを返す
+func (f *flowFile) graph() bool {
+	if f.Parallel {
+		return true
+	}
+	for _, s := range f.Steps {
+		if len(s.Needs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// flowStep は手順1つ
+type flowStep struct {
+	Name        string            `yaml:"name"`
+	Needs       []string          `yaml:"needs"`
+	If          string            `yaml:"if"`
+	Wait        string            `yaml:"wait"`
+	Method      string            `yaml:"method"`
+	URL         string            `yaml:"url"`
+	Headers     map[string]string `yaml:"headers"`
+	Body        string            `yaml:"body"`
+	Extract     map[string]string `yaml:"extract"`
+	Until       string            `yaml:"until"`
+	MaxAttempts int               `yaml:"max_attempts"`
+	Interval    string            `yaml:"interval"`
+	Assert      []string          `yaml:"assert"`
+	Schema      map[string]any    `yaml:"schema"`
+	Fail        string            `yaml:"fail"`
+	Goto        string            `yaml:"goto"`
+}
+
+// flowResponse は直前の手順で受け取ったレスポンス
+type flowResponse struct {
+	method   string
+	url      string
+	status   int
+	header   http.Header
+	body     []byte
+	duration time.Duration
+	// json は本文をJSONとして読んだ値。JSONでなければ nil で、parsed は false
+	json   any
+	parsed bool
+}
+
+// flowRun は実行中の状態
+type flowRun struct {
+	file   *flowFile
+	client *http.Client
+	vars   map[string]string
+	last   *flowResponse
+}
+
+// runFlow は flow サブコマンドを実行して終了コードを返す
+func runFlow(args []string) int {
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+		fmt.Print(FlowHelpMessage)
+		return 0
+	}
+
+	fs := flag.NewFlagSet("flow", flag.ContinueOnError)
+	var varSpecs stringList
+	fs.Var(&varSpecs, "var", "Variable as name=value (repeatable)")
+	timeout := fs.Int("t", 30, "Timeout in seconds")
+	fs.IntVar(timeout, "timeout", 30, "Timeout in seconds")
+	concurrency := fs.Int("concurrency", flowDefaultConcurrency, "Maximum number of steps run at once")
+	fs.BoolVar(&verbose, "verbose", false, "Print requests, responses and extracted values to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		fmt.Println("Error: a flow file is required")
+		fmt.Print(FlowHelpMessage)
+		return 1
+	}
+	if *concurrency < 1 {
+		fmt.Println("Error: --concurrency must be at least 1")
+		return 1
+	}
+
+	file, err := loadFlowFile(fs.Arg(0))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	vars := map[string]string{}
+	for k, v := range file.Vars {
+		vars[k] = v
+	}
+	for _, spec := range varSpecs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			fmt.Printf("Error: invalid --var %q (want name=value)\n", spec)
+			return 1
+		}
+		vars[name] = value
+	}
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if verbose {
+		transport = (&verboseTransport{w: os.Stderr}).wrap(transport)
+	}
+	run := &flowRun{
+		file: file,
+		// ログインで受け取ったクッキーは以降の手順でも送る
+		client: &http.Client{Timeout: time.Duration(*timeout) * time.Second, Transport: transport, Jar: newCookieJar()},
+		vars:   vars,
+	}
+	if file.graph() {
+		return run.executeGraph(*concurrency)
+	}
+	return run.execute()
+}
+
+// loadFlowFile は手順のファイルを読み込み、手順の名前と移り先を確かめる
+func loadFlowFile(path string) (*flowFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f flowFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(f.Steps) == 0 {
+		return nil, fmt.Errorf("%s: no steps", path)
+	}
+	names := map[string]bool{}
+	for i := range f.Steps {
+		s := &f.Steps[i]
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("step%d", i+1)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("%s: duplicate step name %q", path, s.Name)
+		}
+		names[s.Name] = true
+		if s.URL == "" && (s.Until != "" || len(s.Extract) > 0 || s.Schema != nil) {
+			return nil, fmt.Errorf("%s: step %s: until, extract and schema need a url", path, s.Name)
+		}
+		if s.Wait != "" {
+			if _, err := time.ParseDuration(s.Wait); err != nil {
+				return nil, fmt.Errorf("%s: step %s: invalid wait: %w", path, s.Name, err)
+			}
+		}
+		if s.Interval != "" {
+			if _, err := time.ParseDuration(s.Interval); err != nil {
+				return nil, fmt.Errorf("%s: step %s: invalid interval: %w", path, s.Name, err)
+			}
+		}
+		if s.Method != "" {
+			if s.Method, err = parseMethod(s.Method); err != nil {
+				return nil, fmt.Errorf("%s: step %s: %w", path, s.Name, err)
+			}
+		}
+	}
+	graph := f.graph()
+	for _, s := range f.Steps {
+		if s.Goto != "" && !names[s.Goto] {
+			return nil, fmt.Errorf("%s: step %s: goto to unknown step %q", path, s.Name, s.Goto)
+		}
+		if s.Goto != "" && graph {
+			return nil, fmt.Errorf("%s: step %s: goto cannot be used with needs or parallel", path, s.Name)
+		}
+		for _, n := range s.Needs {
+			if !names[n] {
+				return nil, fmt.Errorf("%s: step %s: needs unknown step %q", path, s.Name, n)
+			}
+		}
+	}
+	if cycle := f.findCycle(); cycle != nil {
+		return nil, fmt.Errorf("%s: steps depend on each other: %s", path, strings.Join(cycle, " -> "))
+	}
+	return &f, nil
+}
+
+// execute は手順を順に実行して結果を表示し、終了コードを返す
+func (r *flowRun) execute() int {
+	start := time.Now()
+	passed, skipped := 0, 0
+	for i, executed := 0, 0; i < len(r.file.Steps); executed++ {
+		if executed >= flowMaxSteps {
+			fmt.Printf("Error: stopped after %d steps (does a goto loop forever?)\n", flowMaxSteps)
+			return exitUsage
+		}
+		step := &r.file.Steps[i]
+		if step.If != "" {
+			ok, err := r.eval(step.If)
+			if err != nil {
+				fmt.Printf("FAIL  %s: if: %s\n", step.Name, err)
+				return exitUsage
+			}
+			if !ok {
+				fmt.Printf("SKIP  %s (if %s)\n", step.Name, step.If)
+				skipped++
+				i++
+				continue
+			}
+		}
+		summary, problems, err := r.runStep(step)
+		if code := reportStep(step, summary, problems, err); code != 0 {
+			fmt.Printf("Flow failed at step %s after %s\n", step.Name, time.Since(start).Round(time.Millisecond))
+			return code
+		}
+		passed++
+		if step.Goto != "" {
+			i = r.stepIndex(step.Goto)
+			continue
+		}
+		i++
+	}
+	fmt.Printf("Flow passed: %d step(s) passed, %d skipped in %s\n", passed, skipped, time.Since(start).Round(time.Millisecond))
+	return 0
+}
+
+// reportStep は手順の結果を表示し、失敗なら終了コードを返す
+func reportStep(step *flowStep, summary string, problems []string, err error) int {
+	if err != nil {
+		fmt.Printf("ERROR %s: %s\n", step.Name, redactSecrets(err.Error()))
+		return exitCodeForError(err)
+	}
+	if len(problems) > 0 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "FAIL  %s%s\n", step.Name, summary)
+		for _, p := range problems {
+			fmt.Fprintf(&b, "      %s\n", redactSecrets(p))
+		}
+		// 並行して実行している場合に行が混ざらないよう、まとめて書く
+		fmt.Print(b.String())
+		return exitUsage
+	}
+	fmt.Printf("PASS  %s%s\n", step.Name, summary)
+	return 0
+}
+
+// stepIndex は名前の手順の位置を返す。名前は読み込むときに確かめてある
+func (r *flowRun) stepIndex(name string) int {
+	for i, s := range r.file.Steps {
+		if s.Name == name {
+			return i
+		}
+	}
+	return len(r.file.Steps)
+}
+
+// runStep は手順1つを実行し、表示する要約と成り立たなかった条件を返す
+// リクエストを送れなかった場合はエラーを返す
+func (r *flowRun) runStep(step *flowStep) (string, []string, error) {
+	if step.Wait != "" {
+		wait, _ := time.ParseDuration(step.Wait)
+		time.Sleep(wait)
+	}
+	if step.Fail != "" {
+		msg, err := r.expand(step.Fail)
+		if err != nil {
+			return "", nil, err
+		}
+		return "", []string{msg}, nil
+	}
+
+	var summary string
+	if step.URL != "" {
+		attempts := 1
+		for ; ; attempts++ {
+			if err := r.send(step); err != nil {
+				return "", nil, err
+			}
+			if err := r.extract(step.Extract); err != nil {
+				return "", []string{err.Error()}, nil
+			}
+			if step.Until == "" {
+				break
+			}
+			ok, err := r.eval(step.Until)
+			if err != nil {
+				return "", []string{"until: " + err.Error()}, nil
+			}
+			if ok {
+				break
+			}
+			limit := step.MaxAttempts
+			if limit <= 0 {
+				limit = flowDefaultAttempts
+			}
+			if attempts >= limit {
+				return fmt.Sprintf(": %s %s -> %d", r.last.method, redactSecrets(r.last.url), r.last.status), []string{fmt.Sprintf("until %s: not met after %d attempt(s)", step.Until, attempts)}, nil
+			}
+			interval := flowDefaultInterval
+			if step.Interval != "" {
+				interval, _ = time.ParseDuration(step.Interval)
+			}
+			verbosef("%s: until %s not met, retrying in %s", step.Name, step.Until, interval)
+			time.Sleep(interval)
+		}
+		summary = fmt.Sprintf(": %s %s -> %d in %s", r.last.method, redactSecrets(r.last.url), r.last.status, roundLatency(r.last.duration))
+		if attempts > 1 {
+			summary += fmt.Sprintf(" (%d attempts)", attempts)
+		}
+	}
+
+	var problems []string
+	for _, cond := range step.Assert {
+		ok, err := r.eval(cond)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("assert %s: %s", cond, err))
+		case !ok:
+			problems = append(problems, fmt.Sprintf("assert %s: %s", cond, r.describe(cond)))
+		}
+	}
+	if step.Schema != nil {
+		var value any
+		if err := json.Unmarshal(r.last.body, &value); err != nil {
+			problems = append(problems, "schema: body is not JSON: "+err.Error())
+		} else {
+			spec := &apiSpec{root: map[string]any{}}
+			spec.validate(step.Schema, value, "$", &problems)
+		}
+	}
+	return summary, problems, nil
+}
+
+// send は手順のリクエストを送り、レスポンスを last に入れる
+func (r *flowRun) send(step *flowStep) error {
+	rawURL, err := r.expand(step.URL)
+	if err != nil {
+		return err
+	}
+	if isPathOnly(rawURL) {
+		if r.file.BaseURL == "" {
+			return fmt.Errorf("path-only url %q requires base_url", rawURL)
+		}
+		if rawURL, err = joinBaseURL(r.file.BaseURL, rawURL); err != nil {
+			return err
+		}
+	}
+	var body io.Reader
+	if step.Body != "" {
+		expanded, err := r.expand(step.Body)
+		if err != nil {
+			return err
+		}
+		body = strings.NewReader(expanded)
+	}
+	method := step.Method
+	if method == "" {
+		method = http.MethodGet
+		if body != nil {
+			method = http.MethodPost
+		}
+	}
+	req, err := http.NewRequest(method, rawURL, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range step.Headers {
+		expanded, err := r.expand(v)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", k, err)
+		}
+		req.Header.Set(k, expanded)
+	}
+
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	last := &flowResponse{method: method, url: rawURL, status: resp.StatusCode, header: resp.Header, body: data, duration: time.Since(start)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	last.parsed = dec.Decode(&last.json) == nil
+	r.last = last
+	return nil
+}
+
+// extract はレスポンスから変数に値を取り出す
+func (r *flowRun) extract(specs map[string]string) error {
+	for name, source := range specs {
+		v, ok := r.operand(source)
+		if !ok {
+			return fmt.Errorf("extract %s: %s not found in the response", name, source)
+		}
+		r.vars[name] = v
+		verbosef("%s = %s", name, v)
+	}
+	return nil
+}
+
+// expand は ${変数} とシークレットの埋め込みを展開する
+func (r *flowRun) expand(s string) (string, error) {
+	var missing []string
+	out := os.Expand(s, func(name string) string {
+		v, ok := r.vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variable(s): %s", strings.Join(missing, ", "))
+	}
+	return expandSecrets(out)
+}
+
+// flowOperators は条件で使える演算子
+var flowOperators = []string{"==", "!=", "<=", ">=", "<", ">", "contains", "matches"}
+
+// eval は条件を評価する
+func (r *flowRun) eval(cond string) (bool, error) {
+	tokens, err := splitCondition(cond)
+	if err != nil {
+		return false, err
+	}
+	switch len(tokens) {
+	case 1:
+		v, _ := r.operand(tokens[0])
+		return v != "" && v != "false" && v != "0" && v != "null", nil
+	case 3:
+	default:
+		return false, fmt.Errorf("invalid condition %q (want value, or value operator value)", cond)
+	}
+	left, _ := r.operand(tokens[0])
+	op := tokens[1]
+	right, _ := r.operand(tokens[2])
+
+	lnum, lerr := strconv.ParseFloat(left, 64)
+	rnum, rerr := strconv.ParseFloat(right, 64)
+	numeric := lerr == nil && rerr == nil
+	switch op {
+	case "==":
+		if numeric {
+			return lnum == rnum, nil
+		}
+		return left == right, nil
+	case "!=":
+		if numeric {
+			return lnum != rnum, nil
+		}
+		return left != right, nil
+	case "<", "<=", ">", ">=":
+		if !numeric {
+			return false, fmt.Errorf("%s needs numbers, got %q and %q", op, left, right)
+		}
+		switch op {
+		case "<":
+			return lnum < rnum, nil
+		case "<=":
+			return lnum <= rnum, nil
+		case ">":
+			return lnum > rnum, nil
+		}
+		return lnum >= rnum, nil
+	case "contains":
+		return strings.Contains(left, right), nil
+	case "matches":
+		re, err := regexp.Compile(right)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(left), nil
+	}
+	return false, fmt.Errorf("unknown operator %q (want one of %s)", op, strings.Join(flowOperators, " "))
+}
+
+// describe は成り立たなかった条件の左辺の実際の値を返す
+func (r *flowRun) describe(cond string) string {
+	tokens, _ := splitCondition(cond)
+	if len(tokens) == 0 {
+		return "failed"
+	}
+	v, ok := r.operand(tokens[0])
+	if !ok {
+		return fmt.Sprintf("%s not found", tokens[0])
+	}
+	if len(v) > 200 {
+		v = v[:200] + "..."
+	}
+	return fmt.Sprintf("got %s = %q", tokens[0], v)
+}
+
+// operand は条件や extract の値を文字列にして返す。見つからなければ false
+func (r *flowRun) operand(s string) (string, bool) {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		v, err := r.expand(s[1 : len(s)-1])
+		return v, err == nil
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s, true
+	}
+	switch s {
+	case "true", "false", "null":
+		return s, true
+	}
+	last := r.last
+	if name, ok := strings.CutPrefix(s, "header:"); ok {
+		if last == nil || last.header.Get(name) == "" {
+			return "", false
+		}
+		return last.header.Get(name), true
+	}
+	if strings.HasPrefix(s, ".") {
+		if last == nil || !last.parsed {
+			return "", false
+		}
+		v, ok := flowLookup(last.json, s)
+		if !ok {
+			return "", false
+		}
+		return flowString(v), true
+	}
+	switch s {
+	case "status":
+		if last == nil {
+			return "", false
+		}
+		return strconv.Itoa(last.status), true
+	case "duration_ms":
+		if last == nil {
+			return "", false
+		}
+		return strconv.FormatInt(last.duration.Milliseconds(), 10), true
+	case "body":
+		if last == nil {
+			return "", false
+		}
+		return string(last.body), true
+	}
+	v, ok := r.vars[s]
+	return v, ok
+}
+
+// flowLookup はJSONの値から .a.b.0 の形のパスの値を取り出す。# は配列やオブジェクトの要素の数
+func flowLookup(value any, path string) (any, bool) {
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return value, true
+	}
+	for _, p := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]any:
+			if p == "#" {
+				value = json.Number(strconv.Itoa(len(v)))
+				continue
+			}
+			child, ok := v[p]
+			if !ok {
+				return nil, false
+			}
+			value = child
+		case []any:
+			if p == "#" {
+				value = json.Number(strconv.Itoa(len(v)))
+				continue
+			}
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// flowString はJSONの値を条件で比べる文字列にする
+func flowString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// splitCondition は条件を空白で区切る。引用符で囲んだ部分は区切らない
+func splitCondition(s string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	var quote byte
+	inToken := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			cur.WriteByte(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inToken = true
+			cur.WriteByte(c)
+		case c == ' ' || c == '\t':
+			if inToken {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inToken = false
+			}
+		default:
+			inToken = true
+			cur.WriteByte(c)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in condition")
+	}
+	if inToken {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+// 依存関係に従った手順の実行 (gofetch flow の needs, parallel)
+// 手順ごとに goroutine を起こし、needs の手順が終わるのを待ってから同時に実行する数の枠を取る
+// 手順はそれぞれ変数の写しと自分のレスポンスを持ち、取り出した変数は終わったときに共有の変数へ戻す
+
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// flowOutcome は依存関係に従って実行した手順の結果
+type flowOutcome int
+
+const (
+	flowPassed flowOutcome = iota
+	flowSkipped
+	flowFailed
+	// flowBlocked は needs の手順が失敗したので実行しなかったもの
+	flowBlocked
+)
+
+// findCycle は needs の循環を探し、あればその経路を返す
+func (f *flowFile) findCycle() []string {
+	index := map[string]int{}
+	for i, s := range f.Steps {
+		index[s.Name] = i
+	}
+	// 0: 未訪問, 1: 訪問中, 2: 済み
+	state := make([]int, len(f.Steps))
+	var path []string
+	var visit func(i int) []string
+	visit = func(i int) []string {
+		switch state[i] {
+		case 1:
+			for k, name := range path {
+				if name == f.Steps[i].Name {
+					return append(append([]string{}, path[k:]...), name)
+				}
+			}
+		case 2:
+			return nil
+		}
+		state[i] = 1
+		path = append(path, f.Steps[i].Name)
+		for _, n := range f.Steps[i].Needs {
+			if j, ok := index[n]; ok {
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = 2
+		return nil
+	}
+	for i := range f.Steps {
+		if cycle := visit(i); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// executeGraph は手順を依存関係に従って並行して実行して結果を表示し、終了コードを返す
+// 失敗した手順があっても、それに依存しない手順は最後まで実行する
+func (r *flowRun) executeGraph(concurrency int) int {
+	start := time.Now()
+	steps := r.file.Steps
+	index := map[string]int{}
+	for i, s := range steps {
+		index[s.Name] = i
+	}
+	done := make([]chan struct{}, len(steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	outcomes := make([]flowOutcome, len(steps))
+	codes := make([]int, len(steps))
+	slots := make(chan struct{}, concurrency)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := range steps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[i])
+			step := &steps[i]
+			for _, n := range step.Needs {
+				j := index[n]
+				<-done[j]
+				if outcomes[j] == flowFailed || outcomes[j] == flowBlocked {
+					mu.Lock()
+					fmt.Printf("SKIP  %s (needs %s, which did not pass)\n", step.Name, n)
+					mu.Unlock()
+					outcomes[i] = flowBlocked
+					return
+				}
+			}
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// needs の手順が取り出した変数を含む、この時点の変数の写しで実行する
+			mu.Lock()
+			snapshot := maps.Clone(r.vars)
+			mu.Unlock()
+			run := &flowRun{file: r.file, client: r.client, vars: maps.Clone(snapshot)}
+			outcomes[i], codes[i] = run.runGraphStep(step, &mu)
+
+			mu.Lock()
+			for k, v := range run.vars {
+				if old, ok := snapshot[k]; !ok || old != v {
+					r.vars[k] = v
+				}
+			}
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+
+	passed, skipped, failed, blocked, code := 0, 0, 0, 0, 0
+	for i, o := range outcomes {
+		switch o {
+		case flowPassed:
+			passed++
+		case flowSkipped:
+			skipped++
+		case flowFailed:
+			failed++
+			if code == 0 {
+				code = codes[i]
+			}
+		case flowBlocked:
+			blocked++
+		}
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if failed > 0 {
+		fmt.Printf("Flow failed: %d step(s) passed, %d failed, %d not run, %d skipped in %s\n", passed, failed, blocked, skipped, elapsed)
+		return code
+	}
+	fmt.Printf("Flow passed: %d step(s) passed, %d skipped in %s\n", passed, skipped, elapsed)
+	return 0
+}
+
+// runGraphStep は依存関係に従って実行する手順1つを実行し、結果と終了コードを返す
+// 結果の表示は mu で他の手順と混ざらないようにする
+func (r *flowRun) runGraphStep(step *flowStep, mu *sync.Mutex) (flowOutcome, int) {
+	if step.If != "" {
+		ok, err := r.eval(step.If)
+		if err != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Printf("FAIL  %s: if: %s\n", step.Name, err)
+			return flowFailed, exitUsage
+		}
+		if !ok {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Printf("SKIP  %s (if %s)\n", step.Name, step.If)
+			return flowSkipped, 0
+		}
+	}
+	summary, problems, err := r.runStep(step)
+	mu.Lock()
+	defer mu.Unlock()
+	if code := reportStep(step, summary, problems, err); code != 0 {
+		return flowFailed, code
+	}
+	return flowPassed, 0
+}
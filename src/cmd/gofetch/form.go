@@ -0,0 +1,235 @@
+package main
+
+// フォームの送信 (-F, --form)
+// name=value の項目を application/x-www-form-urlencoded の本文にする
+// name=@path の項目がひとつでもあれば multipart/form-data にし、ファイルを添付する
+// ファイルはメモリに読み込まずに送るので、大きなファイルもそのままアップロードできる
+// 添付の Content-Type とファイル名は curl と同じく ;type= と ;filename= で指定できる
+// name=<path はファイルの内容を添付ではなく値として送る
+//
+//	gofetch -u https://example.com/search -F q=gofetch -F lang=ja
+//	gofetch -u https://example.com/upload -F title=backup -F file=@backup.tar.gz
+//	gofetch -u https://example.com/upload -F 'avatar=@me.jpg;type=image/jpeg;filename=avatar.jpg'
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gofetch/pkg/gofetch"
+)
+
+// formField はフォームの項目1つ
+type formField struct {
+	name  string
+	value string
+	// file が空でなければ添付するファイル
+	file        string
+	filename    string
+	contentType string
+	size        int64
+}
+
+// formBody はフォームから組み立てた本文
+type formBody struct {
+	fields      []formField
+	contentType string
+	// multipart でなければ本文をそのまま持つ
+	data     []byte
+	boundary string
+	length   int64
+}
+
+// quoteEscaper は Content-Disposition の引用符の中の文字をエスケープする
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "%0D", "\n", "%0A")
+
+// parseFormFields は --form の指定を解釈する。添付するファイルはここで大きさを確かめる
+func parseFormFields(specs []string) ([]formField, error) {
+	fields := make([]formField, 0, len(specs))
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --form %q (want name=value, name=@file or name=<file)", spec)
+		}
+		f := formField{name: name}
+		switch {
+		case strings.HasPrefix(value, "@"):
+			parts := strings.Split(value[1:], ";")
+			f.file = parts[0]
+			f.filename = filepath.Base(f.file)
+			for _, opt := range parts[1:] {
+				key, v, _ := strings.Cut(strings.TrimSpace(opt), "=")
+				switch strings.ToLower(key) {
+				case "type":
+					f.contentType = v
+				case "filename":
+					f.filename = v
+				default:
+					return nil, fmt.Errorf("invalid --form %q: unknown option %q (want type= or filename=)", spec, key)
+				}
+			}
+			fi, err := os.Stat(f.file)
+			if err != nil {
+				return nil, fmt.Errorf("--form %s: %w", name, err)
+			}
+			if !fi.Mode().IsRegular() {
+				return nil, fmt.Errorf("--form %s: %s is not a regular file", name, f.file)
+			}
+			f.size = fi.Size()
+			if f.contentType == "" {
+				f.contentType = mime.TypeByExtension(filepath.Ext(f.file))
+			}
+			if f.contentType == "" {
+				f.contentType = "application/octet-stream"
+			}
+		case strings.HasPrefix(value, "<"):
+			data, err := os.ReadFile(value[1:])
+			if err != nil {
+				return nil, fmt.Errorf("--form %s: %w", name, err)
+			}
+			f.value = string(data)
+		default:
+			expanded, err := expandSecrets(value)
+			if err != nil {
+				return nil, fmt.Errorf("--form %s: %w", name, err)
+			}
+			f.value = expanded
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// newFormBody は --form の指定から本文を組み立てる
+func newFormBody(specs []string) (*formBody, error) {
+	fields, err := parseFormFields(specs)
+	if err != nil {
+		return nil, err
+	}
+	b := &formBody{fields: fields}
+	multipart := false
+	for _, f := range fields {
+		multipart = multipart || f.file != ""
+	}
+	if !multipart {
+		// url.Values は名前の順に並べ替えるので、指定した順を保つよう自分で組み立てる
+		pairs := make([]string, len(fields))
+		for i, f := range fields {
+			pairs[i] = url.QueryEscape(f.name) + "=" + url.QueryEscape(f.value)
+		}
+		b.data = []byte(strings.Join(pairs, "&"))
+		b.contentType = "application/x-www-form-urlencoded"
+		b.length = int64(len(b.data))
+		return b, nil
+	}
+
+	b.boundary = "gofetch" + rand.Text()
+	b.contentType = "multipart/form-data; boundary=" + b.boundary
+	// ファイルの中身を除いた大きさにファイルの大きさを足して Content-Length にする
+	// チャンク形式を受け付けないサーバーにも送れる
+	var counter countingWriter
+	if err := b.write(&counter, false); err != nil {
+		return nil, err
+	}
+	b.length = counter.n
+	for _, f := range fields {
+		b.length += f.size
+	}
+	return b, nil
+}
+
+// apply は本文をリクエストに設定する。Content-Type はヘッダーの組み立てで設定する
+func (b *formBody) apply(r *gofetch.Request) {
+	if b.data != nil {
+		r.Body = b.data
+		return
+	}
+	r.OpenBody = b.open
+	r.ContentLength = b.length
+}
+
+// open は multipart の本文を読むリーダーを返す。ファイルは送りながら読む
+func (b *formBody) open() (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(b.write(pw, true))
+	}()
+	return pr, nil
+}
+
+// write は multipart の本文を w に書く。withFiles でなければファイルの中身は書かない
+func (b *formBody) write(w io.Writer, withFiles bool) error {
+	for _, f := range b.fields {
+		disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(f.name))
+		header := ""
+		if f.file != "" {
+			disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(f.filename))
+			header = "Content-Type: " + f.contentType + "\r\n"
+		}
+		if _, err := fmt.Fprintf(w, "--%s\r\nContent-Disposition: %s\r\n%s\r\n", b.boundary, disposition, header); err != nil {
+			return err
+		}
+		if f.file == "" {
+			if _, err := io.WriteString(w, f.value); err != nil {
+				return err
+			}
+		} else if withFiles {
+			if err := copyFormFile(w, f); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "\r\n"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "--%s--\r\n", b.boundary)
+	return err
+}
+
+// copyFormFile は添付するファイルの中身を w に書く
+// Content-Length を先に送っているので、確かめたときから大きさが変わっていれば誤りにする
+func copyFormFile(w io.Writer, f formField) error {
+	file, err := os.Open(f.file)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	n, err := io.Copy(w, io.LimitReader(file, f.size+1))
+	if err != nil {
+		return err
+	}
+	if n != f.size {
+		return fmt.Errorf("--form %s: %s changed size during the upload", f.name, f.file)
+	}
+	return nil
+}
+
+// describe は詳細モードで表示する本文の説明を返す
+func (b *formBody) describe() string {
+	files := 0
+	for _, f := range b.fields {
+		if f.file != "" {
+			files++
+		}
+	}
+	kind := "urlencoded"
+	if b.data == nil {
+		kind = "multipart"
+	}
+	return fmt.Sprintf("%d field(s), %d file(s), %s %s", len(b.fields)-files, files, formatSize(b.length), kind)
+}
+
+// countingWriter は書いたバイト数を数える
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
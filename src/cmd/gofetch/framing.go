@@ -0,0 +1,322 @@
+package main
+
+// 転送のフレーミングの診断 (--framing)
+// 壊れたプロキシの調査のために、接続上で受信したバイト列をHTTP/1.1として読み直し、
+// chunked かどうか、チャンクの大きさと到着時刻、Content-Length と実際のバイト数が一致するか、
+// 応答の途中で接続が閉じられたかを報告する
+// 生のバイト列を見る必要があるので、この診断中はHTTP/1.1だけを使う
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// framingMaxChunks は詳細モードでないときに一覧表示するチャンクの数
+const framingMaxChunks = 10
+
+// framingChunk は受信したチャンク1つ
+type framingChunk struct {
+	Size int64
+	// At は本文の最初のバイトからの経過時間
+	At time.Duration
+}
+
+// framingResponse は接続上で受信したレスポンス1つのフレーミング
+type framingResponse struct {
+	Status        string
+	Chunked       bool
+	ContentLength int64
+	Body          int64
+	Chunks        []framingChunk
+	Complete      bool
+	// CloseDelimited は本文の終わりが接続の切断で示されたこと
+	CloseDelimited bool
+	// Closed は応答が終わる前にサーバーが接続を閉じたこと
+	Closed bool
+	Err    string
+	start  time.Time
+}
+
+// framingRecorder はすべての接続で受信したレスポンスを記録する
+type framingRecorder struct {
+	mu        sync.Mutex
+	responses []*framingResponse
+}
+
+// dialTLSWrapped は dial で接続してHTTP/1.1でTLSのハンドシェイクを行い、TLSの接続を wrap で包む
+// 包んだ接続は *tls.Conn ではないので、レスポンスの TLS は埋まらない
+func dialTLSWrapped(dial dialFunc, conf *tls.Config, wrap func(net.Conn) net.Conn) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := conf.Clone()
+		if c == nil {
+			c = &tls.Config{}
+		}
+		if c.ServerName == "" {
+			c.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		c.NextProtos = []string{"http/1.1"}
+		tc := tls.Client(conn, c)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return wrap(tc), nil
+	}
+}
+
+// wrapDial は dial で確立した接続を wrap で包む
+func wrapDial(dial dialFunc, wrap func(net.Conn) net.Conn) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return wrap(conn), nil
+	}
+}
+
+// wrap は接続を記録する対象にする
+func (r *framingRecorder) wrap(conn net.Conn) net.Conn {
+	return &framingConn{Conn: conn, parser: &framingParser{rec: r}}
+}
+
+// framingConn は受信したバイト列をパーサーに渡す net.Conn
+type framingConn struct {
+	net.Conn
+	parser *framingParser
+}
+
+// Read は受信したバイト列を解析する。サーバーが閉じた場合はそれも記録する
+func (c *framingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.parser.feed(p[:n], time.Now())
+	if err != nil {
+		c.parser.close()
+	}
+	return n, err
+}
+
+// NetConn は包んでいる接続を返す
+func (c *framingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// framingParser の状態
+const (
+	framingHeader = iota
+	framingBody
+	framingChunkSize
+	framingChunkData
+	framingChunkEnd
+	framingTrailer
+	framingUntilClose
+	framingBroken
+)
+
+// framingParser はHTTP/1.xのレスポンスを逐次読み、フレーミングだけを記録する
+type framingParser struct {
+	rec       *framingRecorder
+	state     int
+	line      []byte
+	remaining int64
+	cur       *framingResponse
+}
+
+// feed は受信したバイト列を読み進める
+func (p *framingParser) feed(b []byte, now time.Time) {
+	p.rec.mu.Lock()
+	defer p.rec.mu.Unlock()
+	for len(b) > 0 && p.state != framingBroken {
+		switch p.state {
+		case framingBody, framingChunkData, framingUntilClose:
+			n := int64(len(b))
+			if p.state != framingUntilClose && n > p.remaining {
+				n = p.remaining
+			}
+			p.cur.Body += n
+			p.remaining -= n
+			b = b[n:]
+			if p.state == framingBody && p.remaining == 0 {
+				p.finish()
+			} else if p.state == framingChunkData && p.remaining == 0 {
+				p.state = framingChunkEnd
+			}
+		default:
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				p.line = append(p.line, b...)
+				b = nil
+				if len(p.line) > 64<<10 {
+					p.fail("line too long")
+				}
+				continue
+			}
+			line := strings.TrimRight(string(append(p.line, b[:i]...)), "\r")
+			p.line = p.line[:0]
+			b = b[i+1:]
+			p.handleLine(line, now)
+		}
+	}
+}
+
+// handleLine はステータス行、ヘッダー、チャンクの大きさなどの1行を処理する
+func (p *framingParser) handleLine(line string, now time.Time) {
+	switch p.state {
+	case framingHeader:
+		if p.cur == nil {
+			if line == "" {
+				return
+			}
+			p.cur = &framingResponse{Status: line, ContentLength: -1}
+			p.rec.responses = append(p.rec.responses, p.cur)
+			return
+		}
+		if line != "" {
+			name, value, _ := strings.Cut(line, ":")
+			value = strings.TrimSpace(value)
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "transfer-encoding":
+				p.cur.Chunked = strings.Contains(strings.ToLower(value), "chunked")
+			case "content-length":
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					if p.cur.ContentLength >= 0 && p.cur.ContentLength != n {
+						p.cur.Err = "conflicting Content-Length headers"
+					}
+					p.cur.ContentLength = n
+				}
+			}
+			return
+		}
+		// ヘッダーの終わり
+		p.cur.start = now
+		_, rest, _ := strings.Cut(p.cur.Status, " ")
+		code, _, _ := strings.Cut(rest, " ")
+		switch {
+		case strings.HasPrefix(code, "1"):
+			// 100 Continue などの中間レスポンスは記録しない
+			p.rec.responses = p.rec.responses[:len(p.rec.responses)-1]
+			p.cur = nil
+		case code == "204" || code == "304":
+			p.finish()
+		case p.cur.Chunked:
+			p.state = framingChunkSize
+		case p.cur.ContentLength > 0:
+			p.remaining = p.cur.ContentLength
+			p.state = framingBody
+		case p.cur.ContentLength == 0:
+			p.finish()
+		default:
+			p.state = framingUntilClose
+		}
+	case framingChunkSize:
+		sizeStr, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeStr), 16, 64)
+		if err != nil || size < 0 {
+			p.fail(fmt.Sprintf("invalid chunk size line %q", line))
+			return
+		}
+		if size == 0 {
+			p.state = framingTrailer
+			return
+		}
+		p.cur.Chunks = append(p.cur.Chunks, framingChunk{Size: size, At: now.Sub(p.cur.start)})
+		p.remaining = size
+		p.state = framingChunkData
+	case framingChunkEnd:
+		if line != "" {
+			p.fail("chunk data longer than its declared size")
+			return
+		}
+		p.state = framingChunkSize
+	case framingTrailer:
+		if line == "" {
+			p.finish()
+		}
+	}
+}
+
+// finish は現在のレスポンスを完了として次のレスポンスを待つ
+func (p *framingParser) finish() {
+	p.cur.Complete = true
+	p.cur = nil
+	p.state = framingHeader
+}
+
+// fail はフレーミングの誤りを記録して解析をやめる
+func (p *framingParser) fail(msg string) {
+	if p.cur != nil {
+		p.cur.Err = msg
+	}
+	p.state = framingBroken
+}
+
+// close は接続がサーバーから閉じられたときに呼ばれる
+func (p *framingParser) close() {
+	p.rec.mu.Lock()
+	defer p.rec.mu.Unlock()
+	if p.cur == nil || p.cur.Complete {
+		return
+	}
+	if p.state == framingUntilClose {
+		p.cur.Complete = true
+		p.cur.CloseDelimited = true
+	} else if p.state != framingBroken {
+		p.cur.Closed = true
+	}
+	p.cur = nil
+}
+
+// report は記録したフレーミングを標準エラー出力に表示する
+func (r *framingRecorder) report() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.responses) == 0 {
+		fmt.Fprintln(os.Stderr, "Framing: no HTTP/1.x response was received")
+		return
+	}
+	for _, resp := range r.responses {
+		fmt.Fprintf(os.Stderr, "Framing: %s\n", resp.Status)
+		switch {
+		case resp.Chunked:
+			fmt.Fprintf(os.Stderr, "Framing:   chunked, %d chunk(s), %s\n", len(resp.Chunks), formatSize(resp.Body))
+			for i, c := range resp.Chunks {
+				if i == framingMaxChunks && !verbose {
+					fmt.Fprintf(os.Stderr, "Framing:   ... %d more chunk(s) (use --verbose to list all)\n", len(resp.Chunks)-i)
+					break
+				}
+				fmt.Fprintf(os.Stderr, "Framing:   chunk %d: %s at +%s\n", i+1, formatSize(c.Size), c.At.Round(time.Millisecond))
+			}
+			if resp.ContentLength >= 0 {
+				fmt.Fprintln(os.Stderr, "Framing:   warning: both Transfer-Encoding: chunked and Content-Length were sent")
+			}
+		case resp.ContentLength >= 0:
+			match := "matches"
+			if resp.Body != resp.ContentLength {
+				match = "MISMATCH"
+			}
+			fmt.Fprintf(os.Stderr, "Framing:   Content-Length %d, received %d bytes (%s)\n", resp.ContentLength, resp.Body, match)
+		default:
+			fmt.Fprintf(os.Stderr, "Framing:   no Content-Length or chunked encoding, %s delimited by connection close\n", formatSize(resp.Body))
+		}
+		switch {
+		case resp.Err != "":
+			fmt.Fprintf(os.Stderr, "Framing:   error: %s\n", resp.Err)
+		case resp.Closed:
+			fmt.Fprintln(os.Stderr, "Framing:   connection closed by the server before the response completed")
+		case !resp.Complete:
+			fmt.Fprintln(os.Stderr, "Framing:   response did not complete")
+		}
+	}
+}
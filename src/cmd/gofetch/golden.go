@@ -0,0 +1,222 @@
+package main
+
+// ゴールデンファイルとの比較 (--golden, --golden-mode, --golden-mask, --update-golden)
+// レスポンスの本文を保存しておいた期待どおりの本文と比べ、違えば差分を表示して終了コード1で終了する
+// シェルスクリプトからAPIのスナップショットテストをするために使う
+// exact はそのまま行ごとに比べ、json はキーを並べ替えて整形してから比べるので、キーの順序や空白の違いは無視する
+// auto (既定) はゴールデンファイルが .json かレスポンスがJSONなら json、それ以外は exact にする
+// 時刻やIDのように毎回変わる部分は --mask の規則か --golden-mask の正規表現で両方を伏せてから比べる
+// json では正規表現は整形した後の "key": value の行に対して適用する
+//
+//	gofetch -u https://api.example.com/users/1 --golden testdata/user.json --golden-mask '"updated_at": "[^"]*"'
+//	gofetch -u https://api.example.com/users/1 --golden testdata/user.json --update-golden
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// goldenContext は差分で変わった行の前後に表示する行数
+	goldenContext = 3
+	// goldenMaxCells は行ごとの差分を計算する表の大きさの上限。超えたら最初に違う行だけを表示する
+	goldenMaxCells = 4 << 20
+)
+
+// goldenCheck はゴールデンファイルとの比較の設定
+type goldenCheck struct {
+	path   string
+	mode   string
+	masks  maskRules
+	update bool
+}
+
+// newGoldenCheck は比較の設定を作る。mode は auto、exact、json のどれか
+func newGoldenCheck(path, mode string, masks maskRules, update bool) (*goldenCheck, error) {
+	switch mode {
+	case "auto", "exact", "json":
+	default:
+		return nil, fmt.Errorf("invalid --golden-mode %q (want auto, exact or json)", mode)
+	}
+	return &goldenCheck{path: path, mode: mode, masks: masks, update: update}, nil
+}
+
+// check は本文をゴールデンファイルと比べ、違えば差分を標準エラー出力に表示する
+// update ならゴールデンファイルを本文で書き換える
+func (g *goldenCheck) check(body []byte, contentType string) (bool, error) {
+	asJSON := g.mode == "json" || g.mode == "auto" && (strings.EqualFold(filepath.Ext(g.path), ".json") || contentType == "application/json" || strings.HasSuffix(contentType, "+json"))
+	content := string(body)
+	if asJSON {
+		canonical, err := canonicalJSON(body)
+		if err != nil {
+			if g.mode == "json" {
+				return false, fmt.Errorf("response body is not JSON: %w", err)
+			}
+			// auto では JSON でなければそのまま比べる
+			asJSON = false
+		} else {
+			content = canonical
+		}
+	}
+
+	// ゴールデンファイルには伏せる前の本文を書く
+	if g.update {
+		if err := os.WriteFile(g.path, []byte(content), 0644); err != nil {
+			return false, err
+		}
+		fmt.Fprintf(os.Stderr, "Golden: updated %s\n", g.path)
+		return true, nil
+	}
+
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, fmt.Errorf("golden file %s does not exist (create it with --update-golden)", g.path)
+		}
+		return false, err
+	}
+	if asJSON {
+		if _, err := canonicalJSON(data); err != nil {
+			return false, fmt.Errorf("golden file %s is not JSON: %w", g.path, err)
+		}
+	}
+	want, got := g.normalize(data, asJSON), g.normalize(body, asJSON)
+	if want == got {
+		fmt.Fprintf(os.Stderr, "Golden: %s matches\n", g.path)
+		return true, nil
+	}
+	fmt.Fprintf(os.Stderr, "Golden: %s differs (- expected, + actual)\n", g.path)
+	for _, line := range diffLines(splitLines(want), splitLines(got)) {
+		fmt.Fprintln(os.Stderr, line)
+	}
+	return false, nil
+}
+
+// normalize は規則で伏せ、json なら整形した比べる形にする。JSON であることは確かめてあるものとする
+func (g *goldenCheck) normalize(data []byte, asJSON bool) string {
+	if !asJSON {
+		return string(g.masks.apply(data))
+	}
+	canonical, _ := canonicalJSON(g.masks.applyPaths(data))
+	return string(g.masks.applyPatterns([]byte(canonical)))
+}
+
+// canonicalJSON はキーを並べ替えて整形したJSONを返す。数値は元の書き方のまま残す
+func canonicalJSON(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	if dec.More() {
+		return "", fmt.Errorf("unexpected data after the JSON value")
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// splitLines は末尾の改行を除いて行に分ける
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+// diffLines は行ごとの差分を、変わった行の前後 goldenContext 行とともに返す
+// 行は "- " (want だけにある)、"+ " (got だけにある)、"  " (共通) で始まる
+func diffLines(want, got []string) []string {
+	if (len(want)+1)*(len(got)+1) > goldenMaxCells {
+		for i := 0; ; i++ {
+			switch {
+			case i >= len(want) && i >= len(got):
+				return nil
+			case i >= len(want) || i >= len(got) || want[i] != got[i]:
+				lines := []string{fmt.Sprintf("@@ line %d (too large to show a full diff)", i+1)}
+				if i < len(want) {
+					lines = append(lines, "- "+want[i])
+				}
+				if i < len(got) {
+					lines = append(lines, "+ "+got[i])
+				}
+				return lines
+			}
+		}
+	}
+
+	// 最長共通部分列の長さの表。lcs[i][j] は want[i:] と got[j:] のもの
+	lcs := make([][]int, len(want)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(got)+1)
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		for j := len(got) - 1; j >= 0; j-- {
+			if want[i] == got[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var all []string
+	i, j := 0, 0
+	for i < len(want) || j < len(got) {
+		switch {
+		case i < len(want) && j < len(got) && want[i] == got[j]:
+			all = append(all, "  "+want[i])
+			i++
+			j++
+		case i < len(want) && (j >= len(got) || lcs[i+1][j] >= lcs[i][j+1]):
+			all = append(all, "- "+want[i])
+			i++
+		default:
+			all = append(all, "+ "+got[j])
+			j++
+		}
+	}
+
+	// 変わった行から離れた共通の行は省く
+	var out []string
+	last := -1
+	for k, line := range all {
+		near := false
+		for d := max(0, k-goldenContext); d <= min(len(all)-1, k+goldenContext); d++ {
+			if !strings.HasPrefix(all[d], "  ") {
+				near = true
+				break
+			}
+		}
+		if !near {
+			continue
+		}
+		if last >= 0 && k > last+1 || last < 0 && k > 0 {
+			out = append(out, fmt.Sprintf("@@ line %d", lineNumber(all[:k])+1))
+		}
+		out = append(out, line)
+		last = k
+	}
+	return out
+}
+
+// lineNumber は差分の行までに期待する本文の行がいくつあったかを返す
+func lineNumber(diff []string) int {
+	n := 0
+	for _, line := range diff {
+		if !strings.HasPrefix(line, "+ ") {
+			n++
+		}
+	}
+	return n
+}
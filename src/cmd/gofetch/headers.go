@@ -0,0 +1,101 @@
+package main
+
+// レスポンスヘッダーの表示 (--show-headers)
+// ヘッダーの多いCDNのレスポンスでも読めるように、名前で絞り込み、
+// 名前の最初の語 (x-、cache-、content- など) ごとにまとめて並べ、
+// 同じヘッダーの繰り返しは1行にたたむ
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// headerValueMax は詳細モードでないときに表示するヘッダーの値の長さ
+const headerValueMax = 200
+
+// parseHeaderPatterns は "x-*,cache-*" のようなカンマ区切りのパターンを小文字にして返す
+func parseHeaderPatterns(spec string) []string {
+	var patterns []string
+	for _, p := range splitList(spec) {
+		patterns = append(patterns, strings.ToLower(p))
+	}
+	return patterns
+}
+
+// headerPrefix はヘッダー名の最初の語を返す
+func headerPrefix(name string) string {
+	prefix, _, _ := strings.Cut(strings.ToLower(name), "-")
+	return prefix
+}
+
+// printHeaders はパターンに一致するヘッダーを、まとめて並べ替えて w に書く
+func printHeaders(w io.Writer, h http.Header, patterns []string) {
+	var names []string
+	size := 0
+	for name, values := range h {
+		for _, v := range values {
+			size += len(name) + len(v) + 4
+		}
+		lower := strings.ToLower(name)
+		for _, p := range patterns {
+			if globMatch(p, lower) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	// 最初の語が同じヘッダーが2つ以上あればまとめ、ほかは最後にまとめて並べる
+	prefixes := map[string]int{}
+	for _, name := range names {
+		prefixes[headerPrefix(name)]++
+	}
+	group := func(name string) string {
+		if p := headerPrefix(name); prefixes[p] > 1 {
+			return p
+		}
+		return "~"
+	}
+	sort.Slice(names, func(i, j int) bool {
+		gi, gj := group(names[i]), group(names[j])
+		if gi != gj {
+			return gi < gj
+		}
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	fmt.Fprintf(w, "Headers: %d of %d shown (%s)\n", len(names), len(h), formatSize(int64(size)))
+	prev := ""
+	for _, name := range names {
+		if g := group(name); prev != "" && g != prev {
+			fmt.Fprintln(w)
+		}
+		prev = group(name)
+		for _, line := range foldHeader(h.Values(name)) {
+			fmt.Fprintf(w, "  %s: %s\n", name, line)
+		}
+	}
+}
+
+// foldHeader は同じ値の繰り返しを "値 (xN)" の1行にたたみ、長すぎる値を切り詰める
+func foldHeader(values []string) []string {
+	var lines []string
+	count := map[string]int{}
+	for _, v := range values {
+		if count[v] == 0 {
+			lines = append(lines, v)
+		}
+		count[v]++
+	}
+	for i, v := range lines {
+		if len(v) > headerValueMax && !verbose {
+			lines[i] = fmt.Sprintf("%s... (%d bytes, use --verbose to show all)", v[:headerValueMax], len(v))
+		}
+		if n := count[v]; n > 1 {
+			lines[i] += fmt.Sprintf(" (x%d)", n)
+		}
+	}
+	return lines
+}
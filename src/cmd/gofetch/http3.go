@@ -0,0 +1,31 @@
+//go:build http3
+
+package main
+
+// HTTP/3 (QUIC) 対応
+// quic-go に依存するため、-tags http3 を付けてビルドしたときだけ有効になる
+// 例: go build -tags http3
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Supported はHTTP/3に対応したビルドかどうか
+const http3Supported = true
+
+// newHTTP3Transport はHTTP/3のRoundTripperを返す
+// addr を指定した場合はURLのホストではなく addr へQUICで接続する
+func newHTTP3Transport(tlsConf *tls.Config, addr string) (http.RoundTripper, error) {
+	t := &http3.Transport{TLSClientConfig: tlsConf}
+	if addr != "" {
+		t.Dial = func(ctx context.Context, _ string, tlsCfg *tls.Config, cfg *quic.Config) (*quic.Conn, error) {
+			return quic.DialAddrEarly(ctx, addr, tlsCfg, cfg)
+		}
+	}
+	return t, nil
+}
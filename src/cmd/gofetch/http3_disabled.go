@@ -0,0 +1,17 @@
+//go:build !http3
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+// http3Supported はHTTP/3に対応したビルドかどうか
+const http3Supported = false
+
+// newHTTP3Transport はHTTP/3非対応のビルドでは常にエラーを返す
+func newHTTP3Transport(tlsConf *tls.Config, addr string) (http.RoundTripper, error) {
+	return nil, errors.New("HTTP/3 support is not built in (rebuild with -tags http3)")
+}
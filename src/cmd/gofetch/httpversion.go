@@ -0,0 +1,69 @@
+package main
+
+// HTTPのバージョンの指定 (--http1.1, --http2, --http3)
+// 指定したバージョンだけで取得し、サーバーが対応していなければ失敗させる
+// CDNのプロトコルごとの挙動を同じツールで比べるのに使う。並べて比べるには --compare-protocols を使う
+// --http2 は https:// ではALPNで h2 だけを提示し、http:// では事前の合意があるものとして平文の HTTP/2 (h2c) で送る
+// --http3 は実験的な対応で、-tags http3 でビルドした場合だけ使える。QUICはUDPなので
+// プロキシ、SSHトンネル、接続元のポートの指定、接続の診断とは組み合わせられない
+// 実際に使ったバージョンは --verbose で表示する
+//
+//	gofetch -u https://example.com --http1.1 --verbose
+//	gofetch -u https://example.com --http3 --timing
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// selectHTTPVersion は指定されたバージョン ("1.1", "2", "3") を返す。指定がなければ空文字列を返す
+func selectHTTPVersion(http11, http2, http3 bool) (string, error) {
+	var versions []string
+	for _, v := range []struct {
+		set     bool
+		version string
+	}{{http11, "1.1"}, {http2, "2"}, {http3, "3"}} {
+		if v.set {
+			versions = append(versions, v.version)
+		}
+	}
+	if len(versions) > 1 {
+		return "", fmt.Errorf("--http%s cannot be used together", strings.Join(versions, ", --http"))
+	}
+	if len(versions) == 0 {
+		return "", nil
+	}
+	return versions[0], nil
+}
+
+// forceHTTPVersion は transport を version だけで送るように設定する
+// HTTP/3 では transport の代わりに使う RoundTripper を返す
+func forceHTTPVersion(transport *http.Transport, version, rawURL string) (http.RoundTripper, error) {
+	https := strings.HasPrefix(rawURL, "https://")
+	protocols := new(http.Protocols)
+	switch version {
+	case "1.1":
+		protocols.SetHTTP1(true)
+		// ALPNで h2 を提示するとサーバーが HTTP/2 を選ぶことがあるので、http/1.1 だけを提示する
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	case "2":
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	case "3":
+		if !https {
+			return nil, fmt.Errorf("--http3 requires an https URL")
+		}
+		conf := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			conf = transport.TLSClientConfig.Clone()
+		}
+		return newHTTP3Transport(conf, "")
+	}
+	transport.Protocols = protocols
+	return nil, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+// 画像のレスポンスの表示 (--preview, --preview-mode)
+// 標準出力が端末のときに画像のレスポンスを受け取ると、バイナリをそのまま書かずに形式、大きさ、EXIF の要点を表示する
+// 端末でなければ (リダイレクトやパイプ) いつもどおり本文を書くので、gofetch -u ... > photo.jpg はそのまま使える
+// --preview は縮小した画像を sixel、iTerm2 のインライン画像、ASCII のいずれかで続けて表示する (preview.go)
+// 対応する形式は PNG、JPEG、GIF、WebP、BMP、TIFF。EXIF は JPEG、PNG、WebP、TIFF から読む
+// -o と --preview を指定すると本文は受け取ったままファイルに書き、情報とプレビューを標準出力に表示する
+//
+//	gofetch -u https://example.com/photo.jpg
+//	gofetch -u https://example.com/logo.png --preview --preview-mode ascii
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// isImageResponse は本文が画像かを返す。SVG はテキストなので含めない
+// Content-Type がないか application/octet-stream なら本文の先頭から判断する
+func isImageResponse(contentType string, body []byte) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	if mt == "" || mt == "application/octet-stream" {
+		mt = http.DetectContentType(body)
+	}
+	return strings.HasPrefix(mt, "image/") && !strings.HasSuffix(mt, "+xml")
+}
+
+// describeImage は画像の形式、大きさ、EXIF を表示する文字列を作る。previewMode が空でなければプレビューも付ける
+// 最後の改行は付けない
+func describeImage(body []byte, contentType, previewMode string, columns int) []byte {
+	var buf bytes.Buffer
+	conf, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		mt, _, _ := mime.ParseMediaType(contentType)
+		fmt.Fprintf(&buf, "Image:       %s, %s (dimensions unavailable: %v)\n", orDash(mt), formatSize(int64(len(body))), err)
+		return bytes.TrimRight(buf.Bytes(), "\n")
+	}
+	fmt.Fprintf(&buf, "Image:       %s, %dx%d, %s\n", strings.ToUpper(format), conf.Width, conf.Height, formatSize(int64(len(body))))
+	if format == "gif" {
+		if g, err := gif.DecodeAll(bytes.NewReader(body)); err == nil && len(g.Image) > 1 {
+			fmt.Fprintf(&buf, "Frames:      %d (animated)\n", len(g.Image))
+		}
+	}
+	if exif := readEXIF(body); exif != nil {
+		exif.write(&buf)
+	}
+	if previewMode != "" {
+		img, _, err := image.Decode(bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintf(&buf, "Preview:     unavailable (%v)\n", err)
+		} else {
+			renderPreview(&buf, img, previewMode, columns)
+		}
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+// exifSummary は表示する EXIF の項目。空の項目は表示しない
+type exifSummary struct {
+	make, model, lens, software string
+	taken                       string
+	orientation                 uint32
+	// exposure は 1/250s、fNumber と focal は小数、iso は感度
+	exposure       string
+	fNumber, focal float64
+	iso            uint32
+	gps            string
+}
+
+// write は EXIF の項目を1行ずつ書く
+func (e *exifSummary) write(w io.Writer) {
+	line := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "%-12s %s\n", name+":", value)
+		}
+	}
+	camera := e.model
+	if e.make != "" && !strings.HasPrefix(strings.ToLower(e.model), strings.ToLower(e.make)) {
+		camera = strings.TrimSpace(e.make + " " + e.model)
+	}
+	line("Camera", camera)
+	line("Lens", e.lens)
+	line("Taken", e.taken)
+	var exposure []string
+	if e.exposure != "" {
+		exposure = append(exposure, e.exposure)
+	}
+	if e.fNumber > 0 {
+		exposure = append(exposure, fmt.Sprintf("f/%g", e.fNumber))
+	}
+	if e.iso > 0 {
+		exposure = append(exposure, fmt.Sprintf("ISO %d", e.iso))
+	}
+	if e.focal > 0 {
+		exposure = append(exposure, fmt.Sprintf("%gmm", e.focal))
+	}
+	line("Exposure", strings.Join(exposure, ", "))
+	line("Orientation", exifOrientations[e.orientation])
+	line("Software", e.software)
+	line("GPS", e.gps)
+}
+
+// exifOrientations は Orientation の値の説明。1 (そのまま) は表示しない
+var exifOrientations = map[uint32]string{
+	2: "mirror horizontal",
+	3: "rotate 180",
+	4: "mirror vertical",
+	5: "mirror horizontal and rotate 270 CW",
+	6: "rotate 90 CW",
+	7: "mirror horizontal and rotate 90 CW",
+	8: "rotate 270 CW",
+}
+
+// readEXIF は画像から EXIF を探して読む。見つからないか読めなければ nil を返す
+func readEXIF(body []byte) *exifSummary {
+	var data []byte
+	switch {
+	case bytes.HasPrefix(body, []byte("\xff\xd8")):
+		data = jpegEXIF(body)
+	case bytes.HasPrefix(body, []byte("\x89PNG\r\n\x1a\n")):
+		data = pngEXIF(body)
+	case len(body) >= 12 && bytes.HasPrefix(body, []byte("RIFF")) && string(body[8:12]) == "WEBP":
+		data = webpEXIF(body)
+	case bytes.HasPrefix(body, []byte("II*\x00")), bytes.HasPrefix(body, []byte("MM\x00*")):
+		data = body
+	}
+	// WebP などでは先頭に Exif\0\0 が付いていることがある
+	data = bytes.TrimPrefix(data, []byte("Exif\x00\x00"))
+	if len(data) < 8 {
+		return nil
+	}
+	return parseEXIF(data)
+}
+
+// jpegEXIF は JPEG の APP1 セグメントの EXIF を返す
+func jpegEXIF(body []byte) []byte {
+	for pos := 2; pos+4 <= len(body) && body[pos] == 0xff; {
+		marker := body[pos+1]
+		// SOS の後は画像のデータなので探さない
+		if marker == 0xda {
+			break
+		}
+		size := int(binary.BigEndian.Uint16(body[pos+2:]))
+		if size < 2 || pos+2+size > len(body) {
+			break
+		}
+		segment := body[pos+4 : pos+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment
+		}
+		pos += 2 + size
+	}
+	return nil
+}
+
+// pngEXIF は PNG の eXIf チャンクを返す
+func pngEXIF(body []byte) []byte {
+	for pos := 8; pos+12 <= len(body); {
+		size := int(binary.BigEndian.Uint32(body[pos:]))
+		if size < 0 || size > len(body)-pos-12 {
+			break
+		}
+		if string(body[pos+4:pos+8]) == "eXIf" {
+			return body[pos+8 : pos+8+size]
+		}
+		pos += 12 + size
+	}
+	return nil
+}
+
+// webpEXIF は WebP の EXIF チャンクを返す
+func webpEXIF(body []byte) []byte {
+	for pos := 12; pos+8 <= len(body); {
+		size := int(binary.LittleEndian.Uint32(body[pos+4:]))
+		if size < 0 || size > len(body)-pos-8 {
+			break
+		}
+		if string(body[pos:pos+4]) == "EXIF" {
+			return body[pos+8 : pos+8+size]
+		}
+		// チャンクは偶数のバイトにそろえてある
+		pos += 8 + size + size%2
+	}
+	return nil
+}
+
+// tiffEntry は IFD の項目1つ
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// tiffReader は EXIF の TIFF 構造を読む
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// tiffTypeSizes は型ごとの値1つのバイト数
+var tiffTypeSizes = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// parseEXIF は TIFF のヘッダーから IFD0、Exif IFD、GPS IFD を読む
+func parseEXIF(data []byte) *exifSummary {
+	r := &tiffReader{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		r.order = binary.LittleEndian
+	case "MM\x00*":
+		r.order = binary.BigEndian
+	default:
+		return nil
+	}
+	ifd0 := r.ifd(r.order.Uint32(data[4:]))
+	if ifd0 == nil {
+		return nil
+	}
+	e := &exifSummary{
+		make:        r.ascii(ifd0[0x010f]),
+		model:       r.ascii(ifd0[0x0110]),
+		orientation: r.uint(ifd0[0x0112]),
+		software:    r.ascii(ifd0[0x0131]),
+		taken:       r.ascii(ifd0[0x0132]),
+	}
+	if sub := r.ifd(r.uint(ifd0[0x8769])); sub != nil {
+		if taken := r.ascii(sub[0x9003]); taken != "" {
+			e.taken = taken
+		}
+		if v := r.rationals(sub[0x829a]); len(v) == 1 && v[0][0] > 0 && v[0][1] > 0 {
+			if v[0][0] < v[0][1] {
+				e.exposure = fmt.Sprintf("1/%gs", float64(v[0][1])/float64(v[0][0]))
+			} else {
+				e.exposure = fmt.Sprintf("%gs", float64(v[0][0])/float64(v[0][1]))
+			}
+		}
+		e.fNumber = rationalValue(r.rationals(sub[0x829d]))
+		e.iso = r.uint(sub[0x8827])
+		e.focal = rationalValue(r.rationals(sub[0x920a]))
+		e.lens = r.ascii(sub[0xa434])
+	}
+	if gps := r.ifd(r.uint(ifd0[0x8825])); gps != nil {
+		lat, latOK := gpsDegrees(r.rationals(gps[2]), r.ascii(gps[1]))
+		lon, lonOK := gpsDegrees(r.rationals(gps[4]), r.ascii(gps[3]))
+		if latOK && lonOK {
+			e.gps = fmt.Sprintf("%.6f, %.6f", lat, lon)
+		}
+	}
+	if *e == (exifSummary{}) {
+		return nil
+	}
+	return e
+}
+
+// ifd は offset の IFD の項目をタグで引けるようにして返す。offset が範囲外なら nil を返す
+func (r *tiffReader) ifd(offset uint32) map[uint16]tiffEntry {
+	if offset < 8 || uint64(offset)+2 > uint64(len(r.data)) {
+		return nil
+	}
+	n := uint32(r.order.Uint16(r.data[offset:]))
+	entries := map[uint16]tiffEntry{}
+	for i := uint32(0); i < n; i++ {
+		at := uint64(offset) + 2 + uint64(i)*12
+		if at+12 > uint64(len(r.data)) {
+			break
+		}
+		e := r.data[at : at+12]
+		typ, count := r.order.Uint16(e[2:]), r.order.Uint32(e[4:])
+		size := uint64(tiffTypeSizes[typ]) * uint64(count)
+		if size == 0 {
+			continue
+		}
+		// 4バイトに収まる値は項目の中に、収まらなければ位置を指す
+		value := e[8:12]
+		if size > 4 {
+			start := uint64(r.order.Uint32(e[8:]))
+			if start+size > uint64(len(r.data)) {
+				continue
+			}
+			value = r.data[start : start+size]
+		}
+		entries[r.order.Uint16(e)] = tiffEntry{typ: typ, count: count, value: value[:min(size, uint64(len(value)))]}
+	}
+	return entries
+}
+
+// ascii は ASCII の値を返す
+func (r *tiffReader) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(s)
+}
+
+// uint は SHORT か LONG の最初の値を返す
+func (r *tiffReader) uint(e tiffEntry) uint32 {
+	switch e.typ {
+	case 3:
+		return uint32(r.order.Uint16(e.value))
+	case 4:
+		return r.order.Uint32(e.value)
+	}
+	return 0
+}
+
+// rationals は RATIONAL の値を分子と分母の組で返す
+func (r *tiffReader) rationals(e tiffEntry) [][2]uint32 {
+	if e.typ != 5 {
+		return nil
+	}
+	v := make([][2]uint32, e.count)
+	for i := range v {
+		v[i] = [2]uint32{r.order.Uint32(e.value[i*8:]), r.order.Uint32(e.value[i*8+4:])}
+	}
+	return v
+}
+
+// rationalValue は RATIONAL の1つ目の値を小数にする。なければ0を返す
+func rationalValue(v [][2]uint32) float64 {
+	if len(v) == 0 || v[0][1] == 0 {
+		return 0
+	}
+	return float64(v[0][0]) / float64(v[0][1])
+}
+
+// gpsDegrees は度、分、秒の RATIONAL を南と西が負の度にする
+func gpsDegrees(v [][2]uint32, ref string) (float64, bool) {
+	if len(v) != 3 {
+		return 0, false
+	}
+	var deg float64
+	for i, scale := range []float64{1, 60, 3600} {
+		if v[i][1] == 0 {
+			return 0, false
+		}
+		deg += float64(v[i][0]) / float64(v[i][1]) / scale
+	}
+	if ref == "S" || ref == "W" {
+		deg = -deg
+	}
+	return deg, true
+}
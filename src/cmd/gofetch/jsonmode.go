@@ -0,0 +1,146 @@
+package main
+
+// JSONのAPIを扱うモード (--json, --jq, --color)
+// --json は Accept と (本文があれば) Content-Type を application/json にし、
+// 標準出力に書くレスポンスがJSONならインデントして表示する。キーの順序はそのまま残す
+// --jq は data.items.0.name の形のパスで値を1つ取り出して出力する。スクリプトで使うため、
+// 文字列は引用符を付けずにそのまま、それ以外はJSONで出力する。パスは --table と同じく . 区切りで、
+// 数字は配列の添字、# は要素の数になる。値がなければ終了コード1で終了する
+// 色は --color で指定する。auto (既定) は標準出力が端末で、環境変数 NO_COLOR がなければ付ける
+//
+//	gofetch -u https://api.example.com/items --json
+//	id=$(gofetch -u https://api.example.com/items --jq data.items.0.id)
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// JSONの色付けに使うエスケープシーケンス
+const (
+	colorKey     = "\x1b[34m"
+	colorString  = "\x1b[32m"
+	colorNumber  = "\x1b[36m"
+	colorLiteral = "\x1b[35m"
+	colorReset   = "\x1b[0m"
+)
+
+// useColor は --color の指定から標準出力に色を付けるかを決める
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid --color %q (want auto, always or never)", mode)
+}
+
+// applyJSONHeaders は --json のリクエストヘッダーを設定する。-H で指定したものは変えない
+func applyJSONHeaders(h http.Header, hasBody bool) {
+	if h.Get("Accept") == "" {
+		h.Set("Accept", "application/json")
+	}
+	if hasBody && h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "application/json")
+	}
+}
+
+// prettyJSON はJSONをインデントし、color なら色を付ける。JSONでなければ ok は false
+func prettyJSON(body []byte, color bool) ([]byte, bool) {
+	var out bytes.Buffer
+	if err := json.Indent(&out, bytes.TrimSpace(body), "", "  "); err != nil {
+		return body, false
+	}
+	if color {
+		return colorizeJSON(out.Bytes()), true
+	}
+	return out.Bytes(), true
+}
+
+// extractJSONPath は本文から --jq のパスの値を取り出して出力する形にする
+func extractJSONPath(body []byte, path string, color bool) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("response is not JSON: %w", err)
+	}
+	v, ok := flowLookup(value, path)
+	if !ok {
+		return nil, fmt.Errorf("--jq: no value at %s", path)
+	}
+	switch v.(type) {
+	case map[string]any, []any:
+	default:
+		return []byte(flowString(v)), nil
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	data := bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+	if color {
+		data = colorizeJSON(data)
+	}
+	return data, nil
+}
+
+// colorizeJSON は正しいJSONのテキストのキー、文字列、数値、true/false/null に色を付ける
+func colorizeJSON(data []byte) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			end++
+			// 後に : が続く文字列はキー
+			color := colorString
+			rest := bytes.TrimLeft(data[end:], " \t\r\n")
+			if len(rest) > 0 && rest[0] == ':' {
+				color = colorKey
+			}
+			out.WriteString(color)
+			out.Write(data[i:end])
+			out.WriteString(colorReset)
+			i = end
+		case c == '-' || c >= '0' && c <= '9' || c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(data) && !strings.ContainsRune(",]} \t\r\n", rune(data[end])) {
+				end++
+			}
+			color := colorNumber
+			if c == 't' || c == 'f' || c == 'n' {
+				color = colorLiteral
+			}
+			out.WriteString(color)
+			out.Write(data[i:end])
+			out.WriteString(colorReset)
+			i = end
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,175 @@
+package main
+
+// 実行中のダッシュボード (bench --live, --watch --live)
+// 長い負荷試験や監視の間、現在のRPS、エラー率、直近のp50/p99、RPSの推移のスパークラインを
+// 標準エラー出力に1秒ごとに書き直して表示する。最後の集計が出るまで何も見えない状態をなくす
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// liveWindow は bench でパーセンタイルを計算する直近の期間
+	liveWindow = 10 * time.Second
+	// liveHistory はスパークラインに表示する秒数
+	liveHistory = 40
+)
+
+// sparkBlocks はスパークラインに使う文字
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// liveSample は結果1件
+type liveSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// liveDashboard は結果を受け取り、定期的に端末に表示し直す
+type liveDashboard struct {
+	w     io.Writer
+	start time.Time
+	// tty でなければ書き直さずに1行ずつ追記する
+	tty bool
+	// window はパーセンタイルを計算する直近の期間
+	window time.Duration
+
+	mu      sync.Mutex
+	samples []liveSample
+	total   int
+	errors  int
+	history []float64
+	lines   int
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// newLiveDashboard は直近の window のパーセンタイルを標準エラー出力に表示するダッシュボードを作る
+func newLiveDashboard(window time.Duration) *liveDashboard {
+	fi, err := os.Stderr.Stat()
+	return &liveDashboard{
+		w:      os.Stderr,
+		start:  time.Now(),
+		tty:    err == nil && fi.Mode()&os.ModeCharDevice != 0,
+		window: window,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// observe は結果1件を記録する
+func (d *liveDashboard) observe(latency time.Duration, failed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.samples = append(d.samples, liveSample{at: time.Now(), latency: latency, failed: failed})
+	d.total++
+	if failed {
+		d.errors++
+	}
+}
+
+// run は stop が呼ばれるまで1秒ごとに表示し直す
+func (d *liveDashboard) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case now := <-ticker.C:
+			d.draw(now)
+		}
+	}
+}
+
+// above は fn が書く内容をダッシュボードの上に出す
+// 端末ではダッシュボードを消してから fn を呼び、次の表示でその下に描き直す
+func (d *liveDashboard) above(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tty && d.lines > 0 {
+		fmt.Fprintf(d.w, "\x1b[%dA\x1b[J", d.lines)
+		d.lines = 0
+	}
+	fn()
+}
+
+// close は表示を止める。最後の表示は残す
+func (d *liveDashboard) close() {
+	close(d.stop)
+	<-d.done
+}
+
+// draw は直近1秒のRPSとエラー率、直近の期間のパーセンタイルを計算して表示する
+func (d *liveDashboard) draw(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// 期間より古い結果は捨てる
+	i := sort.Search(len(d.samples), func(i int) bool { return now.Sub(d.samples[i].at) <= d.window })
+	d.samples = d.samples[i:]
+
+	var lastSecond, lastErrors int
+	latencies := make([]time.Duration, 0, len(d.samples))
+	for _, s := range d.samples {
+		if now.Sub(s.at) <= time.Second {
+			lastSecond++
+			if s.failed {
+				lastErrors++
+			}
+		}
+		latencies = append(latencies, s.latency)
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	d.history = append(d.history, float64(lastSecond))
+	if len(d.history) > liveHistory {
+		d.history = d.history[len(d.history)-liveHistory:]
+	}
+	errorRate := 0.0
+	if lastSecond > 0 {
+		errorRate = 100 * float64(lastErrors) / float64(lastSecond)
+	}
+
+	lines := []string{
+		fmt.Sprintf("Elapsed %s  requests %d  errors %d", now.Sub(d.start).Round(time.Second), d.total, d.errors),
+		fmt.Sprintf("RPS %d  error rate %.1f%%  p50 %s  p99 %s (last %s)", lastSecond, errorRate,
+			roundLatency(percentile(latencies, 50)), roundLatency(percentile(latencies, 99)), d.window),
+		"RPS " + sparkline(d.history),
+	}
+	if !d.tty {
+		fmt.Fprintln(d.w, lines[1])
+		return
+	}
+	// 前回の表示の先頭に戻って書き直す
+	if d.lines > 0 {
+		fmt.Fprintf(d.w, "\x1b[%dA", d.lines)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(d.w, "\x1b[2K%s\n", line)
+	}
+	d.lines = len(lines)
+}
+
+// sparkline は値の推移を最大値を基準にしたブロック文字で表す
+func sparkline(values []float64) string {
+	peak := 0.0
+	for _, v := range values {
+		peak = max(peak, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		i := 0
+		if peak > 0 {
+			i = int(v / peak * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+// 接続元のポートの指定 (--local-port-range, --local-port-order)
+// 接続元のポートを範囲の中から順番 (sequential) か無作為 (random) に選んで接続する
+// NATや conntrack の表があふれたときの動作や、ロードバランサーが接続元のポートのハッシュで
+// 振り分ける様子を、ポートを決めて再現できる
+// 使用中のポートは飛ばして次を試し、範囲のポートがどれも空いていなければ接続の誤りにする
+//
+//	gofetch bench -u https://api.example.com/ --new-connections --local-port-range 40000-40100
+//	gofetch -u https://api.example.com/ --local-port-range 50000
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// localPortDialAttempts は空いたポートを探すために1回の接続で試すポートの数の上限
+const localPortDialAttempts = 100
+
+// localPortRange は接続元のポートの範囲と、使ったポートの記録
+type localPortRange struct {
+	low, high int
+	random    bool
+
+	mu   sync.Mutex
+	next int
+	used map[int]bool
+	// busy は使用中で飛ばしたポートの数
+	busy int
+}
+
+// parseLocalPortRange は "40000-40100" か "40000" の形の範囲と、順番 (sequential か random) を解釈する
+func parseLocalPortRange(spec, order string) (*localPortRange, error) {
+	lowStr, highStr, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		highStr = lowStr
+	}
+	low, err1 := strconv.Atoi(strings.TrimSpace(lowStr))
+	high, err2 := strconv.Atoi(strings.TrimSpace(highStr))
+	if err1 != nil || err2 != nil || low < 1 || high > 65535 || low > high {
+		return nil, fmt.Errorf("invalid --local-port-range %q (want LOW-HIGH between 1 and 65535)", spec)
+	}
+	p := &localPortRange{low: low, high: high, next: low, used: map[int]bool{}}
+	switch order {
+	case "", "sequential":
+	case "random":
+		p.random = true
+	default:
+		return nil, fmt.Errorf("invalid --local-port-order %q (want sequential or random)", order)
+	}
+	return p, nil
+}
+
+// pick は次に使うポートを返す
+func (p *localPortRange) pick() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.random {
+		return p.low + rand.IntN(p.high-p.low+1)
+	}
+	port := p.next
+	if p.next++; p.next > p.high {
+		p.next = p.low
+	}
+	return port
+}
+
+// dialContext は接続元のポートを範囲から選んで接続する関数を返す
+func (p *localPortRange) dialContext(base net.Dialer) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !strings.HasPrefix(network, "tcp") {
+			return base.DialContext(ctx, network, addr)
+		}
+		attempts := min(p.high-p.low+1, localPortDialAttempts)
+		for range attempts {
+			port := p.pick()
+			d := base
+			d.LocalAddr = &net.TCPAddr{Port: port}
+			conn, err := d.DialContext(ctx, network, addr)
+			if err == nil {
+				p.mu.Lock()
+				p.used[port] = true
+				p.mu.Unlock()
+				verbosef("Local port: %d -> %s", port, addr)
+				return conn, nil
+			}
+			if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+				return nil, err
+			}
+			p.mu.Lock()
+			p.busy++
+			p.mu.Unlock()
+		}
+		return nil, fmt.Errorf("dial %s: no free local port in %d-%d after %d attempts", addr, p.low, p.high, attempts)
+	}
+}
+
+// String は使ったポートのまとめを返す
+func (p *localPortRange) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	order := "sequential"
+	if p.random {
+		order = "random"
+	}
+	return fmt.Sprintf("%d distinct port(s) used from %d-%d (%s), %d busy port(s) skipped", len(p.used), p.low, p.high, order, p.busy)
+}
@@ -0,0 +1,2296 @@
+package main
+
+// 簡単かつ効率的にURLアクセスやHTTPリクエストを実行するためのツール
+// exe化して配布することを想定している
+// 使い方は、PATHを通して、コマンドライン引数にURLを指定するだけ
+// 例: gofetch --url https://example.com
+// 例: gofetch -u https://example.com
+// 例: gofetch --base-url https://api.example.com/v1 /users/42
+// 例: GOFETCH_BASE_URL=https://api.example.com/v1 gofetch /users/42
+// 例: gofetch -u https://example.com --output output.txt
+// 例: gofetch -u https://example.com -o output.txt
+// 例: gofetch -u https://example.com --timeout 10
+// 例: gofetch -u https://example.com -o export.csv.age --encrypt-output age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+// 例: gofetch -u https://example.com/release.tar.gz --extract ./release --strip-components 1
+// 例: gofetch -u https://example.com/batch --split-multipart parts/
+// 例: gofetch -u https://example.com/video.mp4 -H 'Range: bytes=0-99,500-599' --split-multipart -
+// 例: gofetch -u https://api.example.com/servers --table 'name,status,.meta.region'
+// 例: gofetch -u https://api.example.com/servers --csv 'name,status' -o servers.csv
+// 例: gofetch -u https://api.example.com/items --json -d '{"name":"a"}'
+// 例: gofetch -u https://api.example.com/items --jq data.items.0.name
+// 例: gofetch -u https://api.example.com/v1/users/42 --proto api/user.proto --proto-message example.v1.User
+// 例: gofetch -u https://internal.example.com/v1/state --decode msgpack --jq nodes.0.name
+// 例: gofetch -u https://lake.example.com/events/part-0000.parquet --summarize --sample-rows 5
+// 例: gofetch -u https://example.com/photo.jpg --preview
+// 例: gofetch -u 'https://api.example.com/events?follow=1' --ndjson-in --jq data.id
+// 例: gofetch -X POST -u https://api.example.com/items -d '{"name":"new"}'
+// 例: gofetch --method PUT -u https://api.example.com/items/42 --data-file item.json
+// 例: cat item.json | gofetch -X PATCH -u https://api.example.com/items/42 --data-file -
+// 例: gofetch -u https://example.com/upload -F title=backup -F file=@backup.tar.gz
+// 例: gofetch -u https://api.example.com/me -H "Accept: application/json" -H 'Authorization: Bearer {{env "API_TOKEN"}}'
+// 例: gofetch -u https://example.com --user-agent "my-monitor/1.0"
+// 例: gofetch -u https://example.com/app.js --compressed
+// 例: gofetch -u https://example.com/data.json --compressed --raw -o data.json.br
+// 例: gofetch -u https://api.example.com/me --auth 'admin:{{env "ADMIN_PASSWORD"}}'
+// 例: gofetch -u https://api.example.com/me --bearer '{{env "API_TOKEN"}}'
+// 例: gofetch -u https://api.example.com/items --api-key api_key='{{env "API_KEY"}}' --api-key-in query
+// 例: gofetch -u https://example.com/login -d 'user=alice&password=secret' --cookie-jar cookies.txt
+// 例: gofetch -u https://example.com/account --cookies cookies.txt --cookie-jar cookies.txt
+// 例: gofetch deploy-trigger --post301 --post302 (リダイレクトでもPOSTのまま送り直す)
+// 例: gofetch -u https://example.com --redirect-headers none --verbose
+// 例: gofetch -u https://example.com/old --no-follow --show-headers location
+// 例: gofetch -u https://example.com --max-redirects 3 --verbose
+// 例: gofetch -u https://example.com -t 10
+// 例: gofetch -u https://example.com --show-headers 'x-*,cache-*'
+// 例: gofetch -u https://example.com --show-headers '*' --max-header-bytes 64KB
+// 例: eval "$(gofetch -u https://api.example.com/items -o items.json --export-header ETAG=ETag --export-header X-RateLimit-Remaining)"
+// 例: gofetch -u https://api.example.com/items -o items.json --export-header ETAG=ETag --export-file headers.env
+// 例: gofetch -u https://api.example.com/health --budget time=500ms --save-failures failures
+// 例: gofetch -u https://example.com/large.bin -o large.bin --keep-partial
+// 例: gofetch -u https://example.com/image.iso -o image.iso --continue
+// 例: gofetch -u https://example.com/missing --verbose
+// 例: gofetch -u https://example.com -i
+// 例: gofetch -u https://api.example.com/health --fail || echo "exit $?"
+// 例: gofetch -u https://api.example.com/orders?page=2 --shadow-to https://api-next.internal --shadow-compare
+// 例: gofetch -u https://example.com/image.iso -o image.iso --confirm --confirm-size 1GB
+// 例: gofetch -u https://example.com --retry 5
+// 例: gofetch -u https://flaky.example.com -r 5 --retry-log attempts.jsonl
+// 例: gofetch -u https://api.example.com -r 5 --retry-on 429,500-599 --retry-delay 500ms --retry-max-delay 10s
+// 例: gofetch -u https://example.com -r 5
+// 例: gofetch -u https://example.com/large.iso --speed-limit 10KB --speed-time 15
+// 例: gofetch -u https://example.com --for 10
+// 例: gofetch -u https://api.example.com/health --for 200 --concurrency 10
+// 例: gofetch --url-file urls.txt -o out/ --rate 2/s
+// 例: gofetch --url-file urls.txt -o out/ --concurrency 1 --delay 1s-3s
+// 例: gofetch -u https://example.com/status.json --watch --interval 30s --on-change 'echo changed'
+// 例: gofetch -u https://example.com -f 10
+// 例: gofetch -u https://example.com --ech
+// 例: gofetch -u https://example.com --ech-config AEX+DQBB...
+// 例: gofetch -u https://localhost:8443 --insecure
+// 例: gofetch -u https://internal.example.com --cacert corp-ca.pem --cert client.pem --key client.key
+// 例: gofetch -u https://internal.example.com --capath /etc/corp/certs --ca-merge --print-trust
+// 例: gofetch -u https://internal.example.com --cert client.pem --key ssh-agent
+// 例: gofetch -u https://internal.example.com --cert client.pem --key 'command:./pkcs11-sign.sh 01'
+// 例: gofetch -u https://example.com --svcb --verbose
+// 例: gofetch -u https://example.com --no-alt-svc
+// 例: gofetch -u https://example.com --storage memory
+// 例: gofetch -u https://example.com/feed.xml --cache-dir cache/ --for 10
+// 例: gofetch -u https://example.com --wire-stats
+// 例: gofetch -u https://example.com --timing
+// 例: gofetch -u https://example.com -o page.html --timing-format json 2>> timings.ndjson
+// 例: gofetch -u https://example.com -o /dev/null --format '{{.Status}} {{.Latency}} {{.Size}}'
+// 例: gofetch --url-file urls.txt -o out/ --report csv > results.csv
+// 例: gofetch -u https://api.example.com/ --local-port-range 50000-50010 --local-port-order random
+// 例: gofetch -u http://example.com --framing
+// 例: gofetch -u http://example.com --linger 65
+// 例: gofetch -u http://example.com --half-close
+// 例: gofetch -u https://api.example.com/users --cors-check https://app.example.com --cors-method PUT --cors-headers Content-Type,Authorization
+// 例: gofetch -u https://example.com/app.js --cache-check
+// 例: gofetch -u https://example.com/bundle.js --compare-encodings
+// 例: gofetch -u https://example.com --compare-protocols
+// 例: gofetch -u https://example.com --http1.1 --verbose
+// 例: gofetch -u https://example.com --http3 --timing
+// 例: gofetch -u https://example.com --negotiate-matrix
+// 例: gofetch -u https://example.com --negotiate 'Accept-Language=en|ja|zh-TW'
+// 例: gofetch -u http://staging.example.com --edge-case all
+// 例: gofetch -u http://staging.example.com --edge-case cl-te,te-te
+// 例: gofetch -u http://internal.example:8080 --ssh-tunnel ec2-user@bastion.example.com
+// 例: gofetch -u https://example.com --budget size=500KB,ttfb=200ms,time=1s
+// 例: gofetch -u https://example.com --budget-file budgets.yaml
+// 例: gofetch -u http://localhost:8080/healthz --expect-status 200 --expect-body-contains ok --expect-max-time 2s
+// 例: gofetch -u https://api.example.com/users/1 --golden testdata/user.json --golden-mask '"updated_at": "[^"]*"'
+// 例: gofetch -u https://api.example.com/orders --golden testdata/orders.json --mask .items.*.id --mask timestamp --mask-file masks.txt
+// 例: gofetch -u https://example.com --egress tokyo=socks5://10.0.1.10:1080 --egress frankfurt=http://10.0.2.10:3128
+// 例: gofetch -u https://example.com --egress-file egress.yaml
+// 例: gofetch -u https://example.com --proxy http://proxy.corp:3128 --noproxy localhost,.internal
+// 例: gofetch -u https://example.com --proxy http://proxy.corp:3128 --proxy socks5h://10.0.0.5:1080
+// 例: gofetch -u https://example.com --socks5 127.0.0.1:1080
+// 例: gofetch -u https://example.com --no-system-proxy
+// 例: gofetch -u https://example.com --dns-cache-off
+// 例: gofetch -u https://example.com --dns-reresolve 10
+// 例: gofetch baseline record -i urls.txt -b baseline.json
+// 例: gofetch baseline verify -b baseline.json
+// 例: gofetch oci manifest alpine:3.20
+// 例: gofetch oci tags ghcr.io/owner/image
+// 例: gofetch oci blob alpine@sha256:... -o layer.tar.gz
+// 例: gofetch discover openid accounts.example.com
+// 例: gofetch discover jwks accounts.example.com
+// 例: gofetch api --spec api.yaml getUser --param id=5
+// 例: gofetch api --spec https://api.example.com/openapi.json --list
+// 例: gofetch bench --scenario scenario.yaml -c 20 -d 30s
+// 例: gofetch bench -u https://api.example.com/ --new-connections --local-port-range 40000-40100
+// 例: gofetch flow checkout.yaml --var user=alice
+// 例: gofetch config check
+// 例: printf %s "$CLIENT_SECRET" | gofetch config encrypt --to age1...
+// 例: gofetch -u s3://bucket/key (PATH上の gofetch-proto-s3 が処理する)
+// 例: gofetch mycommand --flag (PATH上の gofetch-mycommand を実行する)
+// 例: gofetch plugins
+// 例: gofetch deploy-status env=prod (設定ファイルのエイリアスを実行する)
+// 例: gofetch --profile prod /users/42 (設定ファイルのプロファイルの既定値を使う)
+// 例: gofetch aliases
+// 例: gofetch --help
+// 例: gofetch -h
+// 例: gofetch --version
+// 例: gofetch -v
+// サブコマンドは以下の通り
+// baseline record: URLの一覧のステータス、ヘッダー、本文のハッシュを記録する
+// baseline verify: 記録したベースラインと比べて変化を報告する
+// oci manifest/tags/blob: コンテナレジストリからマニフェスト、タグの一覧、レイヤーを取得する
+// discover: openid-configuration、JWKS、security.txt、robots.txt、sitemap.xml を取得して表示する
+// api: OpenAPIの仕様から operationId で操作を呼び出し、レスポンスをスキーマで検証する
+// bench: シナリオの重みに応じて複数のターゲットへ負荷をかけ、ターゲットごとの統計を表示する
+// flow: YAMLに書いた手順(リクエスト、値の取り出し、待機、条件分岐、繰り返し、検証)を順に、または needs の依存関係に従って並行して実行し、手順ごとに結果を表示する
+// config check: 設定ファイル、flow の手順、bench のシナリオの知らないキーと型の誤りを行番号とともに報告する。--migrate でキャメルケースのキーと名前を変えたキーを直す
+// config encrypt: 標準入力の値を age の公開鍵かパスフレーズで暗号化し、設定ファイルに書ける {{decrypt "..."}} の形で出力する
+// plugins: PATH上のプラグイン(gofetch-*)を一覧表示する
+// aliases: 設定ファイルのエイリアスを一覧表示する
+// それ以外の名前は設定ファイルのエイリアスがあればそれを、なければ PATH上の gofetch-<name> があればそれを実行する
+// http/https以外のスキームのURLは PATH上の gofetch-proto-<scheme> にJSONで渡して処理する
+// パラメーターは以下の通り
+// -u, --url: アクセスするURLを指定する。必須。オプションの代わりに最後の引数として指定してもよい。繰り返すか引数を並べると複数のURLを並行して取得する
+// --url-file: 取得するURLを1行に1つ書いたファイルを指定する。- なら標準入力から読む。空行と#で始まる行は無視する
+// --base-url: /で始まるパスだけのURLをつなげるベースURLを指定する。省略した場合は環境変数 GOFETCH_BASE_URL
+// --profile: 設定ファイルのプロファイルを名前で指定し、ベースURL、ヘッダー、認証、タイムアウト、プロキシの既定値と制限を使う。省略した場合はURLのホストが一致するプロファイルを使う。複数のURLではURLごとの制限だけを使う
+// -o, --output: 出力先のファイル名を指定する。省略した場合は標準出力に出力される
+// 複数のURLでは必須で、ディレクトリか、{n} (1からの番号)、{host}、{name} (URLの最後のパス要素) を使った名前のパターンを指定する
+// --encrypt-output: 出力ファイルをageで暗号化する受信者を指定する。age1...の公開鍵、SSHの公開鍵、または受信者を書いたファイル。複数指定できる
+// --extract: 取得した.tar.gz/.tar/.zipを指定したディレクトリに展開する。展開先の外に出るエントリはエラーにする
+// --strip-components: --extract で展開するときにパスの先頭から取り除く要素の数を指定する。省略した場合は0
+// --split-multipart: multipart のレスポンスをパートごとにディレクトリのファイルに書く。- ならパートのヘッダーを付けて区切って標準出力に書く
+// --table: JSONの配列から指定したフィールドを取り出して表にして出力する。ネストは.meta.regionのように指定する
+// --csv: --table と同じようにフィールドを指定し、CSVで出力する
+// --json: Accept と本文の Content-Type を application/json にし、標準出力に書くJSONのレスポンスをインデントして表示する
+// --jq: data.items.0.name のようなパスでJSONのレスポンスから値を1つ取り出して出力する。文字列は引用符なしで出力する
+// --color: --json と --jq の出力に色を付けるかを auto、always、never で指定する。省略した場合は auto (端末で NO_COLOR がなければ付ける)
+// --decode: MessagePack と CBOR の本文をJSONにして表示する。auto(既定)は Content-Type で判定し、-o のファイルはそのまま書く。msgpack、cbor は形式を指定し、-o にもJSONを書く。none は変換しない
+// --summarize: Avro、Parquet、CSV のレスポンスをスキーマ、行数、先頭の行の見本にまとめて表示する。-o のファイルには受け取ったまま書く
+// --sample-rows: --summarize で表示する見本の行数を指定する。省略した場合は10
+// --preview: 画像のレスポンスの形式、大きさ、EXIF に続けて、縮小した画像を端末に表示する。画像は標準出力が端末なら --preview がなくても情報だけを表示する
+// --preview-mode: --preview の表示の方法を auto、sixel、iterm2、ascii で指定する。省略した場合は auto (端末から判断する)
+// --proto: protobuf のレスポンスをJSONにして表示するための .proto ファイルか、記述子のセットのファイルを指定する
+// --proto-message: --proto のメッセージの名前を指定する。ファイルにメッセージが1つだけなら省略できる
+// --proto-path: --proto の .proto ファイルの import を探すディレクトリを指定する。複数指定できる
+// --ndjson-in: NDJSON のレスポンスを受け取った行から1件ずつ処理して書く。--jq は1件ずつ適用し、最後に件数を表示する。-t がなければ全体のタイムアウトはかけない
+// -X, --method: リクエストのメソッドを指定する。GET、POST、PUT、PATCH、DELETE、HEAD、OPTIONS。省略した場合はGET、本文を指定した場合はPOST
+// -d, --data: リクエストの本文を文字列で指定する。Content-Typeは application/x-www-form-urlencoded になる
+// --data-file: リクエストの本文をファイルから読む。-なら標準入力から読む
+// -F, --form: フォームの項目を name=value の形で指定する。複数指定できる。name=@path ならファイルを添付して multipart/form-data で送り、name=<path ならファイルの内容を値にする
+// --compress-body: リクエストの本文を gzip か zstd で圧縮しながら送り、Content-Encoding を付ける
+// -H, --header: リクエストヘッダーを "Key: Value" の形で指定する。複数指定でき、エイリアスの同じ名前のヘッダーより優先する。値にはシークレットを埋め込める
+// --user-agent: User-Agentを指定する。省略した場合はGoの既定値
+// --compressed: Accept-Encoding で gzip、br、zstd を提示し、圧縮された本文を展開する
+// --raw: 圧縮された本文を展開せず、受け取ったまま書く
+// --auth: Basic認証のユーザー名とパスワードを user:password の形で指定する
+// --bearer: Authorization: Bearer で送るトークンを指定する。--auth とは同時に使えない
+// --api-key: APIキーを NAME=VALUE の形で指定する。値にはシークレットを埋め込める
+// --api-key-in: APIキーを付ける場所を header(既定) か query で指定する
+// --cookie: 送るクッキーを "name=value" の形で指定する。; で区切るか、繰り返して複数指定できる
+// --cookies: cookies.txt (Netscapeの形式) のファイルからクッキーを読み込んで送る
+// --cookie-jar: 受け取ったクッキーと送ったクッキーを終わったときに cookies.txt の形式でファイルに書く
+// -h, --help: ヘルプを表示する。省略した場合は表示されない
+// -v, --version: バージョン情報を表示する。省略した場合は表示されない
+// -t, --timeout: タイムアウト時間を指定する。省略した場合は30秒
+// --speed-limit: 本文の受信速度の下限(バイト/秒)を指定する。KBなどの単位を付けられる。下回ったら打ち切ってリトライする
+// --speed-time: --speed-limit を下回った状態を何秒続いたら打ち切るかを指定する。省略した場合は30秒
+// --post301, --post302, --post303: そのステータスのリダイレクトでもメソッドを GET に変えずに送り直す
+// --redirect-headers: 別のオリジンへのリダイレクトで転送するリクエストヘッダーを指定する。safe(既定、Authorization、Proxy-Authorization、Cookie以外)、all、none、またはカンマ区切りのヘッダー名
+// --max-redirects: たどるリダイレクトの上限を指定する。省略した場合は10回。0ならリダイレクトをエラーにする
+// --no-follow: リダイレクトをたどらず、リダイレクトのレスポンスをそのまま返す
+// -f, --for: 回数を指定する。省略した場合は1回。2回以上なら同じリクエストを繰り返し、レイテンシ(min/avg/p50/p95/p99/max)、スループット、ステータスごとの数、エラーの数を表示する
+// --concurrency: 複数のURLや --for の繰り返しで同時に送るリクエストの数を指定する。省略した場合は複数のURLなら4、--for なら1
+// --rate: 複数のURLや --for の繰り返しで送る速さの上限を 10/s, 30/m, 100/h の形で指定する。ワーカー全体で共有する
+// --delay: 複数のURLや --for の繰り返しで、ワーカーごとに次のリクエストまで待つ時間。200ms-1s や exp:500ms も使える
+// --watch: Ctrl-C で止めるまで --interval ごとに取得し直し、本文が変わったら差分を表示する。--mask で伏せた値と --jq で選ばなかった部分は比べない
+// --interval: --watch で取得し直す間隔。省略した場合は30s
+// --on-change: --watch で本文が変わるたびに sh -c で実行するコマンド。本文を標準入力に渡し、GOFETCH_URL、GOFETCH_STATUS、GOFETCH_HASH、GOFETCH_PREVIOUS_HASH を設定する
+// --slo: --watch で評価するSLOの目標をカンマ区切りで指定する (例: "p99<500ms over 1h, availability>99.9%")。エラーバジェットを使う速さが速すぎたら警告する
+// --slo-webhook: --slo の警告と回復をJSONで POST するURL
+// --live: --watch の間、取得のレート、エラー率、直近のp50/p99、スパークラインのダッシュボードを標準エラー出力に表示する。変化の表示はその上に書く
+// --show-headers: パターンに一致するレスポンスヘッダーを標準エラー出力に表示する。名前の最初の語ごとにまとめて並べ、同じ値の繰り返しはたたむ
+// --max-header-bytes: 受け取るレスポンスヘッダーの上限を指定する。省略した場合は1MB
+// --export-header: レスポンスヘッダーを KEY=値 の形で標準出力に書く。KEY=ヘッダー名 で指定し、ヘッダー名だけなら変数名はX_REQUEST_IDのように作る。複数指定できる
+// --export-file: --export-header の値を標準出力ではなくdotenv形式のファイルに書く
+// --save-failures: 2xx以外のステータスやバジェットの超過で失敗したレスポンスのヘッダーと本文を、指定したディレクトリに保存する
+// --save-failures-max: --save-failures に残すファイルの数。超えたら古いものから消す。省略した場合は50、0なら無制限
+// --continue: 前回途中で止まったダウンロードの <出力ファイル>.partial か出力ファイルの続きから、Rangeで受け取る。失敗しても .partial を残す。-o が必要。--continue なしで .partial があればエラーにする
+// --no-progress: -o で保存するときの進み具合の表示をしない。標準エラー出力が端末でなければ表示しない。複数のURLでは転送ごとと全体の進み具合を表示し、端末でなければ全体の進み具合を5秒ごとに書く
+// --shadow-to: 同じメソッド、ヘッダー、本文のリクエストを、このベースURLに本来のURLのパスとクエリをつなげたURLにも並行して送る。レスポンスは捨てる
+// --shadow-compare: --shadow-to のレスポンスを本来のレスポンスとステータス、Content-Type、本文で比べて表示する
+// --keep-partial: 本文の受信中にタイムアウトや切断で失敗したとき、受信できた分を <出力ファイル>.partial に保存し、終了コード3で終わる
+// --confirm: 既存のファイルを上書きする前と、HEADで調べた大きさが --confirm-size を超えるダウンロードの前に確認を求める
+// --confirm-size: --confirm で確認を求めるダウンロードの大きさ。省略した場合は100MB
+// --yes: すべての確認に了承したものとして進む。設定ファイルの confirm.hosts に一致するホストへのGET以外のリクエストと、require_confirm のプロファイルは、--confirm がなくても確認を求める
+// -r, --retry: リトライ回数を指定する。省略した場合は3回
+// --retry-delay: 最初のリトライまでに待つ時間。以降は2倍ずつ延ばし、半分から全体の間でばらつかせる。省略した場合は1s
+// --retry-max-delay: リトライまでに待つ時間の上限。Retry-After に従って待つ時間にも使う。省略した場合は30s
+// --retry-on: このステータスのレスポンスもリトライする (例: 429,500-599)。Retry-After があればそれに従う。最後の試行ではそのレスポンスを出力する
+// --retry-log: 試行ごとの番号、ステータスかエラー、待った時間、経過時間をJSON Linesでファイルに追記する。-なら標準エラー出力に書く
+// --ech: Encrypted Client Helloを使用する。設定はDNSのHTTPSレコードから取得する
+// --ech-config: Base64形式のECHConfigListを指定する。指定した場合は--echも有効になる
+// --insecure: サーバー証明書を検証しない。省略した場合は検証する
+// --cacert: システムの証明書の代わりに信頼するCA証明書のPEMファイルを指定する
+// --capath: システムの証明書の代わりに信頼するCA証明書のPEMファイルを置いたディレクトリを指定する
+// --ca-merge: --cacert と --capath の証明書をシステムの証明書の代わりではなく、加えて信頼する
+// --print-trust: 検証した証明書の連鎖と、どのルート証明書で信頼したかを標準エラー出力に表示する
+// --cert: 相互TLS認証のクライアント証明書のPEMファイルを指定する
+// --key: --cert の秘密鍵のPEMファイルを指定する。省略した場合は --cert のファイルから読む。ssh-agent なら ssh-agent の鍵で、command:<コマンド> ならコマンドで署名する (PKCS#11 のトークンなど)
+// --dns-server: 名前解決とHTTPSレコードの問い合わせに使うDNSサーバーを指定する。省略した場合はシステムの設定
+// --dns-cache-off: TTLに従うプロセス内のDNSキャッシュを使わない
+// --dns-reresolve: 同じホストの名前解決をN回に1回はキャッシュを使わずにやり直す。DNSによる負荷分散の確認に使う
+// --svcb: DNSのHTTPS/SVCBレコードから接続先、ALPN、ECH設定を決める。h3 を提供していれば、HTTP/3 に対応したビルドでは HTTP/3 を優先する
+// --verbose: 詳細な情報を標準エラー出力に表示する。送ったリクエスト行とヘッダー、レスポンスのステータスとヘッダー、TLSの版と暗号スイート、時間も表示する
+// -i, --include: レスポンスのステータス行とヘッダーを本文の前に出力する
+// --no-alt-svc: Alt-Svcヘッダーを無視し、キャッシュも使わない。省略した場合はh3の代替サービスを使う(-tags http3でビルドした場合)
+// --wire-stats: ヘッダーやTLSを含めて通信路上で送受信したバイト数を標準エラー出力に表示する。--for と複数のURLではすべてのリクエストの合計を最後に表示する
+// --timing: 名前解決、TCP接続、TLSハンドシェイク、サーバーの待ち時間、本文の転送にかかった時間を標準エラー出力に表示する
+// --timing-format: --timing の出力の形式を text か json で指定する。指定すれば --timing を省略できる
+// --format: 結果を {{.Status}} {{.Latency}} {{.Size}} のような Go のテンプレートで整形して標準出力に書く
+// --report: リクエストごとの結果 (URL、ステータス、バイト数、時間、エラー) を json (1行に1件) か csv で標準出力に書く
+// --connection-close: Connection: close を送り、レスポンスの後に接続を閉じる
+// --local-port-range: 接続元のポートを LOW-HIGH の範囲から選ぶ。1つだけ指定してもよい
+// --local-port-order: --local-port-range のポートを sequential (順番) か random (無作為) に選ぶ
+// --linger: レスポンスの後に接続をN秒アイドルのまま保ち、その間にサーバーが閉じたかを標準エラー出力に表示する
+// --half-close: リクエストを送り終えたら書き込み側だけを閉じる。HTTP/1.1だけを使う
+// --cors-check: 指定したOriginからのCORSのプリフライトと実際のリクエストを送り、足りないヘッダーや合わない値を表示する
+// --cors-method: --cors-check で使うメソッド。既定は GET
+// --cors-headers: --cors-check で送るリクエストヘッダーをカンマ区切りで指定する
+// --cors-credentials: --cors-check を Cookie などの認証情報付きのリクエストとして確認する
+// --cache-check: URLを2回取得し(2回目は条件付き)、キャッシュできるか、鮮度の期間、CDNがヒットしているかを表示する
+// --compare-encodings: identity、gzip、br、zstdで取得し、転送サイズと時間、identityに対する削減率を並べて表示する
+// --compare-protocols: HTTP/1.1、HTTP/2、HTTP/3(-tags http3でビルドした場合)で取得し、時間の内訳と成否を並べて表示する
+// --http1.1, --http2: そのバージョンだけで取得する。--http2 は http:// なら平文の HTTP/2 (h2c) で送る
+// --http3: HTTP/3 (QUIC) だけで取得する。実験的な対応で、-tags http3 でビルドした場合だけ使える
+// --negotiate-matrix: Accept、Accept-Language、Accept-Encodingの値を1つずつ変えて取得し、違いとVaryの不足を表示する
+// --negotiate: --negotiate-matrix で試すヘッダーの値を Header=値1|値2 の形で指定する。複数指定できる
+// --edge-case: 巨大なヘッダーやContent-Lengthの重複など異常なリクエストを1件ずつ送り、応答を表にする。allまたはカンマ区切りの名前。自分のサーバーだけに使うこと
+// --framing: chunkedのチャンクの大きさと時刻、Content-Lengthと実際のバイト数、途中での切断を標準エラー出力に報告する。HTTP/1.1だけを使う
+// --storage: キャッシュなどの保存先を指定する。file(既定、ユーザーのキャッシュディレクトリ)またはmemory(保存しない)。memory は --cache-dir と一緒に使えない
+// --cache-dir: GETのレスポンスをETagとLast-Modifiedと一緒にディレクトリに保存し、鮮度の期間内なら保存したものを返し、過ぎていれば条件付きのリクエストで確かめる。ヒット、304での再検証、全体の取得のどれだったかを表示する
+// --ssh-tunnel: user@host[:port] の踏み台サーバーをSSHで経由して接続する。認証はssh-agentと秘密鍵ファイル
+// --ssh-key: --ssh-tunnel で使う秘密鍵ファイルを指定する。省略した場合は ~/.ssh/id_ed25519 などを探す
+// --budget: サイズ、TTFB、合計時間のしきい値を指定する。超えた場合は終了コード1で終了する
+// --budget-file: URLのパターンごとにしきい値を書いたYAMLファイルを指定する。--budgetの指定が優先される
+// --expect-status: 期待するステータスを 200、200,204、2xx の形で指定する。外れたら終了コード1で終了する
+// --expect-body-contains: 本文に含まれるはずの文字列を指定する。複数指定できる
+// --expect-header: Name なら存在を、Name: value なら値に value を含むかを確かめるヘッダーを指定する。複数指定できる
+// --expect-max-time: 合計時間の上限を 2s のように指定する
+// --golden: 本文を比べるゴールデンファイルを指定する。違う場合は差分を表示して終了コード1で終了する
+// --golden-mode: 比べ方を auto(既定)、exact、json で指定する。json はキーの順序や空白の違いを無視する
+// --golden-mask: 比べる前に両方で伏せる部分を正規表現で指定する。複数指定できる
+// --update-golden: 比べる代わりにゴールデンファイルを本文で書き換える
+// --mask: --golden と --shadow-compare で比べる前に伏せる値を .json.path、re:正規表現、uuid、timestamp、unix-time で指定する。複数指定できる
+// --mask-file: --mask の規則を1行に1つ書いたファイルを指定する
+// --egress: name=proxy-url の形でプロキシを指定する。複数指定でき、それぞれ経由した結果を比較して表示する
+// --egress-file: 名前付きのプロキシの一覧を書いたYAMLファイルを指定する
+// --proxy: 使うプロキシのURLを指定する。省略した場合は HTTP_PROXY と HTTPS_PROXY の環境変数に従う。複数指定すると順に経由する
+// --socks5: 使うSOCKS5プロキシを host:port の形で指定する。名前解決はプロキシで行う
+// --noproxy: プロキシを通さないホストをカンマ区切りで指定する。NO_PROXY の代わりに使い、* ならすべて
+// --no-system-proxy: Windowsでインターネットオプションや netsh winhttp で設定したプロキシを使わない
+// --fail: 4xxか5xxのレスポンスを失敗にし、本文を出力せずに終了コード4で終了する
+//
+// 終了コード: 0 成功、1 使い方や設定の誤りと確認の失敗、2 ネットワークのエラー、
+// 3 本文を途中までしか受信できなかった (--keep-partial)、4 HTTPのエラー (--fail)、5 タイムアウト
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"gofetch/pkg/gofetch"
+	"gofetch/pkg/gofetch/store"
+)
+
+const (
+	//バージョン情報
+	Version = "1.0.0"
+)
+const (
+	// ヘルプメッセージ
+	HelpMessage = `
+Usage: gofetch [options]
+       gofetch [options] <url|/path>
+       gofetch baseline <record|verify> [options]
+       gofetch oci <manifest|tags|blob> <reference> [options]
+       gofetch discover <openid|jwks|security|robots|sitemap> <host|url>
+       gofetch api --spec <file|url> <operationId> [--param name=value ...]
+       gofetch bench [options] (--scenario <file> | -u <url>)
+       gofetch flow [--var name=value ...] <file>
+       gofetch config check [--migrate] [file]
+       gofetch config encrypt (--to <recipient> ... | --passphrase)
+       gofetch plugins
+       gofetch aliases
+       gofetch <alias> [name=value...] [options]   (aliases from the config file)
+       gofetch <plugin> [args...]   (runs gofetch-<plugin> from PATH)
+Options:
+  -u, --url     URL to fetch (required; repeat -u or list several URLs to fetch them
+                concurrently)
+  --url-file    File with one URL per line to fetch (- for stdin)
+  --base-url    Base URL for path-only invocations like "gofetch /users/42"
+                (default: $GOFETCH_BASE_URL)
+  --profile     Config file profile providing defaults (base_url, headers, basic, bearer,
+                timeout, proxy) and restrictions; command line options take precedence
+                (default: the profile whose hosts match the URL; with several URLs only
+                its restrictions apply, per URL)
+  -o, --output  Output file (default: stdout). Required with several URLs: a directory,
+                or a name pattern with {n} (1-based index), {host} and {name} (last
+                path element of the URL), e.g. -o 'out/{n}-{host}-{name}'
+  --encrypt-output Encrypt the output file with age for a recipient
+                (age1... key, SSH public key or recipients file; repeatable, requires -o)
+  --extract     Unpack a fetched .tar.gz/.tar/.zip into a directory
+  --strip-components Remove N leading path elements when extracting (default: 0)
+  --split-multipart Write each part of a multipart/mixed or multipart/byteranges response
+                to a file in a directory, or to stdout with part headers when -
+  --table       Render a JSON array as a table of fields (e.g. 'name,status,.meta.region')
+  --csv         Like --table but output CSV
+  --json        Send Accept/Content-Type: application/json and pretty-print JSON responses
+  --jq          Print a single value from a JSON response by path (e.g. data.items.0.name;
+                strings are printed unquoted, # is the number of elements)
+  --color       Colorize --json/--jq output: auto (default), always or never
+  --decode      Convert MessagePack or CBOR bodies to JSON for display, --jq and --table:
+                auto (default; by Content-Type, files from -o are kept as received),
+                msgpack, cbor (regardless of Content-Type, also for -o) or none
+  --summarize   Print the schema, row count and sample rows of an Avro, Parquet or CSV
+                response instead of the raw bytes (-o still saves the file as received)
+  --sample-rows Number of sample rows for --summarize (default 10)
+  --preview     Render a low-resolution preview of an image response below its format,
+                size and EXIF summary (printed instead of the bytes when stdout is a
+                terminal; -o still saves the file as received)
+  --preview-mode How to render --preview: auto (default; by terminal), sixel, iterm2 or ascii
+  --proto       Decode a binary protobuf response to JSON using a .proto file or a
+                descriptor set (protoc --descriptor_set_out); works with --jq and --table
+  --proto-message Message type of the response (e.g. example.v1.User; the package may be
+                omitted when unambiguous; optional when the file defines one message)
+  --proto-path  Directory to search for imports of the --proto file (repeatable)
+  --ndjson-in   Process an NDJSON response line by line as it arrives: pretty-print each
+                record (or apply --jq to each) and count them; no overall timeout unless -t
+  -X, --method  Request method: GET, POST, PUT, PATCH, DELETE, HEAD or OPTIONS
+                (default: GET, or POST when a body is given)
+  -H, --header  Request header as "Key: Value" (repeatable; values may use
+                {{env "..."}}, {{file "..."}} and {{secret "..."}})
+  --user-agent  User-Agent header to send (default: Go's default)
+  --compressed  Request gzip, br or zstd compression and decompress the response
+  --raw         Do not decompress the response; write the bytes as received
+  --auth        HTTP Basic credentials as user:password
+  --bearer      Send Authorization: Bearer with this token
+  --api-key     API key as NAME=VALUE (values may use {{env "..."}} and friends)
+  --api-key-in  Where to send the API key: header (default) or query
+  --cookie      Cookie to send as "name=value" (repeatable; "a=1; b=2" also works)
+  --cookies     Load cookies to send from a cookies.txt (Netscape format) file
+  --cookie-jar  Write the cookies to a cookies.txt file after the run (may be the
+                same file as --cookies to keep a session across runs)
+  -d, --data    Request body
+  --data-file   Read the request body from a file (- for stdin)
+  -F, --form    Form field as name=value, sent as application/x-www-form-urlencoded
+                (repeatable); name=@file[;type=mime][;filename=name] uploads a file
+                as multipart/form-data without reading it into memory, and
+                name=<file sends the file's contents as the value
+  --compress-body Compress the request body on the fly with gzip or zstd and send
+                Content-Encoding (chunked, without Content-Length)
+  -t, --timeout Timeout in seconds (default: 30)
+  --show-headers Print response headers matching comma-separated patterns to stderr,
+                grouped and sorted, with repeated values folded (e.g. 'x-*,cache-*' or '*')
+  --max-header-bytes Maximum size of response headers (e.g. 64KB, default: 1MB)
+  --export-header Print a response header as a shell-quoted KEY=value line
+                (KEY=Header or just Header, repeatable; use -o to keep the body separate)
+  --export-file Write --export-header values to a dotenv file instead of stdout
+  --save-failures Save the headers and body of non-2xx or over-budget responses
+                to this directory for later diagnosis
+  --save-failures-max Saved failures to keep, oldest removed first (default: 50, 0 = no limit)
+  --continue    Resume an interrupted download from <output>.partial (or the existing
+                output file) with a Range request; the partial file is kept on failure.
+                Without --continue, an existing <output>.partial is an error
+  --no-progress Do not show the progress bar (bytes, percent, speed, ETA) while
+                saving with -o; it is only shown when stderr is a terminal. With several
+                URLs, one bar per active transfer plus a total line is shown, or a total
+                line every 5 seconds when stderr is not a terminal
+  --shadow-to   Also send a copy of each request (method, headers including
+                credentials, body) to this base URL plus the request's path and query;
+                the shadow response is discarded and never affects the exit status
+  --shadow-compare Compare the --shadow-to response with the primary one (status,
+                Content-Type, body; JSON compared as values) and report mismatches
+  --keep-partial Keep the bytes received before a timeout or dropped connection
+                in <output>.partial (or stdout) and exit with status 3
+  --confirm     Ask before overwriting an existing output file and before downloads
+                larger than --confirm-size (checked with HEAD)
+  --confirm-size Download size that needs confirmation (default: 100MB)
+  --yes         Answer yes to all prompts, including non-GET requests to hosts listed
+                under confirm.hosts and profiles with require_confirm in the config file
+                (methods not allowed by a profile are always refused)
+  -r, --retry   Retry count (default: 3)
+  --retry-delay Wait before the first retry, doubled for each further retry with
+                random jitter (default: 1s)
+  --retry-max-delay Upper limit for the wait between retries, including waits taken
+                from Retry-After (default: 30s)
+  --retry-on    Also retry responses with these statuses (e.g. 429,500-599),
+                honoring Retry-After; the last response is kept as the result
+  --retry-log   Append a JSON line per attempt (status or error, backoff, elapsed)
+                to a file (- for stderr)
+  --speed-limit Abort and retry when the body arrives slower than this per second (e.g. 10KB)
+  --speed-time  Seconds below --speed-limit before aborting (default: 30)
+  --post301, --post302, --post303
+                Keep the request method (e.g. POST) on 301/302/303 redirects
+  --redirect-headers Request headers forwarded on cross-origin redirects: safe (default,
+                all but Authorization/Proxy-Authorization/Cookie), all, none, or a comma separated list
+  --max-redirects Maximum number of redirects to follow (default: 10, 0 makes any redirect an error)
+  --no-follow   Do not follow redirects; return the 3xx response itself
+  -f, --for     Number of times to fetch (default: 1). With 2 or more, discard the bodies and
+                print latency (min/avg/p50/p95/p99/max), throughput, status codes and errors
+  --concurrency Requests sent at the same time with several URLs (default: 4) or --for (default: 1)
+  --rate        Limit several URLs or --for to N requests per second, minute or hour
+                across all workers (e.g. 10/s, 30/m)
+  --delay       Wait between requests of each worker with several URLs or --for
+                (e.g. 500ms, 200ms-1s uniform or exp:500ms)
+  --watch       Re-fetch until interrupted and print a diff whenever the body changes
+                (values hidden by --mask are ignored; with --jq only that value is compared)
+  --interval    How often --watch re-fetches (default: 30s)
+  --on-change   Shell command run by --watch on each change, with the body on stdin and
+                GOFETCH_URL, GOFETCH_STATUS, GOFETCH_HASH and GOFETCH_PREVIOUS_HASH set
+  --slo         SLO objectives evaluated by --watch, e.g. "p99<500ms over 1h, availability>99.9%";
+                warns when the error budget burns too fast (default window: 1h)
+  --slo-webhook URL that --slo alerts and recoveries are POSTed to as JSON
+  --live        Show a dashboard on stderr during --watch (polls per second, error rate,
+                p50/p99 over the last 10 intervals or 10s, whichever is longer, and a
+                sparkline), updated every second; changes are printed above it
+  --ech         Use Encrypted Client Hello (config from DNS HTTPS record)
+  --ech-config  Base64 ECHConfigList to use instead of DNS (implies --ech)
+  --insecure    Do not verify the server certificate
+  --cacert      Trust only the CA certificates in this PEM file (instead of the system roots,
+                which on Windows is the certificate store)
+  --capath      Trust only the CA certificates in the PEM files in this directory
+  --ca-merge    Add the --cacert and --capath certificates to the system roots
+                instead of replacing them
+  --print-trust Print the verified certificate chain and the trust anchor it ends in
+  --cert        Client certificate PEM file for mutual TLS
+  --key         Private key PEM file for --cert (default: read from the --cert file);
+                ssh-agent signs with the matching Ed25519 key in ssh-agent, and
+                command:<cmd> runs cmd to sign each handshake (e.g. with a PKCS#11
+                token): it reads the data on stdin, gets GOFETCH_SIGN_ALGORITHM and
+                GOFETCH_SIGN_HASH in the environment and writes the signature to stdout
+  --dns-server  DNS server for lookups (default: system)
+  --dns-cache-off Disable the in-process DNS cache
+  --dns-reresolve Force re-resolution every N lookups of a host (default: 0, never)
+  --svcb        Choose endpoint, ALPN and ECH from DNS HTTPS/SVCB records; an h3 ALPN
+                is tried first with HTTP/3 in builds with -tags http3 (falls back to TCP)
+  --verbose     Print diagnostic information to stderr, including the request line
+                and headers sent, the response status and headers, TLS version and
+                cipher and timing (credentials are masked)
+  -i, --include Write the response status line and headers before the body
+  --no-alt-svc  Do not use or store Alt-Svc (HTTP/3 upgrade) information
+  --wire-stats  Print bytes sent/received on the wire (headers, TLS, compressed body);
+                with --for or several URLs, the total is printed after the summary
+  --timing      Print a breakdown of DNS lookup, TCP connect, TLS handshake, server
+                wait, time to first byte and transfer time to stderr
+  --timing-format
+                Format for --timing: text (default) or json (implies --timing)
+  --format      Print the result with a Go template after the body, e.g.
+                '{{.Status}} {{.Latency}} {{.Size}}' (also .URL, .Proto, .TTFB,
+                .Attempts, .Error, .Output and {{.Header "Name"}})
+  --report      Print one record per request as json (one object per line) or csv
+                (url, status, bytes, duration, error); with multiple URLs this
+                replaces the OK/FAIL list and the summary goes to stderr
+  --connection-close Send Connection: close
+  --local-port-range Bind outgoing connections to a source port in LOW-HIGH (or a
+                single port); busy ports are skipped
+  --local-port-order Pick --local-port-range ports sequential (default) or random
+  --linger      Keep the connection idle N seconds after the response and report if the server closes it
+  --half-close  Close the write side after sending the request (forces HTTP/1.1)
+  --cors-check  Send a CORS preflight and the actual request from the given Origin and
+                report missing or mismatched CORS response headers
+  --cors-method Method for --cors-check (default: GET)
+  --cors-headers Comma-separated request headers for --cors-check
+  --cors-credentials Check --cors-check as a credentialed request
+  --cache-check Fetch twice (the second time conditionally) and report cacheability,
+                freshness lifetime, revalidation and whether the CDN serves hits
+  --compare-encodings Compare transfer sizes and times for identity, gzip, br and zstd
+  --compare-protocols Fetch over HTTP/1.1, HTTP/2 and HTTP/3 and report latency breakdowns
+  --http1.1     Only use HTTP/1.1
+  --http2       Only use HTTP/2 (h2 over TLS, or h2c with prior knowledge for http://)
+  --http3       Only use HTTP/3 over QUIC (experimental; requires a -tags http3 build)
+  --negotiate-matrix Re-request with varying Accept, Accept-Language and Accept-Encoding
+                and report differences and missing Vary entries
+  --negotiate   Values to try for a header (e.g. 'Accept-Language=en|ja', repeatable)
+  --edge-case   Send malformed/edge-case requests and report how the server responds
+                (all, or comma separated: oversized-header, many-headers, obs-fold,
+                bare-lf, space-in-name, duplicate-content-length, cl-te, te-obfuscated,
+                te-te, invalid-chunk-size, absolute-uri, missing-host)
+                Only use against servers you operate
+  --framing     Report transfer framing (chunks, Content-Length match, premature close); forces HTTP/1.1
+  --storage     Where caches are kept: file or memory (default: file); memory cannot
+                be combined with --cache-dir
+  --cache-dir   Cache GET responses in a directory and revalidate them with
+                If-None-Match/If-Modified-Since; reports hit, 304 revalidation or full fetch
+  --ssh-tunnel  Connect through an SSH bastion (user@host[:port])
+  --ssh-key     Private key file for --ssh-tunnel (default: ssh-agent, ~/.ssh/id_*)
+  --budget      Fail when limits are exceeded (e.g. size=500KB,ttfb=200ms,time=1s)
+  --budget-file YAML file with budgets keyed by URL pattern
+  --expect-status Fail (exit 1) unless the status matches (e.g. 200, 200,204 or 2xx)
+  --expect-body-contains
+                Fail unless the body contains this string (repeatable)
+  --expect-header Fail unless the header is present ("Name") or its value contains the
+                given text ("Name: value") (repeatable)
+  --expect-max-time
+                Fail when the request takes longer than this (e.g. 2s)
+  --golden      Compare the body with this file and fail with a diff when it differs
+  --golden-mode How to compare: auto (json for .json files or JSON responses),
+                exact or json (ignores key order and whitespace) (default: auto)
+  --golden-mask Regular expression for volatile parts masked on both sides before
+                comparing (repeatable; applied to the indented JSON in json mode)
+  --update-golden Write the body to the --golden file instead of comparing
+  --mask        Volatile value to ignore in --golden and --shadow-compare (repeatable):
+                a JSON path (.meta.request_id, .items.*.id), re:<regexp>, or one of
+                uuid, timestamp, unix-time
+  --mask-file   File with one --mask rule per line (# for comments)
+  --egress      Compare results through named proxies (name=proxy-url, repeatable)
+  --egress-file YAML file with named egress proxies
+  --proxy       Proxy URL for all requests (http://, https://, socks5:// or socks5h://;
+                default: HTTP_PROXY/HTTPS_PROXY from the environment). Repeat to chain
+                proxies in order through nested CONNECT/SOCKS tunnels
+  --socks5      SOCKS5 proxy as host:port (host names are resolved by the proxy)
+  --noproxy     Comma separated hosts that bypass the proxy, replacing NO_PROXY
+                (* for all; localhost and loopback addresses are never proxied)
+  --no-system-proxy
+                On Windows, ignore the system (Internet Options or WinHTTP) proxy
+                that is used when neither the flags nor the environment set one
+  --fail        Treat 4xx and 5xx responses as failures: do not write the body and
+                exit with status 4
+  -h, --help    Show this help message
+  -v, --version Show version information
+
+Exit status:
+  0  Success (including 4xx and 5xx responses unless --fail is given)
+  1  Usage or configuration error, or a failed check such as --budget or --golden
+  2  Network error (DNS, connect, TLS, connection reset)
+  3  Body only partially received (--keep-partial)
+  4  HTTP error (4xx or 5xx with --fail)
+  5  Timeout
+`
+)
+
+// isValidURL checks if the given URL is valid
+func isValidURL(inputURL string) bool {
+	_, err := url.ParseRequestURI(inputURL)
+	return err == nil
+}
+
+// verbose が true のとき、詳細な情報を標準エラー出力に表示する
+var verbose bool
+
+// verbosef は詳細モードのときだけメッセージを表示する
+func verbosef(format string, args ...any) {
+	if verbose {
+		fmt.Fprintln(os.Stderr, "* "+redactSecrets(fmt.Sprintf(format, args...)))
+	}
+}
+
+// stringList は繰り返し指定できるフラグの値
+type stringList []string
+
+// String はフラグの値をカンマ区切りで返す
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set は指定された値を追加する
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// dialFunc はTCP接続を確立する関数
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// main関数
+// 終了コードは run が返す。run の defer で開いたものを閉じてから終える
+func main() {
+	os.Exit(run())
+}
+
+// run はコマンドを実行して終了コードを返す
+func run() int {
+	reqOpts := requestOptions{Method: http.MethodGet, Header: http.Header{}}
+
+	// サブコマンドの処理
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "baseline":
+			return runBaseline(os.Args[2:])
+		case "oci":
+			return runOCI(os.Args[2:])
+		case "discover":
+			return runDiscover(os.Args[2:])
+		case "api":
+			return runAPI(os.Args[2:])
+		case "bench":
+			return runBench(os.Args[2:])
+		case "flow":
+			return runFlow(os.Args[2:])
+		case "config":
+			return runConfig(os.Args[2:])
+		case "plugins":
+			return runPluginList()
+		case "aliases":
+			return runAliasList()
+		default:
+			if !strings.HasPrefix(os.Args[1], "-") {
+				// 設定ファイルのエイリアスはプラグインより優先する
+				conf, err := loadConfig()
+				if err != nil {
+					fmt.Println("Error:", err)
+					return 1
+				}
+				if alias, ok := conf.Aliases[os.Args[1]]; ok {
+					args, opts, err := alias.expand(os.Args[1], os.Args[2:])
+					if err != nil {
+						fmt.Println("Error:", err)
+						return 1
+					}
+					os.Args = append(os.Args[:1], args...)
+					reqOpts = opts
+				} else if code, ok := runPlugin(os.Args[1], os.Args[2:]); ok {
+					return code
+				}
+			}
+		}
+	}
+
+	// コマンドライン引数のパース
+	// flagパッケージを使用して、コマンドライン引数をパースする
+	var urlFlags stringList
+	flag.Var(&urlFlags, "u", "URL to fetch (repeatable)")
+	urlFile := flag.String("url-file", "", "File with one URL per line to fetch (- for stdin)")
+	concurrency := flag.Int("concurrency", defaultMultiConcurrency, "Number of URLs fetched at the same time with several URLs")
+	rate := flag.String("rate", "", "Limit several URLs or --for to N requests per second, minute or hour")
+	delay := flag.String("delay", "", "Wait between requests of each worker with several URLs or --for")
+	watch := flag.Bool("watch", false, "Re-fetch until interrupted and report changes to the body")
+	watchInterval := flag.Duration("interval", 30*time.Second, "How often --watch re-fetches")
+	onChange := flag.String("on-change", "", "Shell command run by --watch when the body changes")
+	sloSpec := flag.String("slo", "", "SLO objectives evaluated by --watch")
+	live := flag.Bool("live", false, "Show a dashboard on stderr during --watch")
+	sloWebhook := flag.String("slo-webhook", "", "URL that --slo alerts are POSTed to")
+	baseURL := flag.String("base-url", os.Getenv(baseURLEnv), "Base URL for path-only invocations")
+	profileFlag := flag.String("profile", "", "Use the defaults and restrictions of this config file profile")
+	output := flag.String("o", "", "Output file (default: stdout)")
+	var encryptTo stringList
+	flag.Var(&encryptTo, "encrypt-output", "Encrypt the output file with age for a recipient (repeatable)")
+	extractDir := flag.String("extract", "", "Unpack a fetched .tar.gz/.tar/.zip into a directory")
+	stripComponents := flag.Int("strip-components", 0, "Remove N leading path elements when extracting")
+	splitDir := flag.String("split-multipart", "", "Write each part of a multipart response to a directory (- for stdout)")
+	tableSpec := flag.String("table", "", "Render a JSON array as a table of fields")
+	csvSpec := flag.String("csv", "", "Render a JSON array as CSV of fields")
+	jsonMode := flag.Bool("json", false, "Send and pretty-print JSON")
+	jqPath := flag.String("jq", "", "Print a single value from a JSON response by path")
+	decodeSpec := flag.String("decode", "auto", "Convert MessagePack or CBOR bodies to JSON: auto, msgpack, cbor or none")
+	summarize := flag.Bool("summarize", false, "Print the schema, row count and sample rows of an Avro, Parquet or CSV response")
+	sampleRows := flag.Int("sample-rows", 10, "Number of sample rows for --summarize")
+	preview := flag.Bool("preview", false, "Render a low-resolution preview of an image response")
+	previewSpec := flag.String("preview-mode", "auto", "How to render --preview: auto, sixel, iterm2 or ascii")
+	protoFile := flag.String("proto", "", "Decode a protobuf response using a .proto file or descriptor set")
+	protoMessage := flag.String("proto-message", "", "Message type of the protobuf response")
+	var protoPaths stringList
+	flag.Var(&protoPaths, "proto-path", "Directory to search for .proto imports (repeatable)")
+	ndjsonIn := flag.Bool("ndjson-in", false, "Process an NDJSON response line by line as it arrives")
+	colorMode := flag.String("color", "auto", "Colorize JSON output: auto, always or never")
+	method := flag.String("X", "", "Request method")
+	flag.StringVar(method, "method", "", "Request method")
+	var headerSpecs stringList
+	flag.Var(&headerSpecs, "H", "Request header as \"Key: Value\" (repeatable)")
+	flag.Var(&headerSpecs, "header", "Request header as \"Key: Value\" (repeatable)")
+	userAgent := flag.String("user-agent", "", "User-Agent header to send")
+	compressed := flag.Bool("compressed", false, "Request gzip, br or zstd compression and decompress the response")
+	raw := flag.Bool("raw", false, "Do not decompress the response")
+	basicAuth := flag.String("auth", "", "HTTP Basic credentials as user:password")
+	bearer := flag.String("bearer", "", "Bearer token to send in Authorization")
+	apiKey := flag.String("api-key", "", "API key as NAME=VALUE")
+	apiKeyIn := flag.String("api-key-in", "header", "Where to send the API key: header or query")
+	var cookieSpecs stringList
+	flag.Var(&cookieSpecs, "cookie", "Cookie to send as name=value (repeatable)")
+	cookiesFile := flag.String("cookies", "", "Load cookies from a cookies.txt file")
+	cookieJarFile := flag.String("cookie-jar", "", "Write cookies to a cookies.txt file after the run")
+	data := flag.String("d", "", "Request body")
+	flag.StringVar(data, "data", "", "Request body")
+	dataFile := flag.String("data-file", "", "Read the request body from a file (- for stdin)")
+	var formSpecs stringList
+	flag.Var(&formSpecs, "F", "Form field as name=value, name=@file or name=<file (repeatable)")
+	flag.Var(&formSpecs, "form", "Form field as name=value, name=@file or name=<file (repeatable)")
+	compressBody := flag.String("compress-body", "", "Compress the request body with gzip or zstd")
+	timeout := flag.Int("t", 30, "Timeout in seconds")
+	forCount := flag.Int("f", 1, "Number of times to fetch")
+	flag.IntVar(forCount, "for", 1, "Number of times to fetch")
+	showHeaders := flag.String("show-headers", "", "Print response headers matching these patterns to stderr (e.g. 'x-*,cache-*' or '*')")
+	maxHeaderSpec := flag.String("max-header-bytes", "", "Maximum size of response headers (e.g. 64KB, default 1MB)")
+	var exportSpecs stringList
+	flag.Var(&exportSpecs, "export-header", "Print a response header as KEY=value (KEY=Header, repeatable)")
+	exportFile := flag.String("export-file", "", "Write --export-header values to this dotenv file instead of stdout")
+	failuresDir := flag.String("save-failures", "", "Save non-2xx or over-budget responses (headers and body) to this directory")
+	failuresMax := flag.Int("save-failures-max", 50, "Number of saved failures to keep in --save-failures (0 for no limit)")
+	continueFlag := flag.Bool("continue", false, "Resume an interrupted download to -o with a Range request")
+	noProgress := flag.Bool("no-progress", false, "Do not show the progress bar when saving with -o")
+	shadowTo := flag.String("shadow-to", "", "Also send a copy of each request to this base URL")
+	shadowCompare := flag.Bool("shadow-compare", false, "Compare the --shadow-to response with the primary response")
+	keepPartial := flag.Bool("keep-partial", false, "Keep the partially received body (as <output>.partial) when the transfer fails, exiting with 3")
+	confirmFlag := flag.Bool("confirm", false, "Ask before overwriting files and before large downloads")
+	confirmSizeSpec := flag.String("confirm-size", "", "Download size that needs confirmation with --confirm (default 100MB)")
+	yes := flag.Bool("yes", false, "Answer yes to all confirmation prompts")
+	retry := flag.Int("r", 3, "Retry count")
+	retryDelay := flag.Duration("retry-delay", gofetch.DefaultBackoff, "Wait before the first retry, doubled for each retry")
+	retryMaxDelay := flag.Duration("retry-max-delay", 30*time.Second, "Upper limit for the wait between retries")
+	retryOnSpec := flag.String("retry-on", "", "Also retry responses with these statuses (e.g. 429,500-599)")
+	retryLogPath := flag.String("retry-log", "", "Write a JSON line per attempt to this file (- for stderr)")
+	speedLimitSpec := flag.String("speed-limit", "", "Abort when the body arrives slower than this per second")
+	speedTime := flag.Int("speed-time", 30, "Seconds below --speed-limit before aborting")
+	post301 := flag.Bool("post301", false, "Keep the request method on 301 redirects")
+	post302 := flag.Bool("post302", false, "Keep the request method on 302 redirects")
+	post303 := flag.Bool("post303", false, "Keep the request method on 303 redirects")
+	redirectHeaders := flag.String("redirect-headers", "safe", "Headers forwarded on cross-origin redirects: safe, all, none or a list")
+	maxRedirectsFlag := flag.Int("max-redirects", maxRedirects, "Maximum number of redirects to follow")
+	noFollow := flag.Bool("no-follow", false, "Do not follow redirects")
+	help := flag.Bool("h", false, "Show help message")
+	version := flag.Bool("v", false, "Show version information")
+	ech := flag.Bool("ech", false, "Use Encrypted Client Hello")
+	echConfig := flag.String("ech-config", "", "Base64 ECHConfigList (implies --ech)")
+	insecure := flag.Bool("insecure", false, "Do not verify the server certificate")
+	caCert := flag.String("cacert", "", "Trust only the CA certificates in this PEM file")
+	caPath := flag.String("capath", "", "Trust only the CA certificates in the PEM files in this directory")
+	caMerge := flag.Bool("ca-merge", false, "Add --cacert and --capath certificates to the system roots")
+	printTrustFlag := flag.Bool("print-trust", false, "Print the verified certificate chain and its trust anchor")
+	clientCert := flag.String("cert", "", "Client certificate PEM file for mutual TLS")
+	clientKey := flag.String("key", "", "Private key PEM file for --cert, ssh-agent or command:<cmd>")
+	dnsServer := flag.String("dns-server", "", "DNS server for lookups")
+	dnsCacheOff := flag.Bool("dns-cache-off", false, "Disable the in-process DNS cache")
+	dnsReresolve := flag.Int("dns-reresolve", 0, "Force re-resolution every N lookups of a host")
+	svcb := flag.Bool("svcb", false, "Use DNS HTTPS/SVCB records to choose how to connect")
+	flag.BoolVar(&verbose, "verbose", false, "Print diagnostic information to stderr")
+	include := flag.Bool("i", false, "Write the response status line and headers before the body")
+	flag.BoolVar(include, "include", false, "Write the response status line and headers before the body")
+	noAltSvc := flag.Bool("no-alt-svc", false, "Do not use or store Alt-Svc information")
+	wireStats := flag.Bool("wire-stats", false, "Print bytes sent/received on the wire")
+	timingFlag := flag.Bool("timing", false, "Print a DNS, connect, TLS, TTFB and transfer time breakdown")
+	timingFormat := flag.String("timing-format", "", "Format for --timing: text or json")
+	resultFormat := flag.String("format", "", "Go template for the result line (e.g. '{{.Status}} {{.Latency}}')")
+	reportFormat := flag.String("report", "", "Print per-request records as json or csv")
+	connClose := flag.Bool("connection-close", false, "Send Connection: close")
+	localPorts := flag.String("local-port-range", "", "Source ports for outgoing connections as LOW-HIGH")
+	localPortOrder := flag.String("local-port-order", "sequential", "Pick source ports sequential or random")
+	linger := flag.Int("linger", 0, "Keep the connection idle N seconds after the response and report if the server closes it")
+	halfClose := flag.Bool("half-close", false, "Close the write side after sending the request (forces HTTP/1.1)")
+	corsOrigin := flag.String("cors-check", "", "Simulate a CORS preflight and request from this Origin")
+	corsMethod := flag.String("cors-method", http.MethodGet, "Method for --cors-check")
+	corsHeaders := flag.String("cors-headers", "", "Comma-separated request headers for --cors-check")
+	corsCredentials := flag.Bool("cors-credentials", false, "Check the response as a credentialed request for --cors-check")
+	cacheCheck := flag.Bool("cache-check", false, "Fetch twice and report caching headers, revalidation and CDN hits")
+	compareProtocols := flag.Bool("compare-protocols", false, "Fetch over HTTP/1.1, HTTP/2 and HTTP/3 and compare latency")
+	http11 := flag.Bool("http1.1", false, "Only use HTTP/1.1")
+	http2Only := flag.Bool("http2", false, "Only use HTTP/2")
+	http3Only := flag.Bool("http3", false, "Only use HTTP/3 (requires a -tags http3 build)")
+	compareEnc := flag.Bool("compare-encodings", false, "Compare transfer sizes and times for identity, gzip, br and zstd")
+	negotiateMatrix := flag.Bool("negotiate-matrix", false, "Re-request with varying Accept* headers and compare responses")
+	var negotiateSpecs stringList
+	flag.Var(&negotiateSpecs, "negotiate", "Values to try for a header (Header=v1|v2, repeatable, implies --negotiate-matrix)")
+	edgeCaseSpec := flag.String("edge-case", "", "Send malformed/edge-case requests (all or comma separated names)")
+	framing := flag.Bool("framing", false, "Report transfer framing details (forces HTTP/1.1)")
+	storage := flag.String("storage", "file", "Where caches are kept: file or memory")
+	cacheDir := flag.String("cache-dir", "", "Cache GET responses in a directory and revalidate them conditionally")
+	sshTunnel := flag.String("ssh-tunnel", "", "Connect through an SSH bastion (user@host[:port])")
+	sshKey := flag.String("ssh-key", "", "Private key file for --ssh-tunnel")
+	budgetSpec := flag.String("budget", "", "Fail when limits are exceeded (size=,ttfb=,time=)")
+	budgetPath := flag.String("budget-file", "", "YAML file with budgets keyed by URL pattern")
+	expectStatus := flag.String("expect-status", "", "Fail unless the status matches (200, 200,204 or 2xx)")
+	var expectContains, expectHeaders stringList
+	flag.Var(&expectContains, "expect-body-contains", "Fail unless the body contains this string (repeatable)")
+	flag.Var(&expectHeaders, "expect-header", "Fail unless the header is present or contains a value (repeatable)")
+	expectMaxTime := flag.String("expect-max-time", "", "Fail when the request takes longer than this")
+	goldenPath := flag.String("golden", "", "Compare the body with this golden file")
+	goldenMode := flag.String("golden-mode", "auto", "How to compare with --golden: auto, exact or json")
+	var goldenMasks stringList
+	flag.Var(&goldenMasks, "golden-mask", "Regular expression masked before comparing with --golden (repeatable)")
+	updateGolden := flag.Bool("update-golden", false, "Write the body to the --golden file instead of comparing")
+	var maskSpecs stringList
+	flag.Var(&maskSpecs, "mask", "Volatile value to ignore when comparing (repeatable)")
+	maskFile := flag.String("mask-file", "", "File with one --mask rule per line")
+	var egressSpecs stringList
+	flag.Var(&egressSpecs, "egress", "Compare results through a named proxy (name=proxy-url, repeatable)")
+	egressPath := flag.String("egress-file", "", "YAML file with named egress proxies")
+	var proxyURLs stringList
+	flag.Var(&proxyURLs, "proxy", "Proxy URL for all requests (repeat to chain proxies)")
+	socks5 := flag.String("socks5", "", "SOCKS5 proxy as host:port")
+	noProxy := flag.String("noproxy", "", "Comma separated hosts that bypass the proxy (replaces NO_PROXY)")
+	noSystemProxy := flag.Bool("no-system-proxy", false, "Ignore the Windows system proxy settings")
+	fail := flag.Bool("fail", false, "Exit with status 4 without writing the body on 4xx and 5xx responses")
+
+	// URLの引数はオプションの前後どちらにも書けるようにする
+	var positional []string
+	rest := os.Args[1:]
+	// オプションの誤りは flag の既定の2ではなく使い方の誤りとして終える
+	// --help は -h と同じくヘルプを表示して正常に終える。誤りには flag の一覧の代わりにヘルプの案内を出す
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.Usage = func() {}
+	for {
+		if err := flag.CommandLine.Parse(rest); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				fmt.Print(HelpMessage)
+				return 0
+			}
+			fmt.Fprintln(os.Stderr, "Run 'gofetch -h' for usage.")
+			return exitUsage
+		}
+		if flag.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flag.Arg(0))
+		rest = flag.Args()[1:]
+	}
+
+	// ヘルプメッセージの表示
+	if *help {
+		fmt.Print(HelpMessage)
+		return 0
+	}
+
+	// バージョン情報の表示
+	if *version {
+		fmt.Println("Version:", Version)
+		return 0
+	}
+
+	// 設定ファイルのプロファイル
+	// --profile で指定したプロファイルの既定値は、パスだけのURLをつなげるより前に使う
+	conf, err := loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	var profileName string
+	var prof *profile
+	if *profileFlag != "" {
+		if prof, err = conf.profileByName(*profileFlag); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		profileName = *profileFlag
+		if err := prof.applyFlags(flag.CommandLine); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// URLはオプションの代わりに引数でも指定できる
+	// -u を繰り返すか、引数を並べるか、--url-file で複数のURLを指定できる
+	targets := append(append([]string{}, urlFlags...), positional...)
+	if *urlFile != "" {
+		listed, err := readURLList(*urlFile)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		targets = append(targets, listed...)
+	}
+
+	// URLが指定されていない場合はエラー
+	if len(targets) == 0 {
+		fmt.Println("Error: URL is required")
+		fmt.Print(HelpMessage)
+		return 1
+	}
+
+	for i := range targets {
+		// パスだけの指定はベースURLにつなげる
+		if isPathOnly(targets[i]) {
+			if *baseURL == "" {
+				fmt.Println("Error: path-only URL requires --base-url or " + baseURLEnv)
+				return 1
+			}
+			joined, err := joinBaseURL(*baseURL, targets[i])
+			if err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+			verbosef("Base URL: %s", joined)
+			targets[i] = joined
+		}
+
+		// URLのバリデーション
+		if !isValidURL(targets[i]) {
+			fmt.Println("Error: Invalid URL")
+			fmt.Print(HelpMessage)
+			return 1
+		}
+
+		// スキームがなければhttpを付ける
+		if !strings.Contains(targets[i], "://") {
+			targets[i] = "http://" + targets[i]
+		}
+	}
+	url := &targets[0]
+	multi := len(targets) > 1
+
+	// --profile がなければURLのホストに一致するプロファイルを使う
+	// 複数のURLでは、1つめのURLのプロファイルの認証やヘッダーをほかのホストに送らないよう既定値は使わない
+	// メソッドなどの制限はURLごとに確かめる
+	if prof == nil && !multi {
+		if profileName, prof = conf.profileForURL(*url); prof != nil {
+			if err := prof.applyFlags(flag.CommandLine); err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+		}
+	}
+	if prof != nil {
+		verbosef("Profile: %s", profileName)
+	}
+
+	// 複数のURLを取得する場合は、1つのURLだけを対象にするオプションは使えない
+	if multi {
+		for _, o := range []struct {
+			name string
+			set  bool
+		}{
+			{"--extract", *extractDir != ""},
+			{"--split-multipart", *splitDir != ""},
+			{"--table", *tableSpec != ""},
+			{"--csv", *csvSpec != ""},
+			{"--jq", *jqPath != ""},
+			{"--ndjson-in", *ndjsonIn},
+			{"--proto", *protoFile != ""},
+			{"--decode " + *decodeSpec, *decodeSpec == "msgpack" || *decodeSpec == "cbor"},
+			{"--summarize", *summarize},
+			{"--preview", *preview},
+			{"--watch", *watch},
+			{"--export-header", len(exportSpecs) > 0},
+			{"--keep-partial", *keepPartial},
+			{"--continue", *continueFlag},
+			{"--include", *include},
+			{"--save-failures", *failuresDir != ""},
+			{"--budget", *budgetSpec != "" || *budgetPath != ""},
+			{"--expect-*", *expectStatus != "" || len(expectContains) > 0 || len(expectHeaders) > 0 || *expectMaxTime != ""},
+			{"--golden", *goldenPath != ""},
+			{"--cors-check", *corsOrigin != ""},
+			{"--cache-check", *cacheCheck},
+			{"--compare-encodings", *compareEnc},
+			{"--compare-protocols", *compareProtocols},
+			{"--negotiate-matrix", *negotiateMatrix || len(negotiateSpecs) > 0},
+			{"--edge-case", *edgeCaseSpec != ""},
+			{"--egress", len(egressSpecs) > 0 || *egressPath != ""},
+			{"--svcb", *svcb},
+			{"--ech", *ech || *echConfig != ""},
+			{"--linger", *linger > 0},
+			{"--framing", *framing},
+			{"--timing", *timingFlag || *timingFormat != ""},
+		} {
+			if o.set {
+				fmt.Printf("Error: %s cannot be used with multiple URLs\n", o.name)
+				return 1
+			}
+		}
+		// 保存先がなければ、今のディレクトリにあるファイルを黙って上書きしかねないのでエラーにする
+		if *output == "" {
+			fmt.Println("Error: multiple URLs require -o with a directory or a name pattern using {n}, {host} or {name}")
+			return 1
+		}
+		if *concurrency < 1 {
+			fmt.Println("Error: --concurrency must be at least 1")
+			return 1
+		}
+	}
+	if *forCount < 1 {
+		fmt.Println("Error: --for must be at least 1")
+		return 1
+	}
+	if *forCount > 1 && multi {
+		fmt.Println("Error: --for cannot be used with multiple URLs")
+		return 1
+	}
+	pacer, err := newRequestPacer(*rate, *delay)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if pacer != nil && !multi && *forCount < 2 {
+		fmt.Println("Error: --rate and --delay need several URLs or --for")
+		return 1
+	}
+
+	// リクエストのヘッダー
+	// プロファイルのヘッダーはエイリアスにないものだけを加え、
+	// 指定したヘッダーはエイリアスとプロファイルの同じ名前のヘッダーを置き換える
+	if prof != nil {
+		if err := prof.applyHeaders(reqOpts.Header); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	extraHeaders, err := parseRequestHeaders(headerSpecs)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	for name, values := range extraHeaders {
+		reqOpts.Header[name] = values
+	}
+	if *userAgent != "" {
+		reqOpts.Header.Set("User-Agent", *userAgent)
+	}
+	// Accept-Encoding を明示すると net/http は展開しない
+	// --raw だけならいつもどおり gzip を提示し、受け取ったまま書く
+	if reqOpts.Header.Get("Accept-Encoding") == "" {
+		switch {
+		case *compressed:
+			reqOpts.Header.Set("Accept-Encoding", compressedEncodings)
+		case *raw:
+			reqOpts.Header.Set("Accept-Encoding", "gzip")
+		}
+	}
+
+	// 認証情報
+	// ヘッダーは -H とエイリアスの同じ名前のヘッダーを置き換え、クエリはすべてのURLに付ける
+	auth, err := parseRequestAuth(*basicAuth, *bearer, *apiKey, *apiKeyIn)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if auth != nil {
+		auth.applyHeader(reqOpts.Header)
+		for i := range targets {
+			if targets[i], err = auth.applyURL(targets[i]); err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+		}
+	}
+
+	// リクエストのメソッドと本文
+	reqBody, err := readRequestBody(*data, *dataFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	var form *formBody
+	if len(formSpecs) > 0 {
+		if reqBody != nil {
+			fmt.Println("Error: --form cannot be used with -d or --data-file")
+			return 1
+		}
+		if form, err = newFormBody(formSpecs); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		verbosef("Form: %s", form.describe())
+	}
+	var bodyEncoding string
+	if *compressBody != "" {
+		if reqBody == nil && form == nil {
+			fmt.Println("Error: --compress-body requires -d, --data-file or --form")
+			return 1
+		}
+		if bodyEncoding, err = parseBodyEncoding(*compressBody); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if *method != "" {
+		if reqOpts.Method, err = parseMethod(*method); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	} else if (reqBody != nil || form != nil) && reqOpts.Method == http.MethodGet {
+		reqOpts.Method = http.MethodPost
+	}
+	if *jsonMode {
+		applyJSONHeaders(reqOpts.Header, reqBody != nil)
+	}
+	if form != nil {
+		// multipart の境界は本文と合わせる必要があるので、-H の Content-Type より優先する
+		reqOpts.Header.Set("Content-Type", form.contentType)
+	} else if reqBody != nil && reqOpts.Header.Get("Content-Type") == "" {
+		reqOpts.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	// 暗号化の受信者の読み込み
+	// 取得を始める前に指定の誤りを見つける
+	recipients, err := parseRecipients(encryptTo)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if len(recipients) > 0 && *output == "" {
+		fmt.Println("Error: --encrypt-output requires -o")
+		return 1
+	}
+
+	// 展開したファイルは平文になるので暗号化と同時には使えない
+	if *extractDir != "" && len(recipients) > 0 {
+		fmt.Println("Error: --extract cannot be used with --encrypt-output")
+		return 1
+	}
+	if *splitDir != "" && len(recipients) > 0 {
+		fmt.Println("Error: --split-multipart cannot be used with --encrypt-output")
+		return 1
+	}
+
+	// 表にするフィールドの解析
+	var tableFields []tableField
+	if *tableSpec != "" && *csvSpec != "" {
+		fmt.Println("Error: --table and --csv cannot be used together")
+		return 1
+	}
+	if spec := *tableSpec + *csvSpec; spec != "" {
+		tableFields, err = parseTableFields(spec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if *jqPath != "" && tableFields != nil {
+		fmt.Println("Error: --jq cannot be used with --table or --csv")
+		return 1
+	}
+	if *ndjsonIn && (tableFields != nil || *extractDir != "" || *splitDir != "" || len(recipients) > 0 || *include || *forCount > 1) {
+		fmt.Println("Error: --ndjson-in cannot be used with --table, --csv, --extract, --split-multipart, --encrypt-output, --include or --for")
+		return 1
+	}
+	if *watch {
+		if *forCount > 1 || *ndjsonIn || tableFields != nil || *extractDir != "" || *splitDir != "" || len(recipients) > 0 || *goldenPath != "" || *shadowTo != "" {
+			fmt.Println("Error: --watch cannot be used with --for, --ndjson-in, --table, --csv, --extract, --split-multipart, --encrypt-output, --golden or --shadow-to")
+			return 1
+		}
+		if *watchInterval <= 0 {
+			fmt.Println("Error: --interval must be positive")
+			return 1
+		}
+	} else if *onChange != "" || *sloSpec != "" || *live {
+		fmt.Println("Error: --on-change, --slo and --live require --watch")
+		return 1
+	}
+	var slo *sloMonitor
+	if *sloSpec != "" {
+		objectives, err := parseSLO(*sloSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		slo = &sloMonitor{objectives: objectives, interval: *watchInterval, webhook: *sloWebhook}
+	} else if *sloWebhook != "" {
+		fmt.Println("Error: --slo-webhook requires --slo")
+		return 1
+	}
+	decodeFormat, err := parseDecodeFormat(*decodeSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	// forcedDecode は Content-Type にかかわらず変換する形式
+	forcedDecode := ""
+	if decodeFormat == "msgpack" || decodeFormat == "cbor" {
+		forcedDecode = decodeFormat
+		if *protoFile != "" || *ndjsonIn || *extractDir != "" || *splitDir != "" {
+			fmt.Printf("Error: --decode %s cannot be used with --proto, --ndjson-in, --extract or --split-multipart\n", decodeFormat)
+			return 1
+		}
+	}
+	if *summarize {
+		if *jqPath != "" || tableFields != nil || *extractDir != "" || *splitDir != "" || *protoFile != "" || forcedDecode != "" || *ndjsonIn || *watch || *forCount > 1 {
+			fmt.Println("Error: --summarize cannot be used with --jq, --table, --csv, --extract, --split-multipart, --proto, --decode msgpack|cbor, --ndjson-in, --watch or --for")
+			return 1
+		}
+		if *sampleRows < 0 {
+			fmt.Println("Error: --sample-rows must not be negative")
+			return 1
+		}
+	}
+	previewMode, err := parsePreviewMode(*previewSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if *preview {
+		if *summarize || *jqPath != "" || tableFields != nil || *extractDir != "" || *splitDir != "" || *protoFile != "" || forcedDecode != "" || *ndjsonIn || *watch || *forCount > 1 {
+			fmt.Println("Error: --preview cannot be used with --summarize, --jq, --table, --csv, --extract, --split-multipart, --proto, --decode msgpack|cbor, --ndjson-in, --watch or --for")
+			return 1
+		}
+	} else if previewMode != "auto" {
+		fmt.Println("Error: --preview-mode requires --preview")
+		return 1
+	}
+	// protobuf のメッセージの型の読み込み
+	// 取得を始める前に .proto の誤りを見つける
+	var protoType protoreflect.MessageDescriptor
+	if *protoFile == "" && (*protoMessage != "" || len(protoPaths) > 0) {
+		fmt.Println("Error: --proto-message and --proto-path require --proto")
+		return 1
+	}
+	if *protoFile != "" {
+		if *ndjsonIn || *extractDir != "" || *splitDir != "" {
+			fmt.Println("Error: --proto cannot be used with --ndjson-in, --extract or --split-multipart")
+			return 1
+		}
+		protoType, err = loadProtoMessage(*protoFile, *protoMessage, protoPaths)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if reqOpts.Header.Get("Accept") == "" {
+			reqOpts.Header.Set("Accept", "application/x-protobuf")
+		}
+	}
+	color, err := useColor(*colorMode)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	color = color && *output == ""
+
+	// 結果の出力
+	results, err := newResultWriter(os.Stdout, *resultFormat, *reportFormat)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if results != nil && *forCount > 1 {
+		fmt.Println("Error: --format and --report cannot be used with --for (the summary already shows the statistics)")
+		return 1
+	}
+
+	// 書き出すレスポンスヘッダー
+	var exports []headerExport
+	for _, spec := range exportSpecs {
+		e, err := parseHeaderExport(spec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		exports = append(exports, e)
+	}
+
+	// レスポンスヘッダーの上限
+	var maxHeaderBytes int64
+	if *maxHeaderSpec != "" {
+		maxHeaderBytes, err = parseSize(*maxHeaderSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// 転送速度の下限
+	var speedLimit int64
+	if *speedLimitSpec != "" {
+		speedLimit, err = parseSize(*speedLimitSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if *speedTime <= 0 {
+			fmt.Println("Error: --speed-time must be positive")
+			return 1
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if maxHeaderBytes > 0 {
+		transport.MaxResponseHeaderBytes = maxHeaderBytes
+	}
+
+	// プロキシの設定
+	// --noproxy は空でも指定されていれば NO_PROXY の代わりに使う
+	noProxySet := false
+	flag.Visit(func(f *flag.Flag) { noProxySet = noProxySet || f.Name == "noproxy" })
+	firstProxy := ""
+	if len(proxyURLs) > 0 {
+		firstProxy = proxyURLs[0]
+	}
+	proxyConf, err := proxyConfig(firstProxy, *socks5, *noProxy, noProxySet, !*noSystemProxy)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	// 複数のプロキシは接続の段階でトンネルを順に作る
+	var chain *proxyChain
+	transport.Proxy = nil
+	switch {
+	case proxyConf != nil && len(proxyURLs) > 1:
+		chain, err = newProxyChain(proxyURLs, proxyConf)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	case proxyConf != nil:
+		transport.Proxy = proxyFunc(proxyConf)
+	}
+
+	// HTTPSレコードによる接続先の選択
+	var endpoint *svcbEndpoint
+	if *svcb {
+		var err error
+		endpoint, *url, err = discoverEndpoint(context.Background(), *url, *dnsServer)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	// 接続方法の設定
+	// 踏み台サーバーを指定した場合はSSH経由で接続し、名前解決も踏み台で行う
+	// それ以外はTTLに従うDNSキャッシュで名前解決する
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	dial := dialFunc(dialer.DialContext)
+	if *localPorts != "" {
+		ports, err := parseLocalPortRange(*localPorts, *localPortOrder)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		dial = ports.dialContext(*dialer)
+	}
+	if *sshTunnel != "" {
+		sshClient, err := dialSSHTunnel(context.Background(), *sshTunnel, *sshKey)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		defer sshClient.Close()
+		dial = sshClient.DialContext
+	} else if !*dnsCacheOff {
+		servers, err := resolveDNSServers(*dnsServer)
+		if err != nil {
+			verbosef("DNS: cache disabled: %v", err)
+		} else {
+			dial = newDNSCache(servers, *dnsReresolve).dialContext(dial)
+		}
+	}
+	if chain != nil {
+		dial = chain.dialContext(dial)
+	}
+	if endpoint != nil {
+		dial = endpoint.dialContext(dial)
+		// レコードがh2を提供していなければTCPではHTTP/1.1だけを使う
+		if len(endpoint.ALPN) > 0 && !endpoint.offers("h2") {
+			protocols := new(http.Protocols)
+			protocols.SetHTTP1(true)
+			transport.Protocols = protocols
+		}
+	}
+	wire := &wireCounter{}
+	transport.DialContext = wire.dialContext(dial)
+
+	// TLSの設定
+	transport.TLSClientConfig, err = newTLSConfig(*insecure, trustOptions{caFile: *caCert, caPath: *caPath, merge: *caMerge, print: *printTrustFlag}, *clientCert, *clientKey)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if chain != nil {
+		// ECHなど接続先のための設定は後から加えるので、ここで複製する
+		chain.tlsConfig = transport.TLSClientConfig.Clone()
+	}
+
+	// ECHの設定
+	// HTTPSレコードにECH設定があればそれを使う
+	useECH := *ech || *echConfig != ""
+	var echList []byte
+	switch {
+	case endpoint != nil && len(endpoint.ECH) > 0 && *echConfig == "":
+		echList = endpoint.ECH
+		useECH = true
+	case useECH:
+		var err error
+		echList, err = resolveECHConfig(context.Background(), *url, *echConfig, *dnsServer)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if useECH {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.EncryptedClientHelloConfigList = echList
+	}
+
+	// フレーミングの診断
+	// 受信したバイト列をHTTP/1.1として読み直すので、HTTP/2は使わない
+	// 接続の挙動の指定
+	// 書き込み側を閉じるのはHTTP/1.1のときだけ意味がある
+	framingRec := &framingRecorder{}
+	watcher := &connWatcher{halfClose: *halfClose}
+	var wrappers []func(net.Conn) net.Conn
+	http1Only := false
+	if *framing {
+		wrappers = append(wrappers, framingRec.wrap)
+		http1Only = true
+	}
+	if *linger > 0 || *halfClose {
+		wrappers = append(wrappers, watcher.wrap)
+		http1Only = http1Only || *halfClose
+	}
+	if len(wrappers) > 0 {
+		wrap := func(conn net.Conn) net.Conn {
+			for _, w := range wrappers {
+				conn = w(conn)
+			}
+			return conn
+		}
+		base := transport.DialContext
+		transport.DialContext = wrapDial(base, wrap)
+		if http1Only {
+			protocols := new(http.Protocols)
+			protocols.SetHTTP1(true)
+			transport.Protocols = protocols
+			transport.DialTLSContext = dialTLSWrapped(base, transport.TLSClientConfig, wrap)
+		}
+	}
+
+	// HTTPのバージョンの指定
+	httpVersion, err := selectHTTPVersion(*http11, *http2Only, *http3Only)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	var forcedRoundTripper http.RoundTripper
+	if httpVersion != "" {
+		switch {
+		case *compareProtocols:
+			fmt.Printf("Error: --http%s cannot be used with --compare-protocols\n", httpVersion)
+			return 1
+		case httpVersion == "2" && http1Only:
+			fmt.Println("Error: --http2 cannot be used with --framing or --half-close")
+			return 1
+		case httpVersion == "3" && (proxyConf != nil || *sshTunnel != "" || *localPorts != "" || len(wrappers) > 0):
+			fmt.Println("Error: --http3 cannot be used with a proxy, --ssh-tunnel, --local-port-range or connection diagnostics (QUIC runs over UDP)")
+			return 1
+		}
+		forcedRoundTripper, err = forceHTTPVersion(transport, httpVersion, *url)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// http/https以外のスキームはプロトコルハンドラーのプラグインに任せる
+	if scheme, _, _ := strings.Cut(*url, "://"); scheme != "http" && scheme != "https" {
+		plugin, ok := findProtocolPlugin(scheme)
+		if !ok {
+			fmt.Printf("Error: unsupported scheme %q (no %s%s on PATH)\n", scheme, protocolPluginPrefix, scheme)
+			return 1
+		}
+		transport.RegisterProtocol(scheme, plugin)
+	}
+
+	// 異常なリクエストを送って応答を調べる
+	if *edgeCaseSpec != "" {
+		cases, err := selectEdgeCases(*edgeCaseSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		runEdgeCases(transport.DialContext, transport.TLSClientConfig, *url, cases, time.Duration(*timeout)*time.Second)
+		return 0
+	}
+
+	// HTTPのバージョンごとに取得して比較する
+	if *compareProtocols {
+		if !runCompareProtocols(transport, time.Duration(*timeout)*time.Second, *url, reqOpts.Header) {
+			return 1
+		}
+		return 0
+	}
+
+	// エグレスごとに取得して比較する
+	if len(egressSpecs) > 0 || *egressPath != "" {
+		var egresses []egress
+		if *egressPath != "" {
+			list, err := loadEgressFile(*egressPath)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+			egresses = append(egresses, list...)
+		}
+		for _, spec := range egressSpecs {
+			eg, err := parseEgress(spec)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+			egresses = append(egresses, eg)
+		}
+		results := fetchViaEgresses(transport, time.Duration(*timeout)*time.Second, *url, egresses)
+		if !printEgressResults(results) {
+			return 1
+		}
+		return 0
+	}
+
+	// Alt-Svcキャッシュの読み込み
+	// h3の代替サービスはHTTP/3対応ビルドのときだけ使う
+	// QUICはUDPなのでSSHトンネルやプロキシを通せない
+	var altSvc *altSvcCache
+	if !*noAltSvc {
+		st, err := store.Open(*storage, "alt-svc")
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		altSvc, err = loadAltSvcCache(st.KV)
+		if err != nil {
+			verbosef("Alt-Svc: cache unavailable: %v", err)
+		}
+	}
+	// CLIの機能は送受信の上にミドルウェアとして順に重ね、最後に加えたものが一番外側になる
+	var roundTripper http.RoundTripper = transport
+	var middlewares []gofetch.Middleware
+	if forcedRoundTripper != nil {
+		roundTripper = forcedRoundTripper
+	} else if endpoint != nil && endpoint.offers("h3") && httpVersion == "" && *sshTunnel == "" && len(wrappers) == 0 && proxyConf == nil {
+		// HTTPSレコードが h3 を提供していれば、Alt-Svc と同じく HTTP/3 を優先する
+		tlsConf := transport.TLSClientConfig
+		roundTripper = &svcbTransport{
+			ep:   endpoint,
+			base: transport,
+			newHTTP3: func(addr string) (http.RoundTripper, error) {
+				return newHTTP3Transport(tlsConf.Clone(), addr)
+			},
+		}
+	} else if altSvc != nil && httpVersion == "" && http3Supported && *sshTunnel == "" && len(wrappers) == 0 && proxyConf == nil {
+		tlsConf := transport.TLSClientConfig
+		roundTripper = &altSvcTransport{
+			cache: altSvc,
+			base:  transport,
+			newHTTP3: func(addr string) (http.RoundTripper, error) {
+				return newHTTP3Transport(tlsConf.Clone(), addr)
+			},
+		}
+	}
+
+	// リダイレクトの追跡
+	redirects := &redirectTracker{keepMethod: map[int]bool{
+		http.StatusMovedPermanently: *post301,
+		http.StatusFound:            *post302,
+		http.StatusSeeOther:         *post303,
+	}, headers: parseRedirectHeaderPolicy(*redirectHeaders), limit: *maxRedirectsFlag, noFollow: *noFollow}
+	if *maxRedirectsFlag < 0 {
+		fmt.Println("Error: --max-redirects must not be negative")
+		return 1
+	}
+
+	// アクセストークンの自動更新
+	if prof != nil && prof.Auth != nil {
+		st, err := store.Open(*storage, "tokens")
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		tokens, err := newTokenTransport(profileName, prof.Hosts, *prof.Auth, st.KV)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		middlewares = append(middlewares, tokens.wrap)
+	}
+
+	// 時間の内訳の記録
+	// 表示やプラグインの時間を含めないよう、送受信のすぐ上で記録する
+	var timings *timingTransport
+	switch *timingFormat {
+	case "", "text", "json":
+	default:
+		fmt.Printf("Error: invalid --timing-format %q (want text or json)\n", *timingFormat)
+		return 1
+	}
+	if *timingFlag || *timingFormat != "" {
+		if *forCount > 1 {
+			fmt.Println("Error: --timing cannot be used with --for (the summary already shows latency)")
+			return 1
+		}
+		timings = &timingTransport{}
+		middlewares = append(middlewares, timings.wrap)
+	}
+
+	// 送ったリクエストと受け取ったレスポンスの表示
+	if verbose {
+		middlewares = append(middlewares, (&verboseTransport{w: os.Stderr}).wrap)
+	}
+
+	// レスポンスのキャッシュ
+	// verbose の外側に置き、表示するのは実際に送ったリクエストだけにする
+	var responseCache *cacheTransport
+	if *cacheDir != "" {
+		if *cacheCheck {
+			fmt.Println("Error: --cache-dir cannot be used with --cache-check")
+			return 1
+		}
+		// --cache-dir はディレクトリに保存するので、ほかの保存先を選んでいれば黙ってファイルに書かずにエラーにする
+		if *storage != "file" {
+			fmt.Printf("Error: --cache-dir keeps responses in a directory and cannot be used with --storage %s\n", *storage)
+			return 1
+		}
+		responseCache = newCacheTransport(store.NewFileAt(*cacheDir))
+		middlewares = append(middlewares, responseCache.wrap)
+	}
+
+	// 圧縮された本文の展開
+	// キャッシュには圧縮されたまま保存する
+	if (*compressed || *raw) && (*compareEnc || *negotiateMatrix || len(negotiateSpecs) > 0) {
+		fmt.Println("Error: --compressed and --raw cannot be used with --compare-encodings or --negotiate-matrix")
+		return 1
+	}
+	if *compressed && !*raw {
+		middlewares = append(middlewares, (&decompressTransport{}).wrap)
+	}
+
+	// タイムアウト時間の設定
+	client := &http.Client{
+		Timeout:       time.Duration(*timeout) * time.Second,
+		Transport:     gofetch.Chain(roundTripper, middlewares...),
+		CheckRedirect: redirects.checkRedirect,
+	}
+	// 終わりのないストリームを読めるよう、--ndjson-in では -t がなければ全体のタイムアウトをかけない
+	if *ndjsonIn {
+		timeoutSet := false
+		flag.Visit(func(f *flag.Flag) { timeoutSet = timeoutSet || f.Name == "t" })
+		if !timeoutSet {
+			client.Timeout = 0
+		}
+	}
+
+	// クッキー
+	// リダイレクトの途中で受け取ったクッキーも続くリクエストで送る
+	jar := newCookieJar()
+	if *cookiesFile != "" {
+		b, key := cookieFile(*cookiesFile)
+		if err := jar.load(b, key, *cookiesFile); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	for _, spec := range cookieSpecs {
+		if err := jar.addCookies(spec, targets); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	client.Jar = jar
+	saveCookies := func() error {
+		if *cookieJarFile == "" {
+			return nil
+		}
+		if err := jar.save(cookieFile(*cookieJarFile)); err != nil {
+			return err
+		}
+		verbosef("Cookies: saved to %s", *cookieJarFile)
+		return nil
+	}
+	// finishRun はキャッシュの集計を表示し、クッキーを保存して終了コードを返す。--watch、--for、複数のURLの取得の後に使う
+	finishRun := func(code int) int {
+		if responseCache != nil {
+			responseCache.report(os.Stderr)
+		}
+		if err := saveCookies(); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		return code
+	}
+
+	// 比べる前に伏せる値の規則
+	// --golden-mask は正規表現の規則として扱う
+	for _, m := range goldenMasks {
+		maskSpecs = append(maskSpecs, "re:"+m)
+	}
+	masks, err := loadMaskRules(maskSpecs, *maskFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	// リクエストの複製先
+	var shadow *shadowMirror
+	if *shadowTo != "" {
+		shadow, err = newShadowMirror(*shadowTo, client, *shadowCompare, masks)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// CORSのプリフライトの再現
+	if *corsOrigin != "" {
+		cfg := corsCheck{Origin: *corsOrigin, Method: strings.ToUpper(*corsMethod), Headers: splitList(*corsHeaders), Credentials: *corsCredentials}
+		allowed, err := runCORSCheck(client, *url, reqOpts.Header, cfg)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if !allowed {
+			return 1
+		}
+		return 0
+	}
+
+	// キャッシュの挙動の確認
+	if *cacheCheck {
+		cacheable, err := runCacheCheck(client, *url, reqOpts.Header)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if !cacheable {
+			return 1
+		}
+		return 0
+	}
+
+	// 圧縮による削減量の比較
+	if *compareEnc {
+		runCompareEncodings(client, *url, reqOpts.Header)
+		return 0
+	}
+
+	// コンテンツネゴシエーションの確認
+	if *negotiateMatrix || len(negotiateSpecs) > 0 {
+		variations, err := negotiateVariations(negotiateSpecs)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if !runNegotiateMatrix(client, *url, reqOpts.Header, variations) {
+			return 1
+		}
+		return 0
+	}
+
+	// バジェットの読み込み
+	var limits budget
+	if *budgetPath != "" {
+		fileLimits, ok, err := loadBudgetFile(*budgetPath, *url)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		if ok {
+			limits = fileLimits
+		} else {
+			verbosef("Budget: no rule in %s matches %s", *budgetPath, *url)
+		}
+	}
+	if *budgetSpec != "" {
+		flagLimits, err := parseBudget(*budgetSpec)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		limits = limits.merge(flagLimits)
+	}
+
+	// レスポンスに期待することの読み込み
+	expect, err := parseExpectations(*expectStatus, expectContains, expectHeaders, *expectMaxTime)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if !expect.isZero() && (*forCount > 1 || *watch || *ndjsonIn) {
+		fmt.Println("Error: --expect-* cannot be used with --for, --watch or --ndjson-in")
+		return 1
+	}
+
+	// ゴールデンファイルとの比較の設定
+	var golden *goldenCheck
+	if *goldenPath != "" {
+		golden, err = newGoldenCheck(*goldenPath, *goldenMode, masks, *updateGolden)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	} else if *updateGolden || len(goldenMasks) > 0 {
+		fmt.Println("Error: --golden-mask and --update-golden require --golden")
+		return 1
+	}
+
+	// -o への保存は本文をメモリーに溜めずにファイルへ書く
+	// --encrypt-output では暗号化しながら書く。本文全体を使う処理と一緒のときはメモリーに読んでから書く
+	stream := *output != "" && !multi && *extractDir == "" && *splitDir == "" && tableFields == nil && *jqPath == "" && *failuresDir == "" && !*shadowCompare && !*include && golden == nil && !*ndjsonIn && protoType == nil && forcedDecode == "" && !expect.needsBody() && !*summarize && !*preview
+	if *continueFlag && !stream {
+		fmt.Println("Error: --continue requires -o and cannot be combined with --extract, --split-multipart, --table, --csv, --encrypt-output, --save-failures, --shadow-compare, --include or --golden")
+		return 1
+	}
+	// 暗号化したファイルには続きを書き足せない
+	if *continueFlag && len(recipients) > 0 {
+		fmt.Println("Error: --continue cannot be used with --encrypt-output")
+		return 1
+	}
+	if *shadowCompare && *shadowTo == "" {
+		fmt.Println("Error: --shadow-compare requires --shadow-to")
+		return 1
+	}
+	// 複数のURLの本文は受け取りながら保存先に書くので、暗号化したものは影のレスポンスと比べられない
+	if multi && *shadowCompare && len(recipients) > 0 {
+		fmt.Println("Error: --shadow-compare cannot be used with --encrypt-output and multiple URLs")
+		return 1
+	}
+	if *ndjsonIn && (golden != nil || *shadowCompare) {
+		fmt.Println("Error: --ndjson-in cannot be used with --golden or --shadow-compare")
+		return 1
+	}
+
+	// 複数のURLの保存先
+	var outputs []string
+	if multi {
+		outputs = multiOutputPaths(*output, targets)
+	} else if *output != "" {
+		outputs = []string{*output}
+	}
+
+	// 危険な操作の前の確認
+	// 本番のホストへのGET以外のリクエストと、プロファイルの制限は --confirm がなくても確かめる
+	confirm := newConfirmer(*yes)
+	var checks []func() error
+	for _, target := range targets {
+		// --profile で指定したプロファイルはすべてのURLに使う
+		name, p := profileName, prof
+		if *profileFlag == "" {
+			name, p = conf.profileForURL(target)
+		}
+		if p != nil {
+			checks = append(checks, func() error { return p.enforce(name, reqOpts.Method, target, confirm) })
+		}
+	}
+	for _, target := range targets {
+		checks = append(checks, func() error { return confirm.confirmMethod(reqOpts.Method, target, conf.Confirm.Hosts) })
+	}
+	if *confirmFlag {
+		for _, path := range outputs {
+			checks = append(checks, func() error { return confirm.confirmOverwrite(path) })
+		}
+		confirmSize := int64(defaultConfirmSize)
+		if *confirmSizeSpec != "" {
+			confirmSize, err = parseSize(*confirmSizeSpec)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+		}
+		for _, target := range targets {
+			checks = append(checks, func() error { return confirm.confirmSize(client, target, reqOpts.Header, confirmSize) })
+		}
+	}
+	for _, check := range checks {
+		if err := check(); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// リトライの方針
+	backoff, err := retryBackoff(*retryDelay, *retryMaxDelay)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	retryOn, err := parseRetryOn(*retryOnSpec)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	// リトライの記録
+	attempts, err := openRetryLog(*retryLogPath)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	defer attempts.Close()
+
+	// 取得とリトライは pkg/gofetch に任せ、CLIだけの処理をフックで加える
+	fetcher := gofetch.New(client)
+	fetcher.Timeout = 0 // client のタイムアウトを使う
+	fetcher.MaxAttempts = *retry
+	fetcher.Backoff = backoff
+	fetcher.RetryOn = retryOn
+	fetcher.MaxRetryAfter = *retryMaxDelay
+	fetcher.OnRequest = func(req *http.Request) { redirects.start(req.Header) }
+	// 本文の受信が遅すぎる場合は打ち切ってリトライする
+	if speedLimit > 0 {
+		fetcher.WrapBody = func(body io.ReadCloser, cancel context.CancelCauseFunc) io.ReadCloser {
+			return watchLowSpeed(body, speedLimit, time.Duration(*speedTime)*time.Second, cancel)
+		}
+	}
+	fetcher.OnAttempt = func(a gofetch.Attempt) {
+		r := retryAttempt{Attempt: a.Number, Status: a.Status, ElapsedMS: a.Elapsed.Milliseconds(), BackoffMS: a.Backoff.Milliseconds(), Time: a.Start}
+		if a.Err != nil {
+			r.Error = a.Err.Error()
+		}
+		attempts.record(r)
+	}
+	request := gofetch.Request{Method: reqOpts.Method, URL: *url, Header: reqOpts.Header, Body: reqBody, Close: *connClose}
+	if form != nil {
+		form.apply(&request)
+	}
+	if bodyEncoding != "" {
+		compressRequestBody(&request, bodyEncoding)
+	}
+	if *watch {
+		conf := watchConfig{interval: *watchInterval, onChange: *onChange, masks: masks, view: watchView(protoType, *jqPath), output: *output, slo: slo, live: *live}
+		return finishRun(runWatch(fetcher, request, conf))
+	}
+	// --for と複数のURLでは、すべてのリクエストの通信量をまとめの後に表示する
+	// HTTP/3 を使ったかはリクエストごとに違うので、使うかもしれなければ数えていないことを添える
+	wireTotal := func(code int) int {
+		if *wireStats {
+			fmt.Fprintf(os.Stderr, "Wire: %s in total\n", wire)
+			if _, viaAltSvc := roundTripper.(*altSvcTransport); viaAltSvc || httpVersion == "3" {
+				fmt.Fprintln(os.Stderr, "Wire: HTTP/3 traffic is not included")
+			}
+		}
+		return code
+	}
+	if *forCount > 1 {
+		n, err := repeatConcurrency(*concurrency, *forCount)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		return finishRun(wireTotal(runRepeated(client, redirects, request, *forCount, n, *fail, pacer)))
+	}
+	if multi {
+		return finishRun(wireTotal(fetchMulti(client, redirects, *fetcher, request, targets, outputs, recipients, *concurrency, *fail, !*noProgress, shadow, results, pacer)))
+	}
+	var shadowed *shadowCall
+	if shadow != nil {
+		shadowed = shadow.start(request)
+	}
+	var res gofetch.Response
+	var download *downloadFile
+	var ndjson *ndjsonWriter
+	if *ndjsonIn {
+		if ndjson, err = newNDJSONWriter(*output, *jqPath, color); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		res, err = streamNDJSON(fetcher, request, ndjson)
+	} else if stream {
+		if download, err = openDownload(*output, *continueFlag, recipients); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		res, err = runDownload(fetcher, request, download, !*noProgress)
+	} else {
+		res, err = fetcher.Fetch(context.Background(), request)
+	}
+	redirects.report(res.Raw)
+	if timings != nil {
+		timings.print(os.Stderr, *timingFormat)
+	}
+	if responseCache != nil {
+		responseCache.report(os.Stderr)
+	}
+	if err := saveCookies(); err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if shadowed != nil {
+		if err != nil {
+			shadow.finish(shadowed, nil)
+		} else {
+			shadow.finish(shadowed, &res)
+		}
+	}
+	resp, body, ttfb, total := res.Raw, res.Body, res.TTFB, res.Duration
+	// size はこの実行で受け取った本文、bodySize は本文全体の大きさ
+	size, bodySize := int64(len(body)), int64(len(body))
+	if stream {
+		size, bodySize = res.Size-res.Resumed, res.Size
+	}
+	// --ndjson-in で行ごとに書き終えた本文は、後で出力しない
+	streamedLines := ndjson != nil && res.StatusCode >= 200 && res.StatusCode <= 299
+	if streamedLines {
+		size, bodySize = res.Size, res.Size
+	}
+	if err == nil {
+		verbosef("Received %s in %s (first byte after %s)", formatSize(size), roundLatency(total), roundLatency(ttfb))
+	}
+	// failure は失敗した試行で受信できた本文を持つ
+	var failure *gofetch.TransferError
+	if errors.As(err, &failure) {
+		resp = failure.Response
+	}
+
+	if *framing {
+		framingRec.report()
+	}
+
+	if *linger > 0 && resp != nil {
+		watcher.linger(time.Duration(*linger) * time.Second)
+	}
+
+	if useECH {
+		if resp != nil {
+			reportECH(resp.TLS, nil)
+		} else {
+			reportECH(nil, err)
+		}
+	}
+
+	record := newResultRecord(*url, res, size, err)
+	if *output != "" && err == nil && !(*fail && res.StatusCode >= 400) {
+		record.Output = *output
+	}
+
+	if err != nil {
+		if results != nil {
+			results.write(record)
+		}
+		fmt.Println("Error:", redactSecrets(err.Error()))
+		// 書きながら受け取った分は .partial に残っている
+		if download != nil {
+			return failDownload(download, resp, err, *keepPartial, *continueFlag)
+		}
+		// 受信できた分だけでも残す
+		if *keepPartial && failure != nil && len(failure.Partial) > 0 {
+			dest, werr := writePartial(*output, failure.Partial, recipients)
+			if werr != nil {
+				fmt.Println("Error:", werr)
+				return 1
+			}
+			reportPartial(dest, int64(len(failure.Partial)), failure.PartialResponse.ContentLength)
+			return exitPartial
+		}
+		return exitCodeForError(err)
+	}
+
+	// --fail では 4xx と 5xx を失敗にし、本文を出力しない
+	httpFailed := *fail && resp.StatusCode >= 400
+
+	// Alt-Svcキャッシュの更新
+	if altSvc != nil && resp.TLS != nil {
+		altSvc.update(altSvcOrigin(resp.Request), strings.Join(resp.Header.Values("Alt-Svc"), ","), time.Now())
+		if err := altSvc.save(); err != nil {
+			verbosef("Alt-Svc: failed to save cache: %v", err)
+		}
+	}
+
+	// レスポンスヘッダーの表示
+	if *showHeaders != "" {
+		printHeaders(os.Stderr, resp.Header, parseHeaderPatterns(*showHeaders))
+	}
+
+	// アーカイブの展開
+	// -o も指定した場合はアーカイブ自体も保存する
+	if *extractDir != "" && !httpFailed {
+		if err := extractResponse(body, *extractDir, *stripComponents); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// multipart のパートの書き出し
+	// -o も指定した場合はレスポンスの本文自体も保存する
+	if *splitDir != "" && !httpFailed {
+		parts, err := splitMultipart(resp.Header.Get("Content-Type"), body)
+		if err == nil {
+			err = writeMultipartParts(parts, *splitDir, os.Stdout)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// protobuf の本文はJSONにして、以降は JSON のレスポンスと同じように扱う
+	decodedJSON := false
+	if protoType != nil && !httpFailed {
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			body, err = decodeProto(protoType, body)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return 1
+			}
+			decodedJSON = true
+		} else {
+			verbosef("Protobuf: not decoding the %s response", resp.Status)
+		}
+	}
+	// MessagePack と CBOR も同じ
+	// auto では本文をそのまま書くファイルは変換しない
+	binaryFormat := forcedDecode
+	if forcedDecode != "" && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		verbosef("Decode: not decoding the %s response", resp.Status)
+		binaryFormat = ""
+	}
+	if decodeFormat == "auto" && (*output == "" || *jqPath != "" || tableFields != nil) && !streamedLines {
+		binaryFormat = binaryFormatOf(resp.Header.Get("Content-Type"))
+	}
+	if binaryFormat != "" && !httpFailed && len(body) > 0 {
+		body, err = decodeBinaryJSON(binaryFormat, body)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		decodedJSON = true
+	}
+
+	// --summarize は標準出力に本文の代わりに要約を書く。-o のファイルには本文をそのまま書き、要約は標準出力に表示する
+	var summary []byte
+	if *summarize && !httpFailed && resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		summary, err = summarizeData(body, resp.Header.Get("Content-Type"), resp.Request.URL.String(), *sampleRows)
+		if err != nil {
+			fmt.Println("Error: --summarize:", err)
+			return 1
+		}
+	}
+
+	// 画像は端末にバイナリを書かず、形式と大きさ、EXIF を表示する。--preview では縮小した画像も付け、-o のときも表示する
+	if summary == nil && !httpFailed && resp.StatusCode >= 200 && resp.StatusCode <= 299 && !decodedJSON && !streamedLines && *jqPath == "" && tableFields == nil && *extractDir == "" && *splitDir == "" {
+		fi, err := os.Stdout.Stat()
+		tty := err == nil && fi.Mode()&os.ModeCharDevice != 0
+		if (*preview || *output == "" && tty) && isImageResponse(resp.Header.Get("Content-Type"), body) {
+			mode := ""
+			if *preview {
+				mode = previewMode
+			}
+			summary = describeImage(body, resp.Header.Get("Content-Type"), mode, terminalColumns())
+		}
+	}
+
+	// 表として出力する場合は本文の代わりに表を書き出す
+	out := body
+	if summary != nil && *output == "" {
+		out = summary
+	}
+	if tableFields != nil {
+		out, err = renderTable(body, tableFields, *csvSpec != "")
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	// --jq は値だけを、--json は標準出力に書く場合に整形したJSONを書き出す
+	if *jqPath != "" && !httpFailed && !streamedLines {
+		out, err = extractJSONPath(body, *jqPath, color)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	} else if (*jsonMode || decodedJSON) && *output == "" && tableFields == nil {
+		out, _ = prettyJSON(body, color)
+	}
+	if *include {
+		out = append(includedHeaders(resp), out...)
+	}
+
+	if httpFailed {
+		if download != nil {
+			abandonDownload(download, false)
+		}
+	} else if streamedLines {
+		// 受け取った行から書き終えている
+	} else if download != nil {
+		if err := completeDownload(download, res); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	} else if *output == "" {
+		if tableFields != nil {
+			fmt.Print(string(out))
+		} else if *extractDir == "" && *splitDir == "" {
+			fmt.Println(string(out))
+		}
+	} else if len(recipients) > 0 {
+		err = writeEncrypted(*output, out, recipients)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	} else {
+		err = ioutil.WriteFile(*output, out, 0644)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+	if summary != nil && *output != "" {
+		fmt.Println(string(summary))
+	}
+
+	if results != nil {
+		if err := results.write(record); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// レスポンスヘッダーの書き出し
+	if len(exports) > 0 {
+		if err := writeHeaderExports(resp.Header, exports, *exportFile); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// 通信量の表示
+	// HTTP/3(QUIC)の通信は数えられない
+	if *wireStats {
+		fmt.Fprintf(os.Stderr, "Wire: %s (body %s decoded)\n", wire, formatSize(size))
+		if resp.ProtoMajor == 3 {
+			fmt.Fprintln(os.Stderr, "Wire: HTTP/3 traffic is not included")
+		}
+	}
+
+	// バジェットの検査
+	budgetOK := limits.isZero() || limits.check(bodySize, ttfb, total)
+
+	// レスポンスの確認
+	expectOK := expect.isZero() || expect.check(resp, body, total)
+
+	// ゴールデンファイルとの比較
+	goldenOK := true
+	if golden != nil && !httpFailed {
+		goldenOK, err = golden.check(body, mediaType(resp.Header))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	// 失敗したレスポンスの保存
+	if *failuresDir != "" {
+		reason := ""
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			reason = "status " + resp.Status
+		} else if !budgetOK {
+			reason = "budget exceeded"
+		} else if !goldenOK {
+			reason = "golden mismatch"
+		} else if !expectOK {
+			reason = "expectation failed"
+		}
+		if reason != "" {
+			path, err := saveFailure(*failuresDir, resp, body, reason, *failuresMax)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to save the response: %v\n", err)
+			} else {
+				fmt.Fprintf(os.Stderr, "Saved failed response to %s (%s)\n", path, reason)
+			}
+		}
+	}
+
+	if httpFailed {
+		fmt.Println("Error: server returned", resp.Status)
+		return exitHTTP
+	}
+	if !budgetOK || !goldenOK || !expectOK {
+		return exitUsage
+	}
+	return 0
+}
@@ -0,0 +1,176 @@
+package main
+
+// 比較で無視する値の規則 (--mask, --mask-file)
+// 時刻、UUID、リクエストIDのように毎回変わる値を伏せてから比べ、期待どおりの変化を違いとして扱わない
+// --golden、--shadow-compare、baseline record で使う。規則は次のどれか
+//
+//	.meta.request_id    JSONのパス。--table と同じく . 区切りで、数字は配列の添字、* はすべての要素
+//	re:"ts": \d+        正規表現。一致した部分を伏せる
+//	uuid                よく使う形の名前 (uuid、timestamp、unix-time)
+//
+// --mask-file には1行に1つ規則を書く。空行と # で始まる行は無視する
+// JSONのパスは本文がJSONのときだけ使い、正規表現はパスを伏せた後の本文に使う
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maskedValue は伏せた値の代わりに比べる文字列
+const maskedValue = "<masked>"
+
+// maskPresets は名前で指定できる正規表現
+var maskPresets = map[string]string{
+	"uuid":      `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`,
+	"timestamp": `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`,
+	"unix-time": `\b1\d{9}(\d{3})?\b`,
+}
+
+// maskRule は規則1つ。path か pattern のどちらかを持つ
+type maskRule struct {
+	spec    string
+	path    []string
+	pattern *regexp.Regexp
+}
+
+// maskRules は規則の一覧
+type maskRules []maskRule
+
+// parseMaskRule は規則の指定を解釈する
+func parseMaskRule(spec string) (maskRule, error) {
+	spec = strings.TrimSpace(spec)
+	if p, ok := strings.CutPrefix(spec, "re:"); ok {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return maskRule{}, fmt.Errorf("invalid mask %q: %w", spec, err)
+		}
+		return maskRule{spec: spec, pattern: re}, nil
+	}
+	if p, ok := maskPresets[spec]; ok {
+		return maskRule{spec: spec, pattern: regexp.MustCompile(p)}, nil
+	}
+	if p, ok := strings.CutPrefix(strings.TrimPrefix(spec, "$"), "."); ok && p != "" {
+		return maskRule{spec: spec, path: strings.Split(p, ".")}, nil
+	}
+	names := make([]string, 0, len(maskPresets))
+	for name := range maskPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return maskRule{}, fmt.Errorf("invalid mask %q (want .json.path, re:regexp or one of %s)", spec, strings.Join(names, ", "))
+}
+
+// loadMaskRules は指定した規則と --mask-file の規則を読み込む
+func loadMaskRules(specs []string, file string) (maskRules, error) {
+	all := append([]string{}, specs...)
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				all = append(all, line)
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	var rules maskRules
+	for _, spec := range all {
+		r, err := parseMaskRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// specs は規則の指定の一覧を返す
+func (m maskRules) specs() []string {
+	specs := make([]string, len(m))
+	for i, r := range m {
+		specs[i] = r.spec
+	}
+	return specs
+}
+
+// apply はパスと正規表現の両方の規則で伏せる
+func (m maskRules) apply(body []byte) []byte {
+	return m.applyPatterns(m.applyPaths(body))
+}
+
+// applyPaths はJSONのパスの規則で値を伏せる。パスの規則がないか本文がJSONでなければそのまま返す
+// 伏せた場合はキーを並べた1行のJSONになる
+func (m maskRules) applyPaths(body []byte) []byte {
+	var paths [][]string
+	for _, r := range m {
+		if r.path != nil {
+			paths = append(paths, r.path)
+		}
+	}
+	if len(paths) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		return body
+	}
+	for _, p := range paths {
+		v = maskJSONPath(v, p)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return body
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+}
+
+// applyPatterns は正規表現の規則に一致した部分を伏せる
+func (m maskRules) applyPatterns(body []byte) []byte {
+	for _, r := range m {
+		if r.pattern != nil {
+			body = r.pattern.ReplaceAllLiteral(body, []byte(maskedValue))
+		}
+	}
+	return body
+}
+
+// maskJSONPath は v の path の位置にある値を伏せた値を返す。path の位置がなければそのまま返す
+func maskJSONPath(v any, path []string) any {
+	if len(path) == 0 {
+		return maskedValue
+	}
+	key, rest := path[0], path[1:]
+	switch x := v.(type) {
+	case map[string]any:
+		for k, child := range x {
+			if key == "*" || k == key {
+				x[k] = maskJSONPath(child, rest)
+			}
+		}
+	case []any:
+		for i, child := range x {
+			if key == "*" || strconv.Itoa(i) == key {
+				x[i] = maskJSONPath(child, rest)
+			}
+		}
+	}
+	return v
+}
@@ -0,0 +1,231 @@
+package main
+
+// 複数のURLの取得 (-u の複数指定、--url-file)
+// URLを --concurrency の数のワーカーで並行して取得し (pkg/gofetch の DownloadBatch)、
+// 本文を受け取りながらURLごとの名前でファイルに保存して、最後に成功と失敗をまとめて表示する
+// 標準エラー出力が端末なら、実行中の転送ごとの進み具合と全体の進み具合を表示する
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+
+	"gofetch/pkg/gofetch"
+)
+
+// defaultMultiConcurrency は複数のURLを取得するときの並列数の既定値
+const defaultMultiConcurrency = 4
+
+// multiIndexKey はURLの位置をリクエストのコンテキストに入れるキー
+type multiIndexKey struct{}
+
+// multiResult はURL1件分の結果
+type multiResult struct {
+	Status   int
+	Size     int64
+	Duration time.Duration
+	Err      error
+	// Record は --format と --report で書く結果
+	Record resultRecord
+}
+
+// multiOutputName は -o のパターンから index 番目 (0から) のURLの保存先を決める
+// パターンには {n} (1からの番号)、{host}、{name} (URLの最後のパス要素) を使える
+// プレースホルダーがなければディレクトリとみなし、その下に {name} で保存する
+func multiOutputName(pattern string, index int, rawURL string) string {
+	host, name := "", ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = u.Hostname()
+		name = path.Base(u.Path)
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "index.html"
+	}
+	if !strings.Contains(pattern, "{") {
+		return filepath.Join(pattern, name)
+	}
+	return strings.NewReplacer("{n}", strconv.Itoa(index+1), "{host}", host, "{name}", name).Replace(pattern)
+}
+
+// multiOutputPaths はURLごとの保存先を決める。同じ名前になった場合は2つめ以降に -2, -3 を付ける
+func multiOutputPaths(pattern string, urls []string) []string {
+	paths := make([]string, len(urls))
+	seen := map[string]int{}
+	for i, u := range urls {
+		p := multiOutputName(pattern, i, u)
+		seen[p]++
+		if n := seen[p]; n > 1 {
+			ext := filepath.Ext(p)
+			p = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(p, ext), n, ext)
+		}
+		paths[i] = p
+	}
+	return paths
+}
+
+// fetchMulti はURLを並行して取得して paths に保存し、結果をURLの順に表示する
+// 本文はメモリーに溜めずに、受け取るたびに保存先の .partial に書く (recipients があれば暗号化して)
+// fetcher は試行回数などを設定済みのもの。リダイレクトの記録はURLごとに分ける
+// shadow があれば各リクエストの複製も送る。失敗したURLがあれば最初に失敗したURLの終了コードを返す
+// results があれば一覧の代わりに結果を1件ずつ書き、まとめは標準エラー出力に書く
+// pacer があれば各URLを取得する前にその分だけ待つ
+// fail なら 4xx と 5xx のレスポンスは保存せずに失敗とし、終了コードを exitHTTP にする
+// showProgress なら転送ごとと全体の進み具合を表示する
+func fetchMulti(client *http.Client, redirects *redirectTracker, fetcher gofetch.Client, r gofetch.Request, urls, paths []string, recipients []age.Recipient, concurrency int, fail, showProgress bool, shadow *shadowMirror, results *resultWriter, pacer *requestPacer) int {
+	done := make([]multiResult, len(urls))
+	start := time.Now()
+	// リダイレクトの記録、影のリクエスト、進み具合はURLごとに分け、リクエストのコンテキストのURLの位置で引く
+	trackers := make([]*redirectTracker, len(urls))
+	shadowed := make([]*shadowCall, len(urls))
+	downloads := make([]*downloadFile, len(urls))
+	var progress *multiProgress
+	if showProgress {
+		names := make([]string, len(paths))
+		for i, p := range paths {
+			names[i] = filepath.Base(p)
+		}
+		progress = newMultiProgress(names)
+	}
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return trackers[req.Context().Value(multiIndexKey{}).(int)].checkRedirect(req, via)
+	}
+	f := fetcher
+	f.HTTPClient = &c
+	f.OnStart = func(ctx context.Context, i int, req gofetch.Request) context.Context {
+		// 枠を持ったまま待つので、--delay は枠ごとの間隔になる。最初の concurrency 件は待たない
+		pacer.pace(i < concurrency)
+		trackers[i] = redirects.clone()
+		if shadow != nil {
+			shadowed[i] = shadow.start(req)
+		}
+		return context.WithValue(ctx, multiIndexKey{}, i)
+	}
+	f.OnRequest = func(req *http.Request) {
+		trackers[req.Context().Value(multiIndexKey{}).(int)].start(req.Header)
+	}
+	if progress != nil {
+		f.OnResponse = func(resp *http.Response) {
+			progress.begin(resp.Request.Context().Value(multiIndexKey{}).(int), resp)
+		}
+	}
+	open := func(i int) (gofetch.Destination, error) {
+		if dir := filepath.Dir(paths[i]); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, err
+			}
+		}
+		d, err := openDownload(paths[i], false, recipients)
+		if err != nil {
+			return nil, err
+		}
+		downloads[i] = d
+		if progress != nil {
+			return &countedDestination{Destination: d, progress: progress, index: i}, nil
+		}
+		return d, nil
+	}
+
+	reqs := make([]gofetch.Request, len(urls))
+	for i, u := range urls {
+		reqs[i] = r
+		reqs[i].URL = u
+	}
+	// 終わったものから保存先の名前に変える
+	for b := range f.DownloadBatch(context.Background(), reqs, concurrency, open) {
+		i, res, err := b.Index, b.Response, b.Err
+		if progress != nil {
+			progress.end(i)
+		}
+		trackers[i].report(res.Raw)
+		httpFailed := err == nil && fail && res.StatusCode >= 400
+		if d := downloads[i]; d != nil {
+			// 影のレスポンスと比べる本文は書いたファイルから読む。暗号化したものは比べられない
+			if shadowed[i] != nil && shadow.compare && err == nil && d.enc == nil {
+				res.Body, _ = os.ReadFile(d.Name())
+			}
+			if err != nil || httpFailed {
+				abandonDownload(d, false)
+			} else {
+				err = finishDownload(d)
+			}
+		}
+		if shadowed[i] != nil {
+			if err != nil {
+				shadow.finish(shadowed[i], nil)
+			} else {
+				shadow.finish(shadowed[i], &res)
+			}
+		}
+		record := newResultRecord(urls[i], res, res.Size, err)
+		if err == nil && !httpFailed {
+			record.Output = paths[i]
+		}
+		done[i] = multiResult{Status: res.StatusCode, Size: res.Size, Duration: res.Duration, Err: err, Record: record}
+	}
+	if progress != nil {
+		progress.close()
+	}
+	pacer.report()
+
+	failed, code := 0, 0
+	summary := os.Stdout
+	if results != nil {
+		summary = os.Stderr
+	}
+	for i, u := range urls {
+		res := done[i]
+		if results != nil {
+			if err := results.write(res.Record); err != nil {
+				fmt.Println("Error:", err)
+				return exitUsage
+			}
+		}
+		switch {
+		case res.Err != nil:
+			failed++
+			if code == 0 {
+				code = exitCodeForError(res.Err)
+			}
+			if results == nil {
+				fmt.Printf("ERROR  %s: %s\n", u, redactSecrets(res.Err.Error()))
+			}
+		case fail && res.Status >= 400:
+			failed++
+			if code == 0 {
+				code = exitHTTP
+			}
+			if results == nil {
+				fmt.Printf("FAIL   %s (%d, %s, %s)\n", u, res.Status, formatSize(res.Size), res.Duration.Round(time.Millisecond))
+			}
+		default:
+			if results == nil {
+				fmt.Printf("OK     %s -> %s (%d, %s, %s)\n", u, paths[i], res.Status, formatSize(res.Size), res.Duration.Round(time.Millisecond))
+			}
+		}
+	}
+	fmt.Fprintf(summary, "Fetched %d URLs in %s: %d succeeded, %d failed\n", len(urls), time.Since(start).Round(time.Millisecond), len(urls)-failed, failed)
+	return code
+}
+
+// countedDestination は書いた量を multiProgress に伝える
+type countedDestination struct {
+	gofetch.Destination
+	progress *multiProgress
+	index    int
+}
+
+func (d *countedDestination) Write(b []byte) (int, error) {
+	n, err := d.Destination.Write(b)
+	d.progress.add(d.index, n)
+	return n, err
+}
@@ -0,0 +1,139 @@
+package main
+
+// JSON Lines のレスポンスの処理 (--ndjson-in)
+// NDJSON を返すストリーミングのAPIの本文を、全体を待たずに受け取った行から1件ずつ処理して書き出す
+// 終わりのないストリームでも使えるよう、-t を指定しなければ全体のタイムアウトはかけない
+// 止まったストリームを打ち切るには --speed-limit と --speed-time を使う
+// 標準出力には1件ずつインデントして書き、-o のファイルには受け取ったとおり1行ずつ書く
+// --jq を指定すると1件ずつパスの値を取り出し、値のない行は飛ばす。JSONでない行は --jq がなければそのまま書く
+// 最後に件数を標準エラー出力に表示する
+//
+//	gofetch -u https://api.example.com/events?follow=1 --ndjson-in --jq data.id
+//	gofetch -u https://api.example.com/export --ndjson-in -o export.ndjson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"gofetch/pkg/gofetch"
+)
+
+// ndjsonWriter は受け取った本文を行に分け、1件ずつ処理して w に書く
+type ndjsonWriter struct {
+	w      io.Writer
+	path   string
+	pretty bool
+	color  bool
+	// rest は改行がまだ来ていない行の途中
+	rest []byte
+	line int
+	// records は書いた件数、invalid はJSONでなかった行、missing は --jq の値がなかった行の数
+	records, invalid, missing int
+}
+
+// newNDJSONWriter は標準出力か、output があればそのファイルに書く ndjsonWriter を作る
+func newNDJSONWriter(output, path string, color bool) (*ndjsonWriter, error) {
+	n := &ndjsonWriter{w: os.Stdout, path: path, pretty: output == "", color: color}
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return nil, err
+		}
+		n.w = f
+	}
+	return n, nil
+}
+
+// streamNDJSON は r の本文を受け取った行から n で処理し、最後に件数を表示する
+func streamNDJSON(fetcher *gofetch.Client, r gofetch.Request, n *ndjsonWriter) (gofetch.Response, error) {
+	res, err := fetcher.Stream(context.Background(), r, n)
+	if err == nil {
+		err = n.flush()
+	}
+	// エラーのステータスの本文は行ごとに処理せず、そのまま出力する
+	if n.line > 0 || res.StatusCode >= 200 && res.StatusCode <= 299 {
+		fmt.Fprintln(os.Stderr, n.summary())
+	}
+	return res, err
+}
+
+// Write は改行までそろった行を処理する
+func (n *ndjsonWriter) Write(p []byte) (int, error) {
+	n.rest = append(n.rest, p...)
+	for {
+		i := bytes.IndexByte(n.rest, '\n')
+		if i < 0 {
+			break
+		}
+		line := n.rest[:i]
+		n.rest = n.rest[i+1:]
+		if err := n.record(line); err != nil {
+			return 0, err
+		}
+	}
+	// 処理した行の分を詰めて、長いストリームで先頭の領域が残り続けないようにする
+	n.rest = append([]byte(nil), n.rest...)
+	return len(p), nil
+}
+
+// flush は改行で終わらなかった最後の行を処理する
+func (n *ndjsonWriter) flush() error {
+	if len(n.rest) == 0 {
+		return nil
+	}
+	line := n.rest
+	n.rest = nil
+	return n.record(line)
+}
+
+// record は1行を処理して書く
+func (n *ndjsonWriter) record(line []byte) error {
+	n.line++
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+	if !json.Valid(line) {
+		n.invalid++
+		verbosef("NDJSON: line %d is not JSON", n.line)
+		if n.path != "" {
+			return nil
+		}
+		_, err := fmt.Fprintf(n.w, "%s\n", line)
+		return err
+	}
+	var out []byte
+	switch {
+	case n.path != "":
+		v, err := extractJSONPath(line, n.path, n.color)
+		if err != nil {
+			n.missing++
+			verbosef("NDJSON: line %d: %v", n.line, err)
+			return nil
+		}
+		out = v
+	case n.pretty:
+		out, _ = prettyJSON(line, n.color)
+	default:
+		out = line
+	}
+	n.records++
+	_, err := fmt.Fprintf(n.w, "%s\n", out)
+	return err
+}
+
+// summary は件数のまとめを返す
+func (n *ndjsonWriter) summary() string {
+	s := fmt.Sprintf("NDJSON: %d record(s)", n.records)
+	if n.invalid > 0 {
+		s += fmt.Sprintf(", %d line(s) not JSON", n.invalid)
+	}
+	if n.missing > 0 {
+		s += fmt.Sprintf(", %d without a value at %s", n.missing, n.path)
+	}
+	return s
+}
@@ -0,0 +1,161 @@
+package main
+
+// コンテンツネゴシエーションの確認 (--negotiate-matrix, --negotiate)
+// 同じURLを Accept、Accept-Language、Accept-Encoding の値を1つずつ変えて取得し、
+// ステータス、Content-Type、本文のハッシュなどの違いを表にする
+// 値によってレスポンスが変わるのに Vary に含まれていないヘッダーは、CDNが誤ったキャッシュを返す原因になるので警告する
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// negotiateVariation は値を変えて試すヘッダー1つ
+type negotiateVariation struct {
+	Header string
+	Values []string
+}
+
+// defaultNegotiateVariations は --negotiate-matrix で試す既定の値
+var defaultNegotiateVariations = []negotiateVariation{
+	{"Accept", []string{"text/html", "application/json", "image/webp", "*/*"}},
+	{"Accept-Language", []string{"en", "ja", "de"}},
+	{"Accept-Encoding", []string{"identity", "gzip", "br", "zstd"}},
+}
+
+// parseNegotiateVariation は "Header=v1|v2|v3" の形の指定を解析する
+func parseNegotiateVariation(spec string) (negotiateVariation, error) {
+	header, values, ok := strings.Cut(spec, "=")
+	if !ok || header == "" || values == "" {
+		return negotiateVariation{}, fmt.Errorf("invalid --negotiate %q (want Header=value1|value2)", spec)
+	}
+	return negotiateVariation{Header: http.CanonicalHeaderKey(strings.TrimSpace(header)), Values: strings.Split(values, "|")}, nil
+}
+
+// negotiateVariations は既定の値に --negotiate の指定を重ねる
+// 既定にないヘッダーは後ろに追加する
+func negotiateVariations(specs []string) ([]negotiateVariation, error) {
+	variations := append([]negotiateVariation(nil), defaultNegotiateVariations...)
+	for _, spec := range specs {
+		v, err := parseNegotiateVariation(spec)
+		if err != nil {
+			return nil, err
+		}
+		replaced := false
+		for i := range variations {
+			if variations[i].Header == v.Header {
+				variations[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			variations = append(variations, v)
+		}
+	}
+	return variations, nil
+}
+
+// negotiateResult はヘッダーの値1つでの取得結果
+type negotiateResult struct {
+	Header   string
+	Value    string
+	Status   int
+	Type     string
+	Language string
+	Encoding string
+	Vary     string
+	Size     int64
+	SHA256   string
+	Err      error
+}
+
+// fetchNegotiated はヘッダーを1つ加えてURLを取得する
+// header が空なら何も加えない
+func fetchNegotiated(client *http.Client, rawURL string, base http.Header, header, value string) negotiateResult {
+	r := negotiateResult{Header: header, Value: value}
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	req.Header = base.Clone()
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer resp.Body.Close()
+	h := sha256.New()
+	r.Size, r.Err = io.Copy(h, resp.Body)
+	r.Status = resp.StatusCode
+	r.Type = resp.Header.Get("Content-Type")
+	r.Language = resp.Header.Get("Content-Language")
+	r.Encoding = resp.Header.Get("Content-Encoding")
+	r.Vary = strings.Join(resp.Header.Values("Vary"), ", ")
+	r.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return r
+}
+
+// runNegotiateMatrix はヘッダーの値を1つずつ変えて取得し、結果の表と Vary の検査結果を表示する
+// Vary の不足が見つかれば false を返す
+func runNegotiateMatrix(client *http.Client, rawURL string, base http.Header, variations []negotiateVariation) bool {
+	baseline := fetchNegotiated(client, rawURL, base, "", "")
+	results := []negotiateResult{baseline}
+	for _, v := range variations {
+		for _, value := range v.Values {
+			results = append(results, fetchNegotiated(client, rawURL, base, v.Header, value))
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HEADER\tVALUE\tSTATUS\tTYPE\tLANG\tENC\tSIZE\tSHA256")
+	for _, r := range results {
+		header := r.Header
+		if header == "" {
+			header = "(none)"
+		}
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\t%s\tERROR\t\t\t\t\t%v\n", header, r.Value, r.Err)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", header, r.Value, r.Status,
+			orDash(r.Type), orDash(r.Language), orDash(r.Encoding), formatSize(r.Size), r.SHA256[:12])
+	}
+	tw.Flush()
+
+	vary := map[string]bool{}
+	for _, name := range splitList(baseline.Vary) {
+		vary[http.CanonicalHeaderKey(name)] = true
+	}
+	fmt.Printf("Vary: %s\n", orDash(baseline.Vary))
+	ok := true
+	for _, v := range variations {
+		hashes := map[string]bool{}
+		for _, r := range results {
+			if r.Header == v.Header && r.Err == nil {
+				hashes[fmt.Sprintf("%d %s", r.Status, r.SHA256)] = true
+			}
+		}
+		switch {
+		case len(hashes) > 1 && !vary[v.Header] && !vary["*"]:
+			fmt.Printf("Warning: responses differ by %s but Vary does not include it\n", v.Header)
+			ok = false
+		case len(hashes) > 1:
+			fmt.Printf("%s: response varies (listed in Vary)\n", v.Header)
+		case vary[v.Header]:
+			fmt.Printf("%s: listed in Vary but no difference was observed\n", v.Header)
+		default:
+			fmt.Printf("%s: no difference\n", v.Header)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,409 @@
+package main
+
+// コンテナレジストリの操作 (gofetch oci)
+// OCI Distribution API でマニフェストの取得、タグの一覧、レイヤー(blob)のダウンロードを行う
+// 401が返ったときは WWW-Authenticate に従ってトークンを取得し直す (Docker Registry Token Authentication)
+// crane などを入れずにレジストリの問題を調べるために使う
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// oci のヘルプメッセージ
+	OCIHelpMessage = `
+Usage: gofetch oci <manifest|tags|blob> <reference> [options]
+Commands:
+  manifest      Fetch and pretty-print a manifest (ref: [registry/]repo[:tag|@digest])
+  tags          List tags of a repository (ref: [registry/]repo)
+  blob          Download a blob/layer by digest (ref: [registry/]repo@sha256:...)
+Options:
+  -o, --output  Output file for blob (default: stdout)
+  --user        Credentials as user:password (default: $GOFETCH_OCI_USER)
+  --plain-http  Use http instead of https (for local registries)
+  -t, --timeout Timeout in seconds (default: 30)
+  --verbose     Print requests and authentication steps to stderr
+`
+
+	// Docker Hub の名前とAPIのホスト
+	dockerHubName = "docker.io"
+	dockerHubHost = "registry-1.docker.io"
+)
+
+// manifestAccept はマニフェストを要求するときに受け付ける形式
+var manifestAccept = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociReference はイメージの参照を分解したもの
+type ociReference struct {
+	Registry   string
+	Repository string
+	// Reference はタグまたはダイジェスト。省略された場合は空
+	Reference string
+}
+
+// parseOCIReference は "registry/repo:tag" や "repo@sha256:..." の形の参照を解析する
+// レジストリを省略した場合は Docker Hub として扱う
+func parseOCIReference(s string) (ociReference, error) {
+	var ref ociReference
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.Reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.Reference = name[:i], name[i+1:]
+	}
+	first, rest, ok := strings.Cut(name, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.Registry, ref.Repository = first, rest
+	} else {
+		ref.Registry, ref.Repository = dockerHubName, name
+	}
+	if ref.Repository == "" {
+		return ref, fmt.Errorf("invalid reference %q", s)
+	}
+	if ref.Registry == dockerHubName {
+		ref.Registry = dockerHubHost
+		if !strings.Contains(ref.Repository, "/") {
+			ref.Repository = "library/" + ref.Repository
+		}
+	}
+	return ref, nil
+}
+
+// ociClient はトークン認証に対応したレジストリのクライアント
+type ociClient struct {
+	client   *http.Client
+	scheme   string
+	user     string
+	password string
+	// token は取得済みのBearerトークン
+	token string
+}
+
+// runOCI は oci サブコマンドを実行して終了コードを返す
+func runOCI(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(OCIHelpMessage)
+		return 0
+	}
+	command := args[0]
+
+	fs := flag.NewFlagSet("oci "+command, flag.ContinueOnError)
+	output := fs.String("o", "", "Output file for blob")
+	fs.StringVar(output, "output", "", "Output file for blob")
+	user := fs.String("user", os.Getenv("GOFETCH_OCI_USER"), "Credentials as user:password")
+	plainHTTP := fs.Bool("plain-http", false, "Use http instead of https")
+	timeout := fs.Int("t", 30, "Timeout in seconds")
+	fs.IntVar(timeout, "timeout", 30, "Timeout in seconds")
+	fs.BoolVar(&verbose, "verbose", false, "Print diagnostic information to stderr")
+	// 参照はオプションの前後どちらにも書けるようにする
+	var positional []string
+	rest := args[1:]
+	for {
+		if err := fs.Parse(rest); err != nil {
+			return 1
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		fmt.Println("Error: exactly one reference is required")
+		fmt.Print(OCIHelpMessage)
+		return 1
+	}
+	ref, err := parseOCIReference(positional[0])
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+
+	c := &ociClient{
+		client: &http.Client{Timeout: time.Duration(*timeout) * time.Second},
+		scheme: "https",
+	}
+	if *plainHTTP {
+		c.scheme = "http"
+	}
+	if *user != "" {
+		c.user, c.password, _ = strings.Cut(*user, ":")
+	}
+
+	switch command {
+	case "manifest":
+		err = c.printManifest(ref)
+	case "tags":
+		err = c.printTags(ref)
+	case "blob":
+		err = c.downloadBlob(ref, *output)
+	default:
+		fmt.Println("Error: unknown oci command:", command)
+		fmt.Print(OCIHelpMessage)
+		return 1
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// do はレジストリにリクエストを送る
+// 401が返った場合はチャレンジに従って認証し、1度だけやり直す
+func (c *ociClient) do(method, rawURL string, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		switch {
+		case c.token != "":
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		case c.user != "":
+			req.SetBasicAuth(c.user, c.password)
+		}
+		verbosef("OCI: %s %s", method, rawURL)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := c.authenticate(challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authenticate は WWW-Authenticate のチャレンジに応じて認証情報を用意する
+func (c *ociClient) authenticate(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.user == "" {
+			return errors.New("registry requires credentials (use --user)")
+		}
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	values := map[string]string{}
+	for _, p := range splitQuoted(params, ',') {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		values[strings.ToLower(k)] = strings.Trim(v, `"`)
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return fmt.Errorf("invalid bearer challenge %q", challenge)
+	}
+	q := realm.Query()
+	for _, k := range []string{"service", "scope"} {
+		if values[k] != "" {
+			q.Set(k, values[k])
+		}
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	verbosef("OCI: requesting token from %s", realm)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request failed: %s", resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	c.token = tok.Token
+	if c.token == "" {
+		c.token = tok.AccessToken
+	}
+	if c.token == "" {
+		return errors.New("token response did not contain a token")
+	}
+	return nil
+}
+
+// apiURL はレジストリのAPIのURLを返す
+func (c *ociClient) apiURL(ref ociReference, kind, name string) string {
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", c.scheme, ref.Registry, ref.Repository, kind, name)
+}
+
+// checkOCIResponse はエラーのレスポンスをレジストリのエラーメッセージ付きのエラーにする
+func checkOCIResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	var body struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &body) == nil && len(body.Errors) > 0 {
+		return fmt.Errorf("%s: %s: %s", resp.Status, body.Errors[0].Code, body.Errors[0].Message)
+	}
+	return errors.New(resp.Status)
+}
+
+// printManifest はマニフェストを整形して表示する
+func (c *ociClient) printManifest(ref ociReference) error {
+	if ref.Reference == "" {
+		ref.Reference = "latest"
+	}
+	resp, err := c.do(http.MethodGet, c.apiURL(ref, "manifests", ref.Reference), manifestAccept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkOCIResponse(resp); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		sum := sha256.Sum256(data)
+		digest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	fmt.Fprintf(os.Stderr, "Content-Type: %s\nDigest: %s\n", resp.Header.Get("Content-Type"), digest)
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// printTags はタグを1行に1つずつ表示する
+// Link ヘッダーによるページ分けをたどる
+func (c *ociClient) printTags(ref ociReference) error {
+	next := c.apiURL(ref, "tags", "list")
+	for next != "" {
+		resp, err := c.do(http.MethodGet, next, nil)
+		if err != nil {
+			return err
+		}
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = checkOCIResponse(resp)
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&list)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, tag := range list.Tags {
+			fmt.Println(tag)
+		}
+		next = nextLink(resp)
+	}
+	return nil
+}
+
+// nextLink は Link ヘッダーの rel="next" のURLをリクエストのURLを基準に解決して返す
+func nextLink(resp *http.Response) string {
+	for _, link := range resp.Header.Values("Link") {
+		target, params, _ := strings.Cut(link, ";")
+		if !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		u, err := resp.Request.URL.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+		return u.String()
+	}
+	return ""
+}
+
+// downloadBlob はダイジェストで指定したblobを保存し、内容がダイジェストと一致するか確認する
+func (c *ociClient) downloadBlob(ref ociReference, output string) error {
+	algo, want, ok := strings.Cut(ref.Reference, ":")
+	if !ok || algo != "sha256" {
+		return fmt.Errorf("blob requires a sha256 digest reference (repo@sha256:...), got %q", ref.Reference)
+	}
+	resp, err := c.do(http.MethodGet, c.apiURL(ref, "blobs", ref.Reference), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkOCIResponse(resp); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if output != "" {
+		f, err = os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), resp.Body)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		if f != nil {
+			f.Close()
+			os.Remove(output)
+		}
+		return fmt.Errorf("digest mismatch: got sha256:%s", got)
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Downloaded %s (%s, digest verified)\n", ref.Reference, formatSize(n))
+	return nil
+}
@@ -0,0 +1,717 @@
+package main
+
+// OpenAPIの仕様に基づくリクエストの組み立て (gofetch api)
+// 仕様(YAMLまたはJSON、ファイルまたはURL)から operationId で操作を選び、
+// パラメーターを検証してリクエストを組み立てる
+// 本文を指定しなければ仕様の例(なければスキーマ)から作り、
+// レスポンスはスキーマに合っているかを確認する
+// スキーマの検証は type、enum、required、properties、items、allOf/anyOf/oneOf、$ref に対応する
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// api のヘルプメッセージ
+	APIHelpMessage = `
+Usage: gofetch api --spec <file|url> [options] <operationId>
+       gofetch api --spec <file|url> --list
+Options:
+  --spec        OpenAPI 3 document (YAML or JSON, file or URL) (required)
+  --list        List operations in the document
+  --param       Parameter as name=value (repeatable)
+  --body        Request body as JSON, or @file (default: generated from examples)
+  --server      Base URL (default: first entry of servers)
+  -H, --header  Extra request header as "Name: value" (repeatable)
+                Header values and --body may use {{env "NAME"}}, {{file "path"}} and
+                {{secret "vault:path#field"}} or {{secret "aws:id#field"}}
+  -t, --timeout Timeout in seconds (default: 30)
+  --yes         Answer yes when a config profile requires confirmation
+  --verbose     Print the request and validation details to stderr
+`
+)
+
+// apiMethods は仕様の path item に書ける操作のメソッド
+var apiMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// apiSpec は読み込んだOpenAPIの文書
+type apiSpec struct {
+	root map[string]any
+	// source は仕様を読み込んだURL。相対的な servers の解決に使う
+	source *url.URL
+}
+
+// apiOperation は仕様の中の操作1つ
+type apiOperation struct {
+	ID     string
+	Method string
+	Path   string
+	Params []apiParam
+	op     map[string]any
+}
+
+// apiParam は操作のパラメーター1つ
+type apiParam struct {
+	Name     string
+	In       string
+	Required bool
+	Schema   map[string]any
+}
+
+// runAPI は api サブコマンドを実行して終了コードを返す
+func runAPI(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		fmt.Print(APIHelpMessage)
+		return 0
+	}
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	specPath := fs.String("spec", "", "OpenAPI document (file or URL)")
+	list := fs.Bool("list", false, "List operations")
+	var params, headers stringList
+	fs.Var(&params, "param", "Parameter as name=value (repeatable)")
+	body := fs.String("body", "", "Request body as JSON, or @file")
+	server := fs.String("server", "", "Base URL")
+	fs.Var(&headers, "H", "Extra request header (repeatable)")
+	fs.Var(&headers, "header", "Extra request header (repeatable)")
+	timeout := fs.Int("t", 30, "Timeout in seconds")
+	fs.IntVar(timeout, "timeout", 30, "Timeout in seconds")
+	yes := fs.Bool("yes", false, "Answer yes to confirmation prompts")
+	fs.BoolVar(&verbose, "verbose", false, "Print diagnostic information to stderr")
+	// operationId はオプションの前後どちらにも書けるようにする
+	var positional []string
+	rest := args
+	for {
+		if err := fs.Parse(rest); err != nil {
+			return 1
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		rest = fs.Args()[1:]
+	}
+	if *specPath == "" {
+		fmt.Println("Error: --spec is required")
+		fmt.Print(APIHelpMessage)
+		return 1
+	}
+
+	client := &http.Client{Timeout: time.Duration(*timeout) * time.Second}
+	spec, err := loadAPISpec(client, *specPath)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	ops := spec.operations()
+
+	if *list {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "OPERATION\tMETHOD\tPATH\tSUMMARY")
+		for _, op := range ops {
+			summary, _ := op.op["summary"].(string)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", orDash(op.ID), op.Method, op.Path, summary)
+		}
+		tw.Flush()
+		return 0
+	}
+	if len(positional) != 1 {
+		fmt.Println("Error: exactly one operationId is required")
+		fmt.Print(APIHelpMessage)
+		return 1
+	}
+	var op *apiOperation
+	for i := range ops {
+		if ops[i].ID == positional[0] {
+			op = &ops[i]
+		}
+	}
+	if op == nil {
+		fmt.Printf("Error: unknown operation %q (use --list)\n", positional[0])
+		return 1
+	}
+
+	values := map[string]string{}
+	for _, p := range params {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok {
+			fmt.Printf("Error: invalid --param %q (want name=value)\n", p)
+			return 1
+		}
+		values[name] = value
+	}
+	var reqBody []byte
+	if strings.HasPrefix(*body, "@") {
+		reqBody, err = os.ReadFile((*body)[1:])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	} else if *body != "" {
+		reqBody = []byte(*body)
+	}
+	if reqBody != nil {
+		expanded, err := expandSecrets(string(reqBody))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		reqBody = []byte(expanded)
+	}
+
+	base, err := spec.serverURL(*server)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	req, err := spec.buildRequest(op, base, values, reqBody)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			fmt.Printf("Error: invalid header %q (want \"Name: value\")\n", h)
+			return 1
+		}
+		value, err := expandSecretHeader(name, strings.TrimSpace(value))
+		if err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+		req.Header.Add(strings.TrimSpace(name), value)
+	}
+
+	// 設定ファイルのプロファイルの制限
+	conf, err := loadConfig()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	if name, p := conf.profileForURL(req.URL.String()); p != nil {
+		if err := p.enforce(name, req.Method, req.URL.String(), newConfirmer(*yes)); err != nil {
+			fmt.Println("Error:", err)
+			return 1
+		}
+	}
+
+	verbosef("API: %s %s", req.Method, req.URL)
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "Status:", resp.Status)
+	if json.Valid(data) {
+		printJSON(data)
+	} else {
+		fmt.Println(string(data))
+	}
+
+	problems, checked := spec.validateResponse(op, resp.StatusCode, resp.Header.Get("Content-Type"), data)
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, "Schema:", p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	if checked {
+		verbosef("API: response matches the schema")
+	}
+	return 0
+}
+
+// loadAPISpec はファイルまたはURLからOpenAPIの文書を読み込む
+func loadAPISpec(client *http.Client, src string) (*apiSpec, error) {
+	spec := &apiSpec{}
+	var data []byte
+	var err error
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		spec.source, err = url.Parse(src)
+		if err != nil {
+			return nil, err
+		}
+		data, err = fetchDocument(client, spec.source)
+	} else {
+		data, err = os.ReadFile(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &spec.root); err != nil {
+		return nil, fmt.Errorf("%s: %w", src, err)
+	}
+	if v, _ := spec.root["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("%s: not an OpenAPI 3 document", src)
+	}
+	return spec, nil
+}
+
+// resolve は $ref をたどって参照先のオブジェクトを返す
+// 文書内の参照 (#/...) だけに対応する
+func (s *apiSpec) resolve(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	for i := 0; m != nil && i < 32; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		var cur any = s.root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+			obj, _ := cur.(map[string]any)
+			cur = obj[part]
+		}
+		m, _ = cur.(map[string]any)
+	}
+	return m
+}
+
+// operations は文書のすべての操作をパスとメソッドの順に返す
+func (s *apiSpec) operations() []apiOperation {
+	paths, _ := s.root["paths"].(map[string]any)
+	names := make([]string, 0, len(paths))
+	for p := range paths {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	var ops []apiOperation
+	for _, p := range names {
+		item := s.resolve(paths[p])
+		for _, method := range apiMethods {
+			op, ok := item[method].(map[string]any)
+			if !ok {
+				continue
+			}
+			id, _ := op["operationId"].(string)
+			ops = append(ops, apiOperation{
+				ID:     id,
+				Method: strings.ToUpper(method),
+				Path:   p,
+				Params: s.parameters(item["parameters"], op["parameters"]),
+				op:     op,
+			})
+		}
+	}
+	return ops
+}
+
+// parameters はパス共通のパラメーターと操作のパラメーターをまとめる
+// 同じ名前と場所のものは操作の定義が優先される
+func (s *apiSpec) parameters(lists ...any) []apiParam {
+	var params []apiParam
+	index := map[string]int{}
+	for _, list := range lists {
+		items, _ := list.([]any)
+		for _, raw := range items {
+			m := s.resolve(raw)
+			name, _ := m["name"].(string)
+			in, _ := m["in"].(string)
+			required, _ := m["required"].(bool)
+			p := apiParam{Name: name, In: in, Required: required || in == "path", Schema: s.resolve(m["schema"])}
+			if i, ok := index[in+":"+name]; ok {
+				params[i] = p
+				continue
+			}
+			index[in+":"+name] = len(params)
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// serverURL はリクエスト先のベースURLを返す
+// 指定がなければ servers の最初の項目を、変数を既定値で置き換えて使う
+func (s *apiSpec) serverURL(override string) (*url.URL, error) {
+	raw := override
+	if raw == "" {
+		servers, _ := s.root["servers"].([]any)
+		if len(servers) == 0 {
+			return nil, errors.New("the document has no servers (use --server)")
+		}
+		server, _ := servers[0].(map[string]any)
+		raw, _ = server["url"].(string)
+		vars, _ := server["variables"].(map[string]any)
+		for name, v := range vars {
+			def, _ := v.(map[string]any)["default"]
+			raw = strings.ReplaceAll(raw, "{"+name+"}", fmt.Sprint(def))
+		}
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() {
+		if s.source == nil {
+			return nil, fmt.Errorf("relative server URL %q (use --server)", raw)
+		}
+		u = s.source.ResolveReference(u)
+	}
+	return u, nil
+}
+
+// buildRequest はパラメーターを検証してリクエストを組み立てる
+func (s *apiSpec) buildRequest(op *apiOperation, base *url.URL, values map[string]string, body []byte) (*http.Request, error) {
+	known := map[string]bool{}
+	path := op.Path
+	query := url.Values{}
+	header := http.Header{}
+	for _, p := range op.Params {
+		known[p.Name] = true
+		value, ok := values[p.Name]
+		if !ok {
+			if p.Required {
+				return nil, fmt.Errorf("missing required %s parameter %q", p.In, p.Name)
+			}
+			continue
+		}
+		if err := s.checkParam(p, value); err != nil {
+			return nil, err
+		}
+		switch p.In {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.Name+"}", url.PathEscape(value))
+		case "query":
+			query.Set(p.Name, value)
+		case "header":
+			header.Set(p.Name, value)
+		case "cookie":
+			header.Add("Cookie", p.Name+"="+value)
+		}
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown parameter %q for %s", name, op.ID)
+		}
+	}
+
+	u := *base
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if reqBody := s.resolve(op.op["requestBody"]); reqBody != nil {
+		if body == nil {
+			body = s.exampleBody(reqBody)
+			if body != nil {
+				verbosef("API: using generated request body %s", body)
+			}
+		}
+		if body != nil {
+			header.Set("Content-Type", "application/json")
+		}
+		if required, _ := reqBody["required"].(bool); required && body == nil {
+			return nil, errors.New("request body is required (use --body)")
+		}
+	}
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(op.Method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// checkParam はパラメーターの値をスキーマの型と列挙値で検証する
+func (s *apiSpec) checkParam(p apiParam, value string) error {
+	var v any = value
+	switch p.Schema["type"] {
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("parameter %q must be an integer, got %q", p.Name, value)
+		}
+		v, _ = strconv.ParseFloat(value, 64)
+	case "number":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("parameter %q must be a number, got %q", p.Name, value)
+		}
+		v = f
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("parameter %q must be a boolean, got %q", p.Name, value)
+		}
+		v = b
+	}
+	if enum, ok := p.Schema["enum"].([]any); ok && !enumContains(enum, v) {
+		return fmt.Errorf("parameter %q must be one of %v, got %q", p.Name, enum, value)
+	}
+	return nil
+}
+
+// exampleBody は requestBody の例から本文を作る
+// 例がなければスキーマから値を組み立てる
+func (s *apiSpec) exampleBody(reqBody map[string]any) []byte {
+	content, _ := reqBody["content"].(map[string]any)
+	media, _ := content["application/json"].(map[string]any)
+	if media == nil {
+		return nil
+	}
+	var example any
+	if v, ok := media["example"]; ok {
+		example = v
+	} else if examples, ok := media["examples"].(map[string]any); ok && len(examples) > 0 {
+		names := make([]string, 0, len(examples))
+		for name := range examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		example = s.resolve(examples[names[0]])["value"]
+	} else if schema := s.resolve(media["schema"]); schema != nil {
+		example = s.exampleFromSchema(schema, 0)
+	}
+	if example == nil {
+		return nil
+	}
+	data, err := json.Marshal(example)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// exampleFromSchema はスキーマの例、既定値、列挙値、型から値を作る
+func (s *apiSpec) exampleFromSchema(schema map[string]any, depth int) any {
+	if schema == nil || depth > 8 {
+		return nil
+	}
+	for _, k := range []string{"example", "default"} {
+		if v, ok := schema[k]; ok {
+			return v
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		merged := map[string]any{}
+		for _, sub := range all {
+			if m, ok := s.exampleFromSchema(s.resolve(sub), depth+1).(map[string]any); ok {
+				for k, v := range m {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+	for _, k := range []string{"oneOf", "anyOf"} {
+		if list, ok := schema[k].([]any); ok && len(list) > 0 {
+			return s.exampleFromSchema(s.resolve(list[0]), depth+1)
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		obj := map[string]any{}
+		props, _ := schema["properties"].(map[string]any)
+		for name, prop := range props {
+			obj[name] = s.exampleFromSchema(s.resolve(prop), depth+1)
+		}
+		return obj
+	case "array":
+		return []any{s.exampleFromSchema(s.resolve(schema["items"]), depth+1)}
+	case "integer", "number":
+		return 0
+	case "boolean":
+		return false
+	case "string":
+		return "string"
+	}
+	if _, ok := schema["properties"]; ok {
+		return s.exampleFromSchema(mergeMap(schema, map[string]any{"type": "object"}), depth)
+	}
+	return nil
+}
+
+// mergeMap は2つのマップを合わせた新しいマップを返す
+func mergeMap(a, b map[string]any) map[string]any {
+	m := make(map[string]any, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// validateResponse はレスポンスの本文をステータスに対応するスキーマで検証する
+// 検証するスキーマがなければ checked に false を返す
+func (s *apiSpec) validateResponse(op *apiOperation, status int, contentType string, data []byte) (problems []string, checked bool) {
+	responses, _ := op.op["responses"].(map[string]any)
+	code := strconv.Itoa(status)
+	var resp map[string]any
+	for _, key := range []string{code, code[:1] + "XX", "default"} {
+		if r, ok := responses[key]; ok {
+			resp = s.resolve(r)
+			break
+		}
+	}
+	if resp == nil {
+		if len(responses) > 0 {
+			return []string{fmt.Sprintf("status %d is not documented", status)}, true
+		}
+		return nil, false
+	}
+	content, _ := resp["content"].(map[string]any)
+	media, _ := content["application/json"].(map[string]any)
+	schema := s.resolve(media["schema"])
+	if schema == nil {
+		return nil, false
+	}
+	if !strings.Contains(contentType, "json") {
+		return []string{fmt.Sprintf("expected a JSON response, got Content-Type %q", contentType)}, true
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return []string{"invalid JSON: " + err.Error()}, true
+	}
+	s.validate(schema, value, "$", &problems)
+	return problems, true
+}
+
+// validate は値がスキーマに合っているかを調べ、合わない箇所を problems に追加する
+func (s *apiSpec) validate(schema map[string]any, value any, path string, problems *[]string) {
+	if schema == nil {
+		return
+	}
+	if value == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || schema["type"] == nil {
+			return
+		}
+		*problems = append(*problems, fmt.Sprintf("%s: null is not allowed", path))
+		return
+	}
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			s.validate(s.resolve(sub), value, path, problems)
+		}
+	}
+	for _, k := range []string{"anyOf", "oneOf"} {
+		list, ok := schema[k].([]any)
+		if !ok {
+			continue
+		}
+		matched := false
+		for _, sub := range list {
+			var subProblems []string
+			s.validate(s.resolve(sub), value, path, &subProblems)
+			if len(subProblems) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			*problems = append(*problems, fmt.Sprintf("%s: does not match any schema in %s", path, k))
+		}
+	}
+
+	if t, ok := schema["type"].(string); ok && !jsonTypeMatches(t, value) {
+		*problems = append(*problems, fmt.Sprintf("%s: expected %s, got %s", path, t, jsonTypeName(value)))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok && !enumContains(enum, value) {
+		*problems = append(*problems, fmt.Sprintf("%s: %v is not one of %v", path, value, enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		required, _ := schema["required"].([]any)
+		for _, r := range required {
+			name := fmt.Sprint(r)
+			if _, ok := v[name]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: missing required property %q", path, name))
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := props[name]; ok {
+				s.validate(s.resolve(prop), v[name], path+"."+name, problems)
+			}
+		}
+	case []any:
+		items := s.resolve(schema["items"])
+		for i, item := range v {
+			s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	}
+}
+
+// jsonTypeMatches はJSONの値がスキーマの型に合うかを返す
+func jsonTypeMatches(t string, value any) bool {
+	switch v := value.(type) {
+	case map[string]any:
+		return t == "object"
+	case []any:
+		return t == "array"
+	case string:
+		return t == "string"
+	case bool:
+		return t == "boolean"
+	case float64:
+		return t == "number" || (t == "integer" && v == math.Trunc(v))
+	}
+	return false
+}
+
+// jsonTypeName はJSONの値の型の名前を返す
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	}
+	return "null"
+}
+
+// enumContains は列挙値に値が含まれるかを返す
+// YAMLとJSONで数値の型が異なるので文字列にして比べる
+func enumContains(enum []any, value any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+// 途中までの本文の保存 (--keep-partial)
+// 本文の受信中にタイムアウトしたり接続が切れたりしたとき、受信できた分を捨てずに
+// .partial を付けたファイルに保存し、専用の終了コードで不完全であることを伝える
+// 再開できるパイプラインで、続きから取得するために使う
+
+import (
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+)
+
+// exitPartial は本文が途中までしか受信できなかったときの終了コード
+const exitPartial = 3
+
+// partialSuffix は途中までの本文を保存するファイルに付ける拡張子
+const partialSuffix = ".partial"
+
+// writePartial は途中までの本文を保存し、保存先を返す
+// output が空なら標準出力に書く。受信者を指定した場合は暗号化して保存する
+func writePartial(output string, body []byte, recipients []age.Recipient) (string, error) {
+	if output == "" {
+		_, err := os.Stdout.Write(body)
+		return "stdout", err
+	}
+	path := output + partialSuffix
+	if len(recipients) > 0 {
+		return path, writeEncrypted(path, body, recipients)
+	}
+	return path, os.WriteFile(path, body, 0644)
+}
+
+// reportPartial は保存した本文の大きさと、期待された大きさを表示する
+func reportPartial(dest string, received, expected int64) {
+	if expected > 0 {
+		fmt.Fprintf(os.Stderr, "Partial: kept %s of %s in %s\n", formatSize(received), formatSize(expected), dest)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Partial: kept %s in %s\n", formatSize(received), dest)
+}
@@ -0,0 +1,185 @@
+package main
+
+// 外部実行ファイルによるプラグイン
+// PATH上の gofetch-<name> は "gofetch <name>" として、
+// gofetch-proto-<scheme> は <scheme>:// のURLを扱うプロトコルハンドラーとして呼び出す
+//
+// プロトコルハンドラーには標準入力でリクエストをJSONで渡す
+//
+//	{"method": "GET", "url": "s3://bucket/key", "headers": {"Accept": ["*/*"]}, "body": "<base64>"}
+//
+// ハンドラーは標準出力にレスポンスをJSONで返し、失敗した場合は0以外の終了コードで終了する
+//
+//	{"status": 200, "headers": {"Content-Type": ["text/plain"]}, "body": "<base64>"}
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// プラグインの実行ファイル名の接頭辞
+	pluginPrefix = "gofetch-"
+	// プロトコルハンドラーの実行ファイル名の接頭辞
+	protocolPluginPrefix = "gofetch-proto-"
+)
+
+// pluginRequest はプロトコルハンドラーに渡すリクエスト
+type pluginRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// pluginResponse はプロトコルハンドラーが返すレスポンス
+type pluginResponse struct {
+	Status  int         `json:"status"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// runPlugin は gofetch-<name> がPATHにあれば実行して終了コードを返す
+// 見つからなければ ok に false を返す
+func runPlugin(name string, args []string) (code int, ok bool) {
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return 0, false
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), true
+		}
+		fmt.Println("Error:", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// listPlugins はPATH上のプラグインを名前順に返す
+func listPlugins() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			if !strings.HasPrefix(name, pluginPrefix) || seen[name] || e.IsDir() {
+				continue
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runPluginList は plugins サブコマンドとして見つかったプラグインを表示する
+func runPluginList() int {
+	plugins := listPlugins()
+	if len(plugins) == 0 {
+		fmt.Println("No plugins found on PATH")
+		return 0
+	}
+	for _, name := range plugins {
+		if scheme, ok := strings.CutPrefix(name, protocolPluginPrefix); ok {
+			fmt.Printf("%-30s protocol handler for %s://\n", name, scheme)
+		} else {
+			fmt.Printf("%-30s command: gofetch %s\n", name, strings.TrimPrefix(name, pluginPrefix))
+		}
+	}
+	return 0
+}
+
+// protocolPlugin はプロトコルハンドラーを呼び出す http.RoundTripper
+type protocolPlugin struct {
+	path string
+}
+
+// findProtocolPlugin はスキームに対応するプロトコルハンドラーを探す
+func findProtocolPlugin(scheme string) (*protocolPlugin, bool) {
+	path, err := exec.LookPath(protocolPluginPrefix + strings.ToLower(scheme))
+	if err != nil {
+		return nil, false
+	}
+	return &protocolPlugin{path: path}, true
+}
+
+// RoundTrip はリクエストをJSONにしてハンドラーに渡し、返ってきたJSONをレスポンスにする
+func (p *protocolPlugin) RoundTrip(req *http.Request) (*http.Response, error) {
+	in := pluginRequest{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: req.Header,
+	}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		in.Body = body
+	}
+	input, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(req.Context(), p.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	verbosef("Plugin: running %s", p.path)
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("%s: %s", filepath.Base(p.path), msg)
+	}
+
+	var out pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %w", filepath.Base(p.path), err)
+	}
+	if out.Status == 0 {
+		out.Status = http.StatusOK
+	}
+	if out.Headers == nil {
+		out.Headers = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", out.Status, http.StatusText(out.Status)),
+		StatusCode:    out.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        out.Headers,
+		Body:          io.NopCloser(bytes.NewReader(out.Body)),
+		ContentLength: int64(len(out.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+// 画像のプレビュー (--preview, --preview-mode)
+// 画像を端末の幅に合わせて縮小し、sixel、iTerm2 のインライン画像、ASCII のいずれかで表示する
+// auto (既定) は TERM_PROGRAM と LC_TERMINAL で iTerm2 と WezTerm を、TERM で sixel に対応した端末を判断し、
+// どちらでもなければ ASCII にする。tmux や screen の中では判断できないので、--preview-mode で指定する
+// sixel と iTerm2 は幅 320 ピクセルまで、ASCII は 80 文字までに縮小する
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	// previewMaxPixels は sixel と iTerm2 のプレビューの最大の幅
+	previewMaxPixels = 320
+	// previewMaxColumns は ASCII のプレビューの最大の文字数
+	previewMaxColumns = 80
+)
+
+// asciiRamp は暗いほうから明るいほうへ並べた ASCII のプレビューの文字
+const asciiRamp = " .:-=+*#%@"
+
+// parsePreviewMode は --preview-mode の指定を確かめる
+func parsePreviewMode(s string) (string, error) {
+	switch s {
+	case "auto", "sixel", "iterm2", "ascii":
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid --preview-mode %q (want auto, sixel, iterm2 or ascii)", s)
+}
+
+// detectPreviewMode は auto のときに端末に合う表示の方法を選ぶ
+func detectPreviewMode() string {
+	switch {
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app", os.Getenv("TERM_PROGRAM") == "WezTerm", os.Getenv("LC_TERMINAL") == "iTerm2":
+		return "iterm2"
+	}
+	switch t := os.Getenv("TERM"); {
+	case strings.Contains(t, "sixel"), t == "mlterm", strings.HasPrefix(t, "foot"), strings.HasPrefix(t, "yaft"):
+		return "sixel"
+	}
+	return "ascii"
+}
+
+// terminalColumns は標準出力の端末の幅を返す。端末でなければ ASCII のプレビューの最大の幅を返す
+func terminalColumns() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return w
+	}
+	return previewMaxColumns
+}
+
+// renderPreview は img を mode で w に書く。columns は端末の幅
+func renderPreview(w io.Writer, img image.Image, mode string, columns int) {
+	if mode == "auto" {
+		mode = detectPreviewMode()
+	}
+	b := img.Bounds()
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return
+	}
+	if mode == "ascii" {
+		// 文字は縦が横のおよそ2倍なので、行数は半分にする
+		cols := min(b.Dx(), columns, previewMaxColumns)
+		rows := max(1, cols*b.Dy()/b.Dx()/2)
+		writeASCIIPreview(w, scaleImage(img, cols, rows))
+		return
+	}
+	width := min(b.Dx(), previewMaxPixels)
+	height := max(1, width*b.Dy()/b.Dx())
+	small := scaleImage(img, width, height)
+	if mode == "iterm2" {
+		var buf bytes.Buffer
+		png.Encode(&buf, small)
+		fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n", buf.Len(), base64.StdEncoding.EncodeToString(buf.Bytes()))
+		return
+	}
+	writeSixel(w, small)
+}
+
+// scaleImage は img を width x height に縮小する。各ピクセルは元の範囲から最大 4x4 点を取った平均にする
+func scaleImage(img image.Image, width, height int) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+max((y+1)*b.Dy()/height, y*b.Dy()/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+max((x+1)*b.Dx()/width, x*b.Dx()/width+1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += max(1, (y1-y0)/4) {
+				for sx := x0; sx < x1; sx += max(1, (x1-x0)/4) {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// writeASCIIPreview は明るさに合わせた文字で img を書く。透明な部分は黒として扱う
+func writeASCIIPreview(w io.Writer, img *image.RGBA) {
+	b := img.Bounds()
+	line := make([]byte, b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := img.RGBAAt(x, y)
+			// RGBA は透明度をかけた値なので、そのまま黒の上に重ねた色になる
+			lum := (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+			line[x] = asciiRamp[lum*(len(asciiRamp)-1)/255]
+		}
+		fmt.Fprintln(w, strings.TrimRight(string(line), " "))
+	}
+}
+
+// writeSixel は img を 6x6x6 の色の sixel で書く。半分より透明なピクセルは描かず、端末の背景のままにする
+func writeSixel(w io.Writer, img *image.RGBA) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	index := func(x, y int) int {
+		c := img.RGBAAt(x, y)
+		if c.A < 128 {
+			return -1
+		}
+		// 透明度をかける前の色に戻してから6段階にする
+		level := func(v uint8) int { return (int(v)*255/int(c.A)*5 + 127) / 255 }
+		return level(c.R)*36 + level(c.G)*6 + level(c.B)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "\x1bP0;1q\"1;1;%d;%d", width, height)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&out, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	for top := 0; top < height; top += 6 {
+		// 6行の帯ごとに、色ごとのビットの並びを作る
+		bands := map[int][]byte{}
+		var order []int
+		for dy := 0; dy < 6 && top+dy < height; dy++ {
+			for x := 0; x < width; x++ {
+				i := index(x, top+dy)
+				if i < 0 {
+					continue
+				}
+				bits, ok := bands[i]
+				if !ok {
+					bits = make([]byte, width)
+					bands[i] = bits
+					order = append(order, i)
+				}
+				bits[x] |= 1 << dy
+			}
+		}
+		for n, i := range order {
+			if n > 0 {
+				out.WriteByte('$')
+			}
+			fmt.Fprintf(&out, "#%d", i)
+			writeSixelRuns(&out, bands[i])
+		}
+		out.WriteByte('-')
+	}
+	out.WriteString("\x1b\\\n")
+	w.Write(out.Bytes())
+}
+
+// writeSixelRuns は帯1色分のビットを、同じ文字が続く部分を !n でまとめて書く
+func writeSixelRuns(out *bytes.Buffer, bits []byte) {
+	for x := 0; x < len(bits); {
+		n := 1
+		for x+n < len(bits) && bits[x+n] == bits[x] {
+			n++
+		}
+		ch := byte(63 + bits[x])
+		if n > 3 {
+			fmt.Fprintf(out, "!%d%c", n, ch)
+		} else {
+			out.Write(bytes.Repeat([]byte{ch}, n))
+		}
+		x += n
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+// プロファイル
+// 設定ファイルのプロファイルに、送ってよいメソッドや確認の要否を書いておくと、
+// プロファイルのホストへのリクエストでCLIがそれを強制する
+// 本番に誤って DELETE を送るといった操作をツール自体が止める
+// ベースURL、ヘッダー、認証、タイムアウト、プロキシの既定値も書ける。同じAPIに何度もリクエストを
+// 送るときに毎回オプションを並べなくてよい。コマンドラインで指定したオプションはプロファイルより優先する
+// プロファイルは --profile で名前を指定するか、URLのホストが hosts に一致すれば使う
+//
+//	profiles:
+//	  prod:
+//	    hosts: ["api.example.com", "*.prod.example.com"]
+//	    methods: [GET, HEAD]
+//	    require_confirm: true
+//	    base_url: https://api.example.com/v2
+//	    headers:
+//	      Accept: application/json
+//	    bearer: '{{decrypt "..."}}'
+//	    timeout: 10
+//	    proxy: http://proxy.corp:3128
+//
+//	gofetch --profile prod /users/1
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// profile は設定ファイルのプロファイル1つ
+type profile struct {
+	// Hosts はこのプロファイルを適用するホスト。* を使える
+	Hosts []string `yaml:"hosts"`
+	// Methods は送ってよいメソッド。空ならすべて許す
+	Methods []string `yaml:"methods"`
+	// RequireConfirm はリクエストのたびに確認を求めるか
+	RequireConfirm bool `yaml:"require_confirm"`
+	// Auth はアクセストークンの取得と更新の設定
+	Auth *authConfig `yaml:"auth"`
+
+	// 以下はコマンドラインで指定しなかった場合に使うオプションの値
+	// BaseURL は --base-url、Headers は -H、Basic は --auth、Bearer は --bearer、
+	// Timeout は -t (秒)、Proxy は --proxy の代わり
+	BaseURL string            `yaml:"base_url"`
+	Headers map[string]string `yaml:"headers"`
+	Basic   string            `yaml:"basic"`
+	Bearer  string            `yaml:"bearer"`
+	Timeout int               `yaml:"timeout"`
+	Proxy   string            `yaml:"proxy"`
+}
+
+// profileByName は名前でプロファイルを探す
+func (c *config) profileByName(name string) (*profile, error) {
+	p, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return nil, fmt.Errorf("unknown profile %q (the config file has no profiles)", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+	return &p, nil
+}
+
+// applyFlags はコマンドラインで指定しなかったオプションにプロファイルの値を設定する
+func (p *profile) applyFlags(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	values := []struct {
+		names []string
+		value string
+	}{
+		{[]string{"base-url"}, p.BaseURL},
+		{[]string{"auth"}, p.Basic},
+		{[]string{"bearer"}, p.Bearer},
+		{[]string{"t"}, strconv.Itoa(p.Timeout)},
+		// --socks5 を指定した場合もプロファイルのプロキシは使わない
+		{[]string{"proxy", "socks5"}, p.Proxy},
+	}
+	for _, v := range values {
+		if v.value == "" || v.value == "0" || set[v.names[0]] || len(v.names) > 1 && set[v.names[1]] {
+			continue
+		}
+		if err := fs.Set(v.names[0], v.value); err != nil {
+			return fmt.Errorf("profile: %s: %w", v.names[0], err)
+		}
+	}
+	return nil
+}
+
+// applyHeaders はプロファイルのヘッダーのうち、まだないものを h に加える
+// 値には ${VAR} とシークレットの埋め込みを使える
+func (p *profile) applyHeaders(h http.Header) error {
+	for name, value := range p.Headers {
+		if h.Get(name) != "" {
+			continue
+		}
+		v, err := expandSecretHeader(name, os.ExpandEnv(value))
+		if err != nil {
+			return fmt.Errorf("profile: header %s: %w", name, err)
+		}
+		h.Set(name, v)
+	}
+	return nil
+}
+
+// profileForURL はURLのホストに一致するプロファイルを名前の順で探す
+func (c *config) profileForURL(rawURL string) (string, *profile) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil
+	}
+	host := strings.ToLower(u.Hostname())
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := c.Profiles[name]
+		for _, pattern := range p.Hosts {
+			if globMatch(strings.ToLower(pattern), host) {
+				return name, &p
+			}
+		}
+	}
+	return "", nil
+}
+
+// enforce はプロファイルの制限を確かめる
+// 許されていないメソッドはエラーにし、確認が必要なら confirm で尋ねる
+func (p *profile) enforce(name, method, rawURL string, confirm *confirmer) error {
+	if len(p.Methods) > 0 {
+		allowed := false
+		for _, m := range p.Methods {
+			if strings.EqualFold(m, method) {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("method %s is not allowed by profile %s (allowed: %s)", method, name, strings.Join(p.Methods, ", "))
+		}
+	}
+	if p.RequireConfirm {
+		return confirm.ask("Send %s %s (profile %s)?", method, rawURL, name)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package main
+
+// ダウンロードの進み具合の表示
+// -o でファイルに保存するとき、標準エラー出力が端末なら受け取った量、割合、速さ、残り時間を
+// 1行で表示し直す。全体の大きさは Content-Length か、続きから受け取る場合は Content-Range から知る
+// 複数のURLを保存するときは、実行中の転送ごとに1行と全体の1行を表示し直す
+// 端末でなければ書き直せないので、一定の間隔で全体の進み具合を1行ずつ書く
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressInterval は表示し直す間隔
+	progressInterval = 200 * time.Millisecond
+	// progressWidth は棒の幅
+	progressWidth = 30
+	// progressLogInterval は端末でないときに進み具合を書く間隔
+	progressLogInterval = 5 * time.Second
+	// progressNameWidth は複数の転送の表示でファイル名に使う幅
+	progressNameWidth = 24
+)
+
+// progressBar はダウンロードの進み具合を標準エラー出力に表示する
+type progressBar struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	// offset は続きから受け取る場合に既にあった大きさ
+	offset int64
+	// received はこの試行で受け取った大きさ
+	received int64
+	// total は全体の大きさ。わからなければ -1
+	total int64
+	drawn time.Time
+}
+
+// newProgressBar は進み具合の表示を作る。標準エラー出力が端末でなければ nil を返す
+func newProgressBar() *progressBar {
+	fi, err := os.Stderr.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{w: os.Stderr, total: -1}
+}
+
+// begin は試行のレスポンスを受け取ったときに呼び、全体の大きさと始めの位置を決める
+func (p *progressBar) begin(resp *http.Response) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.start, p.received = time.Now(), 0
+	p.offset, p.total = responseExtent(resp)
+}
+
+// responseExtent はレスポンスの本文が始まる位置と全体の大きさを返す。全体の大きさがわからなければ -1
+func responseExtent(resp *http.Response) (offset, total int64) {
+	if resp.StatusCode == http.StatusPartialContent {
+		var first, last, total int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total); err == nil {
+			return first, total
+		}
+	}
+	return 0, resp.ContentLength
+}
+
+// wrap は本文を読むたびに進み具合を数える
+func (p *progressBar) wrap(body io.ReadCloser) io.ReadCloser {
+	return &progressReader{ReadCloser: body, bar: p}
+}
+
+// progressReader は読んだ量を progressBar に伝える
+type progressReader struct {
+	io.ReadCloser
+	bar *progressBar
+}
+
+// Read は読んだ量を数え、間隔が空いていれば表示し直す
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.bar.mu.Lock()
+	r.bar.received += int64(n)
+	if time.Since(r.bar.drawn) >= progressInterval {
+		r.bar.draw()
+	}
+	r.bar.mu.Unlock()
+	return n, err
+}
+
+// draw は1行を表示し直す。mu を持った状態で呼ぶ
+func (p *progressBar) draw() {
+	p.drawn = time.Now()
+	fmt.Fprintf(p.w, "\r\033[K%s", progressLine(p.offset+p.received, p.total, p.received, time.Since(p.start)))
+}
+
+// progressLine は受け取った量 done と全体の大きさ total の棒、速さ、残り時間を1行にする
+// 速さは elapsed の間に受け取った received から計算する。total がわからなければ量と速さだけにする
+func progressLine(done, total, received int64, elapsed time.Duration) string {
+	speed := 0.0
+	if elapsed > 0 {
+		speed = float64(received) / elapsed.Seconds()
+	}
+	if total <= 0 {
+		return fmt.Sprintf("%s  %s/s", formatSize(done), formatSize(int64(speed)))
+	}
+	ratio := min(float64(done)/float64(total), 1)
+	filled := int(ratio * progressWidth)
+	eta := "-"
+	if speed > 0 {
+		eta = time.Duration(float64(total-done) / speed * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("[%s%s] %3.0f%%  %s / %s  %s/s  ETA %s",
+		strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled), 100*ratio,
+		formatSize(done), formatSize(total), formatSize(int64(speed)), eta)
+}
+
+// finish は最後の状態を表示して改行する
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.start.IsZero() {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(p.w)
+}
+
+// transferProgress は複数の転送のうち1件の進み具合
+type transferProgress struct {
+	name   string
+	active bool
+	start  time.Time
+	// offset、received、total は progressBar と同じ
+	offset, received, total int64
+}
+
+// multiProgress は並行したダウンロードの進み具合を標準エラー出力に表示する
+type multiProgress struct {
+	w     io.Writer
+	start time.Time
+	// tty でなければ書き直さずに全体の進み具合を1行ずつ追記する
+	tty bool
+
+	mu        sync.Mutex
+	transfers []transferProgress
+	finished  int
+	lines     int
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// newMultiProgress は names の転送の進み具合の表示を作り、表示を始める
+func newMultiProgress(names []string) *multiProgress {
+	fi, err := os.Stderr.Stat()
+	p := &multiProgress{
+		w:         os.Stderr,
+		start:     time.Now(),
+		tty:       err == nil && fi.Mode()&os.ModeCharDevice != 0,
+		transfers: make([]transferProgress, len(names)),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for i, name := range names {
+		p.transfers[i] = transferProgress{name: name, total: -1}
+	}
+	go p.run()
+	return p
+}
+
+// begin は i 番目の転送が試行のレスポンスを受け取ったときに呼ぶ
+func (p *multiProgress) begin(i int, resp *http.Response) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := &p.transfers[i]
+	t.active, t.start, t.received = true, time.Now(), 0
+	t.offset, t.total = responseExtent(resp)
+}
+
+// add は i 番目の転送で n バイト受け取ったことを数える
+func (p *multiProgress) add(i int, n int) {
+	p.mu.Lock()
+	p.transfers[i].received += int64(n)
+	p.mu.Unlock()
+}
+
+// end は i 番目の転送が終わったときに呼ぶ
+func (p *multiProgress) end(i int) {
+	p.mu.Lock()
+	p.transfers[i].active = false
+	p.finished++
+	p.mu.Unlock()
+}
+
+// run は close が呼ばれるまで一定の間隔で表示し直す
+func (p *multiProgress) run() {
+	defer close(p.done)
+	interval := progressInterval
+	if !p.tty {
+		interval = progressLogInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.draw()
+		}
+	}
+}
+
+// close は表示を止める。端末では全体の1行だけを残す
+func (p *multiProgress) close() {
+	close(p.stop)
+	<-p.done
+	if p.tty {
+		p.draw()
+	}
+}
+
+// draw は実行中の転送ごとの行と全体の行を表示する。端末でなければ全体の行だけを書く
+func (p *multiProgress) draw() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var lines []string
+	var received int64
+	active := 0
+	for _, t := range p.transfers {
+		received += t.offset + t.received
+		if !t.active {
+			continue
+		}
+		active++
+		name := t.name
+		if r := []rune(name); len(r) > progressNameWidth {
+			name = string(r[:progressNameWidth-3]) + "..."
+		}
+		lines = append(lines, fmt.Sprintf("%-*s %s", progressNameWidth, name, progressLine(t.offset+t.received, t.total, t.received, time.Since(t.start))))
+	}
+	total := fmt.Sprintf("Total: %d/%d done, %d active  %s", p.finished, len(p.transfers), active, progressLine(received, -1, received, time.Since(p.start)))
+	if !p.tty {
+		fmt.Fprintln(p.w, total)
+		return
+	}
+	lines = append(lines, total)
+	// 前回の表示の先頭に戻って書き直し、行が減った分は消す
+	if p.lines > 0 {
+		fmt.Fprintf(p.w, "\x1b[%dA", p.lines)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(p.w, "\x1b[2K%s\n", line)
+	}
+	fmt.Fprint(p.w, "\x1b[J")
+	p.lines = len(lines)
+}
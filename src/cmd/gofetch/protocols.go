@@ -0,0 +1,170 @@
+package main
+
+// HTTPのバージョンごとの比較 (--compare-protocols)
+// 同じURLをHTTP/1.1、HTTP/2、HTTP/3でそれぞれ新しい接続から取得し、
+// 名前解決、接続、TLS、最初のバイトまでの時間と成否を並べて、
+// クライアントから見たプロトコルの展開状況を確かめる
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// protocolResult はプロトコル1つでの取得結果
+type protocolResult struct {
+	Name    string
+	Proto   string
+	Status  int
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+	Size    int64
+	SHA256  string
+	Err     error
+	// Skipped はこのURLやビルドでは試せない理由
+	Skipped string
+}
+
+// protocolTransports は比べるプロトコルごとのRoundTripperを作る
+// 試せないプロトコルはその理由を返す
+func protocolTransports(base *http.Transport, rawURL string) ([]string, map[string]http.RoundTripper, map[string]string) {
+	names := []string{"HTTP/1.1", "HTTP/2", "HTTP/3"}
+	rts := map[string]http.RoundTripper{}
+	skipped := map[string]string{}
+
+	// ALPNでh2を提示するとHTTP/1.1だけの Transport では読めないので、http/1.1だけを提示する
+	h1 := base.Clone()
+	h1.Protocols = new(http.Protocols)
+	h1.Protocols.SetHTTP1(true)
+	if h1.TLSClientConfig != nil {
+		h1.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+	rts["HTTP/1.1"] = h1
+
+	if strings.HasPrefix(rawURL, "https://") {
+		h2 := base.Clone()
+		h2.Protocols = new(http.Protocols)
+		h2.Protocols.SetHTTP2(true)
+		rts["HTTP/2"] = h2
+		h3, err := newHTTP3Transport(base.TLSClientConfig.Clone(), "")
+		if err != nil {
+			skipped["HTTP/3"] = err.Error()
+		} else {
+			rts["HTTP/3"] = h3
+		}
+	} else {
+		skipped["HTTP/2"] = "requires an https URL"
+		skipped["HTTP/3"] = "requires an https URL"
+	}
+	return names, rts, skipped
+}
+
+// fetchWithProtocol は rt で新しい接続からURLを取得し、段階ごとの時間を記録する
+func fetchWithProtocol(rt http.RoundTripper, timeout time.Duration, rawURL string, header http.Header, name string) protocolResult {
+	r := protocolResult{Name: name}
+	var dnsStart, connectStart, tlsStart time.Time
+	start := time.Now()
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { r.DNS = time.Since(dnsStart) },
+		ConnectStart:         func(string, string) { connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { r.Connect = time.Since(connectStart) },
+		TLSHandshakeStart:    func() { tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { r.TLS = time.Since(tlsStart) },
+		GotFirstResponseByte: func() { r.TTFB = time.Since(start) },
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, rawURL, nil)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	req.Header = header.Clone()
+	client := &http.Client{Timeout: timeout, Transport: rt}
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer resp.Body.Close()
+	// HTTP/3のRoundTripperは httptrace を呼ばないので、ヘッダーを受け取った時刻で代える
+	if r.TTFB == 0 {
+		r.TTFB = time.Since(start)
+	}
+	h := sha256.New()
+	r.Size, r.Err = io.Copy(h, resp.Body)
+	r.Total = time.Since(start)
+	r.Status = resp.StatusCode
+	r.Proto = resp.Proto
+	r.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return r
+}
+
+// runCompareProtocols はプロトコルごとに取得し、時間の内訳を表にして表示する
+// 試したすべてのプロトコルで成功し、ステータスが一致していれば true を返す
+// 本文はプロトコルを含むページもあるので、ハッシュの違いは表示するだけにする
+func runCompareProtocols(base *http.Transport, timeout time.Duration, rawURL string, header http.Header) bool {
+	names, rts, skipped := protocolTransports(base, rawURL)
+	var results []protocolResult
+	for _, name := range names {
+		if reason := skipped[name]; reason != "" {
+			results = append(results, protocolResult{Name: name, Skipped: reason})
+			continue
+		}
+		results = append(results, fetchWithProtocol(rts[name], timeout, rawURL, header, name))
+	}
+
+	ms := func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return d.Round(time.Millisecond).String()
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROTOCOL\tNEGOTIATED\tSTATUS\tDNS\tCONNECT\tTLS\tTTFB\tTOTAL\tSIZE\tSHA256")
+	ok := true
+	tried := 0
+	sameBody := true
+	var first *protocolResult
+	for i, r := range results {
+		if r.Skipped != "" {
+			fmt.Fprintf(tw, "%s\t\tSKIPPED\t\t\t\t\t\t\t%s\n", r.Name, r.Skipped)
+			continue
+		}
+		tried++
+		if r.Err != nil {
+			fmt.Fprintf(tw, "%s\t\tERROR\t\t\t\t\t\t\t%v\n", r.Name, r.Err)
+			ok = false
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.Proto, r.Status,
+			ms(r.DNS), ms(r.Connect), ms(r.TLS), ms(r.TTFB), ms(r.Total), formatSize(r.Size), r.SHA256[:12])
+		if first == nil {
+			first = &results[i]
+		} else {
+			ok = ok && r.Status == first.Status
+			sameBody = sameBody && r.SHA256 == first.SHA256
+		}
+	}
+	tw.Flush()
+	switch {
+	case !ok:
+		fmt.Println("Result: some protocols failed or returned a different status")
+	case !sameBody:
+		fmt.Printf("Result: %d protocol(s) succeeded with the same status, but the bodies differ\n", tried)
+	default:
+		fmt.Printf("Result: consistent across %d protocol(s)\n", tried)
+	}
+	return ok
+}
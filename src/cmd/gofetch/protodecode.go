@@ -0,0 +1,142 @@
+package main
+
+// protobuf のレスポンスのデコード (--proto, --proto-message, --proto-path)
+// .proto ファイルか、protoc --descriptor_set_out で作った記述子のセットからメッセージの型を読み込み、
+// バイナリの protobuf で返す本文をJSONにして表示する。JSONにした本文には --jq、--table、--csv も使える
+// .proto の import は --proto-path のディレクトリとファイルのあるディレクトリから探し、
+// google/protobuf/*.proto の標準の型は含まれているものを使う
+// --proto-message はパッケージを含む名前で指定する。ほかと重ならなければパッケージを省いてもよく、
+// ファイルにメッセージが1つだけなら省略できる
+// Accept を指定しなければ application/x-protobuf を送る。デコードするのは 2xx のレスポンスだけで、
+// エラーのレスポンスはそのまま出力する。-o を指定した場合もJSONを書く
+//
+//	gofetch -u https://api.example.com/v1/users/42 --proto api/user.proto --proto-message example.v1.User
+//	gofetch -u https://api.example.com/v1/users --proto users.protoset --proto-message UserList --jq users.0.name
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// loadProtoMessage は file からメッセージ name の型を読み込む
+// file が .proto でなければ記述子のセットとして読む
+func loadProtoMessage(file, name string, importPaths []string) (protoreflect.MessageDescriptor, error) {
+	var files []protoreflect.FileDescriptor
+	if strings.EqualFold(filepath.Ext(file), ".proto") {
+		paths := append(append([]string(nil), importPaths...), filepath.Dir(file))
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: paths}),
+		}
+		compiled, err := compiler.Compile(context.Background(), filepath.Base(file))
+		if err != nil {
+			return nil, fmt.Errorf("--proto: %w", err)
+		}
+		for _, f := range compiled {
+			files = append(files, f)
+		}
+	} else {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("--proto: %w", err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("--proto: %s is neither a .proto file nor a descriptor set: %w", file, err)
+		}
+		registry, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return nil, fmt.Errorf("--proto: %s: %w", file, err)
+		}
+		registry.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+			files = append(files, f)
+			return true
+		})
+	}
+
+	var all []protoreflect.MessageDescriptor
+	for _, f := range files {
+		collectMessages(f.Messages(), &all)
+	}
+	if name == "" {
+		if len(all) == 1 {
+			return all[0], nil
+		}
+		return nil, fmt.Errorf("--proto: %s defines %d messages, choose one with --proto-message (%s)", file, len(all), messageNames(all))
+	}
+	var matches []protoreflect.MessageDescriptor
+	for _, md := range all {
+		if string(md.FullName()) == name {
+			return md, nil
+		}
+		// パッケージを省いた名前でもよい
+		if strings.HasSuffix(string(md.FullName()), "."+name) {
+			matches = append(matches, md)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("--proto-message: no message %s in %s (%s)", name, file, messageNames(all))
+	case 1:
+		return matches[0], nil
+	}
+	return nil, fmt.Errorf("--proto-message: %s is ambiguous (%s)", name, messageNames(matches))
+}
+
+// collectMessages は入れ子のものも含めてメッセージの型を集める
+func collectMessages(messages protoreflect.MessageDescriptors, all *[]protoreflect.MessageDescriptor) {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		// map のフィールドのために作られる型は除く
+		if md.IsMapEntry() {
+			continue
+		}
+		*all = append(*all, md)
+		collectMessages(md.Messages(), all)
+	}
+}
+
+// messageNames はメッセージの名前を並べて返す
+func messageNames(messages []protoreflect.MessageDescriptor) string {
+	names := make([]string, len(messages))
+	for i, md := range messages {
+		names[i] = string(md.FullName())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// decodeProto は protobuf の本文を md の型としてデコードし、JSONにして返す
+func decodeProto(md protoreflect.MessageDescriptor, body []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("--proto: cannot decode the response as %s: %w", md.FullName(), err)
+	}
+	// JSONにすると型にないフィールドは落ちるので、あったことだけは知らせる
+	if unknown := msg.GetUnknown(); len(unknown) > 0 {
+		verbosef("Protobuf: %s of fields not in %s were dropped", formatSize(int64(len(unknown))), md.FullName())
+	}
+	out, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("--proto: %w", err)
+	}
+	// protojson は空白の入れ方を決めていないので詰めて返す
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, out); err != nil {
+		return nil, fmt.Errorf("--proto: %w", err)
+	}
+	return compact.Bytes(), nil
+}
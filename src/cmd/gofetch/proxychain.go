@@ -0,0 +1,216 @@
+package main
+
+// プロキシの多段接続 (--proxy の複数指定)
+// --proxy を複数指定すると、指定した順にプロキシを経由するトンネルを入れ子に作る
+// 最初のプロキシに接続し、HTTPのプロキシには CONNECT、SOCKS5のプロキシにはSOCKSの接続要求で
+// 次のプロキシへのトンネルを作り、最後のプロキシから接続先へのトンネルを作る
+// 外に出るには社内のプロキシから専用のプロキシを経由する必要がある環境などで使う
+// http:// の接続先も CONNECT で送るので、443以外への CONNECT を拒むプロキシでは https:// を使う
+// --noproxy と NO_PROXY のホストにはプロキシを通さずに接続する
+//
+//	gofetch -u https://example.com --proxy http://proxy.corp:3128 --proxy socks5h://10.0.0.5:1080
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// proxyChain は順に経由するプロキシ
+type proxyChain struct {
+	hops []*url.URL
+	// bypass は --noproxy と NO_PROXY でプロキシを通さないかを判定する
+	bypass func(*url.URL) (*url.URL, error)
+	// tlsConfig は https:// のプロキシとの接続に使う設定。証明書の検証の設定は接続先と同じ
+	tlsConfig *tls.Config
+}
+
+// newProxyChain は --proxy の指定から多段接続を作る。conf は最初のプロキシで組み立てた設定
+func newProxyChain(specs []string, conf *httpproxy.Config) (*proxyChain, error) {
+	c := &proxyChain{}
+	for _, spec := range specs {
+		u, err := url.Parse(spec)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", redactProxyURL(spec))
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q (want http, https, socks5 or socks5h)", u.Scheme)
+		}
+		if password, ok := u.User.Password(); ok {
+			registerSecret(password)
+		}
+		c.hops = append(c.hops, u)
+	}
+	// 判定にはホストとポートしか使わないので、どのプロキシを返すかは問わない
+	c.bypass = (&httpproxy.Config{HTTPSProxy: specs[0], NoProxy: conf.NoProxy}).ProxyFunc()
+	return c, nil
+}
+
+// String は経由するプロキシを表示用に返す
+func (c *proxyChain) String() string {
+	names := make([]string, len(c.hops))
+	for i, u := range c.hops {
+		names[i] = redactProxyURL(u.String())
+	}
+	return strings.Join(names, " -> ")
+}
+
+// dialContext は dial で最初のプロキシに接続し、プロキシを順に経由して addr に接続する関数を返す
+func (c *proxyChain) dialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if u, err := c.bypass(&url.URL{Scheme: "https", Host: addr}); err == nil && u == nil {
+			return dial(ctx, network, addr)
+		}
+		conn, err := dial(ctx, "tcp", proxyHostPort(c.hops[0]))
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %w", redactProxyURL(c.hops[0].String()), err)
+		}
+		// トンネルを作る間だけ ctx の期限を接続に設定する
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		for i, hop := range c.hops {
+			if hop.Scheme == "https" {
+				conn, err = c.handshake(ctx, conn, hop)
+			}
+			if err == nil {
+				next := addr
+				if i+1 < len(c.hops) {
+					next = proxyHostPort(c.hops[i+1])
+				}
+				conn, err = openTunnel(ctx, conn, hop, next)
+			}
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("proxy %d of %d (%s): %w", i+1, len(c.hops), redactProxyURL(hop.String()), err)
+			}
+		}
+		conn.SetDeadline(time.Time{})
+		verbosef("Proxy: %s via %s", addr, c)
+		return conn, nil
+	}
+}
+
+// handshake は https:// のプロキシとTLSで接続する
+func (c *proxyChain) handshake(ctx context.Context, conn net.Conn, hop *url.URL) (net.Conn, error) {
+	conf := &tls.Config{}
+	if c.tlsConfig != nil {
+		conf = c.tlsConfig.Clone()
+	}
+	conf.ServerName = hop.Hostname()
+	// プロキシとは HTTP/1.1 で CONNECT を送る
+	conf.NextProtos = nil
+	tlsConn := tls.Client(conn, conf)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return conn, err
+	}
+	return tlsConn, nil
+}
+
+// openTunnel は conn の先の hop に next へのトンネルを作らせる
+func openTunnel(ctx context.Context, conn net.Conn, hop *url.URL, next string) (net.Conn, error) {
+	if strings.HasPrefix(hop.Scheme, "socks5") {
+		return openSOCKSTunnel(ctx, conn, hop, next)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: next},
+		Host:   next,
+		Header: http.Header{},
+	}
+	if hop.User != nil {
+		password, _ := hop.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(hop.User.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT %s: %s", next, resp.Status)
+	}
+	// トンネルの先のデータを先に読み込んでいれば、それから返す
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// openSOCKSTunnel は conn の先のSOCKS5のプロキシに next への接続を作らせる
+// socks5:// では名前解決を手元で行い、socks5h:// ではプロキシに任せる
+func openSOCKSTunnel(ctx context.Context, conn net.Conn, hop *url.URL, next string) (net.Conn, error) {
+	if hop.Scheme == "socks5" {
+		host, port, err := net.SplitHostPort(next)
+		if err != nil {
+			return conn, err
+		}
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return conn, err
+		}
+		next = net.JoinHostPort(addrs[0], port)
+	}
+	var auth *proxy.Auth
+	if hop.User != nil {
+		password, _ := hop.User.Password()
+		auth = &proxy.Auth{User: hop.User.Username(), Password: password}
+	}
+	d, err := proxy.SOCKS5("tcp", hop.Host, auth, connDialer{conn})
+	if err != nil {
+		return conn, err
+	}
+	tunnel, err := d.(proxy.ContextDialer).DialContext(ctx, "tcp", next)
+	if err != nil {
+		return conn, err
+	}
+	return tunnel, nil
+}
+
+// proxyHostPort はプロキシのURLの host:port を返す。ポートを省略した場合はスキームの既定のポートにする
+func proxyHostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[u.Scheme]
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// connDialer は接続済みの conn を返す。SOCKSの接続要求を既存のトンネルの上で送るのに使う
+type connDialer struct {
+	conn net.Conn
+}
+
+func (d connDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+func (d connDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.conn, nil
+}
+
+// bufferedConn は先に読み込んだデータを返してから conn を読む
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,137 @@
+package main
+
+// 送る速さの制限 (--rate, --delay)
+// 複数のURLの取得と --for の繰り返しで、サーバーに負荷をかけすぎて遮断されないようにする
+// --rate 10/s はトークンバケットで全体の送信を毎秒10件までにする。ワーカーはバケットを共有するので、
+// --concurrency を増やしても全体の速さは変わらない。単位は /s, /m, /h で、数だけなら毎秒とみなす
+// --delay 500ms はワーカーごとに、リクエストを送ってから次を送るまで待つ時間
+// gofetch bench の think_time と同じく 200ms-1s (一様分布) や exp:500ms (指数分布) も指定できる
+// 待った時間はレイテンシに含めない。リダイレクトとリトライはそれぞれの取得の一部として制限しない
+//
+//	gofetch --url-file urls.txt -o out/ --rate 2/s
+//	gofetch --url-file urls.txt -o out/ --concurrency 1 --delay 1s-3s
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter はトークンバケットで送信の速さを制限する
+type rateLimiter struct {
+	// interval はトークンが1つ増えるまでの時間
+	interval time.Duration
+	// burst はバケットに貯められるトークンの数
+	burst int
+	spec  string
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// waited は待った時間の合計
+	waited time.Duration
+}
+
+// parseRateLimit は "10/s", "30/m", "100/h", "5" の形の速さを解釈する
+func parseRateLimit(s string) (*rateLimiter, error) {
+	count, unit, _ := strings.Cut(strings.TrimSpace(s), "/")
+	per := time.Second
+	switch unit {
+	case "", "s":
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		return nil, fmt.Errorf("invalid --rate %q (want N/s, N/m or N/h)", s)
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid --rate %q (want N/s, N/m or N/h)", s)
+	}
+	// 最初のリクエストは待たずに送り、それ以降は間隔を空けて送る
+	return &rateLimiter{interval: time.Duration(float64(per) / n), burst: 1, spec: s, tokens: 1}, nil
+}
+
+// wait はトークンが得られるまで待つ
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = min(float64(l.burst), l.tokens+float64(now.Sub(l.last))/float64(l.interval))
+	}
+	l.last = now
+	// トークンを先に取り、足りなければ借りた分だけ待つ。待っている間に来た次の呼び出しはその後ろに並ぶ
+	l.tokens--
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens * float64(l.interval))
+		l.waited += d
+	}
+	l.mu.Unlock()
+	time.Sleep(d)
+}
+
+// String は速さと待った時間を表示用に返す
+func (l *rateLimiter) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fmt.Sprintf("%s, waited %s in total", l.spec, l.waited.Round(time.Millisecond))
+}
+
+// requestPacer は --rate と --delay をまとめたもの
+type requestPacer struct {
+	rate  *rateLimiter
+	delay thinkTime
+}
+
+// newRequestPacer は --rate と --delay から pacer を作る。どちらも指定がなければ nil を返す
+func newRequestPacer(rate, delay string) (*requestPacer, error) {
+	if rate == "" && delay == "" {
+		return nil, nil
+	}
+	p := &requestPacer{}
+	if rate != "" {
+		l, err := parseRateLimit(rate)
+		if err != nil {
+			return nil, err
+		}
+		p.rate = l
+	}
+	d, err := parseThinkTime(delay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --delay %q (want e.g. 500ms, 200ms-1s or exp:500ms)", delay)
+	}
+	p.delay = d
+	return p, nil
+}
+
+// pace は次のリクエストを送れるまで待つ。first はワーカーの最初のリクエストで、--delay では待たない
+func (p *requestPacer) pace(first bool) {
+	if p == nil {
+		return
+	}
+	if !first && p.delay.kind != "" {
+		time.Sleep(p.delay.sample(rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))))
+	}
+	p.rate.wait()
+}
+
+// report は待った時間を詳細モードで表示する
+func (p *requestPacer) report() {
+	if p == nil {
+		return
+	}
+	if p.rate != nil {
+		verbosef("Rate limit: %s", p.rate)
+	}
+	if p.delay.kind != "" {
+		verbosef("Delay: %s per worker", p.delay)
+	}
+}
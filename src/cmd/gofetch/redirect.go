@@ -0,0 +1,228 @@
+package main
+
+// リダイレクトの追跡 (--post301, --post302, --post303)
+// リダイレクトごとのステータスと所要時間を記録し、同じURLを redirectLoopVisits 回を超えて訪れたら
+// 回数の上限を待たずにループとして打ち切って、ループの経路を表示する
+// Cookie を設定してから元のURLに戻す (/a -> /set-cookie -> /a) のように、一度戻るだけならループにしない
+// 古いサーバーのために、301/302/303 でもメソッドを変えずに送り直せるようにする
+// 別のオリジンへのリダイレクトで転送するリクエストヘッダーは --redirect-headers で選ぶ
+// --max-redirects でたどる回数の上限を変え、--no-follow ではたどらずにリダイレクトのレスポンスを返す
+// 詳細モードではリダイレクトをたどったあとに、ステータスと Location を含めた経路全体を表示する
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gofetch/pkg/gofetch"
+)
+
+// maxRedirects はたどるリダイレクトの既定の上限 (net/http の既定と同じ)
+const maxRedirects = 10
+
+// redirectLoopVisits は同じメソッドとURLを訪れてよい回数。これを超えるとループとする
+const redirectLoopVisits = 2
+
+// sensitiveHeaders は既定では別のオリジンに転送しない認証情報のヘッダー
+// --api-key をヘッダーに付ける場合は、その名前も addSensitiveHeader で加える
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// addSensitiveHeader は認証情報のヘッダーとして name を加える
+func addSensitiveHeader(name string) {
+	name = http.CanonicalHeaderKey(name)
+	for _, s := range sensitiveHeaders {
+		if name == s {
+			return
+		}
+	}
+	sensitiveHeaders = append(sensitiveHeaders, name)
+}
+
+// redirectHeaderPolicy は別のオリジンへのリダイレクトで転送するヘッダーの決め方
+type redirectHeaderPolicy struct {
+	// Mode は safe (認証情報以外を転送)、all、none、list のどれか
+	Mode string
+	// Allow は Mode が list のときに転送するヘッダー
+	Allow map[string]bool
+}
+
+// parseRedirectHeaderPolicy は safe、all、none、またはカンマ区切りのヘッダー名を解析する
+func parseRedirectHeaderPolicy(spec string) redirectHeaderPolicy {
+	switch spec {
+	case "", "safe":
+		return redirectHeaderPolicy{Mode: "safe"}
+	case "all", "none":
+		return redirectHeaderPolicy{Mode: spec}
+	}
+	p := redirectHeaderPolicy{Mode: "list", Allow: map[string]bool{}}
+	for _, name := range splitList(spec) {
+		p.Allow[http.CanonicalHeaderKey(name)] = true
+	}
+	return p
+}
+
+// forward は別のオリジンへのリダイレクトでヘッダーを転送するかを返す
+func (p redirectHeaderPolicy) forward(name string) bool {
+	switch p.Mode {
+	case "all":
+		return true
+	case "none":
+		return false
+	case "list":
+		return p.Allow[name]
+	}
+	for _, s := range sensitiveHeaders {
+		if name == s {
+			return false
+		}
+	}
+	return true
+}
+
+// urlOrigin はURLのオリジンを scheme://host:port の形で返す
+func urlOrigin(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return u.Scheme + "://" + net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// redirectHop はリダイレクト1回分
+type redirectHop struct {
+	Status int
+	From   string
+	To     string
+	// Location はレスポンスの Location ヘッダーそのまま
+	Location string
+	Latency  time.Duration
+}
+
+// redirectTracker はリダイレクトを記録する http.Client の CheckRedirect
+type redirectTracker struct {
+	// keepMethod はメソッドを変えずにリダイレクトするステータス
+	keepMethod map[int]bool
+	headers    redirectHeaderPolicy
+	// limit はたどるリダイレクトの上限。noFollow ならたどらない
+	limit    int
+	noFollow bool
+	hops     []redirectHop
+	last     time.Time
+	// header は最初のリクエストに指定したヘッダー。クッキージャーが加えた Cookie を含まない
+	header http.Header
+}
+
+// clone は同じ設定で記録を別にした redirectTracker を作る
+func (t *redirectTracker) clone() *redirectTracker {
+	return &redirectTracker{keepMethod: t.keepMethod, headers: t.headers, limit: t.limit, noFollow: t.noFollow}
+}
+
+// start は新しいリクエストを始めるときに記録を消し、リダイレクト先に付け直すヘッダーを覚える
+// header はクッキージャーが Cookie を加える前のもの。ジャーのクッキーはリダイレクト先ごとにジャーが付ける
+func (t *redirectTracker) start(header http.Header) {
+	t.hops = nil
+	t.last = time.Now()
+	t.header = header.Clone()
+}
+
+// checkRedirect はリダイレクトを記録し、ループや上限を超えた場合はエラーを返す
+func (t *redirectTracker) checkRedirect(req *http.Request, via []*http.Request) error {
+	now := time.Now()
+	prev := via[len(via)-1]
+	hop := redirectHop{From: prev.URL.String(), To: req.URL.String(), Latency: now.Sub(t.last)}
+	if req.Response != nil {
+		hop.Status = req.Response.StatusCode
+		hop.Location = req.Response.Header.Get("Location")
+	}
+	// たどらない場合はリダイレクトのレスポンスをそのまま返す
+	if t.noFollow {
+		verbosef("Redirect: not following %d to %s (--no-follow)", hop.Status, hop.Location)
+		return http.ErrUseLastResponse
+	}
+	t.hops = append(t.hops, hop)
+	t.last = now
+	verbosef("Redirect: %d %s -> %s (%s)", hop.Status, hop.From, hop.To, hop.Latency.Round(time.Millisecond))
+
+	// 指定されたステータスでは元のメソッドと本文で送り直す
+	if t.keepMethod[hop.Status] && req.Method != prev.Method {
+		req.Method = prev.Method
+		if prev.GetBody != nil {
+			body, err := prev.GetBody()
+			if err != nil {
+				return err
+			}
+			req.Body = body
+			req.GetBody = prev.GetBody
+			req.ContentLength = prev.ContentLength
+		}
+		verbosef("Redirect: keeping method %s on %d", req.Method, hop.Status)
+	}
+
+	t.applyHeaderPolicy(req, via[0].URL)
+
+	first, visits := -1, 0
+	for i, r := range via {
+		if r.Method == req.Method && r.URL.String() == req.URL.String() {
+			if first < 0 {
+				first = i
+			}
+			visits++
+		}
+	}
+	if visits >= redirectLoopVisits {
+		return &gofetch.RedirectLoopError{URL: req.URL.String(), Visits: visits + 1, Path: t.describeLoop(first)}
+	}
+	if len(via) > t.limit {
+		return fmt.Errorf("stopped after %d redirects (--max-redirects %d):\n%s", t.limit, t.limit, t.describeLoop(0))
+	}
+	return nil
+}
+
+// applyHeaderPolicy は最初のリクエストに指定したヘッダーを、リダイレクト先のオリジンに応じて付け直す
+// 最初のリクエストの first と同じオリジンならすべて転送し、違えばポリシーに従う
+// net/http は別のドメインへのリダイレクトで認証情報を落とすので、転送する場合は元に戻す
+// ジャーのクッキーは付け直さない。同じクッキーを二重に送ったり、ジャーのドメインの制限を越えて送ったりしないため
+func (t *redirectTracker) applyHeaderPolicy(req *http.Request, first *url.URL) {
+	crossOrigin := urlOrigin(req.URL) != urlOrigin(first)
+	var dropped []string
+	for name, values := range t.header {
+		if !crossOrigin || t.headers.forward(name) {
+			req.Header[name] = values
+			continue
+		}
+		req.Header.Del(name)
+		dropped = append(dropped, name)
+	}
+	if len(dropped) > 0 {
+		sort.Strings(dropped)
+		verbosef("Redirect: not forwarding %s to %s (--redirect-headers %s)", strings.Join(dropped, ", "), urlOrigin(req.URL), t.headers.Mode)
+	}
+}
+
+// report はリダイレクトをたどった場合に、最後のレスポンスまでの経路を詳細モードで表示する
+func (t *redirectTracker) report(final *http.Response) {
+	if !verbose || len(t.hops) == 0 || final == nil {
+		return
+	}
+	verbosef("Redirect chain (%d hop(s)):", len(t.hops))
+	for i, h := range t.hops {
+		verbosef("  %d. %d %s (Location: %s, %s)", i+1, h.Status, h.From, h.Location, h.Latency.Round(time.Millisecond))
+	}
+	verbosef("  %d. %d %s", len(t.hops)+1, final.StatusCode, final.Request.URL)
+}
+
+// describeLoop は start 番目のリダイレクトからの経路を1行ずつ表す
+func (t *redirectTracker) describeLoop(start int) string {
+	var b strings.Builder
+	for _, h := range t.hops[start:] {
+		fmt.Fprintf(&b, "  %d %s -> %s (%s)\n", h.Status, h.From, h.To, h.Latency.Round(time.Millisecond))
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
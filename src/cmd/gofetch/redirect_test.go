@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gofetch/pkg/gofetch"
+)
+
+// redirectServer は Location の対応表どおりにリダイレクトし、表にないパスには 200 を返す
+// /a は Cookie がなければ /set-cookie に送り、/set-cookie は Cookie を設定して /a に戻す
+func redirectServer(t *testing.T, locations map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/a":
+			if _, err := r.Cookie("session"); err != nil {
+				http.Redirect(w, r, "/set-cookie", http.StatusFound)
+				return
+			}
+		case r.URL.Path == "/set-cookie":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1", Path: "/"})
+			http.Redirect(w, r, "/a", http.StatusFound)
+			return
+		case strings.HasPrefix(r.URL.Path, "/chain/"):
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chain/"))
+			http.Redirect(w, r, "/chain/"+strconv.Itoa(n+1), http.StatusFound)
+			return
+		case locations[r.URL.RequestURI()] != "":
+			http.Redirect(w, r, locations[r.URL.RequestURI()], http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRedirectLoopDetection(t *testing.T) {
+	locations := map[string]string{"/self": "/self", "/x": "/y", "/y": "/x", "/once": "/back", "/back": "/once?done=1"}
+	tests := []struct {
+		name string
+		path string
+		// hops は最後までたどれた場合のリダイレクトの回数
+		hops int
+		loop bool
+		// wantErr はループ以外のエラーに含まれる文字列
+		wantErr string
+	}{
+		{name: "cookie handshake", path: "/a", hops: 2},
+		{name: "query changes the URL", path: "/once", hops: 2},
+		{name: "redirect to itself", path: "/self", loop: true},
+		{name: "ping-pong", path: "/x", loop: true},
+		{name: "limit without a loop", path: "/chain/0", wantErr: "stopped after 10 redirects"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := redirectServer(t, locations)
+			jar, _ := cookiejar.New(nil)
+			tracker := &redirectTracker{limit: maxRedirects, headers: parseRedirectHeaderPolicy("safe")}
+			tracker.start(nil)
+			client := &http.Client{Jar: jar, CheckRedirect: tracker.checkRedirect}
+			resp, err := client.Get(srv.URL + tt.path)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			var loopErr *gofetch.RedirectLoopError
+			switch {
+			case tt.loop:
+				if !errors.As(err, &loopErr) {
+					t.Fatalf("err = %v, want *gofetch.RedirectLoopError", err)
+				}
+				if loopErr.Visits != redirectLoopVisits+1 {
+					t.Errorf("Visits = %d, want %d", loopErr.Visits, redirectLoopVisits+1)
+				}
+				if gofetch.IsRetryable(err) {
+					t.Error("IsRetryable = true for a redirect loop")
+				}
+				if code := exitCodeForError(err); code != exitHTTP {
+					t.Errorf("exit code = %d, want %d", code, exitHTTP)
+				}
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || errors.As(err, &loopErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(tracker.hops) != tt.hops {
+					t.Errorf("hops = %d, want %d", len(tracker.hops), tt.hops)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyHeaderPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		to     string
+		want   []string
+	}{
+		{name: "same origin keeps everything", policy: "none", to: "https://api.example.com/v2", want: []string{"Authorization", "Cookie", "X-Trace"}},
+		{name: "same origin with default port", policy: "none", to: "https://API.example.com:443/v2", want: []string{"Authorization", "Cookie", "X-Trace"}},
+		{name: "safe drops credentials", policy: "safe", to: "https://cdn.example.net/", want: []string{"X-Trace"}},
+		{name: "scheme change is cross-origin", policy: "safe", to: "http://api.example.com/", want: []string{"X-Trace"}},
+		{name: "port change is cross-origin", policy: "safe", to: "https://api.example.com:8443/", want: []string{"X-Trace"}},
+		{name: "all forwards credentials", policy: "all", to: "https://cdn.example.net/", want: []string{"Authorization", "Cookie", "X-Trace"}},
+		{name: "none drops everything", policy: "none", to: "https://cdn.example.net/", want: nil},
+		{name: "list forwards named headers", policy: "authorization,x-trace", to: "https://cdn.example.net/", want: []string{"Authorization", "X-Trace"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v1", nil)
+			first.Header.Set("Authorization", "Bearer secret")
+			first.Header.Set("Cookie", "session=1")
+			first.Header.Set("X-Trace", "abc")
+			req, _ := http.NewRequest(http.MethodGet, tt.to, nil)
+			tracker := &redirectTracker{headers: parseRedirectHeaderPolicy(tt.policy)}
+			tracker.start(first.Header)
+			tracker.applyHeaderPolicy(req, first.URL)
+			var got []string
+			for name := range req.Header {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("forwarded %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// cookieEchoTransport は /redirect に来たリクエストを to へリダイレクトし、それ以外では受け取った Cookie を記録する
+type cookieEchoTransport struct {
+	to      string
+	cookies map[string][]string
+}
+
+func (c *cookieEchoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}
+	if req.URL.Path == "/redirect" {
+		resp.StatusCode = http.StatusFound
+		resp.Header.Set("Location", c.to)
+		return resp, nil
+	}
+	c.cookies[req.URL.Host] = req.Header.Values("Cookie")
+	return resp, nil
+}
+
+func TestRedirectDoesNotCopyJarCookies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		to     string
+		// want はリダイレクト先のホストが受け取る Cookie ヘッダー
+		want []string
+	}{
+		{name: "same origin sends the jar cookie once", policy: "safe", to: "https://api.example.com/next", want: []string{"session=1"}},
+		{name: "cross-origin with all keeps the jar cookie home", policy: "all", to: "https://cdn.example.net/next", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jar, _ := cookiejar.New(nil)
+			origin, _ := url.Parse("https://api.example.com/")
+			jar.SetCookies(origin, []*http.Cookie{{Name: "session", Value: "1"}})
+			transport := &cookieEchoTransport{to: tt.to, cookies: map[string][]string{}}
+			tracker := &redirectTracker{limit: maxRedirects, headers: parseRedirectHeaderPolicy(tt.policy)}
+			client := &http.Client{Jar: jar, Transport: transport, CheckRedirect: tracker.checkRedirect}
+
+			req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/redirect", nil)
+			req.Header.Set("X-Trace", "abc")
+			tracker.start(req.Header)
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			to, _ := url.Parse(tt.to)
+			if got := transport.cookies[to.Host]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cookie at %s = %q, want %q", to.Host, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyHeaderIsSensitive(t *testing.T) {
+	tests := []struct {
+		name, keyIn, to string
+		// forwarded はリダイレクト先に X-Api-Key を転送するか
+		forwarded bool
+		masked    string
+	}{
+		{name: "cross-origin drops the key", keyIn: "header", to: "https://evil.example.net/", masked: "****"},
+		{name: "same origin keeps the key", keyIn: "header", to: "https://api.example.com/v2", forwarded: true, masked: "****"},
+		{name: "query key is not a header", keyIn: "query", to: "https://evil.example.net/", masked: "secret-key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := sensitiveHeaders
+			t.Cleanup(func() { sensitiveHeaders = saved })
+			sensitiveHeaders = append([]string(nil), saved...)
+
+			auth, err := parseRequestAuth("", "", "x-api-key=secret-key", tt.keyIn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			first, _ := http.NewRequest(http.MethodGet, "https://api.example.com/v1", nil)
+			auth.applyHeader(first.Header)
+			req, _ := http.NewRequest(http.MethodGet, tt.to, nil)
+			tracker := &redirectTracker{headers: parseRedirectHeaderPolicy("safe")}
+			tracker.start(first.Header)
+			tracker.applyHeaderPolicy(req, first.URL)
+			if got := req.Header.Get("X-Api-Key") != ""; got != tt.forwarded {
+				t.Errorf("forwarded = %v, want %v", got, tt.forwarded)
+			}
+			if got := maskSensitive("X-Api-Key", "secret-key"); got != tt.masked {
+				t.Errorf("maskSensitive = %q, want %q", got, tt.masked)
+			}
+		})
+	}
+}
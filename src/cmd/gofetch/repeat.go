@@ -0,0 +1,157 @@
+package main
+
+// 同じリクエストの繰り返しと計測 (-f, --for)
+// 同じリクエストを N 回送り、レイテンシの最小、平均、p50、p95、p99、最大とスループット、
+// ステータスごとの数、エラーの数を表示する。ab や hey のような手軽な計測に使う
+// --concurrency を指定すればその数だけ同時に送る。省略した場合は1件ずつ送る
+// TLS、プロキシ、認証などの設定は1回だけ取得する場合と同じものを使い、リトライはしない
+// 本文は読み捨てる。重みをつけた複数のターゲットや時間を決めた負荷には gofetch bench を使う
+//
+//	gofetch -u https://api.example.com/health --for 200 --concurrency 10
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gofetch/pkg/gofetch"
+)
+
+// repeatConcurrency は --for で同時に送る数を返す。--concurrency の指定がなければ1にする
+func repeatConcurrency(concurrency, count int) (int, error) {
+	n := 1
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "concurrency" {
+			n = concurrency
+		}
+	})
+	if n < 1 {
+		return 0, fmt.Errorf("--concurrency must be at least 1")
+	}
+	return min(n, count), nil
+}
+
+// runRepeated は r を n 回送って集計を表示し、終了コードを返す
+// pacer があれば送る前にその分だけ待つ
+// 失敗したリクエストがあれば最初のエラーの終了コード、fail で 4xx か 5xx があれば exitHTTP を返す
+func runRepeated(client *http.Client, redirects *redirectTracker, r gofetch.Request, n, concurrency int, fail bool, pacer *requestPacer) int {
+	rec := newBenchRecorder(1, n)
+	var received atomic.Int64
+	var firstErr error
+	var errOnce sync.Once
+
+	fmt.Fprintf(os.Stderr, "Sending %d request(s) to %s with concurrency %d\n", n, redactSecrets(r.URL), concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// リダイレクトの記録は並行して書かないよう、仮想ユーザーごとに分ける
+			c := *client
+			tracker := redirects.clone()
+			c.CheckRedirect = tracker.checkRedirect
+			first := true
+			for rec.reserve() {
+				pacer.pace(first)
+				first = false
+				tracker.start(r.Header)
+				reqStart := time.Now()
+				status, size, err := sendRepeated(&c, r)
+				latency := time.Since(reqStart)
+				received.Add(size)
+				if err != nil {
+					verbosef("Request failed: %v", err)
+					errOnce.Do(func() { firstErr = err })
+				}
+				rec.record(0, status, latency, latency, err)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	pacer.report()
+
+	s := rec.stats[0]
+	printRepeatSummary(s, concurrency, elapsed, received.Load())
+	if firstErr != nil {
+		return exitCodeForError(firstErr)
+	}
+	if fail {
+		for code := range s.Statuses {
+			if code >= 400 {
+				return exitHTTP
+			}
+		}
+	}
+	return 0
+}
+
+// sendRepeated はリクエストを1件送って本文を読み捨て、ステータスと本文の大きさを返す
+func sendRepeated(client *http.Client, r gofetch.Request) (int, int64, error) {
+	req, err := r.NewHTTPRequest(context.Background())
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	size, err := io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, size, err
+}
+
+// printRepeatSummary は繰り返したリクエストの集計を表示する
+func printRepeatSummary(s benchStats, concurrency int, elapsed time.Duration, received int64) {
+	ms := roundLatency
+	latencies := s.Latencies
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+
+	fmt.Printf("Requests:    %d in %s (concurrency %d)\n", s.Requests, elapsed.Round(time.Millisecond), concurrency)
+	fmt.Printf("Throughput:  %.1f req/s, %s/s received\n", float64(s.Requests)/elapsed.Seconds(), formatSize(int64(float64(received)/elapsed.Seconds())))
+	if len(latencies) > 0 {
+		avg := total / time.Duration(len(latencies))
+		fmt.Printf("Latency:     min %s  avg %s  p50 %s  p95 %s  p99 %s  max %s\n",
+			ms(latencies[0]), ms(avg), ms(percentile(latencies, 50)), ms(percentile(latencies, 95)),
+			ms(percentile(latencies, 99)), ms(latencies[len(latencies)-1]))
+	}
+
+	codes := make([]int, 0, len(s.Statuses))
+	for code := range s.Statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	var statuses []string
+	for _, code := range codes {
+		statuses = append(statuses, fmt.Sprintf("%d:%d", code, s.Statuses[code]))
+	}
+	fmt.Printf("Status:      %s\n", orDash(strings.Join(statuses, " ")))
+
+	// レスポンスを受け取れなかったものを種類ごとに数える
+	var failures []string
+	failed := 0
+	for _, class := range responseClasses {
+		if k := len(s.Classes.samples[class]); k > 0 && !strings.HasSuffix(class, "xx") {
+			failures = append(failures, fmt.Sprintf("%s:%d", class, k))
+			failed += k
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("Errors:      %d (%s)\n", failed, strings.Join(failures, " "))
+	} else {
+		fmt.Println("Errors:      0")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+// 結果の出力 (--format, --report)
+// --format は取得の結果を Go のテンプレートで1行に整形して標準出力に書く
+// --report json はリクエストごとの結果をJSONで1行ずつ、--report csv は見出しの行に続けてCSVで書く
+// 複数のURLでは OK や FAIL の一覧の代わりに1件ずつ書き、最後のまとめは標準エラー出力に書く
+// 1つのURLでは本文の後に書くので、本文は -o でファイルに保存するとよい
+//
+//	gofetch -u https://example.com -o /dev/null --format '{{.Status}} {{.Latency}} {{.Size}}'
+//	gofetch --url-file urls.txt -o out/ --report csv > results.csv
+//
+// テンプレートで使える値は .URL .Status .Proto .Size .Latency .TTFB .Attempts .Error .Output と
+// {{.Header "Content-Type"}} のようなレスポンスヘッダー
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"gofetch/pkg/gofetch"
+)
+
+// resultRecord はリクエスト1件の結果
+type resultRecord struct {
+	URL    string
+	Status int
+	Proto  string
+	// Size は受け取った本文のバイト数
+	Size     int64
+	Latency  time.Duration
+	TTFB     time.Duration
+	Attempts int
+	// Error は失敗した場合の理由
+	Error string
+	// Output は本文を保存したファイル
+	Output string
+	header http.Header
+}
+
+// Header はレスポンスヘッダーの値を返す
+func (r resultRecord) Header(name string) string {
+	return r.header.Get(name)
+}
+
+// newResultRecord はレスポンスと誤りから結果を作る
+func newResultRecord(rawURL string, res gofetch.Response, size int64, err error) resultRecord {
+	r := resultRecord{
+		URL:      rawURL,
+		Status:   res.StatusCode,
+		Size:     size,
+		Latency:  res.Duration.Round(time.Microsecond),
+		TTFB:     res.TTFB.Round(time.Microsecond),
+		Attempts: res.Attempts,
+		header:   res.Header,
+	}
+	if res.Raw != nil {
+		r.Proto = res.Raw.Proto
+	}
+	if err != nil {
+		r.Error = redactSecrets(err.Error())
+	}
+	return r
+}
+
+// reportColumns は --report で書く項目
+var reportColumns = []string{"url", "status", "proto", "bytes", "duration_ms", "ttfb_ms", "attempts", "error", "output"}
+
+// resultWriter は結果を --format か --report の形式で書く
+type resultWriter struct {
+	w      io.Writer
+	tmpl   *template.Template
+	report string
+	csv    *csv.Writer
+	// wroteHeader は --report csv の見出しの行を書いたか
+	wroteHeader bool
+}
+
+// newResultWriter は結果の書き出しを作る。どちらも指定がなければ nil を返す
+func newResultWriter(w io.Writer, format, report string) (*resultWriter, error) {
+	switch {
+	case format != "" && report != "":
+		return nil, fmt.Errorf("--format and --report cannot be used together")
+	case format != "":
+		tmpl, err := template.New("format").Parse(format)
+		if err == nil {
+			// 知らない項目の誤りは取得する前に見つける
+			err = tmpl.Execute(io.Discard, resultRecord{})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid --format: %w", err)
+		}
+		return &resultWriter{w: w, tmpl: tmpl}, nil
+	case report == "json":
+		return &resultWriter{w: w, report: report}, nil
+	case report == "csv":
+		return &resultWriter{w: w, report: report, csv: csv.NewWriter(w)}, nil
+	case report != "":
+		return nil, fmt.Errorf("invalid --report %q (want json or csv)", report)
+	}
+	return nil, nil
+}
+
+// write は結果1件を書く
+func (rw *resultWriter) write(r resultRecord) error {
+	if rw.tmpl != nil {
+		var b strings.Builder
+		if err := rw.tmpl.Execute(&b, r); err != nil {
+			return fmt.Errorf("--format: %w", err)
+		}
+		line := b.String()
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		_, err := io.WriteString(rw.w, line)
+		return err
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	if rw.csv != nil {
+		if !rw.wroteHeader {
+			rw.csv.Write(reportColumns)
+			rw.wroteHeader = true
+		}
+		rw.csv.Write([]string{
+			r.URL, strconv.Itoa(r.Status), r.Proto, strconv.FormatInt(r.Size, 10),
+			strconv.FormatFloat(ms(r.Latency), 'f', -1, 64), strconv.FormatFloat(ms(r.TTFB), 'f', -1, 64),
+			strconv.Itoa(r.Attempts), r.Error, r.Output,
+		})
+		rw.csv.Flush()
+		return rw.csv.Error()
+	}
+	data, err := json.Marshal(struct {
+		URL      string  `json:"url"`
+		Status   int     `json:"status"`
+		Proto    string  `json:"proto,omitempty"`
+		Bytes    int64   `json:"bytes"`
+		Duration float64 `json:"duration_ms"`
+		TTFB     float64 `json:"ttfb_ms"`
+		Attempts int     `json:"attempts"`
+		Error    string  `json:"error,omitempty"`
+		Output   string  `json:"output,omitempty"`
+	}{r.URL, r.Status, r.Proto, r.Size, ms(r.Latency), ms(r.TTFB), r.Attempts, r.Error, r.Output})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(rw.w, string(data))
+	return err
+}
@@ -0,0 +1,342 @@
+package main
+
+// レスポンスのキャッシュ (--cache-dir)
+// GET の 200 のレスポンスを ETag、Last-Modified と一緒にディレクトリに保存し、次の取得では
+// Cache-Control の max-age か Expires の期間内ならサーバーに送らずに保存した本文を返す (hit)
+// 期間を過ぎていれば If-None-Match と If-Modified-Since を付けて送り、304 なら保存した本文を返す (revalidated)
+// それ以外は普通に取得して保存し直す (fetched)。どれだったかを標準エラー出力に表示する
+// --for や --watch で同じURLを繰り返し取得するときに、変わっていない本文を受け取り直さずに済む
+// 期間が指定されていなければ毎回確かめる。no-store のレスポンスと Vary: * のレスポンスは保存しない
+// 自分で条件付きのヘッダーや Range を付けたリクエストはキャッシュを使わない
+// GET 以外のリクエストが成功した場合は、そのURLの保存したレスポンスを消す
+//
+//	gofetch -u https://api.example.com/items --cache-dir ~/.cache/gofetch-api
+//	gofetch -u https://example.com/feed.xml --cache-dir cache/ --for 10
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gofetch/pkg/gofetch/store"
+)
+
+// cacheEntry は保存したレスポンスのヘッダーなど。本文は別に保存する
+type cacheEntry struct {
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Proto  string      `json:"proto"`
+	Header http.Header `json:"header"`
+	// Vary は Vary に挙がったリクエストヘッダーの、保存したときの値
+	Vary     map[string]string `json:"vary,omitempty"`
+	StoredAt time.Time         `json:"stored_at"`
+	Size     int64             `json:"size"`
+}
+
+// cacheTransport はレスポンスを保存し、保存したものを返すか確かめ直す
+type cacheTransport struct {
+	next http.RoundTripper
+	st   *store.Store
+
+	mu sync.Mutex
+	// outcomes は結果 (hit, revalidated, fetched) ごとの数
+	outcomes map[string]int
+	// last は最後のリクエストの結果の説明
+	last string
+}
+
+// newCacheTransport は st に保存するキャッシュを作る
+func newCacheTransport(st *store.Store) *cacheTransport {
+	return &cacheTransport{st: st, outcomes: map[string]int{}}
+}
+
+// cacheKey はURLから保存に使うキーを作る
+func cacheKey(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	return hex.EncodeToString(sum[:])
+}
+
+// wrap は next の上でレスポンスをキャッシュする gofetch.Middleware
+func (t *cacheTransport) wrap(next http.RoundTripper) http.RoundTripper {
+	t.next = next
+	return t
+}
+
+// RoundTrip はキャッシュを使ってリクエストを送る
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := cacheKey(req.URL.String())
+	if req.Method != http.MethodGet {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil && req.Method != http.MethodHead && req.Method != http.MethodOptions && resp.StatusCode < 400 {
+			t.delete(key)
+		}
+		return resp, err
+	}
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "Range"} {
+		if req.Header.Get(name) != "" {
+			return t.next.RoundTrip(req)
+		}
+	}
+
+	entry := t.load(key, req)
+	if entry != nil {
+		if fresh := entry.freshness() - entry.age(time.Now()); fresh > 0 {
+			if resp, err := t.cachedResponse(key, entry, req); err == nil {
+				t.record("hit", fmt.Sprintf("hit (fresh for %s more)", fresh.Round(time.Second)))
+				return resp, nil
+			}
+			entry = nil
+		}
+	}
+
+	out := req
+	if entry != nil {
+		etag, modified := entry.Header.Get("ETag"), entry.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			out = req.Clone(req.Context())
+			if etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				out.Header.Set("If-Modified-Since", modified)
+			}
+		} else {
+			entry = nil
+		}
+	}
+	resp, err := t.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// 304 で届いたヘッダーで保存したヘッダーを新しくする
+		for name, values := range resp.Header {
+			if name != "Content-Length" && name != "Connection" && name != "Transfer-Encoding" {
+				entry.Header[name] = values
+			}
+		}
+		entry.StoredAt = time.Now()
+		if err := t.saveEntry(key, entry); err != nil {
+			verbosef("Cache: failed to update %s: %v", req.URL, err)
+		}
+		cached, err := t.cachedResponse(key, entry, req)
+		if err != nil {
+			return nil, fmt.Errorf("cache: %w", err)
+		}
+		t.record("revalidated", "revalidated (304 Not Modified)")
+		return cached, nil
+	}
+
+	if reason := notStorable(resp); reason != "" {
+		t.record("fetched", fmt.Sprintf("full fetch (%d, not stored: %s)", resp.StatusCode, reason))
+		return resp, nil
+	}
+	w, err := t.st.Blob.Create(key)
+	if err != nil {
+		verbosef("Cache: cannot store %s: %v", req.URL, err)
+		t.record("fetched", fmt.Sprintf("full fetch (%d, not stored)", resp.StatusCode))
+		return resp, nil
+	}
+	entry = &cacheEntry{URL: req.URL.String(), Status: resp.StatusCode, Proto: resp.Proto, Header: resp.Header.Clone(), Vary: map[string]string{}}
+	for _, name := range splitList(strings.Join(resp.Header.Values("Vary"), ",")) {
+		entry.Vary[http.CanonicalHeaderKey(name)] = req.Header.Get(name)
+	}
+	resp.Body = &cacheBody{ReadCloser: resp.Body, w: w, finish: func(size int64, complete bool) {
+		if !complete {
+			// 途中までの本文は保存しない。以前に保存したものも古くなっているので消す
+			t.delete(key)
+			return
+		}
+		entry.StoredAt, entry.Size = time.Now(), size
+		if err := t.saveEntry(key, entry); err != nil {
+			verbosef("Cache: failed to store %s: %v", req.URL, err)
+		}
+	}}
+	t.record("fetched", fmt.Sprintf("full fetch (%d, stored)", resp.StatusCode))
+	return resp, nil
+}
+
+// load は保存したレスポンスを読み込む。ないか、Vary のヘッダーが違えば nil を返す
+func (t *cacheTransport) load(key string, req *http.Request) *cacheEntry {
+	data, err := t.st.KV.Get(key)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			verbosef("Cache: %v", err)
+		}
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		verbosef("Cache: ignoring a broken entry for %s: %v", req.URL, err)
+		return nil
+	}
+	for name, value := range entry.Vary {
+		if req.Header.Get(name) != value {
+			verbosef("Cache: %s differs from the stored response (Vary)", name)
+			return nil
+		}
+	}
+	return &entry
+}
+
+// saveEntry はヘッダーなどを保存する
+func (t *cacheTransport) saveEntry(key string, entry *cacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return t.st.KV.Set(key, data)
+}
+
+// delete は保存したレスポンスを消す
+func (t *cacheTransport) delete(key string) {
+	t.st.KV.Delete(key)
+	t.st.Blob.Delete(key)
+}
+
+// cachedResponse は保存した本文からレスポンスを作る
+func (t *cacheTransport) cachedResponse(key string, entry *cacheEntry, req *http.Request) (*http.Response, error) {
+	body, err := t.st.Blob.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	major, minor, ok := http.ParseHTTPVersion(entry.Proto)
+	if !ok {
+		entry.Proto, major, minor = "HTTP/1.1", 1, 1
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Status, http.StatusText(entry.Status)),
+		StatusCode:    entry.Status,
+		Proto:         entry.Proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        entry.Header.Clone(),
+		Body:          body,
+		ContentLength: entry.Size,
+		Request:       req,
+	}, nil
+}
+
+// record は結果を数える
+func (t *cacheTransport) record(outcome, description string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.outcomes[outcome]++
+	t.last = description
+	verbosef("Cache: %s", description)
+}
+
+// report は結果を w に書く。リクエストが1件なら結果を、複数なら結果ごとの数を書く
+func (t *cacheTransport) report(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := t.outcomes["hit"] + t.outcomes["revalidated"] + t.outcomes["fetched"]
+	switch {
+	case total == 1:
+		fmt.Fprintf(w, "Cache: %s\n", t.last)
+	case total > 1:
+		fmt.Fprintf(w, "Cache: %d hit(s), %d revalidated (304), %d full fetch(es)\n", t.outcomes["hit"], t.outcomes["revalidated"], t.outcomes["fetched"])
+	}
+}
+
+// freshness は保存したレスポンスを確かめずに使える期間を返す
+// max-age か Expires の指定がなければ0にして、毎回確かめる
+func (e *cacheEntry) freshness() time.Duration {
+	cc := parseCacheControl(e.Header.Values("Cache-Control"))
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(n) * time.Second
+	}
+	if exp := e.Header.Get("Expires"); exp != "" {
+		expires, err := http.ParseTime(exp)
+		if err != nil {
+			return 0
+		}
+		date, err := http.ParseTime(e.Header.Get("Date"))
+		if err != nil {
+			date = e.StoredAt
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
+// age は保存したレスポンスが生成されてからの時間を返す。Age ヘッダーの分を含む
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	age := now.Sub(e.StoredAt)
+	if n, err := strconv.Atoi(e.Header.Get("Age")); err == nil && n > 0 {
+		age += time.Duration(n) * time.Second
+	}
+	return age
+}
+
+// notStorable はレスポンスを保存しない理由を返す。保存できれば空文字列を返す
+func notStorable(resp *http.Response) string {
+	if resp.StatusCode != http.StatusOK {
+		return "status"
+	}
+	if _, ok := parseCacheControl(resp.Header.Values("Cache-Control"))["no-store"]; ok {
+		return "no-store"
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return "Vary: *"
+	}
+	return ""
+}
+
+// cacheBody は読んだ本文を保存先にも書く。最後まで読めたときだけ保存を確定する
+type cacheBody struct {
+	io.ReadCloser
+	w      io.WriteCloser
+	n      int64
+	failed bool
+	once   sync.Once
+	finish func(size int64, complete bool)
+}
+
+func (b *cacheBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.failed {
+		if _, werr := b.w.Write(p[:n]); werr != nil {
+			verbosef("Cache: %v", werr)
+			b.failed = true
+		}
+		b.n += int64(n)
+	}
+	if err == io.EOF {
+		b.done(!b.failed)
+	}
+	return n, err
+}
+
+func (b *cacheBody) Close() error {
+	b.done(false)
+	return b.ReadCloser.Close()
+}
+
+// done は保存を確定するか取りやめる
+func (b *cacheBody) done(complete bool) {
+	b.once.Do(func() {
+		if err := b.w.Close(); err != nil {
+			complete = false
+		}
+		b.finish(b.n, complete)
+	})
+}
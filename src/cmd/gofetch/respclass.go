@@ -0,0 +1,103 @@
+package main
+
+// レスポンスの種類ごとのレイテンシ
+// 2xx/3xx/4xx/5xx とタイムアウト、接続エラーを分けて集計し、
+// すぐに返る 503 のおかげでエンドポイントが速く見えるといったことを防ぐ
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// responseClasses は表示するレスポンスの種類の順序
+var responseClasses = []string{"2xx", "3xx", "4xx", "5xx", "timeout", "connect error", "other error", "chaos"}
+
+// responseClass はステータスかエラーからレスポンスの種類を返す
+func responseClass(status int, err error) string {
+	if err != nil {
+		// bench --chaos-* で注入した障害は実際のエラーと分ける
+		if errors.Is(err, errChaos) {
+			return "chaos"
+		}
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return "timeout"
+		}
+		var opErr *net.OpError
+		var dnsErr *net.DNSError
+		if (errors.As(err, &opErr) && opErr.Op == "dial") || errors.As(err, &dnsErr) {
+			return "connect error"
+		}
+		return "other error"
+	}
+	if status >= 200 && status < 600 {
+		return fmt.Sprintf("%dxx", status/100)
+	}
+	return "other error"
+}
+
+// latencyBreakdown はレスポンスの種類ごとのレイテンシを並行して記録する
+type latencyBreakdown struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+}
+
+// newLatencyBreakdown は空の latencyBreakdown を作る
+func newLatencyBreakdown() *latencyBreakdown {
+	return &latencyBreakdown{samples: map[string][]time.Duration{}}
+}
+
+// add は結果1件のレイテンシを記録する
+func (b *latencyBreakdown) add(status int, err error, latency time.Duration) {
+	class := responseClass(status, err)
+	b.mu.Lock()
+	b.samples[class] = append(b.samples[class], latency)
+	b.mu.Unlock()
+}
+
+// merge は別の集計を加える
+func (b *latencyBreakdown) merge(other *latencyBreakdown) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for class, s := range other.samples {
+		b.samples[class] = append(b.samples[class], s...)
+	}
+}
+
+// writeRows は種類ごとの件数とパーセンタイルを1行ずつ表に書く
+// prefix は各行の先頭に付ける列 (ターゲット名など)
+func (b *latencyBreakdown) writeRows(tw io.Writer, prefix string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, class := range responseClasses {
+		s := b.samples[class]
+		if len(s) == 0 {
+			continue
+		}
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		fmt.Fprintf(tw, "%s%s\t%d\t%s\t%s\t%s\t%s\n", prefix, class, len(s),
+			roundLatency(percentile(s, 50)), roundLatency(percentile(s, 90)), roundLatency(percentile(s, 99)), roundLatency(s[len(s)-1]))
+	}
+}
+
+// print はレスポンスの種類ごとの表を w に書く
+func (b *latencyBreakdown) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLASS\tCOUNT\tP50\tP90\tP99\tMAX")
+	b.writeRows(tw, "")
+	tw.Flush()
+}
+
+// roundLatency はレイテンシを表示用に 0.1ms 単位に丸める
+func roundLatency(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
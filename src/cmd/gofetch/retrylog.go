@@ -0,0 +1,80 @@
+package main
+
+// リトライの試行ごとの記録 (--retry-log)
+// 不安定なエンドポイントの調査のために、試行ごとに番号、エラーかステータス、
+// 待った時間、経過時間を記録し、詳細モードでは1行ずつ表示し、
+// --retry-log を指定した場合はJSON Linesで書き出す
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// retryAttempt は試行1回分の記録
+type retryAttempt struct {
+	Attempt int    `json:"attempt"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// ElapsedMS は試行を始めてから本文を読み終えるか失敗するまでの時間
+	ElapsedMS int64 `json:"elapsed_ms"`
+	// BackoffMS は次の試行までに待つ時間。最後の試行では0
+	BackoffMS int64     `json:"backoff_ms"`
+	Time      time.Time `json:"time"`
+}
+
+// retryLog は試行の記録を書き出す
+type retryLog struct {
+	w     io.Writer
+	close func() error
+}
+
+// openRetryLog は記録の書き出し先を開く。"-" なら標準エラー出力に書く
+// path が空なら詳細モードの表示だけを行う
+func openRetryLog(path string) (*retryLog, error) {
+	switch path {
+	case "":
+		return &retryLog{}, nil
+	case "-":
+		return &retryLog{w: os.Stderr}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &retryLog{w: f, close: f.Close}, nil
+}
+
+// record は試行1回分を表示し、書き出し先があればJSONで書く
+func (l *retryLog) record(a retryAttempt) {
+	result := fmt.Sprintf("status %d", a.Status)
+	if a.Error != "" {
+		result = "error: " + a.Error
+	}
+	backoff := ""
+	if a.BackoffMS > 0 {
+		backoff = fmt.Sprintf(", retrying in %s", time.Duration(a.BackoffMS)*time.Millisecond)
+	}
+	verbosef("Attempt %d: %s after %s%s", a.Attempt, result, time.Duration(a.ElapsedMS)*time.Millisecond, backoff)
+	if l.w == nil {
+		return
+	}
+	a.Error = redactSecrets(a.Error)
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	if _, err := fmt.Fprintf(l.w, "%s\n", data); err != nil {
+		verbosef("Retry log: %v", err)
+	}
+}
+
+// Close は書き出し先のファイルを閉じる
+func (l *retryLog) Close() error {
+	if l.close == nil {
+		return nil
+	}
+	return l.close()
+}
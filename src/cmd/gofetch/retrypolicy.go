@@ -0,0 +1,58 @@
+package main
+
+// リトライの方針 (--retry-delay, --retry-max-delay, --retry-on)
+// 待ち時間は --retry-delay から2倍ずつ --retry-max-delay まで延ばし、ばらつきを加える
+// --retry-on に一致するステータスのレスポンスも送り直し、Retry-After があればそれに従う
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gofetch/pkg/gofetch"
+)
+
+// statusRange はステータスの範囲。1つだけの場合は Min と Max が同じ
+type statusRange struct {
+	Min, Max int
+}
+
+// parseRetryOn は "429,500-599" の形のステータスの一覧を解釈する
+func parseRetryOn(spec string) (func(status int) bool, error) {
+	var ranges []statusRange
+	for _, part := range splitList(spec) {
+		lo, hi, isRange := strings.Cut(part, "-")
+		min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		max, err2 := min, error(nil)
+		if isRange {
+			max, err2 = strconv.Atoi(strings.TrimSpace(hi))
+		}
+		if err1 != nil || err2 != nil || min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("invalid status %q in --retry-on (want e.g. 429,500-599)", part)
+		}
+		ranges = append(ranges, statusRange{Min: min, Max: max})
+	}
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	return func(status int) bool {
+		for _, r := range ranges {
+			if status >= r.Min && status <= r.Max {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// retryBackoff は --retry-delay と --retry-max-delay からばらつきのある指数的な待ち時間を作る
+func retryBackoff(delay, maxDelay time.Duration) (gofetch.BackoffFunc, error) {
+	if delay <= 0 {
+		return nil, fmt.Errorf("--retry-delay must be positive")
+	}
+	if maxDelay < delay {
+		return nil, fmt.Errorf("--retry-max-delay must not be shorter than --retry-delay")
+	}
+	return gofetch.WithJitter(gofetch.ExponentialBackoff(delay, maxDelay)), nil
+}
@@ -0,0 +1,260 @@
+package main
+
+// シークレットの埋め込み
+// エイリアスのURLとヘッダー、api サブコマンドのヘッダーと本文に書いた
+// {{env "API_KEY"}}、{{file "/run/secrets/token"}}、{{secret "vault:kv/data/api#token"}} を
+// リクエストの直前に解決する
+// secret は env:、file:、vault: (HashiCorp Vault)、aws: (AWS Secrets Manager)、age: (暗号化した値) から取り出す
+// {{decrypt "..."}} は gofetch config encrypt で暗号化した値を復号する (configcrypt.go)
+// 解決した値は詳細モードの表示やリトライの記録に出さないように伏せる
+// 短い値は文字列の中から探さず、埋め込みを使ったヘッダーとクエリパラメーターの値を位置で伏せる
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretPlaceholder は {{env "NAME"}} のような埋め込み
+var secretPlaceholder = regexp.MustCompile(`\{\{\s*(env|file|secret|decrypt)\s+"([^"]*)"\s*\}\}`)
+
+// secretMask は伏せた値の代わりに表示する文字列
+const secretMask = "****"
+
+// minSecretLen は文字列の中から探して伏せるシークレットの最小の長さ
+// これより短い値は https や URL の一部まで伏せてしまうので探さない
+// 認証のヘッダーとクエリパラメーターの値は長さによらず位置で伏せる (maskSensitive、registerSecretParam)
+const minSecretLen = 6
+
+// secretValues は解決したシークレットの値と、値を伏せるクエリパラメーター。表示する前に伏せる
+var secretValues struct {
+	sync.Mutex
+	list   []string
+	params []*regexp.Regexp
+}
+
+// registerSecret は値を伏せる対象に加える。minSecretLen より短い値は加えない
+func registerSecret(v string) {
+	if len(v) < minSecretLen {
+		return
+	}
+	secretValues.Lock()
+	secretValues.list = append(secretValues.list, v)
+	secretValues.Unlock()
+}
+
+// registerSecretParam はURLのクエリパラメーター name の値を伏せる対象に加える
+func registerSecretParam(name string) {
+	re := regexp.MustCompile(`([?&]` + regexp.QuoteMeta(url.QueryEscape(name)) + `=)[^&#\s"']*`)
+	secretValues.Lock()
+	secretValues.params = append(secretValues.params, re)
+	secretValues.Unlock()
+}
+
+// redactSecrets は文字列に含まれるシークレットの値と、伏せる対象のクエリパラメーターの値を伏せる
+func redactSecrets(s string) string {
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	for _, v := range secretValues.list {
+		s = strings.ReplaceAll(s, v, secretMask)
+	}
+	for _, re := range secretValues.params {
+		s = re.ReplaceAllString(s, "${1}"+secretMask)
+	}
+	return s
+}
+
+// expandSecrets は文字列の中の埋め込みをすべて解決する
+func expandSecrets(s string) (string, error) {
+	var firstErr error
+	out := secretPlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+		sub := secretPlaceholder.FindStringSubmatch(m)
+		var v string
+		var err error
+		switch sub[1] {
+		case "env":
+			v, err = resolveSecret("env:" + sub[2])
+		case "file":
+			v, err = resolveSecret("file:" + sub[2])
+		case "decrypt":
+			v, err = resolveSecret("age:" + sub[2])
+		default:
+			v, err = resolveSecret(sub[2])
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return m
+		}
+		registerSecret(v)
+		return v
+	})
+	return out, firstErr
+}
+
+// expandSecretHeader はヘッダーの値の埋め込みを解決する
+// 埋め込みを使ったヘッダーは、短い値も伏せられるよう認証情報のヘッダーと同じく表示では値ごと伏せ、別のオリジンに転送しない
+func expandSecretHeader(name, value string) (string, error) {
+	if !secretPlaceholder.MatchString(value) {
+		return value, nil
+	}
+	addSensitiveHeader(name)
+	return expandSecrets(value)
+}
+
+// expandSecretURL はURLの埋め込みを解決する
+// 埋め込みを使ったクエリパラメーターは、短い値も伏せられるよう表示するURLの中の値を伏せる
+func expandSecretURL(rawURL string) (string, error) {
+	if _, query, ok := strings.Cut(rawURL, "?"); ok {
+		for _, pair := range strings.Split(query, "&") {
+			if name, value, _ := strings.Cut(pair, "="); secretPlaceholder.MatchString(value) {
+				if n, err := url.QueryUnescape(name); err == nil {
+					registerSecretParam(n)
+				}
+			}
+		}
+	}
+	return expandSecrets(rawURL)
+}
+
+// resolveSecret は "provider:reference" の形の参照からシークレットを取り出す
+func resolveSecret(ref string) (string, error) {
+	provider, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid secret reference %q (want provider:reference)", ref)
+	}
+	switch provider {
+	case "env":
+		v, ok := os.LookupEnv(rest)
+		if !ok {
+			return "", fmt.Errorf("secret: environment variable %s is not set", rest)
+		}
+		return v, nil
+	case "file":
+		data, err := os.ReadFile(rest)
+		if err != nil {
+			return "", fmt.Errorf("secret: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "vault":
+		path, field, _ := strings.Cut(rest, "#")
+		return vaultSecret(path, field)
+	case "aws":
+		id, field, _ := strings.Cut(rest, "#")
+		return awsSecret(id, field)
+	case "age":
+		return decryptConfigValue(rest)
+	}
+	return "", fmt.Errorf("unknown secret provider %q (want env, file, vault, aws or age)", provider)
+}
+
+// secretField はJSONのオブジェクトからフィールドを取り出す
+// フィールドを省略した場合は、オブジェクトにフィールドが1つだけならそれを使う
+func secretField(data map[string]any, field, ref string) (string, error) {
+	if field == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("secret %s has %d fields; choose one with #field", ref, len(data))
+		}
+		for k := range data {
+			field = k
+		}
+	}
+	v, ok := data[field]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no field %q", ref, field)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// vaultSecret はHashiCorp VaultのKVからシークレットを読む
+// アドレスは VAULT_ADDR、トークンは VAULT_TOKEN か ~/.vault-token から取る
+// KV v2 では kv/data/api のように data を含めたパスを指定する
+func vaultSecret(path, field string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("secret vault:%s: VAULT_ADDR is not set", path)
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("secret vault:%s: VAULT_TOKEN is not set and ~/.vault-token is missing", path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("secret vault:%s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("secret vault:%s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secret vault:%s: %s", path, resp.Status)
+	}
+	var doc struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", fmt.Errorf("secret vault:%s: %w", path, err)
+	}
+	// KV v2 は data の中にさらに data がある
+	data := doc.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, hasMeta := data["metadata"]; hasMeta {
+			data = inner
+		}
+	}
+	return secretField(data, field, "vault:"+path)
+}
+
+// awsSecret はAWS Secrets Managerからシークレットを読む
+// 認証情報とリージョンの扱いをそろえるため、aws コマンドに任せる
+// #field を指定した場合はシークレットの文字列をJSONとして読み、そのフィールドを取り出す
+func awsSecret(id, field string) (string, error) {
+	out, err := exec.Command("aws", "secretsmanager", "get-secret-value",
+		"--secret-id", id, "--query", "SecretString", "--output", "text").Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("secret aws:%s: %s", id, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("secret aws:%s: %w", id, err)
+	}
+	value := strings.TrimRight(string(out), "\r\n")
+	if field == "" {
+		return value, nil
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return "", fmt.Errorf("secret aws:%s: value is not JSON, cannot select #%s", id, field)
+	}
+	return secretField(data, field, "aws:"+id)
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+// resetSecrets はテストの間だけ伏せる対象を空にする
+func resetSecrets(t *testing.T) {
+	t.Helper()
+	secretValues.Lock()
+	list, params := secretValues.list, secretValues.params
+	secretValues.list, secretValues.params = nil, nil
+	secretValues.Unlock()
+	saved := sensitiveHeaders
+	sensitiveHeaders = append([]string(nil), saved...)
+	t.Cleanup(func() {
+		secretValues.Lock()
+		secretValues.list, secretValues.params = list, params
+		secretValues.Unlock()
+		sensitiveHeaders = saved
+	})
+}
+
+func TestRedactSecretsAuth(t *testing.T) {
+	tests := []struct {
+		name                  string
+		basic, bearer, apiKey string
+		keyIn                 string
+		in, want              string
+	}{
+		{name: "short password leaves the URL alone", basic: "u:p", keyIn: "header",
+			in: `Get "https://example.com/up": EOF`, want: `Get "https://example.com/up": EOF`},
+		{name: "long password is found anywhere", basic: "admin:hunter22", keyIn: "header",
+			in: "echo hunter22", want: "echo ****"},
+		{name: "short bearer token is not searched for", bearer: "abc", keyIn: "header",
+			in: "https://abc.example.com/", want: "https://abc.example.com/"},
+		{name: "short query key is masked by position", apiKey: "k=ab", keyIn: "query",
+			in: `Get "https://ab.example.com/?k=ab&page=2": EOF`, want: `Get "https://ab.example.com/?k=****&page=2": EOF`},
+		{name: "other parameters keep their values", apiKey: "api_key=ab", keyIn: "query",
+			in: "/items?q=ab&api_key=ab", want: "/items?q=ab&api_key=****"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSecrets(t)
+			if _, err := parseRequestAuth(tt.basic, tt.bearer, tt.apiKey, tt.keyIn); err != nil {
+				t.Fatal(err)
+			}
+			if got := redactSecrets(tt.in); got != tt.want {
+				t.Errorf("redactSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandSecretPositions(t *testing.T) {
+	resetSecrets(t)
+	t.Setenv("GOFETCH_TEST_SHORT", "ab")
+
+	v, err := expandSecretHeader("X-Token", `{{env "GOFETCH_TEST_SHORT"}}`)
+	if err != nil || v != "ab" {
+		t.Fatalf("expandSecretHeader = %q, %v", v, err)
+	}
+	if got := maskSensitive("X-Token", v); got != "****" {
+		t.Errorf("maskSensitive(X-Token) = %q, want ****", got)
+	}
+	if got := maskSensitive("X-Trace", "ab"); got != "ab" {
+		t.Errorf("maskSensitive(X-Trace) = %q, want ab", got)
+	}
+
+	u, err := expandSecretURL(`https://ab.example.com/items?token={{env "GOFETCH_TEST_SHORT"}}&q=ab`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := redactSecrets(u), "https://ab.example.com/items?token=****&q=ab"; got != want {
+		t.Errorf("redactSecrets = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+// リクエストの複製 (--shadow-to, --shadow-compare)
+// 本来のリクエストと同じメソッド、ヘッダー、本文のリクエストを別のエンドポイントにも並行して送る
+// 切り替える前の新しいバックエンドを実際の形のリクエストで確かめるために使う
+// 複製先のURLは --shadow-to のベースURLに本来のURLのパスとクエリをつなげたもの
+// 複製先のレスポンスは捨てるか、--shadow-compare なら本来のレスポンスとステータス、
+// Content-Type、本文を比べる。JSONの本文はキーの順序や空白の違いを無視して比べる
+// --mask の規則に一致した値は両方で伏せてから比べる
+// 複製先の失敗は終了コードに影響しない
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"gofetch/pkg/gofetch"
+)
+
+// shadowMirror はリクエストを複製先に送る
+type shadowMirror struct {
+	base    string
+	client  *http.Client
+	compare bool
+	masks   maskRules
+}
+
+// shadowCall は複製したリクエスト1件。done が閉じたら結果が入っている
+type shadowCall struct {
+	url     string
+	method  string
+	done    chan struct{}
+	status  int
+	header  http.Header
+	body    []byte
+	elapsed time.Duration
+	err     error
+}
+
+// newShadowMirror は base に複製を送る shadowMirror を作る
+// client は本来のリクエストと同じTLSやプロキシの設定を使うためのもの。リダイレクトは既定のとおりにたどる
+func newShadowMirror(base string, client *http.Client, compare bool, masks maskRules) (*shadowMirror, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid --shadow-to URL %q (want scheme://host[/path])", base)
+	}
+	c := *client
+	c.CheckRedirect = nil
+	return &shadowMirror{base: base, client: &c, compare: compare, masks: masks}, nil
+}
+
+// start は r の複製を送り始める
+func (m *shadowMirror) start(r gofetch.Request) *shadowCall {
+	call := &shadowCall{method: r.Method, done: make(chan struct{})}
+	if call.method == "" {
+		call.method = http.MethodGet
+	}
+	u, err := url.Parse(r.URL)
+	if err == nil {
+		call.url, err = joinBaseURL(m.base, u.RequestURI())
+	}
+	if err != nil {
+		call.err = err
+		close(call.done)
+		return call
+	}
+	go func() {
+		defer close(call.done)
+		start := time.Now()
+		mirror := r
+		mirror.Method, mirror.URL, mirror.Close = call.method, call.url, false
+		req, err := mirror.NewHTTPRequest(context.Background())
+		if err != nil {
+			call.err = err
+			return
+		}
+		resp, err := m.client.Do(req)
+		if err != nil {
+			call.err = err
+			return
+		}
+		defer resp.Body.Close()
+		if m.compare {
+			call.body, call.err = io.ReadAll(resp.Body)
+		} else {
+			_, call.err = io.Copy(io.Discard, resp.Body)
+		}
+		call.status, call.header, call.elapsed = resp.StatusCode, resp.Header, time.Since(start)
+	}()
+	return call
+}
+
+// finish は複製の結果を待って表示する。compare なら本来のレスポンスと比べる
+// primary が nil なら本来のリクエストは失敗している
+func (m *shadowMirror) finish(call *shadowCall, primary *gofetch.Response) {
+	<-call.done
+	if call.err != nil {
+		fmt.Fprintf(os.Stderr, "Shadow: %s %s: %s\n", call.method, call.url, redactSecrets(call.err.Error()))
+		return
+	}
+	summary := fmt.Sprintf("Shadow: %s %s -> %d in %s", call.method, call.url, call.status, call.elapsed.Round(time.Millisecond))
+	if !m.compare || primary == nil {
+		fmt.Fprintln(os.Stderr, summary)
+		return
+	}
+	var diffs []string
+	if primary.StatusCode != call.status {
+		diffs = append(diffs, fmt.Sprintf("status %d != %d", primary.StatusCode, call.status))
+	}
+	primaryType, shadowType := mediaType(primary.Header), mediaType(call.header)
+	if primaryType != shadowType {
+		diffs = append(diffs, fmt.Sprintf("Content-Type %s != %s", orDash(primaryType), orDash(shadowType)))
+	}
+	if !sameBody(m.masks.apply(primary.Body), m.masks.apply(call.body), primaryType) {
+		diffs = append(diffs, fmt.Sprintf("body differs (%s vs %s)", formatSize(int64(len(primary.Body))), formatSize(int64(len(call.body)))))
+	}
+	if len(diffs) == 0 {
+		fmt.Fprintf(os.Stderr, "%s, same as the primary response\n", summary)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s, MISMATCH: %s\n", summary, strings.Join(diffs, "; "))
+}
+
+// mediaType は Content-Type のパラメーターを除いた部分を返す
+func mediaType(h http.Header) string {
+	t, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return h.Get("Content-Type")
+	}
+	return t
+}
+
+// sameBody は本文が同じかを返す。JSONなら値として比べる
+func sameBody(a, b []byte, contentType string) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	if contentType != "application/json" && !strings.HasSuffix(contentType, "+json") {
+		return false
+	}
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,280 @@
+package main
+
+// 監視中のSLOの評価 (--slo, --slo-webhook)
+// --watch の取得ごとにレイテンシとステータスを記録し、SLOの目標ごとにエラーバジェットを使う速さ (燃焼率) を求める
+// 目標はカンマで区切って並べ、期間は over で指定する。期間を書かなかった目標は最初に書いた期間 (なければ1h) を使う
+//
+//	p99<500ms over 1h   99% の取得が 500ms 以内 (失敗した取得は数えない)
+//	availability>99.9%  99.9% の取得が成功する (接続などの失敗と 5xx を失敗とする)
+//
+// 燃焼率は悪い取得の割合を許される割合 (エラーバジェット) で割ったもので、1 なら期間の終わりにちょうど使い切る
+// 期間全体と直近の期間の 1/12 の両方で燃焼率が sloAlertBurnRate 以上になったら、このままでは目標を守れないとして
+// 標準エラー出力に警告し、--slo-webhook があればJSONで POST する。直近の燃焼率が下がったら回復を知らせる
+//
+//	gofetch -u https://api.example.com/health --watch --interval 10s --slo "p99<500ms over 1h, availability>99.9%" --slo-webhook https://hooks.example.com/slo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sloDefaultWindow は期間を書かなかった場合の期間
+	sloDefaultWindow = time.Hour
+	// sloAlertBurnRate は警告する燃焼率。2 なら期間の半分でエラーバジェットを使い切る速さ
+	sloAlertBurnRate = 2
+	// sloMinSamples は期間全体で燃焼率を判断するのに要る取得の数
+	sloMinSamples = 3
+	// sloWebhookTimeout は --slo-webhook に送るときのタイムアウト
+	sloWebhookTimeout = 10 * time.Second
+)
+
+// sloObjectivePattern は目標1つの書き方
+var sloObjectivePattern = regexp.MustCompile(`^(?:p(\d+(?:\.\d+)?)\s*<\s*(\S+)|availability\s*>=?\s*(\d+(?:\.\d+)?)%)(?:\s+over\s+(\S+))?$`)
+
+// sloObjective はSLOの目標1つ
+type sloObjective struct {
+	// spec は表示に使う元の書き方
+	spec string
+	// latency が0でなければレイテンシの目標で、latency より遅い取得を悪いものとする
+	latency time.Duration
+	// target は良い取得の割合の目標 (0.999 など)
+	target float64
+	window time.Duration
+	// firing は警告を出している最中か
+	firing bool
+	alerts int
+}
+
+// sloSample は1回の取得の記録
+type sloSample struct {
+	at      time.Time
+	latency time.Duration
+	status  int
+	// failed はレスポンスを受け取れなかったか
+	failed bool
+}
+
+// sloMonitor は --watch の取得を記録してSLOを評価する
+type sloMonitor struct {
+	objectives []*sloObjective
+	samples    []sloSample
+	// interval は --interval。直近の範囲が取得の間隔より短くならないようにする
+	interval time.Duration
+	webhook  string
+}
+
+// parseSLO は --slo の指定を解釈する
+func parseSLO(spec string) ([]*sloObjective, error) {
+	var objectives []*sloObjective
+	var window time.Duration
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		m := sloObjectivePattern.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid --slo objective %q (want e.g. p99<500ms over 1h or availability>99.9%%)", part)
+		}
+		o := &sloObjective{spec: part}
+		if m[1] != "" {
+			p, _ := strconv.ParseFloat(m[1], 64)
+			d, err := time.ParseDuration(m[2])
+			if err != nil || d <= 0 || p <= 0 || p >= 100 {
+				return nil, fmt.Errorf("invalid --slo objective %q (want a percentile below 100 and a positive duration)", part)
+			}
+			o.latency, o.target = d, p/100
+		} else {
+			a, _ := strconv.ParseFloat(m[3], 64)
+			if a <= 0 || a >= 100 {
+				return nil, fmt.Errorf("invalid --slo objective %q (availability must be between 0%% and 100%%)", part)
+			}
+			o.target = a / 100
+		}
+		if m[4] != "" {
+			d, err := parseSLOWindow(m[4])
+			if err != nil {
+				return nil, fmt.Errorf("invalid --slo window in %q: %w", part, err)
+			}
+			o.window = d
+			if window == 0 {
+				window = d
+			}
+		}
+		objectives = append(objectives, o)
+	}
+	if window == 0 {
+		window = sloDefaultWindow
+	}
+	for _, o := range objectives {
+		if o.window == 0 {
+			o.window = window
+		}
+	}
+	return objectives, nil
+}
+
+// parseSLOWindow は 1h や 30m のほかに 7d のような日数も受け付ける
+func parseSLOWindow(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n float64
+		n, err = strconv.ParseFloat(days, 64)
+		d = time.Duration(n * float64(24*time.Hour))
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("want a positive duration like 1h or 7d, got %q", s)
+	}
+	return d, nil
+}
+
+// bad は取得が目標を数える対象か、悪いものかを返す
+func (o *sloObjective) bad(s sloSample) (counted, bad bool) {
+	if o.latency > 0 {
+		return !s.failed, !s.failed && s.latency > o.latency
+	}
+	return true, s.failed || s.status >= 500
+}
+
+// record は1回の取得を記録し、最も長い期間より古い記録を捨てる
+func (m *sloMonitor) record(s sloSample) {
+	m.samples = append(m.samples, s)
+	var longest time.Duration
+	for _, o := range m.objectives {
+		longest = max(longest, o.window)
+	}
+	keep := slices.IndexFunc(m.samples, func(old sloSample) bool { return s.at.Sub(old.at) <= longest })
+	m.samples = m.samples[keep:]
+}
+
+// burnRate は since より後の取得での目標 o の燃焼率、良い取得の割合、数えた取得の数を返す
+func (m *sloMonitor) burnRate(o *sloObjective, since time.Time) (rate, good float64, n int) {
+	bad := 0
+	for _, s := range m.samples {
+		if s.at.Before(since) {
+			continue
+		}
+		if counted, isBad := o.bad(s); counted {
+			n++
+			if isBad {
+				bad++
+			}
+		}
+	}
+	if n == 0 {
+		return 0, 1, 0
+	}
+	ratio := float64(bad) / float64(n)
+	return ratio / (1 - o.target), 1 - ratio, n
+}
+
+// shortWindow は直近として見る範囲。期間の 1/12 で、取得の間隔の3回分より短くはしない
+func (m *sloMonitor) shortWindow(o *sloObjective) time.Duration {
+	return min(max(o.window/12, 3*m.interval), o.window)
+}
+
+// evaluate は最新の記録でそれぞれの目標を評価し、警告と回復を知らせる
+func (m *sloMonitor) evaluate(ctx context.Context, url string, now time.Time) {
+	for _, o := range m.objectives {
+		short := m.shortWindow(o)
+		shortRate, _, shortN := m.burnRate(o, now.Add(-short))
+		longRate, good, longN := m.burnRate(o, now.Add(-o.window))
+		alert := sloAlert{URL: redactSecrets(url), SLO: o.spec, ShortBurnRate: shortRate, LongBurnRate: longRate,
+			ShortWindow: short.String(), LongWindow: o.window.String(), GoodRatio: good, Objective: o.target, Time: now}
+		switch {
+		case !o.firing && longN >= sloMinSamples && shortN > 0 && shortRate >= sloAlertBurnRate && longRate >= sloAlertBurnRate:
+			o.firing = true
+			o.alerts++
+			alert.State = "firing"
+			fmt.Fprintf(os.Stderr, "%s SLO at risk: %s: burn rate %.1f over %s, %.1f over %s (%.2f%% good, objective %.2f%%)\n",
+				now.Format("15:04:05"), o.spec, shortRate, short, longRate, o.window, good*100, o.target*100)
+		case o.firing && shortRate < sloAlertBurnRate:
+			o.firing = false
+			alert.State = "resolved"
+			fmt.Fprintf(os.Stderr, "%s SLO recovered: %s: burn rate %.1f over %s\n", now.Format("15:04:05"), o.spec, shortRate, short)
+		default:
+			verbosef("SLO %s: burn rate %.1f over %s, %.1f over %s", o.spec, shortRate, short, longRate, o.window)
+			continue
+		}
+		if m.webhook != "" {
+			if err := sendSLOAlert(ctx, m.webhook, alert); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "SLO webhook: %v\n", redactSecrets(err.Error()))
+			}
+		}
+	}
+}
+
+// report は監視を止めたときに、目標ごとの期間全体の結果を表示する
+func (m *sloMonitor) report(now time.Time) {
+	for _, o := range m.objectives {
+		rate, good, n := m.burnRate(o, now.Add(-o.window))
+		if n == 0 {
+			fmt.Fprintf(os.Stderr, "SLO %s: no results to evaluate\n", o.spec)
+			continue
+		}
+		observed := ""
+		if o.latency > 0 {
+			var latencies []time.Duration
+			for _, s := range m.samples {
+				if counted, _ := o.bad(s); counted && !s.at.Before(now.Add(-o.window)) {
+					latencies = append(latencies, s.latency)
+				}
+			}
+			slices.Sort(latencies)
+			if len(latencies) > 0 {
+				observed = fmt.Sprintf(", p%s %s", strconv.FormatFloat(o.target*100, 'f', -1, 64), percentile(latencies, o.target*100).Round(time.Millisecond))
+			}
+		}
+		fmt.Fprintf(os.Stderr, "SLO %s: %.2f%% good of %d (objective %.2f%%)%s, burn rate %.1f, %d alert(s)\n",
+			o.spec, good*100, n, o.target*100, observed, rate, o.alerts)
+	}
+}
+
+// sloAlert は --slo-webhook に送る警告
+type sloAlert struct {
+	URL string `json:"url"`
+	SLO string `json:"slo"`
+	// State は firing (警告) か resolved (回復)
+	State         string    `json:"state"`
+	ShortBurnRate float64   `json:"short_burn_rate"`
+	LongBurnRate  float64   `json:"long_burn_rate"`
+	ShortWindow   string    `json:"short_window"`
+	LongWindow    string    `json:"long_window"`
+	GoodRatio     float64   `json:"good_ratio"`
+	Objective     float64   `json:"objective"`
+	Time          time.Time `json:"time"`
+}
+
+// sendSLOAlert は警告をJSONで webhook に POST する
+func sendSLOAlert(ctx context.Context, webhook string, alert sloAlert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, sloWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", webhook, resp.Status)
+	}
+	return nil
+}
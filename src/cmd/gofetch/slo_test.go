@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseSLO(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []sloObjective
+		wantErr bool
+	}{
+		{spec: "p99<500ms over 1h", want: []sloObjective{{latency: 500 * time.Millisecond, target: 0.99, window: time.Hour}}},
+		{spec: "availability>99.5%", want: []sloObjective{{target: 0.995, window: sloDefaultWindow}}},
+		// 期間を書かなかった目標は最初に書いた期間を使う
+		{spec: "availability>=99% , p95<1s over 7d", want: []sloObjective{
+			{target: 0.99, window: 7 * 24 * time.Hour},
+			{latency: time.Second, target: 0.95, window: 7 * 24 * time.Hour},
+		}},
+		{spec: "p100<1s", wantErr: true},
+		{spec: "p99<fast", wantErr: true},
+		{spec: "availability>100%", wantErr: true},
+		{spec: "availability>99% over 0s", wantErr: true},
+		{spec: "uptime>99%", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseSLO(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d objectives, want %d", len(got), len(tt.want))
+			}
+			for i, o := range got {
+				w := tt.want[i]
+				if o.latency != w.latency || o.target != w.target || o.window != w.window {
+					t.Errorf("%d: latency %v target %v window %v, want %v %v %v", i, o.latency, o.target, o.window, w.latency, w.target, w.window)
+				}
+			}
+		})
+	}
+}
+
+func TestSLOEvaluate(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// statuses は1秒おきの取得のステータス。0 は接続の失敗
+		statuses   []int
+		wantAlerts int
+		wantFiring bool
+	}{
+		{name: "healthy", statuses: []int{200, 200, 200, 200, 200, 200}},
+		{name: "too few samples", statuses: []int{500, 500}},
+		{name: "sustained errors", statuses: []int{200, 200, 500, 0, 500}, wantAlerts: 1, wantFiring: true},
+		{name: "recovers", statuses: []int{200, 200, 500, 500, 200, 200, 200, 200, 200, 200}, wantAlerts: 1},
+		{name: "fires again", statuses: []int{500, 500, 500, 200, 200, 200, 200, 200, 200, 500, 500}, wantAlerts: 2, wantFiring: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objectives, err := parseSLO("availability>90% over 60s")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &sloMonitor{objectives: objectives, interval: time.Second}
+			for i, status := range tt.statuses {
+				now := start.Add(time.Duration(i) * time.Second)
+				m.record(sloSample{at: now, status: status, failed: status == 0})
+				m.evaluate(context.Background(), "http://example.com/", now)
+			}
+			o := objectives[0]
+			if o.alerts != tt.wantAlerts || o.firing != tt.wantFiring {
+				t.Errorf("alerts = %d firing = %v, want %d %v", o.alerts, o.firing, tt.wantAlerts, tt.wantFiring)
+			}
+		})
+	}
+}
+
+func TestSLORecordDropsOldSamples(t *testing.T) {
+	objectives, _ := parseSLO("p99<1s over 10s")
+	m := &sloMonitor{objectives: objectives, interval: time.Second}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 30 {
+		m.record(sloSample{at: start.Add(time.Duration(i) * time.Second), status: 200})
+	}
+	if len(m.samples) != 11 {
+		t.Errorf("kept %d samples, want 11", len(m.samples))
+	}
+}
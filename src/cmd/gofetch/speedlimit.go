@@ -0,0 +1,57 @@
+package main
+
+// 転送速度の下限 (--speed-limit, --speed-time)
+// curl の --speed-limit/--speed-time と同じように、本文の受信速度が一定時間しきい値を下回ったら転送を打ち切る
+// 止まった接続を全体のタイムアウトまで待たずに失敗させ、リトライに回す
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// lowSpeedReader は一定時間ごとに受信したバイト数を調べ、遅すぎれば cancel を呼ぶ
+type lowSpeedReader struct {
+	io.ReadCloser
+	n    atomic.Int64
+	stop chan struct{}
+	once sync.Once
+}
+
+// watchLowSpeed は本文の受信速度が period の間 limit バイト/秒を下回ったら cancel で転送を打ち切る
+func watchLowSpeed(body io.ReadCloser, limit int64, period time.Duration, cancel context.CancelCauseFunc) io.ReadCloser {
+	r := &lowSpeedReader{ReadCloser: body, stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				n := r.n.Swap(0)
+				if float64(n) < float64(limit)*period.Seconds() {
+					cancel(fmt.Errorf("transfer slower than %s/s for %s (received %s)", formatSize(limit), period, formatSize(n)))
+					return
+				}
+			}
+		}
+	}()
+	return r
+}
+
+// Read は受信したバイト数を数える
+func (r *lowSpeedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+// Close は監視を止めて本文を閉じる
+func (r *lowSpeedReader) Close() error {
+	r.once.Do(func() { close(r.stop) })
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+// multipart のレスポンスの分割 (--split-multipart)
+// multipart/mixed や複数の範囲を頼んだ multipart/byteranges のレスポンスを境界で分け、
+// パートごとに別のファイルに書く。境界の行をそのまま標準出力に出さずに済む
+// ファイル名は Content-Disposition の filename があればそれを、なければ part-1.json のように番号と
+// Content-Type の拡張子を使う。byteranges では part-1-bytes-0-499.bin のように範囲を付ける
+// - を指定するとファイルに書かずに、パートのヘッダーを付けて区切って標準出力に書く
+// Content-Transfer-Encoding が base64 と quoted-printable のパートは元に戻して書く
+//
+//	gofetch -u https://example.com/batch --split-multipart parts/
+//	gofetch -u https://example.com/video.mp4 -H 'Range: bytes=0-99,500-599' --split-multipart -
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// responsePart は multipart のレスポンスのパート1つ
+type responsePart struct {
+	header http.Header
+	body   []byte
+}
+
+// splitMultipart は multipart のレスポンスの本文をパートに分ける
+func splitMultipart(contentType string, body []byte) ([]responsePart, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("--split-multipart: response is not multipart (Content-Type: %s)", orDash(contentType))
+	}
+	if params["boundary"] == "" {
+		return nil, fmt.Errorf("--split-multipart: %s response has no boundary", mediaType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	var parts []responsePart
+	for {
+		// NextRawPart は quoted-printable をそのまま返すので、base64 と合わせて自分で戻す
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, fmt.Errorf("--split-multipart: part %d: %w", len(parts)+1, err)
+		}
+		data, err := io.ReadAll(decodeTransferEncoding(p.Header.Get("Content-Transfer-Encoding"), p))
+		if err != nil {
+			return parts, fmt.Errorf("--split-multipart: part %d: %w", len(parts)+1, err)
+		}
+		parts = append(parts, responsePart{header: http.Header(p.Header), body: data})
+	}
+}
+
+// decodeTransferEncoding は Content-Transfer-Encoding を元に戻すリーダーを返す
+func decodeTransferEncoding(encoding string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		// 行の区切りは base64 のデコーダーが読み飛ばす
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// partFileName はパートを書くファイルの名前を決める
+func partFileName(index int, p responsePart) string {
+	if _, params, err := mime.ParseMediaType(p.header.Get("Content-Disposition")); err == nil {
+		// パスの要素を除き、ディレクトリの外に書かないようにする
+		if name := filepath.Base(filepath.Clean("/" + params["filename"])); name != "/" && name != "." {
+			return name
+		}
+	}
+	name := fmt.Sprintf("part-%d", index+1)
+	if r := p.header.Get("Content-Range"); r != "" {
+		var start, end int64
+		if _, err := fmt.Sscanf(r, "bytes %d-%d/", &start, &end); err == nil {
+			name += fmt.Sprintf("-bytes-%d-%d", start, end)
+		}
+	}
+	ext := ".bin"
+	if mediaType, _, err := mime.ParseMediaType(p.header.Get("Content-Type")); err == nil {
+		if e, ok := partExtensions[mediaType]; ok {
+			ext = e
+		} else if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return name + ext
+}
+
+// partExtensions はよく使う Content-Type の拡張子
+// mime.ExtensionsByType は拡張子を名前の順に返すので、text/plain が .asc になるのを避ける
+var partExtensions = map[string]string{
+	"text/plain":       ".txt",
+	"text/html":        ".html",
+	"text/csv":         ".csv",
+	"application/json": ".json",
+	"application/xml":  ".xml",
+	"image/jpeg":       ".jpg",
+	"video/mp4":        ".mp4",
+	"audio/mpeg":       ".mp3",
+}
+
+// writeMultipartParts はパートを dir に書き、書いたファイルを標準エラー出力に表示する
+// dir が - なら区切りとヘッダーを付けて w に書く
+func writeMultipartParts(parts []responsePart, dir string, w io.Writer) error {
+	if dir == "-" {
+		for i, p := range parts {
+			fmt.Fprintf(w, "--- part %d of %d ---\n", i+1, len(parts))
+			for _, name := range sortedHeaderNames(p.header) {
+				for _, v := range p.header[name] {
+					fmt.Fprintf(w, "%s: %s\n", name, v)
+				}
+			}
+			fmt.Fprintln(w)
+			w.Write(p.body)
+			if !bytes.HasSuffix(p.body, []byte("\n")) {
+				fmt.Fprintln(w)
+			}
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	seen := map[string]int{}
+	for i, p := range parts {
+		name := partFileName(i, p)
+		// 同じ名前のパートは -2, -3 を付けて上書きしない
+		if seen[name]++; seen[name] > 1 {
+			ext := filepath.Ext(name)
+			name = fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), seen[name], ext)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, p.body, 0644); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Part %d: %s, %s -> %s\n", i+1, orDash(p.header.Get("Content-Type")), formatSize(int64(len(p.body))), path)
+	}
+	return nil
+}
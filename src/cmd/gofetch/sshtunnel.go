@@ -0,0 +1,149 @@
+package main
+
+// SSHの踏み台サーバーを経由した接続 (--ssh-tunnel)
+// "ssh -D" でSOCKSプロキシを立ててから --proxy を指定する手間を省くため、
+// HTTPの接続をSSHの direct-tcpip チャネルで直接張る
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSSHKeys は鍵ファイルを指定しなかったときに探す秘密鍵
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// parseSSHTarget は user@host[:port] を分解する
+// ユーザー名を省略した場合は現在のユーザー、ポートを省略した場合は22を使う
+func parseSSHTarget(target string) (string, string, error) {
+	username, hostport, ok := strings.Cut(target, "@")
+	if !ok {
+		hostport = target
+		u, err := user.Current()
+		if err != nil {
+			return "", "", err
+		}
+		username = u.Username
+		// Windowsでは DOMAIN\user の形になる
+		if i := strings.LastIndex(username, `\`); i >= 0 {
+			username = username[i+1:]
+		}
+	}
+	if hostport == "" {
+		return "", "", fmt.Errorf("invalid SSH tunnel target %q", target)
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(hostport, "22")
+	}
+	return username, hostport, nil
+}
+
+// sshAuthMethods はssh-agentと秘密鍵ファイルから認証方法を組み立てる
+func sshAuthMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+
+	// ssh-agent
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			verbosef("SSH: cannot connect to agent: %v", err)
+		} else {
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err != nil {
+				verbosef("SSH: agent: %v", err)
+			}
+			signers = append(signers, agentSigners...)
+		}
+	}
+
+	// 秘密鍵ファイル
+	var keyFiles []string
+	if keyFile != "" {
+		keyFiles = []string{keyFile}
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultSSHKeys {
+			keyFiles = append(keyFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+	for _, path := range keyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if keyFile != "" {
+				return nil, err
+			}
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		var passErr *ssh.PassphraseMissingError
+		if errors.As(err, &passErr) {
+			// パスフレーズ付きの鍵はssh-agentに登録して使ってもらう
+			verbosef("SSH: skipping passphrase-protected key %s (add it to ssh-agent)", path)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, errors.New("no SSH keys available (start ssh-agent or use --ssh-key)")
+	}
+	return []ssh.AuthMethod{ssh.PublicKeys(signers...)}, nil
+}
+
+// sshHostKeyCallback は ~/.ssh/known_hosts でホスト鍵を検証する
+func sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	callback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// dialSSHTunnel は踏み台サーバーへSSHで接続する
+func dialSSHTunnel(ctx context.Context, target string, keyFile string) (*ssh.Client, error) {
+	username, addr, err := parseSSHTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := sshAuthMethods(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := sshHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SSH tunnel to %s: %w", addr, err)
+	}
+	verbosef("SSH: connected to %s as %s", addr, username)
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,273 @@
+package main
+
+// データファイルの要約 (--summarize, --sample-rows)
+// Avro、Parquet、CSV のレスポンスを、バイナリをそのまま出さずにスキーマと行数、先頭の行の見本で表示する
+// データレイクのHTTPのエンドポイントで、中身を確かめるためだけにファイルを保存して別のツールで開かなくて済む
+// 形式は本文の先頭のバイト (Avro は Obj\x01、Parquet は PAR1) で、CSV は Content-Type か URL の拡張子で判断する
+// CSV の区切り文字はカンマ、セミコロン、タブから選び、列の型は値から integer、number、boolean、string を推測する
+// -o を指定すると本文は受け取ったままファイルに書き、要約を標準出力に表示する
+//
+//	gofetch -u https://lake.example.com/events/part-0000.parquet --summarize
+//	gofetch -u https://lake.example.com/exports/users.avro --summarize --sample-rows 3 -o users.avro
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// summarizeFormat は本文の形式を判断する。分からなければ空文字列を返す
+func summarizeFormat(body []byte, contentType, rawURL string) string {
+	switch {
+	case bytes.HasPrefix(body, avroMagic):
+		return "avro"
+	case len(body) >= 8 && bytes.HasPrefix(body, []byte("PAR1")) && bytes.HasSuffix(body, []byte("PAR1")):
+		return "parquet"
+	}
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mt {
+		case "text/csv", "application/csv", "text/tab-separated-values":
+			return "csv"
+		}
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".csv", ".tsv":
+			return "csv"
+		}
+	}
+	return ""
+}
+
+// summarizeData は本文の要約を作る。最後の改行は付けない
+func summarizeData(body []byte, contentType, rawURL string, sampleRows int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch summarizeFormat(body, contentType, rawURL) {
+	case "avro":
+		err = summarizeAvro(&buf, body, sampleRows)
+	case "parquet":
+		err = summarizeParquet(&buf, body, sampleRows)
+	case "csv":
+		err = summarizeCSV(&buf, body, sampleRows)
+	default:
+		return nil, errors.New("unknown format (supported: Avro, Parquet, CSV)")
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), err
+}
+
+// summarizeAvro は Avro の Object Container File を要約する
+func summarizeAvro(w io.Writer, body []byte, sampleRows int) error {
+	f, err := readAvroFile(body, sampleRows)
+	if f == nil {
+		return err
+	}
+	fmt.Fprintf(w, "Format: Avro (codec %s, %s)\n", f.codec, formatSize(int64(len(body))))
+	fmt.Fprintf(w, "Rows:   %d in %d block(s)\n", f.rows, f.blocks)
+	fmt.Fprintln(w, "Schema:")
+	var columns []string
+	if record, ok := f.schema.(map[string]any); ok && record["type"] == "record" {
+		fields, _ := record["fields"].([]any)
+		for _, field := range fields {
+			m, _ := field.(map[string]any)
+			name, _ := m["name"].(string)
+			columns = append(columns, name)
+			fmt.Fprintf(w, "  %s: %s\n", name, avroTypeName(m["type"]))
+		}
+	} else {
+		columns = []string{"value"}
+		fmt.Fprintf(w, "  %s\n", avroTypeName(f.schema))
+	}
+	if err != nil {
+		return err
+	}
+	if f.sampleErr != nil {
+		fmt.Fprintf(w, "Sample: unavailable (%v)\n", f.sampleErr)
+		return nil
+	}
+	writeSampleRows(w, columns, f.sample, f.rows)
+	return nil
+}
+
+// summarizeParquet は Parquet のファイルを要約する
+func summarizeParquet(w io.Writer, body []byte, sampleRows int) error {
+	f, err := parquet.OpenFile(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("parquet: %w", err)
+	}
+	format := "Parquet (" + formatSize(int64(len(body)))
+	if createdBy := f.Metadata().CreatedBy; createdBy != "" {
+		format += ", created by " + createdBy
+	}
+	fmt.Fprintf(w, "Format: %s)\n", format)
+	fmt.Fprintf(w, "Rows:   %d in %d row group(s)\n", f.NumRows(), len(f.RowGroups()))
+	fmt.Fprintln(w, "Schema:")
+	for _, line := range splitLines(f.Schema().String()) {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+
+	var columns []string
+	for _, field := range f.Schema().Fields() {
+		columns = append(columns, field.Name())
+	}
+	var rows []map[string]any
+	r := parquet.NewReader(f)
+	defer r.Close()
+	for len(rows) < sampleRows {
+		row := map[string]any{}
+		if err := r.Read(&row); err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Fprintf(w, "Sample: unavailable (%v)\n", err)
+				return nil
+			}
+			break
+		}
+		rows = append(rows, row)
+	}
+	writeSampleRows(w, columns, rows, f.NumRows())
+	return nil
+}
+
+// summarizeCSV は CSV を要約する。1行目を見出しとして扱う
+func summarizeCSV(w io.Writer, body []byte, sampleRows int) error {
+	delimiter := sniffDelimiter(body)
+	r := csv.NewReader(bytes.NewReader(body))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("csv: %w", err)
+	}
+	kinds := make([]string, len(header))
+	var rows []map[string]any
+	var count int64
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("csv: %w", err)
+		}
+		count++
+		row := map[string]any{}
+		for i, v := range record {
+			if i < len(header) {
+				kinds[i] = widenCSVKind(kinds[i], v)
+				row[header[i]] = v
+			}
+		}
+		if len(rows) < sampleRows {
+			rows = append(rows, row)
+		}
+	}
+
+	name := map[rune]string{',': "comma", ';': "semicolon", '\t': "tab"}[delimiter]
+	fmt.Fprintf(w, "Format: CSV (%s-separated, %s)\n", name, formatSize(int64(len(body))))
+	fmt.Fprintf(w, "Rows:   %d (excluding the header)\n", count)
+	fmt.Fprintln(w, "Schema:")
+	for i, col := range header {
+		kind := kinds[i]
+		if kind == "" {
+			kind = "empty"
+		}
+		fmt.Fprintf(w, "  %s: %s\n", col, kind)
+	}
+	writeSampleRows(w, header, rows, count)
+	return nil
+}
+
+// sniffDelimiter は1行目に最も多く現れる区切り文字を返す
+func sniffDelimiter(body []byte) rune {
+	line, _, _ := bytes.Cut(body, []byte("\n"))
+	best, most := ',', 0
+	for _, d := range []rune{',', ';', '\t'} {
+		if n := bytes.Count(line, []byte(string(d))); n > most {
+			best, most = d, n
+		}
+	}
+	return best
+}
+
+// widenCSVKind はこれまでの列の型 kind と値 v の両方に合う型を返す。空の値は型を変えない
+func widenCSVKind(kind, v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" || kind == "string" {
+		return kind
+	}
+	var got string
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		got = "integer"
+	} else if _, err := strconv.ParseFloat(v, 64); err == nil {
+		got = "number"
+	} else if _, err := strconv.ParseBool(v); err == nil {
+		got = "boolean"
+	} else {
+		got = "string"
+	}
+	switch {
+	case kind == "" || kind == got:
+		return got
+	case kind == "integer" && got == "number", kind == "number" && got == "integer":
+		return "number"
+	}
+	return "string"
+}
+
+// writeSampleRows は見本の行を columns の順に表にする。total は全体の行数
+func writeSampleRows(w io.Writer, columns []string, rows []map[string]any, total int64) {
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Sample (first %d of %d rows):\n", len(rows), total)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	cells := make([]string, len(columns))
+	for i, col := range columns {
+		cells[i] = strings.ToUpper(col)
+	}
+	fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	for _, row := range rows {
+		for i, col := range columns {
+			cells[i] = sampleCell(row[col])
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+// sampleCell は値を表の1マスにする。文字列はそのまま、それ以外はJSONの表記にする
+func sampleCell(v any) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		s = v
+	default:
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+	// 改行やタブが入ると表が崩れるので空白にする
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, s)
+	if r := []rune(s); len(r) > 60 {
+		s = string(r[:57]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,309 @@
+package main
+
+// DNSのHTTPS/SVCBレコードを使った接続先の選択 (RFC 9460)
+// レコードのALPNが h3 を提供していれば、HTTP/3 に対応したビルド (-tags http3) では HTTP/3 を優先し、
+// 失敗した場合はTCPにフォールバックする。h2 を提供していなければTCPではHTTP/1.1だけを使う
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// エイリアスモードのレコードをたどる最大回数
+	maxSVCBAliasDepth = 8
+)
+
+// supportedALPN はgofetchが話せるプロトコルの一覧。HTTP/3 に対応したビルドでは h3 を最優先にする
+var supportedALPN = func() []string {
+	if http3Supported {
+		return []string{"h3", "h2", "http/1.1"}
+	}
+	return []string{"h2", "http/1.1"}
+}()
+
+// svcbEndpoint はHTTPSレコードから選んだ接続先
+type svcbEndpoint struct {
+	// Origin はURLに書かれたホストとポート
+	Origin string
+	// Host と Port は実際に接続する先
+	Host  string
+	Port  string
+	Addrs []string
+	ALPN  []string
+	ECH   []byte
+}
+
+// String はレコードを dig の表示に近い形で返す
+func (r httpsRecord) String() string {
+	target := r.Target
+	if target == "" {
+		target = "."
+	}
+	parts := []string{strconv.Itoa(int(r.Priority)), target}
+	if len(r.Mandatory) > 0 {
+		keys := make([]string, len(r.Mandatory))
+		for i, k := range r.Mandatory {
+			keys[i] = k.String()
+		}
+		parts = append(parts, "mandatory="+strings.Join(keys, ","))
+	}
+	if len(r.ALPN) > 0 {
+		parts = append(parts, "alpn="+strings.Join(r.ALPN, ","))
+	}
+	if r.NoDefaultALPN {
+		parts = append(parts, "no-default-alpn")
+	}
+	if r.Port != 0 {
+		parts = append(parts, "port="+strconv.Itoa(int(r.Port)))
+	}
+	if len(r.IPv4Hint) > 0 {
+		parts = append(parts, "ipv4hint="+joinIPs(r.IPv4Hint))
+	}
+	if len(r.ECH) > 0 {
+		parts = append(parts, fmt.Sprintf("ech=(%d bytes)", len(r.ECH)))
+	}
+	if len(r.IPv6Hint) > 0 {
+		parts = append(parts, "ipv6hint="+joinIPs(r.IPv6Hint))
+	}
+	return strings.Join(parts, " ")
+}
+
+// joinIPs はIPアドレスをカンマ区切りにする
+func joinIPs(ips []net.IP) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// protocols はレコードが提供するALPNの一覧を返す
+func (r httpsRecord) protocols() []string {
+	protos := append([]string(nil), r.ALPN...)
+	if !r.NoDefaultALPN {
+		protos = append(protos, "http/1.1")
+	}
+	return protos
+}
+
+// usable はレコードをgofetchで使えるかどうかを返す
+// 解釈できない必須キーがある場合や、話せるプロトコルがない場合は使えない
+func (r httpsRecord) usable() bool {
+	for _, k := range r.Mandatory {
+		switch k {
+		case dnsmessage.SVCParamALPN, dnsmessage.SVCParamNoDefaultALPN, dnsmessage.SVCParamPort,
+			dnsmessage.SVCParamIPv4Hint, dnsmessage.SVCParamIPv6Hint, dnsmessage.SVCParamECH:
+		default:
+			return false
+		}
+	}
+	return len(commonALPN(r.protocols())) > 0
+}
+
+// commonALPN はprotosのうちgofetchが話せるものを優先順に返す
+func commonALPN(protos []string) []string {
+	var common []string
+	for _, s := range supportedALPN {
+		for _, p := range protos {
+			if p == s {
+				common = append(common, s)
+				break
+			}
+		}
+	}
+	return common
+}
+
+// discoverEndpoint はURLのホストのHTTPSレコードを調べて接続先を決める
+// レコードがない場合は nil を返す
+// http:// のURLにHTTPSレコードがある場合は https:// に書き換えたURLを返す
+func discoverEndpoint(ctx context.Context, rawURL string, dnsServer string) (*svcbEndpoint, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, rawURL, err
+	}
+	host := u.Hostname()
+	if net.ParseIP(host) != nil {
+		verbosef("HTTPS RR: skipped for IP address %s", host)
+		return nil, rawURL, nil
+	}
+
+	servers, err := resolveDNSServers(dnsServer)
+	if err != nil {
+		return nil, rawURL, err
+	}
+
+	port := u.Port()
+	if u.Scheme == "http" && (port == "80" || port == "") {
+		port = ""
+	}
+	name := host
+	var records []httpsRecord
+	for depth := 0; ; depth++ {
+		if depth >= maxSVCBAliasDepth {
+			return nil, rawURL, errors.New("too many HTTPS RR aliases")
+		}
+		records, err = lookupHTTPS(ctx, servers, name, port)
+		if err != nil {
+			return nil, rawURL, err
+		}
+		for _, r := range records {
+			verbosef("HTTPS RR: %s %s", name, r)
+		}
+		// エイリアスモードのレコードだけならエイリアス先を問い合わせ直す
+		if len(records) == 0 || records[0].Priority != 0 || records[0].Target == "" {
+			break
+		}
+		name = records[0].Target
+		port = ""
+	}
+	if len(records) == 0 {
+		verbosef("HTTPS RR: none found for %s", host)
+		return nil, rawURL, nil
+	}
+
+	// RFC 9460 9.5: HTTPSレコードがあるオリジンには https で接続する
+	if u.Scheme == "http" {
+		u.Scheme = "https"
+		if u.Port() == "80" {
+			u.Host = host
+		}
+		verbosef("HTTPS RR: upgrading to %s", u.String())
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+	for _, r := range records {
+		if r.Priority == 0 || !r.usable() {
+			continue
+		}
+		ep := &svcbEndpoint{
+			Origin: u.Host,
+			Host:   r.Target,
+			Port:   u.Port(),
+			ALPN:   commonALPN(r.protocols()),
+			ECH:    r.ECH,
+		}
+		if ep.Host == "" {
+			ep.Host = name
+		}
+		if ep.Port == "" {
+			ep.Port = "443"
+		}
+		if u.Port() == "" {
+			ep.Origin = net.JoinHostPort(host, "443")
+		}
+		if r.Port != 0 {
+			ep.Port = strconv.Itoa(int(r.Port))
+		}
+		// ヒントはターゲットがオリジン自身のときだけ使う
+		if ep.Host == name {
+			for _, ip := range append(r.IPv6Hint, r.IPv4Hint...) {
+				ep.Addrs = append(ep.Addrs, ip.String())
+			}
+		}
+		verbosef("HTTPS RR: using %s (alpn %s)", net.JoinHostPort(ep.Host, ep.Port), strings.Join(ep.ALPN, ","))
+		return ep, u.String(), nil
+	}
+	verbosef("HTTPS RR: no usable record, connecting directly")
+	return nil, u.String(), nil
+}
+
+// offers はレコードが proto を提供しているかを返す
+func (ep *svcbEndpoint) offers(proto string) bool {
+	for _, p := range ep.ALPN {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// http3Addr は HTTP/3 で接続する先を返す。ヒントのアドレスがあれば最初のものを使う
+func (ep *svcbEndpoint) http3Addr() string {
+	if len(ep.Addrs) > 0 {
+		return net.JoinHostPort(ep.Addrs[0], ep.Port)
+	}
+	return net.JoinHostPort(ep.Host, ep.Port)
+}
+
+// svcbTransport は HTTPS レコードが h3 を提供するオリジンへ HTTP/3 で送り、
+// 失敗した場合はTCPの base にフォールバックする。一度失敗したら以降はTCPだけを使う
+type svcbTransport struct {
+	ep       *svcbEndpoint
+	base     http.RoundTripper
+	newHTTP3 func(addr string) (http.RoundTripper, error)
+
+	mu     sync.Mutex
+	h3     http.RoundTripper
+	failed bool
+}
+
+// RoundTrip はオリジンへの本文のないリクエストを HTTP/3 で送る
+// 本文は失敗したときに送り直せないので、本文のあるリクエストはTCPで送る
+func (t *svcbTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil && altSvcOrigin(req) == "https://"+t.ep.Origin {
+		if rt := t.http3(); rt != nil {
+			resp, err := rt.RoundTrip(req)
+			if err == nil {
+				return resp, nil
+			}
+			verbosef("HTTPS RR: h3 failed (%v), falling back to TCP", err)
+			t.mu.Lock()
+			t.failed = true
+			t.mu.Unlock()
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// http3 は HTTP/3 の RoundTripper を返す。作れなかった場合と、前に失敗した場合は nil を返す
+func (t *svcbTransport) http3() http.RoundTripper {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed {
+		return nil
+	}
+	if t.h3 == nil {
+		addr := t.ep.http3Addr()
+		verbosef("HTTPS RR: trying h3 at %s", addr)
+		rt, err := t.newHTTP3(addr)
+		if err != nil {
+			verbosef("HTTPS RR: h3 unavailable (%v), using TCP", err)
+			t.failed = true
+			return nil
+		}
+		t.h3 = rt
+	}
+	return t.h3
+}
+
+// dialContext はオリジンへの接続をHTTPSレコードで選んだ接続先へ振り替える
+func (ep *svcbEndpoint) dialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr != ep.Origin {
+			return dial(ctx, network, addr)
+		}
+		// ヒントのアドレスを先に試し、だめならターゲット名で接続する
+		for _, ip := range ep.Addrs {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip, ep.Port))
+			if err == nil {
+				return conn, nil
+			}
+			verbosef("HTTPS RR: connect to hint %s failed: %v", ip, err)
+		}
+		return dial(ctx, network, net.JoinHostPort(ep.Host, ep.Port))
+	}
+}
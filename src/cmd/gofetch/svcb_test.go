@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// roundTripperFunc は関数を http.RoundTripper にする
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// answer は via を X-Via ヘッダーに入れたレスポンスを返す RoundTripper
+func answer(via string) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"X-Via": {via}}, Body: http.NoBody, Request: req}, nil
+	})
+}
+
+func TestSVCBTransport(t *testing.T) {
+	ep := &svcbEndpoint{Origin: "example.com:443", Host: "edge.example.net", Port: "8443", Addrs: []string{"192.0.2.1"}, ALPN: []string{"h3", "h2"}}
+	tests := []struct {
+		name  string
+		url   string
+		body  string
+		h3    http.RoundTripper
+		h3Err error
+		// want は各リクエストを送った経路
+		want []string
+	}{
+		{name: "h3 first", url: "https://example.com/", h3: answer("h3"), want: []string{"h3", "h3"}},
+		{name: "other origin uses TCP", url: "https://other.example.com/", h3: answer("h3"), want: []string{"tcp", "tcp"}},
+		{name: "body uses TCP", url: "https://example.com/", body: "x", h3: answer("h3"), want: []string{"tcp", "tcp"}},
+		{name: "h3 not built in", url: "https://example.com/", h3Err: errors.New("not built in"), want: []string{"tcp", "tcp"}},
+		{name: "h3 failure falls back for good", url: "https://example.com/", h3: roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("quic: no route")
+		}), want: []string{"tcp", "tcp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dialed []string
+			tr := &svcbTransport{ep: ep, base: answer("tcp"), newHTTP3: func(addr string) (http.RoundTripper, error) {
+				dialed = append(dialed, addr)
+				return tt.h3, tt.h3Err
+			}}
+			for i, want := range tt.want {
+				req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+				if tt.body != "" {
+					req, _ = http.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+				}
+				resp, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := resp.Header.Get("X-Via"); got != want {
+					t.Errorf("request %d via %s, want %s", i, got, want)
+				}
+			}
+			if len(dialed) > 1 || len(dialed) == 1 && dialed[0] != "192.0.2.1:8443" {
+				t.Errorf("HTTP/3 transports = %v, want at most one to 192.0.2.1:8443", dialed)
+			}
+		})
+	}
+}
+
+func TestCommonALPN(t *testing.T) {
+	got := strings.Join(commonALPN([]string{"http/1.1", "h3", "h2"}), ",")
+	want := "h2,http/1.1"
+	if http3Supported {
+		want = "h3,h2,http/1.1"
+	}
+	if got != want {
+		t.Errorf("commonALPN = %s, want %s", got, want)
+	}
+}
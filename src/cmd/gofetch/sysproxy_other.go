@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// systemProxySettings はOSのプロキシの設定を返す
+// Windows以外では環境変数が設定の場所なので、何も返さない
+func systemProxySettings() (proxy, bypass string) {
+	return "", ""
+}
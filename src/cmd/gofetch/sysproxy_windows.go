@@ -0,0 +1,69 @@
+//go:build windows
+
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	winhttp                                   = windows.NewLazySystemDLL("winhttp.dll")
+	procWinHttpGetIEProxyConfigForCurrentUser = winhttp.NewProc("WinHttpGetIEProxyConfigForCurrentUser")
+	procWinHttpGetDefaultProxyConfiguration   = winhttp.NewProc("WinHttpGetDefaultProxyConfiguration")
+	procGlobalFree                            = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalFree")
+)
+
+// winhttpIEProxyConfig は WINHTTP_CURRENT_USER_IE_PROXY_CONFIG
+type winhttpIEProxyConfig struct {
+	autoDetect    int32
+	autoConfigURL *uint16
+	proxy         *uint16
+	proxyBypass   *uint16
+}
+
+// winhttpProxyInfo は WINHTTP_PROXY_INFO
+type winhttpProxyInfo struct {
+	accessType  uint32
+	proxy       *uint16
+	proxyBypass *uint16
+}
+
+// winhttpAccessTypeNamedProxy は WINHTTP_ACCESS_TYPE_NAMED_PROXY
+const winhttpAccessTypeNamedProxy = 3
+
+// systemProxySettings はユーザーのインターネットオプション(WinINet)のプロキシを返す
+// 設定がなければ netsh winhttp set proxy で設定したWinHTTPのプロキシを返す
+// 自動構成スクリプト(PAC)と自動検出には対応していない
+func systemProxySettings() (proxy, bypass string) {
+	var ie winhttpIEProxyConfig
+	if r, _, _ := procWinHttpGetIEProxyConfigForCurrentUser.Call(uintptr(unsafe.Pointer(&ie))); r != 0 {
+		proxy, bypass = takeGlobalString(ie.proxy), takeGlobalString(ie.proxyBypass)
+		autoConfig := takeGlobalString(ie.autoConfigURL)
+		if proxy == "" && (autoConfig != "" || ie.autoDetect != 0) {
+			verbosef("Proxy: automatic proxy configuration (PAC or WPAD) is not supported; use --proxy")
+		}
+		if proxy != "" {
+			return proxy, bypass
+		}
+	}
+	var info winhttpProxyInfo
+	if r, _, _ := procWinHttpGetDefaultProxyConfiguration.Call(uintptr(unsafe.Pointer(&info))); r != 0 {
+		proxy, bypass = takeGlobalString(info.proxy), takeGlobalString(info.proxyBypass)
+		if info.accessType == winhttpAccessTypeNamedProxy {
+			return proxy, bypass
+		}
+	}
+	return "", ""
+}
+
+// takeGlobalString はWinHTTPが確保した文字列を読んで解放する
+func takeGlobalString(p *uint16) string {
+	if p == nil {
+		return ""
+	}
+	s := windows.UTF16PtrToString(p)
+	procGlobalFree.Call(uintptr(unsafe.Pointer(p)))
+	return s
+}
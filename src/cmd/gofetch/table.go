@@ -0,0 +1,128 @@
+package main
+
+// JSONの配列を表にして表示する (--table, --csv)
+// "name,status,.meta.region" のようにフィールドを指定すると、配列の各要素から値を取り出して
+// 揃えた表(またはCSV)にする。jq と column を組み合わせる手間を省く
+// フィールドは . 区切りでネストしたオブジェクトをたどり、数字は配列の添字として扱う
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// tableField は表の列1つ
+type tableField struct {
+	// Name は見出しに使う指定そのままの名前
+	Name string
+	path []string
+}
+
+// parseTableFields はカンマ区切りのフィールドの指定を解析する
+func parseTableFields(spec string) ([]tableField, error) {
+	var fields []tableField
+	for _, name := range splitList(spec) {
+		path := strings.Split(strings.TrimPrefix(name, "."), ".")
+		for _, p := range path {
+			if p == "" {
+				return nil, fmt.Errorf("invalid field %q", name)
+			}
+		}
+		fields = append(fields, tableField{Name: name, path: path})
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no fields given")
+	}
+	return fields, nil
+}
+
+// lookup はJSONの値からフィールドの値を取り出して文字列にする
+// 見つからなければ空文字列を返す
+func (f tableField) lookup(value any) string {
+	for _, p := range f.path {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[p]
+		case []any:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(v) {
+				return ""
+			}
+			value = v[i]
+		default:
+			return ""
+		}
+	}
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64, bool:
+		return fmt.Sprint(v)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// renderTable はJSONの本文を表またはCSVにする
+// 本文がオブジェクトの場合は1行の表にする
+func renderTable(body []byte, fields []tableField, asCSV bool) ([]byte, error) {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("response is not JSON: %w", err)
+	}
+	var rows []any
+	switch v := value.(type) {
+	case []any:
+		rows = v
+	case map[string]any:
+		rows = []any{v}
+	default:
+		return nil, errors.New("response is not a JSON array or object")
+	}
+
+	var buf bytes.Buffer
+	if asCSV {
+		w := csv.NewWriter(&buf)
+		record := make([]string, len(fields))
+		for i, f := range fields {
+			record[i] = f.Name
+		}
+		w.Write(record)
+		for _, row := range rows {
+			for i, f := range fields {
+				record[i] = f.lookup(row)
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	}
+
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	cells := make([]string, len(fields))
+	for i, f := range fields {
+		cells[i] = strings.ToUpper(strings.TrimPrefix(f.Name, "."))
+	}
+	fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	for _, row := range rows {
+		for i, f := range fields {
+			// 改行やタブが入ると表が崩れるので空白にする
+			cells[i] = strings.Map(func(r rune) rune {
+				if r == '\t' || r == '\n' || r == '\r' {
+					return ' '
+				}
+				return r
+			}, f.lookup(row))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package main
+
+// 時間の内訳 (--timing, --timing-format)
+// 最後のリクエストの名前解決、TCP接続、TLSハンドシェイク、サーバーの待ち時間、本文の転送にかかった時間を
+// 標準エラー出力に表示する。接続までが遅ければネットワーク、待ち時間が長ければサーバーが原因とわかる
+// 失敗した場合も、そこまでにかかった時間を表示する
+// --timing-format json なら同じ内容をミリ秒のJSONで1行に出力し、監視のスクリプトで読み込める
+//
+//	gofetch -u https://example.com --timing
+//	gofetch -u https://example.com -o page.html --timing-format json 2>> timings.ndjson
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// timingTransport はリクエストごとの各段階の時刻を記録する
+type timingTransport struct {
+	next http.RoundTripper
+	mu   sync.Mutex
+	// first は最初のリクエストを始めた時刻。リダイレクトを含めた全体の時間に使う
+	first time.Time
+	last  *requestTiming
+	// done は最後のリクエストの本文を読み終えたか、失敗した時刻
+	done time.Time
+	// requests は送ったリクエストの数。リダイレクトとリトライを含む
+	requests int
+}
+
+// timingReport は --timing-format json で出力する内容
+type timingReport struct {
+	DNS      *float64 `json:"dns_ms"`
+	Connect  *float64 `json:"connect_ms"`
+	TLS      *float64 `json:"tls_ms"`
+	Wait     *float64 `json:"wait_ms"`
+	TTFB     *float64 `json:"ttfb_ms"`
+	Transfer *float64 `json:"transfer_ms"`
+	Total    float64  `json:"total_ms"`
+	Reused   bool     `json:"connection_reused"`
+	// Earlier はリダイレクトやリトライで最後のリクエストより前に送った数
+	Earlier   int      `json:"earlier_requests"`
+	EarlierMS *float64 `json:"earlier_ms,omitempty"`
+}
+
+// wrap は next の上で時間の内訳を記録する gofetch.Middleware
+func (t *timingTransport) wrap(next http.RoundTripper) http.RoundTripper {
+	t.next = next
+	return t
+}
+
+// RoundTrip はリクエストを送り、各段階の時刻を記録する
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timing := &requestTiming{start: time.Now()}
+	t.mu.Lock()
+	if t.first.IsZero() {
+		t.first = timing.start
+	}
+	t.last, t.done = timing, time.Time{}
+	t.requests++
+	t.mu.Unlock()
+
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), timing.trace())))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.done = time.Now()
+		return resp, err
+	}
+	// HTTP/3 では httptrace が呼ばれないので、ヘッダーを受け取った時刻で代える
+	if timing.firstByte.IsZero() {
+		timing.firstByte = time.Now()
+	}
+	resp.Body = &timingBody{ReadCloser: resp.Body, finish: func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.last == timing && t.done.IsZero() {
+			t.done = time.Now()
+		}
+	}}
+	return resp, nil
+}
+
+// report は記録した時刻から内訳を作る。リクエストを送っていなければ false を返す
+func (t *timingTransport) report() (timingReport, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.last
+	if r == nil {
+		return timingReport{}, false
+	}
+	end := t.done
+	if end.IsZero() {
+		end = time.Now()
+	}
+	ms := func(from, to time.Time) *float64 {
+		if from.IsZero() || to.IsZero() {
+			return nil
+		}
+		v := float64(to.Sub(from).Microseconds()) / 1000
+		return &v
+	}
+	report := timingReport{
+		DNS:      ms(r.dnsStart, r.dnsDone),
+		Connect:  ms(r.connectStart, r.connectDone),
+		TLS:      ms(r.tlsStart, r.tlsDone),
+		Wait:     ms(r.wroteRequest, r.firstByte),
+		TTFB:     ms(r.start, r.firstByte),
+		Transfer: ms(r.firstByte, t.done),
+		Total:    *ms(t.first, end),
+		Reused:   r.reused,
+		Earlier:  t.requests - 1,
+	}
+	if t.requests > 1 {
+		report.EarlierMS = ms(t.first, r.start)
+	}
+	return report, true
+}
+
+// print は内訳を format (text か json) で w に書く
+func (t *timingTransport) print(w io.Writer, format string) {
+	report, ok := t.report()
+	if !ok {
+		return
+	}
+	if format == "json" {
+		data, _ := json.Marshal(report)
+		fmt.Fprintln(w, string(data))
+		return
+	}
+	show := func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return roundLatency(time.Duration(*v * float64(time.Millisecond)))
+	}
+	fmt.Fprintln(w, "Timing:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if report.Earlier > 0 {
+		fmt.Fprintf(tw, "  Earlier\t%s\t(%d redirect(s) or retries before the final request)\n", show(report.EarlierMS), report.Earlier)
+	}
+	if report.Reused {
+		fmt.Fprintln(tw, "  Connection\treused")
+	} else {
+		fmt.Fprintf(tw, "  DNS lookup\t%s\n", show(report.DNS))
+		fmt.Fprintf(tw, "  TCP connect\t%s\n", show(report.Connect))
+		fmt.Fprintf(tw, "  TLS handshake\t%s\n", show(report.TLS))
+	}
+	fmt.Fprintf(tw, "  Server wait\t%s\t(request sent to first byte)\n", show(report.Wait))
+	fmt.Fprintf(tw, "  First byte\t%s\t(TTFB from the start of the request)\n", show(report.TTFB))
+	fmt.Fprintf(tw, "  Transfer\t%s\t(first byte to end of body)\n", show(report.Transfer))
+	fmt.Fprintf(tw, "  Total\t%s\n", show(&report.Total))
+	tw.Flush()
+}
+
+// timingBody は本文を読み終えたか閉じた時刻を記録する
+type timingBody struct {
+	io.ReadCloser
+	finish func()
+}
+
+func (b *timingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *timingBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
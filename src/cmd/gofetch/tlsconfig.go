@@ -0,0 +1,192 @@
+package main
+
+// TLSの設定 (--insecure, --cacert, --capath, --ca-merge, --print-trust, --cert, --key)
+// --insecure はサーバー証明書を検証しない。自己署名の証明書の開発サーバーに使い、本番では使わない
+// 既定ではOSの証明書ストアを信頼する。Windowsでは証明書ストアで検証するので、
+// グループポリシーで配布した社内のルート証明書も --cacert なしで信頼する
+// --cacert は curl と同じく、システムの証明書の代わりに指定したPEMファイルの証明書だけを信頼する
+// --capath はディレクトリのPEMファイル (c_rehash で作った 1a2b3c4d.0 のような名前も可) の証明書を信頼する
+// --ca-merge なら --cacert と --capath の証明書をシステムの証明書に加え、社内のCAと公開のCAの両方を信頼する
+// --print-trust は接続ごとに検証した証明書の連鎖と、どのルート証明書で信頼したかを表示する
+// --cert と --key はクライアント証明書による相互TLS認証に使う。--key を省略した場合は
+// --cert のファイルに秘密鍵も入っているものとする
+// --key に ssh-agent か command:<コマンド> を指定すれば、秘密鍵をファイルに置かずに署名させられる (clientkey.go)
+//
+//	gofetch -u https://internal.example.com --cacert corp-ca.pem --cert client.pem --key client.key
+//	gofetch -u https://internal.example.com --capath /etc/corp/certs --ca-merge --print-trust
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// trustOptions は信頼するルート証明書の指定
+type trustOptions struct {
+	caFile string
+	caPath string
+	// merge ならシステムの証明書に加える
+	merge bool
+	// print なら検証した連鎖を表示する
+	print bool
+}
+
+// trustAnchors は追加したルート証明書と、それを読んだファイル
+type trustAnchors map[[sha256.Size]byte]string
+
+// newTLSConfig はTLSの設定を作る。どれも指定がなければ nil を返し、Goの既定の設定を使う
+func newTLSConfig(insecure bool, trust trustOptions, certFile, keyFile string) (*tls.Config, error) {
+	if !insecure && trust == (trustOptions{}) && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: insecure}
+	if insecure {
+		verbosef("TLS: certificate verification disabled by --insecure")
+	}
+	anchors := trustAnchors{}
+	if trust.caFile != "" || trust.caPath != "" {
+		pool := x509.NewCertPool()
+		if trust.merge {
+			// Windows と macOS では、証明書を加えたプールでもOSの検証を先に使う
+			system, err := x509.SystemCertPool()
+			if err != nil {
+				return nil, fmt.Errorf("--ca-merge: %w", err)
+			}
+			pool = system
+		}
+		if trust.caFile != "" {
+			n, err := anchors.addPEMFile(pool, trust.caFile)
+			if err != nil {
+				return nil, fmt.Errorf("--cacert: %w", err)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("--cacert: no PEM certificates found in %s", trust.caFile)
+			}
+		}
+		if trust.caPath != "" {
+			if err := anchors.addPEMDir(pool, trust.caPath); err != nil {
+				return nil, fmt.Errorf("--capath: %w", err)
+			}
+		}
+		conf.RootCAs = pool
+		if trust.merge {
+			verbosef("TLS: trusting %d extra CA certificate(s) in addition to the system roots", len(anchors))
+		} else {
+			verbosef("TLS: trusting only %d CA certificate(s) instead of the system roots", len(anchors))
+		}
+	} else if trust.merge {
+		return nil, fmt.Errorf("--ca-merge requires --cacert or --capath")
+	}
+	if trust.print {
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			printTrust(cs, anchors)
+			return nil
+		}
+	}
+	switch {
+	case keyFile != "" && certFile == "":
+		return nil, fmt.Errorf("--key requires --cert")
+	case certFile != "":
+		if keyFile == "" {
+			keyFile = certFile
+		}
+		var cert tls.Certificate
+		var err error
+		if isExternalKey(keyFile) {
+			cert, err = loadExternalKeyPair(certFile, keyFile)
+		} else {
+			cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+		if cert.Leaf != nil {
+			verbosef("TLS: client certificate %s, expires %s", cert.Leaf.Subject, cert.Leaf.NotAfter.Format("2006-01-02"))
+		}
+	}
+	return conf, nil
+}
+
+// addPEMFile はPEMファイルの証明書を pool に加え、加えた数を返す
+func (a trustAnchors) addPEMFile(pool *x509.CertPool, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return n, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", path, err)
+		}
+		pool.AddCert(cert)
+		a[sha256.Sum256(cert.Raw)] = path
+		n++
+	}
+}
+
+// addPEMDir はディレクトリのPEMファイルの証明書を pool に加える
+// CRLなど証明書の入っていないファイルは飛ばす
+func (a trustAnchors) addPEMDir(pool *x509.CertPool, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for _, e := range entries {
+		if !e.Type().IsRegular() && e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		n, err := a.addPEMFile(pool, filepath.Join(dir, e.Name()))
+		if err != nil {
+			// c_rehash のディレクトリのリンク切れなど、読めないファイルは飛ばす
+			verbosef("TLS: skipping %v", err)
+			continue
+		}
+		total += n
+	}
+	if total == 0 {
+		return fmt.Errorf("no PEM certificates found in %s", dir)
+	}
+	return nil
+}
+
+// printTrust は検証した証明書の連鎖と、信頼の元になったルート証明書を標準エラー出力に表示する
+func printTrust(cs tls.ConnectionState, anchors trustAnchors) {
+	if len(cs.VerifiedChains) == 0 {
+		fmt.Fprintf(os.Stderr, "Trust: %s: certificate not verified (--insecure)\n", cs.ServerName)
+		return
+	}
+	chain := cs.VerifiedChains[0]
+	fmt.Fprintf(os.Stderr, "Trust: %s: chain of %d certificate(s)\n", cs.ServerName, len(chain))
+	for i, cert := range chain {
+		line := fmt.Sprintf("  %d %s (expires %s)", i, cert.Subject, cert.NotAfter.Format("2006-01-02"))
+		if i == len(chain)-1 {
+			source := "system roots"
+			if path, ok := anchors[sha256.Sum256(cert.Raw)]; ok {
+				source = path
+			}
+			line += fmt.Sprintf("\n    anchor: %s, sha256 %s", source, fingerprint(cert))
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// fingerprint は証明書のSHA-256のフィンガープリントを返す
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return fmt.Sprintf("%X", sum)
+}
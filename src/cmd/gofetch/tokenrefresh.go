@@ -0,0 +1,243 @@
+package main
+
+// アクセストークンの自動更新
+// プロファイルに auth の設定があれば、保存したアクセストークンを Authorization に付けて送る
+// 期限が切れていたり 401 が返ったりした場合は、リフレッシュトークンか
+// クライアントクレデンシャルで新しいトークンを取得し、元のリクエストを1回だけ送り直す
+// 取得したトークンは保存先の tokens 名前空間にプロファイル名で保存する
+//
+//	profiles:
+//	  api:
+//	    hosts: ["api.example.com"]
+//	    auth:
+//	      token_url: https://auth.example.com/oauth/token
+//	      client_id: gofetch
+//	      client_secret: '{{secret "vault:kv/data/api#client_secret"}}'
+//	      scope: read write
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gofetch/pkg/gofetch/store"
+)
+
+// authConfig はプロファイルのトークン取得の設定
+// 値には ${VAR} とシークレットの埋め込みを使える
+type authConfig struct {
+	TokenURL string `yaml:"token_url"`
+	// Grant は refresh_token か client_credentials
+	// 省略した場合はリフレッシュトークンがあれば refresh_token、なければ client_credentials
+	Grant        string `yaml:"grant"`
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	RefreshToken string `yaml:"refresh_token"`
+	Scope        string `yaml:"scope"`
+}
+
+// storedToken は保存するトークン
+type storedToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// expired はトークンの期限が切れているか、まもなく切れるかを返す
+func (t storedToken) expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(30*time.Second).After(t.Expiry)
+}
+
+// tokenTransport はトークンを付けてリクエストを送り、必要なら更新する http.RoundTripper
+type tokenTransport struct {
+	base    http.RoundTripper
+	auth    authConfig
+	profile string
+	// hosts はトークンを付けるホスト。リダイレクト先の別のホストには付けない
+	hosts []string
+	kv    store.KV
+
+	mu    sync.Mutex
+	token storedToken
+}
+
+// newTokenTransport は保存したトークンを読み込んで tokenTransport を作る
+func newTokenTransport(profile string, hosts []string, auth authConfig, kv store.KV) (*tokenTransport, error) {
+	for _, v := range []*string{&auth.TokenURL, &auth.ClientID, &auth.ClientSecret, &auth.RefreshToken, &auth.Scope} {
+		expanded, err := expandSecrets(os.ExpandEnv(*v))
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile, err)
+		}
+		*v = expanded
+	}
+	if auth.TokenURL == "" {
+		return nil, fmt.Errorf("profile %s: auth.token_url is required", profile)
+	}
+	t := &tokenTransport{auth: auth, profile: profile, hosts: hosts, kv: kv}
+	data, err := kv.Get(profile)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &t.token); err != nil {
+			verbosef("Auth: ignoring unreadable saved token: %v", err)
+		}
+		registerSecret(t.token.AccessToken)
+		registerSecret(t.token.RefreshToken)
+	}
+	return t, nil
+}
+
+// wrap は next の上でアクセストークンを付ける gofetch.Middleware
+func (t *tokenTransport) wrap(next http.RoundTripper) http.RoundTripper {
+	t.base = next
+	return t
+}
+
+// RoundTrip は Authorization がなければトークンを付けて送り、401 なら更新して1回だけ送り直す
+// 呼び出し側が Authorization を指定した場合と、プロファイルのホスト以外へのリクエストはそのまま送る
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || !t.matchHost(req.URL.Hostname()) {
+		return t.base.RoundTrip(req)
+	}
+	t.mu.Lock()
+	token := t.token
+	t.mu.Unlock()
+	if token.AccessToken == "" || token.expired() {
+		var err error
+		if token, err = t.refresh(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(withBearer(req, token.AccessToken))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// 本文を送り直せない場合は 401 をそのまま返す
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	verbosef("Auth: 401 from %s, refreshing the token", req.URL.Host)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if token, err = t.refresh(); err != nil {
+		return nil, err
+	}
+	retry := withBearer(req, token.AccessToken)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// matchHost はホストがプロファイルのホストに一致するかを返す
+func (t *tokenTransport) matchHost(host string) bool {
+	for _, pattern := range t.hosts {
+		if globMatch(strings.ToLower(pattern), strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withBearer は Authorization にトークンを付けたリクエストの複製を返す
+func withBearer(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// refresh はトークンのエンドポイントから新しいトークンを取得して保存する
+func (t *tokenTransport) refresh() (storedToken, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	refreshToken := t.token.RefreshToken
+	if refreshToken == "" {
+		refreshToken = t.auth.RefreshToken
+	}
+	grant := t.auth.Grant
+	if grant == "" {
+		grant = "client_credentials"
+		if refreshToken != "" {
+			grant = "refresh_token"
+		}
+	}
+	form := url.Values{"grant_type": {grant}}
+	switch grant {
+	case "refresh_token":
+		if refreshToken == "" {
+			return storedToken{}, fmt.Errorf("profile %s: no refresh token to refresh with", t.profile)
+		}
+		form.Set("refresh_token", refreshToken)
+	case "client_credentials":
+	default:
+		return storedToken{}, fmt.Errorf("profile %s: unsupported auth.grant %q (want refresh_token or client_credentials)", t.profile, grant)
+	}
+	if t.auth.Scope != "" {
+		form.Set("scope", t.auth.Scope)
+	}
+	// クライアントシークレットがなければ公開クライアントとして client_id を本文で送る
+	if t.auth.ClientSecret == "" && t.auth.ClientID != "" {
+		form.Set("client_id", t.auth.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.auth.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return storedToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if t.auth.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(t.auth.ClientID), url.QueryEscape(t.auth.ClientSecret))
+	}
+	verbosef("Auth: requesting a token from %s (%s)", t.auth.TokenURL, grant)
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return storedToken{}, fmt.Errorf("token refresh: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return storedToken{}, fmt.Errorf("token refresh: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return storedToken{}, fmt.Errorf("token refresh: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	var r struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &r); err != nil || r.AccessToken == "" {
+		return storedToken{}, fmt.Errorf("token refresh: response has no access_token")
+	}
+
+	token := storedToken{AccessToken: r.AccessToken, RefreshToken: r.RefreshToken}
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	if r.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+	}
+	registerSecret(token.AccessToken)
+	registerSecret(token.RefreshToken)
+	t.token = token
+	if data, err := json.Marshal(token); err == nil {
+		if err := t.kv.Set(t.profile, data); err != nil {
+			verbosef("Auth: failed to save the token: %v", err)
+		}
+	}
+	return token, nil
+}
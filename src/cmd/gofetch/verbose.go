@@ -0,0 +1,185 @@
+package main
+
+// リクエストとレスポンスの表示 (--verbose, -i/--include)
+// --verbose では送ったリクエスト行とヘッダー、受け取ったステータスとヘッダー、TLSの版と暗号スイート、
+// 名前解決から最初のバイトまでの時間を curl -v と同じく > と < を付けて標準エラー出力に表示する
+// リダイレクトをたどった場合は1回ごとに表示する。本文はそのまま標準出力かファイルに書く
+// 送ったヘッダーは実際に書いたものを httptrace で受け取るので、Host や User-Agent も含む
+// 認証情報のヘッダーは値を伏せる
+// --include では最後のレスポンスのステータス行とヘッダーを本文の前に出力する
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// verboseTransport は送ったリクエストと受け取ったレスポンスを表示する
+type verboseTransport struct {
+	next http.RoundTripper
+	w    io.Writer
+	// mu は並行したリクエストの表示が混ざらないようにする
+	mu sync.Mutex
+}
+
+// requestTiming はリクエスト1回の各段階の時刻
+type requestTiming struct {
+	start, firstByte          time.Time
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	wroteRequest              time.Time
+	// reused は接続を使い回したか
+	reused bool
+}
+
+// trace は各段階の時刻を記録する httptrace のフックを返す
+func (r *requestTiming) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn:              func(info httptrace.GotConnInfo) { r.reused = info.Reused },
+		DNSStart:             func(httptrace.DNSStartInfo) { r.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { r.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { r.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { r.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { r.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { r.tlsDone = time.Now() },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { r.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { r.firstByte = time.Now() },
+	}
+}
+
+// wrap は next の上で送ったリクエストと受け取ったレスポンスを表示する gofetch.Middleware
+func (t *verboseTransport) wrap(next http.RoundTripper) http.RoundTripper {
+	t.next = next
+	return t
+}
+
+// RoundTrip はリクエストを送り、送ったヘッダーとレスポンスを表示する
+func (t *verboseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var mu sync.Mutex
+	var fields [][2]string
+	timing := &requestTiming{start: time.Now()}
+	trace := timing.trace()
+	trace.WroteHeaderField = func(key string, values []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, v := range values {
+			fields = append(fields, [2]string{key, v})
+		}
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// HTTP/1.x では常に HTTP/1.1 として送る
+	proto := req.Proto
+	if resp != nil && resp.ProtoMajor >= 2 {
+		proto = resp.Proto
+	}
+	fmt.Fprintf(t.w, "> %s %s %s\n", req.Method, redactSecrets(req.URL.RequestURI()), proto)
+	mu.Lock()
+	// HTTP/3 では書いたヘッダーを受け取れないので、リクエストに設定したヘッダーを表示する
+	if len(fields) == 0 {
+		fields = append(fields, [2]string{"Host", req.URL.Host})
+		for _, name := range sortedHeaderNames(req.Header) {
+			for _, v := range req.Header[name] {
+				fields = append(fields, [2]string{name, v})
+			}
+		}
+	}
+	for _, f := range fields {
+		// HTTP/2 の疑似ヘッダーはリクエスト行に含まれている
+		if !strings.HasPrefix(f[0], ":") {
+			fmt.Fprintf(t.w, "> %s: %s\n", f[0], redactSecrets(maskSensitive(f[0], f[1])))
+		}
+	}
+	mu.Unlock()
+	fmt.Fprintln(t.w, ">")
+	if err != nil {
+		fmt.Fprintf(t.w, "* %s\n", redactSecrets(err.Error()))
+		return resp, err
+	}
+
+	fmt.Fprintf(t.w, "< %s %s\n", resp.Proto, resp.Status)
+	for _, name := range sortedHeaderNames(resp.Header) {
+		for _, v := range resp.Header[name] {
+			fmt.Fprintf(t.w, "< %s: %s\n", name, redactSecrets(maskSensitive(name, v)))
+		}
+	}
+	fmt.Fprintln(t.w, "<")
+	if resp.TLS != nil {
+		fmt.Fprintf(t.w, "* TLS: %s, %s, ALPN %s\n", tls.VersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite), orDash(resp.TLS.NegotiatedProtocol))
+		if certs := resp.TLS.PeerCertificates; len(certs) > 0 {
+			fmt.Fprintf(t.w, "* Certificate: %s, issued by %s, expires %s\n", certs[0].Subject, certs[0].Issuer, certs[0].NotAfter.Format("2006-01-02"))
+		}
+	}
+	// 実際に使ったバージョン。--http1.1 などの指定が守られたかをここで確かめられる
+	transportName := "cleartext TCP"
+	switch {
+	case resp.ProtoMajor == 3:
+		transportName = "QUIC"
+	case resp.TLS != nil:
+		transportName = "TLS"
+	}
+	fmt.Fprintf(t.w, "* Protocol: %s over %s\n", resp.Proto, transportName)
+	fmt.Fprintf(t.w, "* Timing: %s\n", timing)
+	return resp, nil
+}
+
+// String は各段階にかかった時間を表示用に返す
+func (r *requestTiming) String() string {
+	since := func(from, to time.Time) string {
+		if from.IsZero() || to.IsZero() {
+			return "-"
+		}
+		return roundLatency(to.Sub(from))
+	}
+	if r.reused {
+		return fmt.Sprintf("connection reused, first byte %s", since(r.start, r.firstByte))
+	}
+	return fmt.Sprintf("dns %s, connect %s, tls %s, first byte %s",
+		since(r.dnsStart, r.dnsDone), since(r.connectStart, r.connectDone), since(r.tlsStart, r.tlsDone), since(r.start, r.firstByte))
+}
+
+// maskSensitive は認証情報のヘッダーの値を伏せる。Authorization の Bearer などの方式名は残す
+func maskSensitive(name, value string) string {
+	for _, s := range sensitiveHeaders {
+		if strings.EqualFold(name, s) {
+			if scheme, _, ok := strings.Cut(value, " "); ok && strings.HasSuffix(s, "Authorization") {
+				return scheme + " ****"
+			}
+			return "****"
+		}
+	}
+	return value
+}
+
+// sortedHeaderNames はヘッダーの名前を並べて返す
+func sortedHeaderNames(h http.Header) []string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// includedHeaders は --include で本文の前に出力するステータス行とヘッダーを返す
+func includedHeaders(resp *http.Response) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s\r\n", resp.Proto, resp.Status)
+	for _, name := range sortedHeaderNames(resp.Header) {
+		for _, v := range resp.Header[name] {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, v)
+		}
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
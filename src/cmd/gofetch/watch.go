@@ -0,0 +1,225 @@
+package main
+
+// 変化の監視 (--watch, --interval, --on-change)
+// URLを --interval ごとに取得し直し、本文のハッシュが前回と変わったら時刻とステータス、ハッシュを標準エラー出力に、
+// 前回との行ごとの差分を標準出力に表示する。最初の取得では本文をそのまま出力する。Ctrl-C で止めると回数をまとめて表示する
+// JSON はキーを並べ替えて整形してから比べるので、キーの順序や空白だけの違いは変化にしない
+// 毎回変わる時刻やIDは --mask で伏せてから比べる。--jq を指定すればその値だけを、--proto ならJSONにしてから比べる
+// --on-change のコマンドは変化のたびに sh -c で実行する。標準入力に本文を渡し、環境変数 GOFETCH_URL、
+// GOFETCH_STATUS、GOFETCH_HASH、GOFETCH_PREVIOUS_HASH を設定する
+// -o を指定すると最初と変化のたびに最新の内容をファイルに書く
+// 取得に失敗しても監視は続け、次の取得と比べるのは最後に取得できた内容にする
+// --cache-dir と組み合わせれば、変わっていない本文は 304 で確かめるだけで済む
+// --slo を指定すると取得ごとのレイテンシとステータスでSLOを評価し、守れなくなりそうなら知らせる (slo.go)
+// --live を指定すると取得のレート、エラー率、レイテンシのダッシュボードを標準エラー出力に表示する (live.go)
+// 変化の表示はダッシュボードの上に書く
+//
+//	gofetch -u https://example.com/status.json --watch --interval 30s --mask .updated_at
+//	gofetch -u https://api.example.com/release --watch --interval 5m --jq tag_name --on-change 'notify-send "New release"'
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"gofetch/pkg/gofetch"
+)
+
+// watchLivePolls は --live でパーセンタイルを計算する期間に含める取得の回数
+// 取得の間隔は長いことが多いので、bench の期間より短ければ bench と同じにする
+const watchLivePolls = 10
+
+// watchConfig は監視の設定
+type watchConfig struct {
+	interval time.Duration
+	onChange string
+	masks    maskRules
+	// view は本文から比べる内容を作る。--jq や --proto の分で、なければ本文をそのまま使う
+	view   func(body []byte) ([]byte, error)
+	output string
+	// slo は --slo の評価。なければ評価しない
+	slo *sloMonitor
+	// live は --live のダッシュボードを表示するか
+	live bool
+}
+
+// watchView は --proto と --jq から watchConfig.view を作る。どちらもなければ nil を返す
+func watchView(protoType protoreflect.MessageDescriptor, jqPath string) func(body []byte) ([]byte, error) {
+	if protoType == nil && jqPath == "" {
+		return nil
+	}
+	return func(body []byte) ([]byte, error) {
+		if protoType != nil {
+			decoded, err := decodeProto(protoType, body)
+			if err != nil {
+				return nil, err
+			}
+			body = decoded
+		}
+		if jqPath != "" {
+			return extractJSONPath(body, jqPath, false)
+		}
+		return body, nil
+	}
+}
+
+// watchSnapshot は1回の取得で得た内容
+type watchSnapshot struct {
+	status int
+	body   []byte
+	// normalized は伏せて整形した比べる形、hash はそのハッシュ
+	normalized string
+	hash       string
+}
+
+// runWatch は Ctrl-C で止めるまで r を取得し直し、変化を表示する。終了コードを返す
+func runWatch(fetcher *gofetch.Client, r gofetch.Request, conf watchConfig) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Watching %s every %s (Ctrl-C to stop)\n", redactSecrets(r.URL), conf.interval)
+	var dashboard *liveDashboard
+	if conf.live {
+		dashboard = newLiveDashboard(max(liveWindow, watchLivePolls*conf.interval))
+		go dashboard.run()
+	}
+	var prev *watchSnapshot
+	var polls, changes, failures int
+	for ctx.Err() == nil {
+		start := time.Now()
+		snap, err := conf.poll(ctx, fetcher, r)
+		now := time.Now()
+		stamp := now.Format("15:04:05")
+		if ctx.Err() != nil {
+			break
+		}
+		polls++
+		if conf.slo != nil {
+			s := sloSample{at: now, latency: now.Sub(start), failed: snap == nil}
+			if snap != nil {
+				s.status = snap.status
+			}
+			conf.slo.record(s)
+			conf.slo.evaluate(ctx, r.URL, now)
+		}
+		if dashboard != nil {
+			dashboard.observe(now.Sub(start), snap == nil)
+		}
+		show := func() {
+			switch {
+			case err != nil:
+				failures++
+				fmt.Fprintf(os.Stderr, "%s error: %v\n", stamp, redactSecrets(err.Error()))
+			case prev == nil:
+				fmt.Fprintf(os.Stderr, "%s initial: %d, %s, sha256 %s\n", stamp, snap.status, formatSize(int64(len(snap.body))), snap.hash[:12])
+				conf.write(snap, true)
+				prev = snap
+			case snap.hash != prev.hash || snap.status != prev.status:
+				changes++
+				status := strconv.Itoa(snap.status)
+				if snap.status != prev.status {
+					status = fmt.Sprintf("%d (was %d)", snap.status, prev.status)
+				}
+				fmt.Fprintf(os.Stderr, "%s changed: %s, %s, sha256 %s (was %s)\n", stamp, status, formatSize(int64(len(snap.body))), snap.hash[:12], prev.hash[:12])
+				for _, line := range diffLines(splitLines(prev.normalized), splitLines(snap.normalized)) {
+					fmt.Println(line)
+				}
+				conf.write(snap, false)
+				if conf.onChange != "" {
+					conf.runHook(ctx, r.URL, snap, prev)
+				}
+				prev = snap
+			default:
+				verbosef("%s unchanged: %d, sha256 %s", stamp, snap.status, snap.hash[:12])
+			}
+		}
+		// 結果はダッシュボードの上に書く。何も書かない取得ではダッシュボードを消さない
+		quiet := err == nil && prev != nil && snap.hash == prev.hash && snap.status == prev.status && !verbose
+		if dashboard != nil && !quiet {
+			dashboard.above(show)
+		} else {
+			show()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(conf.interval):
+		}
+	}
+	if dashboard != nil {
+		dashboard.close()
+	}
+	fmt.Fprintf(os.Stderr, "Watch: %d poll(s), %d change(s), %d error(s)\n", polls, changes, failures)
+	if conf.slo != nil {
+		conf.slo.report(time.Now())
+	}
+	return 0
+}
+
+// poll は1回取得し、比べる内容を作る
+// レスポンスを受け取れた後の --jq などの失敗では、ステータスだけを入れた内容とエラーを返す
+func (conf watchConfig) poll(ctx context.Context, fetcher *gofetch.Client, r gofetch.Request) (*watchSnapshot, error) {
+	res, err := fetcher.Fetch(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	body := res.Body
+	// --jq や --proto は成功したレスポンスにだけ使い、エラーのレスポンスはそのまま比べる
+	if conf.view != nil && res.StatusCode >= 200 && res.StatusCode <= 299 {
+		if body, err = conf.view(body); err != nil {
+			return &watchSnapshot{status: res.StatusCode}, err
+		}
+	}
+	snap := &watchSnapshot{status: res.StatusCode, body: body}
+	// 比べ方は --golden の json と同じにする
+	if canonical, err := canonicalJSON(conf.masks.applyPaths(body)); err == nil {
+		snap.normalized = string(conf.masks.applyPatterns([]byte(canonical)))
+	} else {
+		snap.normalized = string(conf.masks.apply(body))
+	}
+	sum := sha256.Sum256([]byte(snap.normalized))
+	snap.hash = hex.EncodeToString(sum[:])
+	return snap, nil
+}
+
+// write は最新の内容を -o のファイルに書く。-o がなければ最初の内容だけを標準出力に書く
+func (conf watchConfig) write(snap *watchSnapshot, initial bool) {
+	if conf.output == "" {
+		if initial {
+			os.Stdout.Write(snap.body)
+			if !bytes.HasSuffix(snap.body, []byte("\n")) {
+				fmt.Println()
+			}
+		}
+		return
+	}
+	if err := os.WriteFile(conf.output, snap.body, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// runHook は --on-change のコマンドを実行する。失敗しても監視は続ける
+func (conf watchConfig) runHook(ctx context.Context, url string, snap, prev *watchSnapshot) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", conf.onChange)
+	cmd.Stdin = bytes.NewReader(snap.body)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"GOFETCH_URL="+url,
+		"GOFETCH_STATUS="+strconv.Itoa(snap.status),
+		"GOFETCH_HASH="+snap.hash,
+		"GOFETCH_PREVIOUS_HASH="+prev.hash,
+	)
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "On change: %s: %v\n", conf.onChange, err)
+	}
+}
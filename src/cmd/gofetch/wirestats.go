@@ -0,0 +1,62 @@
+package main
+
+// 通信路上のバイト数の計測 (--wire-stats)
+// 接続そのものを数えるので、ヘッダー、TLSレコード、圧縮されたままの本文まで含まれる
+// API呼び出しの帯域コストを見積もるときに使う
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// wireCounter は接続で送受信したバイト数を数える
+type wireCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+	conns    atomic.Int64
+}
+
+// dialContext は dial で確立した接続を数える対象にする
+func (c *wireCounter) dialContext(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c.conns.Add(1)
+		return &countingConn{Conn: conn, counter: c}, nil
+	}
+}
+
+// String は計測結果を1行で返す
+func (c *wireCounter) String() string {
+	return fmt.Sprintf("sent %s, received %s over %d connection(s)",
+		formatSize(c.sent.Load()), formatSize(c.received.Load()), c.conns.Load())
+}
+
+// countingConn は読み書きしたバイト数を wireCounter に加算する net.Conn
+type countingConn struct {
+	net.Conn
+	counter *wireCounter
+}
+
+// Read は受信したバイト数を数える
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.received.Add(int64(n))
+	return n, err
+}
+
+// Write は送信したバイト数を数える
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+// NetConn は包んでいる接続を返す
+func (c *countingConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,89 @@
+package gofetch
+
+// 複数のリクエストの並行した取得 (DoBatch, DownloadBatch)
+// リクエストを並列数までのゴルーチンで Fetch し、終わった順に結果をチャネルで返す
+// DownloadBatch は本文をメモリーに溜めずに、リクエストごとに開いた書き出し先へ Download で書く
+//
+//	for res := range c.DoBatch(ctx, reqs, 8) {
+//		if res.Err != nil {
+//			log.Printf("%s: %v", res.Request.URL, res.Err)
+//			continue
+//		}
+//		save(res.Index, res.Response.Body)
+//	}
+
+import (
+	"context"
+	"sync"
+)
+
+// BatchResult は DoBatch のリクエスト1件分の結果
+type BatchResult struct {
+	// Index は DoBatch に渡したリクエストの中の位置
+	Index   int
+	Request Request
+	// Response は Fetch のレスポンス。Err が nil でなければゼロ値で、受け取れた部分は *TransferError の Partial にある
+	Response Response
+	// Err は Fetch のエラー。すべての試行が失敗した場合は *TransferError
+	Err error
+}
+
+// DoBatch は reqs を concurrency 件まで並行して Fetch し、終わった順に結果を送るチャネルを返す
+// リクエストは reqs の順に始め、すべての結果を送るとチャネルを閉じる。呼び出し側は閉じるまで読むこと
+// ctx が終わった後は、まだ始めていないリクエストを送らずに ctx のエラーを結果にする
+func (c *Client) DoBatch(ctx context.Context, reqs []Request, concurrency int) <-chan BatchResult {
+	return c.batch(ctx, reqs, concurrency, func(ctx context.Context, _ int, r Request) (Response, error) {
+		return c.Fetch(ctx, r)
+	})
+}
+
+// DownloadBatch は DoBatch と同じように reqs を並行して取得するが、本文は open で開いた書き出し先に Download で書く
+// open は OnStart の後、リクエストを送る前に呼ばれる。エラーを返せばそのリクエストは送らずにエラーを結果にする
+// 書き出し先を閉じるのは呼び出し側。結果の Response は Download のもので、Size に書いた大きさが入る
+func (c *Client) DownloadBatch(ctx context.Context, reqs []Request, concurrency int, open func(index int) (Destination, error)) <-chan BatchResult {
+	return c.batch(ctx, reqs, concurrency, func(ctx context.Context, i int, r Request) (Response, error) {
+		dst, err := open(i)
+		if err != nil {
+			return Response{}, err
+		}
+		return c.Download(ctx, r, dst, 0)
+	})
+}
+
+// batch は reqs を concurrency 件まで並行して fetch で取得する
+func (c *Client) batch(ctx context.Context, reqs []Request, concurrency int, fetch func(ctx context.Context, i int, r Request) (Response, error)) <-chan BatchResult {
+	results := make(chan BatchResult)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(concurrency, 1), len(reqs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results <- c.batchFetch(ctx, i, reqs[i], fetch)
+			}
+		}()
+	}
+	go func() {
+		for i := range reqs {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// batchFetch は batch のリクエスト1件を取得する
+func (c *Client) batchFetch(ctx context.Context, i int, r Request, fetch func(ctx context.Context, i int, r Request) (Response, error)) BatchResult {
+	res := BatchResult{Index: i, Request: r}
+	if res.Err = ctx.Err(); res.Err != nil {
+		return res
+	}
+	if c.OnStart != nil {
+		ctx = c.OnStart(ctx, i, r)
+	}
+	res.Response, res.Err = fetch(ctx, i, r)
+	return res
+}
@@ -0,0 +1,151 @@
+package gofetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoBatch(t *testing.T) {
+	var mu sync.Mutex
+	inflight, peak := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inflight++
+		peak = max(peak, inflight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		paths       []string
+		concurrency int
+		// wantErr は結果がエラーになるリクエストの位置
+		wantErr map[int]bool
+		status  map[int]int
+	}{
+		{name: "all succeed", paths: []string{"/a", "/b", "/c", "/d", "/e"}, concurrency: 2},
+		{name: "zero concurrency runs one at a time", paths: []string{"/a", "/b"}, concurrency: 0},
+		{name: "more workers than requests", paths: []string{"/a"}, concurrency: 8},
+		{name: "per-request detail", paths: []string{"/a", "/missing", "::bad"}, concurrency: 3,
+			wantErr: map[int]bool{2: true}, status: map[int]int{1: http.StatusNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peak = 0
+			reqs := make([]Request, len(tt.paths))
+			for i, p := range tt.paths {
+				reqs[i] = Request{URL: srv.URL + p}
+			}
+			c := New(nil)
+			c.MaxAttempts = 1
+			seen := map[int]bool{}
+			for res := range c.DoBatch(context.Background(), reqs, tt.concurrency) {
+				if seen[res.Index] {
+					t.Errorf("index %d reported twice", res.Index)
+				}
+				seen[res.Index] = true
+				if (res.Err != nil) != tt.wantErr[res.Index] {
+					t.Errorf("%d: err = %v", res.Index, res.Err)
+					continue
+				}
+				if res.Err != nil {
+					continue
+				}
+				want := http.StatusOK
+				if s, ok := tt.status[res.Index]; ok {
+					want = s
+				}
+				if res.Response.StatusCode != want || string(res.Response.Body) != tt.paths[res.Index] {
+					t.Errorf("%d: %d %q, want %d %q", res.Index, res.Response.StatusCode, res.Response.Body, want, tt.paths[res.Index])
+				}
+			}
+			if len(seen) != len(reqs) {
+				t.Errorf("got %d results, want %d", len(seen), len(reqs))
+			}
+			if limit := max(tt.concurrency, 1); peak > limit {
+				t.Errorf("peak concurrency = %d, want at most %d", peak, limit)
+			}
+		})
+	}
+}
+
+func TestDoBatchCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	reqs := []Request{{URL: srv.URL}, {URL: srv.URL}, {URL: srv.URL}}
+	c := New(nil)
+	// 1件目を始めたところで取り消し、OnStart のコンテキストがリクエストに届くことも確かめる
+	type key struct{}
+	c.OnStart = func(ctx context.Context, i int, _ Request) context.Context {
+		cancel()
+		return context.WithValue(ctx, key{}, i)
+	}
+	var got []int
+	c.OnRequest = func(req *http.Request) { got = append(got, req.Context().Value(key{}).(int)) }
+	canceled := 0
+	for res := range c.DoBatch(ctx, reqs, 1) {
+		if errors.Is(res.Err, context.Canceled) {
+			canceled++
+		}
+	}
+	if canceled != len(reqs) {
+		t.Errorf("canceled = %d, want %d", canceled, len(reqs))
+	}
+	if len(got) != 1 || got[0] != 0 {
+		t.Errorf("OnRequest saw %v, want [0]", got)
+	}
+}
+
+func TestDownloadBatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	paths := []string{"/a", "/b", "/c"}
+	reqs := make([]Request, len(paths))
+	for i, p := range paths {
+		reqs[i] = Request{URL: srv.URL + p}
+	}
+	files := make([]*os.File, len(paths))
+	open := func(i int) (Destination, error) {
+		if i == 2 {
+			return nil, errors.New("no space")
+		}
+		f, err := os.Create(filepath.Join(dir, paths[i]))
+		files[i] = f
+		return f, err
+	}
+	for res := range New(nil).DownloadBatch(context.Background(), reqs, 2, open) {
+		if res.Index == 2 {
+			if res.Err == nil {
+				t.Errorf("2: open error not reported")
+			}
+			continue
+		}
+		files[res.Index].Close()
+		if res.Err != nil || res.Response.Size != 2 || res.Response.Body != nil {
+			t.Errorf("%d: size %d, body %q, err %v", res.Index, res.Response.Size, res.Response.Body, res.Err)
+		}
+		if got, _ := os.ReadFile(files[res.Index].Name()); string(got) != paths[res.Index] {
+			t.Errorf("%d: wrote %q, want %q", res.Index, got, paths[res.Index])
+		}
+	}
+}
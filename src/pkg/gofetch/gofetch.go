@@ -0,0 +1,594 @@
+// Package gofetch は gofetch コマンドの取得、リトライ、待ち時間の処理をまとめたもの
+// ほかのGoのプログラムから gofetch の実行ファイルを呼ばずに同じ動作で取得できる
+//
+//	c := gofetch.New(nil)
+//	c.MaxAttempts = 5
+//	c.Backoff = gofetch.WithJitter(gofetch.ExponentialBackoff(500*time.Millisecond, 10*time.Second))
+//	c.RetryOn = func(status int) bool { return status == 429 || status >= 500 }
+//	res, err := c.Fetch(ctx, gofetch.Request{URL: "https://example.com"})
+//
+// 既定の設定でよければ Get、Post、Download で Client を作らずに取得できる
+package gofetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout は1回の試行のタイムアウトの既定値
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxAttempts は試行回数の既定値
+	DefaultMaxAttempts = 3
+	// DefaultBackoff は次の試行までに待つ時間の既定値
+	DefaultBackoff = time.Second
+)
+
+// Request は送るリクエスト
+type Request struct {
+	// Method は省略した場合 GET
+	Method string
+	URL    string
+	Header http.Header
+	// Body は試行のたびに先頭から送り直す
+	Body []byte
+	// OpenBody は Body の代わりに試行のたびに本文を開く。大きなファイルをメモリに読まずに送るのに使う
+	OpenBody func() (io.ReadCloser, error)
+	// ContentLength は OpenBody の本文の大きさ。わからなければ -1 にし、チャンク形式で送る
+	ContentLength int64
+	// Close は Connection: close を送り、レスポンスの後に接続を閉じるか
+	Close bool
+}
+
+// NewHTTPRequest は1回の試行で送る http.Request を作る
+// 本文は GetBody でも開き直せるので、307 などのリダイレクトでも送り直せる
+func (r Request) NewHTTPRequest(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.OpenBody != nil && r.Body == nil {
+		if req.Body, err = r.OpenBody(); err != nil {
+			return nil, err
+		}
+		req.GetBody = r.OpenBody
+		req.ContentLength = r.ContentLength
+	}
+	if r.Header != nil {
+		req.Header = r.Header.Clone()
+	}
+	req.Close = r.Close
+	return req, nil
+}
+
+// Response は本文を読み終えたレスポンス
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte
+	// TTFB は最後の試行を始めてから最初のバイトを受け取るまでの時間
+	TTFB time.Duration
+	// Duration は最後の試行を始めてから本文を読み終えるまでの時間
+	Duration time.Duration
+	// Attempts は成功するまでの試行回数
+	Attempts int
+	// Raw は元のレスポンス。本文は読み終えて閉じてある
+	Raw *http.Response
+	// Resumed は Download で書き出し先に既にあったデータの続きから受け取った場合のその大きさ
+	Resumed int64
+	// Size は Download で書き出し先に書いた本文の全体の大きさ。Resumed を含む
+	// Stream では書き出し先に書いた大きさ
+	Size int64
+}
+
+// Complete は Download で書き出し先に既に全体があり、受け取るものがなかったかを返す
+// サーバーは範囲の外の Range に 416 を返す
+func (r Response) Complete() bool {
+	return r.StatusCode == http.StatusRequestedRangeNotSatisfiable && r.Resumed > 0 && r.Size == r.Resumed
+}
+
+// Destination は Download の書き出し先。*os.File が満たす
+type Destination interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// Attempt は試行1回分の結果
+type Attempt struct {
+	Number  int
+	Start   time.Time
+	Elapsed time.Duration
+	// Status はレスポンスを受け取れた場合のステータス
+	Status int
+	Err    error
+	// Backoff は次の試行までに待つ時間。最後の試行では0
+	Backoff time.Duration
+}
+
+// TransferError はすべての試行が失敗したときのエラー
+type TransferError struct {
+	// Err は最後の試行のエラー
+	Err error
+	// Response は最後にレスポンスを受け取れた試行のレスポンス
+	Response *http.Response
+	// Partial は本文の受信中に失敗した試行で受け取れた本文のうち最も長いもの
+	Partial []byte
+	// PartialResponse は Partial を受け取った試行のレスポンス
+	PartialResponse *http.Response
+}
+
+// Error は最後の試行のエラーを返す
+func (e *TransferError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap は最後の試行のエラーを返す
+func (e *TransferError) Unwrap() error {
+	return e.Err
+}
+
+// RedirectLoopError はリダイレクトが同じURLに戻り続けたために打ち切ったことを表す
+// サーバーの設定の誤りなので、送り直しても直らない
+type RedirectLoopError struct {
+	// URL は繰り返し訪れたURL
+	URL string
+	// Visits は打ち切るまでに URL を訪れた回数
+	Visits int
+	// Path はループの経路を1行に1回ずつ表したもの
+	Path string
+}
+
+// Error はループの経路を含めたメッセージを返す
+func (e *RedirectLoopError) Error() string {
+	return "redirect loop detected:\n" + e.Path
+}
+
+// BackoffFunc は attempt 回目の試行が失敗した後に待つ時間を返す
+type BackoffFunc func(attempt int) time.Duration
+
+// ConstantBackoff は毎回同じ時間だけ待つ
+func ConstantBackoff(d time.Duration) BackoffFunc {
+	return func(int) time.Duration { return d }
+}
+
+// ExponentialBackoff は base から2倍ずつ、最大 limit まで待つ時間を延ばす
+func ExponentialBackoff(base, limit time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < limit; i++ {
+			d *= 2
+		}
+		return min(d, limit)
+	}
+}
+
+// WithJitter は b の待ち時間を半分から全体までの間でばらつかせる
+// 多くのクライアントが同時に送り直してサーバーに負荷が集中するのを避ける
+func WithJitter(b BackoffFunc) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := b(attempt)
+		if d <= 1 {
+			return d
+		}
+		return d/2 + time.Duration(rand.Int64N(int64(d/2)+1))
+	}
+}
+
+// IsRetryable はエラーが送り直せば直る可能性のあるものかを返す
+// 証明書の誤り、リダイレクトのループ、呼び出し側による取り消しは送り直しても変わらない
+func IsRetryable(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+	var loop *RedirectLoopError
+	switch {
+	case errors.Is(err, context.Canceled),
+		errors.As(err, &unknownAuthority),
+		errors.As(err, &invalidCert),
+		errors.As(err, &hostname),
+		errors.As(err, &verification),
+		errors.As(err, &loop):
+		return false
+	}
+	return true
+}
+
+// retryAfter は Retry-After ヘッダーの秒数か日時から待つ時間を返す
+func retryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// Client はタイムアウトとリトライを設定してリクエストを送る
+// ゼロ値でも既定の設定で使える
+type Client struct {
+	// HTTPClient はリクエストを送るクライアント。nil なら http.DefaultClient
+	HTTPClient *http.Client
+	// Timeout は1回の試行のタイムアウト。0なら HTTPClient のタイムアウトだけを使う
+	Timeout time.Duration
+	// MaxAttempts は試行回数。0以下なら DefaultMaxAttempts
+	MaxAttempts int
+	// Backoff は次の試行までに待つ時間。nil なら DefaultBackoff
+	Backoff BackoffFunc
+	// RetryOn はステータスを受け取り、送り直すかを返す。nil ならステータスでは送り直さない
+	// 最後の試行ではそのステータスのレスポンスを返す
+	RetryOn func(status int) bool
+	// Retryable はエラーを受け取り、送り直すかを返す。nil なら IsRetryable
+	Retryable func(err error) bool
+	// MaxRetryAfter は Retry-After に従って待つ時間の上限。0なら上限なし
+	MaxRetryAfter time.Duration
+
+	// Middlewares は HTTPClient の Transport を包むミドルウェア。Use で加える
+	Middlewares []Middleware
+
+	// OnStart は DoBatch でリクエストを始める前に呼ばれ、そのリクエストに使うコンテキストを返す
+	// 並列数の枠を取った後に呼ばれるので、リクエストごとの準備や待ち合わせに使える
+	OnStart func(ctx context.Context, index int, r Request) context.Context
+	// OnRequest は試行ごとに送る前のリクエストを受け取る
+	OnRequest func(req *http.Request)
+	// OnResponse は試行ごとにレスポンスのヘッダーを受け取ったとき、本文を読む前に呼ばれる
+	OnResponse func(resp *http.Response)
+	// OnRetry は試行が失敗して送り直す前、待ち始めるときに呼ばれる
+	OnRetry func(Attempt)
+	// WrapBody はレスポンスの本文を包む。cancel を呼ぶとその試行を打ち切り、原因をエラーにする
+	WrapBody func(body io.ReadCloser, cancel context.CancelCauseFunc) io.ReadCloser
+	// OnAttempt は試行が終わるたびに呼ばれる
+	OnAttempt func(Attempt)
+}
+
+// New は hc でリクエストを送る Client を既定の設定で作る
+func New(hc *http.Client) *Client {
+	return &Client{HTTPClient: hc, Timeout: DefaultTimeout, MaxAttempts: DefaultMaxAttempts, Backoff: ConstantBackoff(DefaultBackoff)}
+}
+
+// Fetch はリクエストを送って本文を読み、失敗すれば待ってから試行回数まで送り直す
+// すべての試行が失敗した場合は *TransferError を返す
+func (c *Client) Fetch(ctx context.Context, r Request) (Response, error) {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	// URLやメソッドの誤りは送り直しても直らない
+	if _, err := http.NewRequest(r.Method, r.URL, nil); err != nil {
+		return Response{}, err
+	}
+	return c.retry(ctx, func() (Response, error) {
+		return c.attempt(ctx, r, func(_ *http.Response, body io.Reader) ([]byte, error) {
+			return io.ReadAll(body)
+		})
+	})
+}
+
+// Download は Fetch と同じように送るが、本文をメモリーに溜めずに dst へ書く
+// dst の先頭 offset バイトに既に本文の始めの部分があれば、Range でその続きを頼む
+// 本文の途中で失敗した試行の後も、受け取れた分の続きから頼み直す
+// サーバーが Range に応じなければ dst を空にして初めから書く
+// 続きを頼んだのにエラーのステータスが返った場合は dst に触れず、本文を Body に入れる
+func (c *Client) Download(ctx context.Context, r Request, dst Destination, offset int64) (Response, error) {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	if _, err := http.NewRequest(r.Method, r.URL, nil); err != nil {
+		return Response{}, err
+	}
+	// have は dst にある本文として使える大きさ。validator は途中で中身が変わっていないかを確かめる If-Range の値
+	have, validator := offset, ""
+	return c.retry(ctx, func() (Response, error) {
+		req := r
+		req.Header = r.Header.Clone()
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		if have > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", have))
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+		var resumed, size int64
+		res, err := c.attempt(ctx, req, func(resp *http.Response, body io.Reader) ([]byte, error) {
+			switch start, total := contentRange(resp.Header.Get("Content-Range")); {
+			case resp.StatusCode == http.StatusPartialContent && have > 0 && start == have:
+				resumed = have
+			case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && have > 0 && total == have:
+				// 既に全体がある
+				resumed, size = have, have
+				return nil, nil
+			case have > 0 && resp.StatusCode != http.StatusOK:
+				// 受け取ってある分をエラーのレスポンスで消さない
+				return io.ReadAll(body)
+			default:
+				if err := dst.Truncate(0); err != nil {
+					return nil, err
+				}
+				have = 0
+			}
+			if _, err := dst.Seek(resumed, io.SeekStart); err != nil {
+				return nil, err
+			}
+			if v := resp.Header.Get("ETag"); v != "" && !strings.HasPrefix(v, "W/") {
+				validator = v
+			} else {
+				validator = resp.Header.Get("Last-Modified")
+			}
+			n, err := io.Copy(dst, body)
+			size = resumed + n
+			// 続きから頼み直せるのは本文を受け取れている場合だけ
+			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+				have = size
+			}
+			return nil, err
+		})
+		res.Resumed, res.Size = resumed, size
+		return res, err
+	})
+}
+
+// Stream は Fetch と同じように送るが、2xx のレスポンスの本文は受け取るたびに dst へ書く
+// 終わりのない本文も扱える。それ以外のステータスの本文は Fetch と同じく Body に入れる
+// dst に書き始めた後に失敗した場合は、同じ部分を二度書かないよう送り直さない
+func (c *Client) Stream(ctx context.Context, r Request, dst io.Writer) (Response, error) {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	if _, err := http.NewRequest(r.Method, r.URL, nil); err != nil {
+		return Response{}, err
+	}
+	wrote := false
+	sc := *c
+	retryable := c.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	sc.Retryable = func(err error) bool {
+		return !wrote && retryable(err)
+	}
+	return sc.retry(ctx, func() (Response, error) {
+		var size int64
+		res, err := sc.attempt(ctx, r, func(resp *http.Response, body io.Reader) ([]byte, error) {
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return io.ReadAll(body)
+			}
+			// 書き始めたら、それ以降の失敗では送り直さない
+			n, err := io.Copy(writerFunc(func(p []byte) (int, error) {
+				wrote = wrote || len(p) > 0
+				return dst.Write(p)
+			}), body)
+			size = n
+			return nil, err
+		})
+		res.Size = size
+		return res, err
+	})
+}
+
+// writerFunc は関数を io.Writer にする
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// contentRange は "bytes 100-199/1000" や "bytes */1000" から始まりと全体の大きさを返す
+// わからない部分は -1 にする
+func contentRange(v string) (start, total int64) {
+	start, total = -1, -1
+	spec, ok := strings.CutPrefix(v, "bytes ")
+	if !ok {
+		return start, total
+	}
+	rng, size, _ := strings.Cut(spec, "/")
+	if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+		total = n
+	}
+	if first, _, ok := strings.Cut(rng, "-"); ok {
+		if n, err := strconv.ParseInt(first, 10, 64); err == nil {
+			start = n
+		}
+	}
+	return start, total
+}
+
+// retry は attempt を試行回数まで繰り返し、試行の間は待つ
+func (c *Client) retry(ctx context.Context, attempt func() (Response, error)) (Response, error) {
+	attempts := c.MaxAttempts
+	if attempts <= 0 {
+		attempts = DefaultMaxAttempts
+	}
+	backoff := c.Backoff
+	if backoff == nil {
+		backoff = ConstantBackoff(DefaultBackoff)
+	}
+	retryable := c.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	failure := &TransferError{}
+	for i := 1; ; i++ {
+		start := time.Now()
+		res, err := attempt()
+		a := Attempt{Number: i, Start: start, Elapsed: time.Since(start), Status: res.StatusCode, Err: err}
+		if err == nil {
+			if i >= attempts || c.RetryOn == nil || !c.RetryOn(res.StatusCode) {
+				res.Attempts = i
+				c.report(a)
+				return res, nil
+			}
+			// 送り直すステータスでは Retry-After があればそれに従う
+			a.Backoff = backoff(i)
+			if d, ok := retryAfter(res.Header, time.Now()); ok {
+				a.Backoff = d
+				if c.MaxRetryAfter > 0 {
+					a.Backoff = min(d, c.MaxRetryAfter)
+				}
+			}
+			c.report(a)
+			c.retrying(a)
+			if err := sleepContext(ctx, a.Backoff); err != nil {
+				return Response{}, &TransferError{Err: err, Response: res.Raw}
+			}
+			continue
+		}
+		failure.Err = err
+		if res.Raw != nil {
+			failure.Response = res.Raw
+			if len(res.Body) > len(failure.Partial) {
+				failure.Partial, failure.PartialResponse = res.Body, res.Raw
+			}
+		}
+		// 最後の試行の後と、送り直しても直らないエラーの後は待たない
+		last := i >= attempts || !retryable(err)
+		if !last {
+			a.Backoff = backoff(i)
+		}
+		c.report(a)
+		if last {
+			return Response{}, failure
+		}
+		c.retrying(a)
+		if err := sleepContext(ctx, a.Backoff); err != nil {
+			failure.Err = err
+			return Response{}, failure
+		}
+	}
+}
+
+// sleepContext は d だけ待つ。その間に ctx が終われば ctx のエラーを返す
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// errKeepBody は attempt の read が本文を読まずに呼び出し側へ渡すときに返す
+var errKeepBody = errors.New("gofetch: keep response body open")
+
+// attempt はリクエストを1回送り、read で本文を読む
+// 本文の途中で失敗した場合は受け取れた分をレスポンスに入れてエラーを返す
+// read が errKeepBody を返した場合は本文を閉じずに Raw.Body に残し、閉じたときに試行を終える
+func (c *Client) attempt(ctx context.Context, r Request, read func(resp *http.Response, body io.Reader) ([]byte, error)) (Response, error) {
+	start := time.Now()
+	var ttfb time.Duration
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			ttfb = time.Since(start)
+		},
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	reqCtx, cancelTimeout := ctx, context.CancelFunc(func() {})
+	if c.Timeout > 0 {
+		reqCtx, cancelTimeout = context.WithTimeout(ctx, c.Timeout)
+	}
+	release := func() {
+		cancelTimeout()
+		cancel(nil)
+	}
+	kept := false
+	defer func() {
+		if !kept {
+			release()
+		}
+	}()
+
+	req, err := r.NewHTTPRequest(httptrace.WithClientTrace(reqCtx, trace))
+	if err != nil {
+		return Response{}, err
+	}
+	if c.OnRequest != nil {
+		c.OnRequest(req)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return Response{}, err
+	}
+	if c.OnResponse != nil {
+		c.OnResponse(resp)
+	}
+	if c.WrapBody != nil {
+		resp.Body = c.WrapBody(resp.Body, cancel)
+	}
+	data, err := read(resp, resp.Body)
+	if errors.Is(err, errKeepBody) {
+		kept = true
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+		err = nil
+	} else {
+		resp.Body.Close()
+	}
+	res := Response{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       data,
+		TTFB:       ttfb,
+		Duration:   time.Since(start),
+		Raw:        resp,
+	}
+	if err != nil {
+		// WrapBody が打ち切った場合はその原因をエラーにする
+		if cause := context.Cause(ctx); cause != nil {
+			err = cause
+		}
+		return res, err
+	}
+	return res, nil
+}
+
+// releaseBody は閉じたときに試行のコンテキストを終える本文
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// report は OnAttempt があれば試行の結果を渡す
+func (c *Client) report(a Attempt) {
+	if c.OnAttempt != nil {
+		c.OnAttempt(a)
+	}
+}
+
+// retrying は OnRetry があれば送り直す試行の結果を渡す
+func (c *Client) retrying(a Attempt) {
+	if c.OnRetry != nil {
+		c.OnRetry(a)
+	}
+}
@@ -0,0 +1,202 @@
+package gofetch
+
+// 簡単に使うための関数 (Get, Post, Download)
+// Client を作らずに既定の設定で取得する。設定は Option で変える
+//
+//	res, err := gofetch.Get(ctx, "https://api.example.com/items", gofetch.WithHeader("Accept", "application/json"))
+//	if err != nil {
+//		return err
+//	}
+//	defer res.Close()
+//	var items []Item
+//	err = res.JSON(&items)
+//
+// Get と Post は本文を読まずにレスポンスを返し、本文は読むときに受け取る
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LazyResponse は本文をまだ読んでいないレスポンス
+// 本文は Body から少しずつ読むか、Bytes、Text、JSON でまとめて読む。どの場合も最後に Close で閉じる
+type LazyResponse struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// Body は本文。Bytes などでまとめて読んだ後は読み終えている
+	Body io.ReadCloser
+	// TTFB は最後の試行を始めてから最初のバイトを受け取るまでの時間
+	TTFB time.Duration
+	// Attempts はレスポンスを受け取るまでの試行回数
+	Attempts int
+	// Raw は元のレスポンス
+	Raw *http.Response
+
+	data []byte
+	err  error
+	read bool
+}
+
+// Bytes は本文をすべて読んで閉じる。2回目からは同じ内容を返す
+func (r *LazyResponse) Bytes() ([]byte, error) {
+	if !r.read {
+		r.read = true
+		r.data, r.err = io.ReadAll(r.Body)
+		if err := r.Body.Close(); r.err == nil {
+			r.err = err
+		}
+	}
+	return r.data, r.err
+}
+
+// Text は本文をすべて読んで文字列で返す
+func (r *LazyResponse) Text() (string, error) {
+	data, err := r.Bytes()
+	return string(data), err
+}
+
+// JSON は本文をすべて読んで v に JSON として読み込む
+func (r *LazyResponse) JSON(v any) error {
+	data, err := r.Bytes()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Close は本文を閉じる。読み終えていなければ残りは受け取らない
+func (r *LazyResponse) Close() error {
+	if r.read {
+		return nil
+	}
+	r.read = true
+	return r.Body.Close()
+}
+
+// Open は Fetch と同じように送るが、本文を読まずにレスポンスを返す
+// 送り直すのはレスポンスのヘッダーを受け取るまでの失敗と RetryOn のステータスだけで、
+// 本文は呼び出し側が読んで閉じる。Timeout は本文を読み終えるまでにかかる
+func (c *Client) Open(ctx context.Context, r Request) (*LazyResponse, error) {
+	if r.Method == "" {
+		r.Method = http.MethodGet
+	}
+	if _, err := http.NewRequest(r.Method, r.URL, nil); err != nil {
+		return nil, err
+	}
+	res, err := c.retry(ctx, func() (Response, error) {
+		return c.attempt(ctx, r, func(resp *http.Response, body io.Reader) ([]byte, error) {
+			// 送り直すかもしれないステータスの本文は読み切って、接続を使い回せるようにする
+			if c.RetryOn != nil && c.RetryOn(resp.StatusCode) {
+				return io.ReadAll(body)
+			}
+			return nil, errKeepBody
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	lazy := &LazyResponse{StatusCode: res.StatusCode, Status: res.Status, Header: res.Header, TTFB: res.TTFB, Attempts: res.Attempts, Raw: res.Raw}
+	if res.Body != nil {
+		lazy.data, lazy.read = res.Body, true
+		lazy.Body = io.NopCloser(bytes.NewReader(res.Body))
+	} else {
+		lazy.Body = res.Raw.Body
+	}
+	return lazy, nil
+}
+
+// Option は Get、Post、Download の Client と Request の設定を変える
+type Option func(c *Client, r *Request)
+
+// WithHTTPClient は hc でリクエストを送る
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client, _ *Request) { c.HTTPClient = hc }
+}
+
+// WithHeader はリクエストにヘッダーを加える
+func WithHeader(name, value string) Option {
+	return func(_ *Client, r *Request) {
+		if r.Header == nil {
+			r.Header = http.Header{}
+		}
+		r.Header.Add(name, value)
+	}
+}
+
+// WithTimeout は1回の試行のタイムアウトを変える
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client, _ *Request) { c.Timeout = d }
+}
+
+// WithMaxAttempts は試行回数を変える
+func WithMaxAttempts(n int) Option {
+	return func(c *Client, _ *Request) { c.MaxAttempts = n }
+}
+
+// WithBackoff は次の試行までに待つ時間を変える
+func WithBackoff(b BackoffFunc) Option {
+	return func(c *Client, _ *Request) { c.Backoff = b }
+}
+
+// WithRetryOn は送り直すステータスを決める
+func WithRetryOn(retryOn func(status int) bool) Option {
+	return func(c *Client, _ *Request) { c.RetryOn = retryOn }
+}
+
+// newRequest は既定の設定の Client と Request に opts を適用する
+func newRequest(method, url string, opts []Option) (*Client, Request) {
+	c := New(nil)
+	r := Request{Method: method, URL: url}
+	for _, opt := range opts {
+		opt(c, &r)
+	}
+	return c, r
+}
+
+// Get は url を GET で取得し、本文を読まずにレスポンスを返す
+func Get(ctx context.Context, url string, opts ...Option) (*LazyResponse, error) {
+	c, r := newRequest(http.MethodGet, url, opts)
+	return c.Open(ctx, r)
+}
+
+// Post は body を contentType として url に POST し、本文を読まずにレスポンスを返す
+func Post(ctx context.Context, url, contentType string, body []byte, opts ...Option) (*LazyResponse, error) {
+	c, r := newRequest(http.MethodPost, url, append([]Option{WithHeader("Content-Type", contentType)}, opts...))
+	r.Body = body
+	return c.Open(ctx, r)
+}
+
+// Download は url を取得して本文を path に書く
+// 同じディレクトリの一時ファイルに書いてから置き換えるので、失敗しても path にあったファイルは残る
+// 2xx 以外のステータスでは path に書かず、本文を Body に入れて返す
+func Download(ctx context.Context, url, path string, opts ...Option) (Response, error) {
+	c, r := newRequest(http.MethodGet, url, opts)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.part")
+	if err != nil {
+		return Response{}, err
+	}
+	defer os.Remove(tmp.Name())
+	res, err := c.Download(ctx, r, tmp, 0)
+	// 一時ファイルは 0600 で作られるので、ふつうのファイルと同じにする
+	if chmodErr := tmp.Chmod(0644); err == nil {
+		err = chmodErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return res, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body, err = os.ReadFile(tmp.Name())
+		return res, err
+	}
+	return res, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,142 @@
+package gofetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte("busy"))
+				return
+			}
+		case "/post":
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte(r.Header.Get("Content-Type") + " " + string(body)))
+			return
+		}
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+	retry5xx := WithRetryOn(func(status int) bool { return status >= 500 })
+
+	tests := []struct {
+		name string
+		get  func() (*LazyResponse, error)
+		want string
+		// attempts は受け取るまでの試行回数
+		attempts int
+	}{
+		{name: "get", get: func() (*LazyResponse, error) { return Get(ctx, srv.URL+"/items") }, want: `{"ok":true}`, attempts: 1},
+		{name: "retry on status", get: func() (*LazyResponse, error) {
+			return Get(ctx, srv.URL+"/flaky", retry5xx, WithBackoff(ConstantBackoff(time.Millisecond)))
+		}, want: `{"ok":true}`, attempts: 2},
+		{name: "last retried status is returned", get: func() (*LazyResponse, error) {
+			calls.Store(0)
+			return Get(ctx, srv.URL+"/flaky", retry5xx, WithMaxAttempts(1))
+		}, want: "busy", attempts: 1},
+		{name: "post", get: func() (*LazyResponse, error) {
+			return Post(ctx, srv.URL+"/post", "text/plain", []byte("hello"))
+		}, want: "text/plain hello", attempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.get()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Close()
+			got, err := res.Text()
+			if err != nil || got != tt.want {
+				t.Errorf("Text() = %q, %v; want %q", got, err, tt.want)
+			}
+			// 2回目も同じ内容を返す
+			if again, _ := res.Text(); again != got {
+				t.Errorf("second Text() = %q, want %q", again, got)
+			}
+			if res.Attempts != tt.attempts {
+				t.Errorf("Attempts = %d, want %d", res.Attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestGetLazyBody(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("head "))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("tail"))
+	}))
+	defer srv.Close()
+
+	// 本文を読み終える前にレスポンスを返す
+	res, err := Get(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	var v struct{}
+	if err := res.JSON(&v); err == nil {
+		t.Error("JSON() of a text body succeeded")
+	}
+	if got, _ := res.Text(); got != "head tail" {
+		t.Errorf("Text() = %q, want %q", got, "head tail")
+	}
+}
+
+func TestDownload(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("new content"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name, path string
+		status     int
+		// want は後に残るファイルの内容
+		want string
+	}{
+		{name: "replaces the file", path: "/file", status: http.StatusOK, want: "new content"},
+		{name: "keeps the file on an error status", path: "/missing", status: http.StatusNotFound, want: "old content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out.txt")
+			os.WriteFile(path, []byte("old content"), 0644)
+			res, err := Download(context.Background(), srv.URL+tt.path, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", res.StatusCode, tt.status)
+			}
+			if got, _ := os.ReadFile(path); string(got) != tt.want {
+				t.Errorf("file = %q, want %q", got, tt.want)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("left %d files in the directory, want 1", len(entries))
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package gofetch
+
+// ミドルウェア
+// 認証、計測、記録などの処理を RoundTripper を包む関数として送受信に重ねる
+//
+//	c := gofetch.New(nil)
+//	c.Use(func(next http.RoundTripper) http.RoundTripper {
+//		return gofetch.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+//			req.Header.Set("Authorization", "Bearer "+token())
+//			return next.RoundTrip(req)
+//		})
+//	})
+//
+// ミドルウェアはリダイレクトとリトライのたびに呼ばれる。試行ごとの処理は OnRequest、OnRetry、OnResponse を使う
+
+import "net/http"
+
+// Middleware は next を包んだ RoundTripper を返す
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc は関数を http.RoundTripper にする
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip は f を呼ぶ
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain は base を middlewares で順に包む。最後のものが一番外側になり、最初にリクエストを受け取る
+// base が nil なら http.DefaultTransport を包む
+func Chain(base http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for _, m := range middlewares {
+		base = m(base)
+	}
+	return base
+}
+
+// Use は Client の送受信にミドルウェアを加える。後に加えたものほど外側になる
+func (c *Client) Use(middlewares ...Middleware) {
+	c.Middlewares = append(c.Middlewares, middlewares...)
+}
+
+// httpClient は試行に使う http.Client を返す。ミドルウェアがあれば HTTPClient の Transport を包む
+func (c *Client) httpClient() *http.Client {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	if len(c.Middlewares) == 0 {
+		return hc
+	}
+	wrapped := *hc
+	wrapped.Transport = Chain(hc.Transport, c.Middlewares...)
+	return &wrapped
+}
@@ -0,0 +1,83 @@
+package gofetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tagMiddleware はリクエストの X-Chain に name を書き足す
+func tagMiddleware(name string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req.Header.Add("X-Chain", name)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+func TestMiddlewareAndHooks(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" && calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(strings.Join(r.Header.Values("X-Chain"), ",")))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		middlewares []Middleware
+		want        string
+		// events はフックが呼ばれた順
+		events []string
+	}{
+		{name: "no middleware", path: "/", want: "", events: []string{"request", "response"}},
+		{name: "last added runs first", path: "/", middlewares: []Middleware{tagMiddleware("inner"), tagMiddleware("outer")},
+			want: "outer,inner", events: []string{"request", "response"}},
+		{name: "hooks on retry", path: "/flaky", middlewares: []Middleware{tagMiddleware("auth")}, want: "auth",
+			events: []string{"request", "response", "retry 1", "request", "response", "retry 2", "request", "response"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			c := New(nil)
+			c.Backoff = ConstantBackoff(time.Millisecond)
+			c.RetryOn = func(status int) bool { return status >= 500 }
+			c.Use(tt.middlewares...)
+			c.OnRequest = func(*http.Request) { events = append(events, "request") }
+			c.OnResponse = func(*http.Response) { events = append(events, "response") }
+			c.OnRetry = func(a Attempt) { events = append(events, "retry "+strconv.Itoa(a.Number)) }
+			res, err := c.Fetch(context.Background(), Request{URL: srv.URL + tt.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(res.Body) != tt.want {
+				t.Errorf("chain = %q, want %q", res.Body, tt.want)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("events = %v, want %v", events, tt.events)
+			}
+		})
+	}
+}
+
+func TestChainKeepsHTTPClient(t *testing.T) {
+	// ミドルウェアは HTTPClient の Transport を包み、HTTPClient そのものは変えない
+	base := &http.Client{Timeout: time.Second}
+	c := New(base)
+	c.Use(tagMiddleware("a"))
+	hc := c.httpClient()
+	if hc == base || hc.Timeout != base.Timeout || base.Transport != nil {
+		t.Errorf("httpClient() = %+v, base = %+v", hc, base)
+	}
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore はディレクトリの下にキーごとのファイルとして保存する。KV と Blob の両方に使える
+// キーはファイル名として安全な形にするが、cookies.txt のような普通の名前はそのままのファイル名になる
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore は dir のディレクトリに保存する FileStore を作る。ディレクトリは最初に書くときに作る
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// NewFile はユーザーのキャッシュディレクトリ以下に名前空間のディレクトリを使う
+func NewFile(namespace string) (*Store, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return NewFileAt(filepath.Join(base, "gofetch", namespace)), nil
+}
+
+// NewFileAt は root のディレクトリの下に保存する
+func NewFileAt(root string) *Store {
+	return &Store{
+		KV:   NewFileStore(filepath.Join(root, "kv")),
+		Blob: NewFileStore(filepath.Join(root, "blob")),
+	}
+}
+
+// path はキーをファイル名として安全な形にしたパスを返す
+func (s *FileStore) path(key string) string {
+	return filepath.Join(s.dir, fileName(key))
+}
+
+// fileName はキーのパスの区切り、%、制御文字を %XX にしてファイル名にする
+// . と ..、書き込み中の一時ファイルと同じ名前にならないよう、先頭の . も . と .tmp- では %XX にする
+func fileName(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		leadingDot := i == 0 && c == '.' && (key == "." || key == ".." || strings.HasPrefix(key, ".tmp-"))
+		if c == '/' || c == '\\' || c == '%' || c < 0x20 || c == 0x7f || leadingDot {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	if b.Len() == 0 {
+		return "%"
+	}
+	return b.String()
+}
+
+// Get はファイルの内容を返す
+func (s *FileStore) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Set はファイルに書き込む
+func (s *FileStore) Set(key string, value []byte) error {
+	w, err := s.Create(key)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(value); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Delete はファイルを削除する
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Open はファイルを読み出し用に開く
+func (s *FileStore) Open(key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Create は一時ファイルに書き込み、Close したときに置き換える
+// 書き込みの途中で失敗しても以前の内容は壊れない。ファイルは本人だけが読める
+func (s *FileStore) Create(key string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{File: f, dest: s.path(key)}, nil
+}
+
+// fileWriter は Close でファイルを確定させる書き込み先
+type fileWriter struct {
+	*os.File
+	dest string
+}
+
+// Close は一時ファイルを閉じて本来の名前に変更する
+func (w *fileWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		os.Remove(w.Name())
+		return err
+	}
+	return os.Rename(w.Name(), w.dest)
+}
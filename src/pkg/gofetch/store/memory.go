@@ -0,0 +1,76 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// MemoryStore は実行中のメモリ上だけに保存する。KV と Blob の両方に使える
+type MemoryStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+// NewMemoryStore は空の MemoryStore を作る
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: map[string][]byte{}}
+}
+
+// NewMemory はメモリ上の保存先を作る。名前空間ごとに独立している
+func NewMemory(namespace string) (*Store, error) {
+	return &Store{KV: NewMemoryStore(), Blob: NewMemoryStore()}, nil
+}
+
+// Get は保存された値の複製を返す
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(v), nil
+}
+
+// Set は値の複製を保存する
+func (s *MemoryStore) Set(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = bytes.Clone(value)
+	return nil
+}
+
+// Delete は値を削除する
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
+
+// Open は保存されたデータを読み出す
+func (s *MemoryStore) Open(key string) (io.ReadCloser, error) {
+	v, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(v)), nil
+}
+
+// Create は Close したときに保存されるバッファを返す
+func (s *MemoryStore) Create(key string) (io.WriteCloser, error) {
+	return &memoryWriter{store: s, key: key}, nil
+}
+
+// memoryWriter は Close で内容を保存する書き込み先
+type memoryWriter struct {
+	bytes.Buffer
+	store *MemoryStore
+	key   string
+}
+
+// Close はバッファの内容を保存する
+func (w *memoryWriter) Close() error {
+	return w.store.Set(w.key, w.Bytes())
+}
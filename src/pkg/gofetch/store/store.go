@@ -0,0 +1,84 @@
+// Package store は gofetch のキャッシュやクッキーなどを永続化する保存先
+// 小さな値をキーで保存する KV と、大きなデータをストリームで保存する Blob の2つのインターフェースを持つ
+// 既定はユーザーのキャッシュディレクトリ以下のファイル (file) で、実行中のメモリ上にだけ保存する memory も選べる
+//
+//	st, err := store.Open("file", "cache")
+//	err = st.KV.Set("etag", []byte(`"abc"`))
+//
+// SQLite などほかの保存先は、ドライバーへの依存を増やさないよう同梱しない
+// 組み込む側で KV と Blob を実装し、Register で名前を付ければ Open (gofetch の --storage) で選べる
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound はキーが保存されていないことを表す
+var ErrNotFound = errors.New("not found")
+
+// KV は小さな値をキーで保存する
+type KV interface {
+	// Get はキーの値を返す。保存されていなければ ErrNotFound を返す
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte) error
+	Delete(key string) error
+}
+
+// Blob は大きなデータをストリームで保存する
+type Blob interface {
+	// Open はキーのデータを読み出す。保存されていなければ ErrNotFound を返す
+	Open(key string) (io.ReadCloser, error)
+	// Create はキーのデータを書き込む。Close したときに確定する
+	Create(key string) (io.WriteCloser, error)
+	Delete(key string) error
+}
+
+// Store は機能ごとの名前空間に区切られた保存先
+type Store struct {
+	KV   KV
+	Blob Blob
+}
+
+// backends は Open で選べる保存先
+var backends = struct {
+	sync.Mutex
+	m map[string]func(namespace string) (*Store, error)
+}{m: map[string]func(namespace string) (*Store, error){
+	"file":   NewFile,
+	"memory": NewMemory,
+}}
+
+// Register は保存先に名前を付けて Open で選べるようにする。同じ名前があれば置き換える
+func Register(name string, open func(namespace string) (*Store, error)) {
+	backends.Lock()
+	defer backends.Unlock()
+	backends.m[name] = open
+}
+
+// Backends は選べる保存先の名前を名前順に返す
+func Backends() []string {
+	backends.Lock()
+	defer backends.Unlock()
+	names := make([]string, 0, len(backends.m))
+	for name := range backends.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open は保存先の名前と名前空間から Store を作る
+func Open(backend, namespace string) (*Store, error) {
+	backends.Lock()
+	open, ok := backends.m[backend]
+	backends.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage %q (want %s)", backend, strings.Join(Backends(), ", "))
+	}
+	return open(namespace)
+}
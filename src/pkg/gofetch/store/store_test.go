@@ -0,0 +1,103 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	tests := []struct {
+		name string
+		st   *Store
+	}{
+		{name: "file", st: NewFileAt(t.TempDir())},
+		{name: "memory", st: func() *Store { st, _ := NewMemory("test"); return st }()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.st.KV.Get("missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(missing) err = %v, want ErrNotFound", err)
+			}
+			if _, err := tt.st.Blob.Open("missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Open(missing) err = %v, want ErrNotFound", err)
+			}
+			if err := tt.st.KV.Set("host:443/a b", []byte("v1")); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := tt.st.KV.Get("host:443/a b"); err != nil || string(got) != "v1" {
+				t.Errorf("Get = %q, %v, want v1", got, err)
+			}
+
+			w, err := tt.st.Blob.Create("body")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(w, "partial")
+			// Close するまでは以前の内容 (なし) のまま
+			if _, err := tt.st.Blob.Open("body"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Open before Close err = %v, want ErrNotFound", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := tt.st.Blob.Open("body")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(r)
+			r.Close()
+			if string(data) != "partial" {
+				t.Errorf("blob = %q, want partial", data)
+			}
+
+			if err := tt.st.KV.Delete("host:443/a b"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tt.st.KV.Get("host:443/a b"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get after Delete err = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestFileStoreNames(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	for _, key := range []string{"cookies.txt", "my cookies.txt", ".cookies", "../escape", "..", ".tmp-x", "a%2Fb", ""} {
+		if err := s.Set(key, []byte(key)); err != nil {
+			t.Fatalf("Set(%q): %v", key, err)
+		}
+	}
+	// 普通の名前はそのままのファイル名になり、ディレクトリの外には書かない
+	for _, name := range []string{"cookies.txt", "my cookies.txt", ".cookies"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name)); err != nil || string(data) != name {
+			t.Errorf("file %q = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape")); err == nil {
+		t.Error("../escape was written outside the directory")
+	}
+	for _, key := range []string{"../escape", "..", ".tmp-x", "a%2Fb", ""} {
+		if data, err := s.Get(key); err != nil || string(data) != key {
+			t.Errorf("Get(%q) = %q, %v", key, data, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	if _, err := Open("sqlite", "cache"); err == nil || err.Error() != `unknown storage "sqlite" (want file, memory)` {
+		t.Errorf("Open(sqlite) err = %v", err)
+	}
+	Register("test", NewMemory)
+	t.Cleanup(func() {
+		backends.Lock()
+		delete(backends.m, "test")
+		backends.Unlock()
+	})
+	if _, err := Open("test", "cache"); err != nil {
+		t.Errorf("Open(test) err = %v", err)
+	}
+}